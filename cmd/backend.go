@@ -0,0 +1,412 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/meln5674/gosh"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	kubeterm "k8s.io/kubectl/pkg/util/term"
+
+	"github.com/meln5674/kink/pkg/docker"
+	"github.com/meln5674/kink/pkg/helm"
+	"github.com/meln5674/kink/pkg/kubectl"
+)
+
+// The functions in this file choose between executing helm, kubectl, and docker, or using their native go clients,
+// based on the configuration. The external commands are the default, the native clients are used if the matching
+// Native field is set in the config file or by flag.
+//
+// Both are returned as gosh commands so that they can be freely mixed in pipelines and fan-outs.
+
+// nativeCmd is a gosh.Pipelineable which runs a go function instead of a process.
+// Unlike gosh.FuncCmd, it runs deferred functions after the function returns, so stream setters like
+// gosh.FuncOut and gosh.FileIn behave the same as they would for a process.
+type nativeCmd struct {
+	ctx            context.Context
+	cancel         context.CancelFunc
+	f              func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error
+	stdin          io.Reader
+	stdout         io.Writer
+	stderr         io.Writer
+	done           chan error
+	deferredBefore []func() error
+	deferredAfter  []func() error
+}
+
+var _ = gosh.Pipelineable(&nativeCmd{})
+
+func newNativeCmd(ctx context.Context, f func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error) *nativeCmd {
+	cmd := &nativeCmd{
+		f:      f,
+		stdout: devNull,
+		stderr: devNull,
+	}
+	cmd.ctx, cmd.cancel = context.WithCancel(ctx)
+	return cmd
+}
+
+func (n *nativeCmd) Start() error {
+	if n.done != nil {
+		return gosh.ErrAlreadyStarted
+	}
+	for _, f := range n.deferredBefore {
+		err := f()
+		if err != nil {
+			// The deferred after functions close any files and pipes which were already opened
+			n.cancel()
+			return utilerrors.NewAggregate(append([]error{err}, n.runDeferredAfter()...))
+		}
+	}
+	n.done = make(chan error, 1)
+	go func() {
+		defer close(n.done)
+		defer n.cancel()
+		err := n.f(n.ctx, n.stdin, n.stdout, n.stderr)
+		for _, afterErr := range n.runDeferredAfter() {
+			if err == nil {
+				err = afterErr
+			}
+		}
+		n.done <- err
+	}()
+	return nil
+}
+
+// runDeferredAfter runs every deferred after function, even if some fail, and returns their errors
+func (n *nativeCmd) runDeferredAfter() []error {
+	var errs []error
+	for _, f := range n.deferredAfter {
+		err := f()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (n *nativeCmd) Wait() error {
+	if n.done == nil {
+		return gosh.ErrNotStarted
+	}
+	return <-n.done
+}
+
+func (n *nativeCmd) Run() error {
+	err := n.Start()
+	if err != nil {
+		return err
+	}
+	return n.Wait()
+}
+
+func (n *nativeCmd) Kill() error {
+	if n.done == nil {
+		return gosh.ErrNotStarted
+	}
+	n.cancel()
+	return nil
+}
+
+func (n *nativeCmd) DeferBefore(f func() error) {
+	n.deferredBefore = append(n.deferredBefore, f)
+}
+
+func (n *nativeCmd) DeferAfter(f func() error) {
+	n.deferredAfter = append(n.deferredAfter, f)
+}
+
+func (n *nativeCmd) SetStdin(stdin io.Reader) error {
+	n.stdin = stdin
+	return nil
+}
+
+func (n *nativeCmd) SetStdout(stdout io.Writer) error {
+	n.stdout = stdout
+	return nil
+}
+
+func (n *nativeCmd) SetStderr(stderr io.Writer) error {
+	n.stderr = stderr
+	return nil
+}
+
+// withStreams applies stream setters to either an external or native command
+func withStreams(cmd gosh.Pipelineable, streams ...gosh.StreamSetter) gosh.Pipelineable {
+	for _, stream := range streams {
+		// Errors are deliberately ignored to match gosh.Cmd.WithStreams
+		stream(cmd)
+	}
+	return cmd
+}
+
+func nativeHelmClient(cfg *resolvedConfigT) (*helm.NativeClient, error) {
+	return helm.NewNativeClient(&cfg.KinkConfig.Chart, &cfg.KinkConfig.Kubernetes, cfg.ReleaseNamespace)
+}
+
+func helmRepoAdd(ctx context.Context, cfg *resolvedConfigT) gosh.Pipelineable {
+	if cfg.KinkConfig.Helm.Native {
+		// The native client uses the repository URL directly, there is no local repository configuration to update
+		return newNativeCmd(ctx, func(context.Context, io.Reader, io.Writer, io.Writer) error { return nil })
+	}
+	return gosh.Command(helm.RepoAdd(&cfg.KinkConfig.Helm, &cfg.KinkConfig.Chart)...).WithContext(ctx)
+}
+
+func helmRepoUpdate(ctx context.Context, cfg *resolvedConfigT) gosh.Pipelineable {
+	if cfg.KinkConfig.Helm.Native {
+		// See above
+		return newNativeCmd(ctx, func(context.Context, io.Reader, io.Writer, io.Writer) error { return nil })
+	}
+	return gosh.Command(helm.RepoUpdate(&cfg.KinkConfig.Helm, cfg.KinkConfig.Chart.RepoName())...).WithContext(ctx)
+}
+
+func helmUpgradeCluster(ctx context.Context, cfg *resolvedConfigT) gosh.Pipelineable {
+	if cfg.KinkConfig.Helm.Native {
+		return newNativeCmd(ctx, func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+			client, err := nativeHelmClient(cfg)
+			if err != nil {
+				return err
+			}
+			return client.UpgradeCluster(ctx, &cfg.KinkConfig.Chart, &cfg.KinkConfig.Release, stdout)
+		})
+	}
+	return gosh.Command(helm.UpgradeCluster(&cfg.KinkConfig.Helm, &cfg.KinkConfig.Chart, &cfg.KinkConfig.Release, &cfg.KinkConfig.Kubernetes)...).WithContext(ctx)
+}
+
+func helmTemplateCluster(ctx context.Context, cfg *resolvedConfigT) gosh.Pipelineable {
+	if cfg.KinkConfig.Helm.Native {
+		return newNativeCmd(ctx, func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+			client, err := nativeHelmClient(cfg)
+			if err != nil {
+				return err
+			}
+			return client.TemplateCluster(ctx, &cfg.KinkConfig.Chart, &cfg.KinkConfig.Release, stdout)
+		})
+	}
+	return gosh.Command(helm.TemplateCluster(&cfg.KinkConfig.Helm, &cfg.KinkConfig.Chart, &cfg.KinkConfig.Release, &cfg.KinkConfig.Kubernetes)...).WithContext(ctx)
+}
+
+func helmDeleteCluster(ctx context.Context, cfg *resolvedConfigT) gosh.Pipelineable {
+	raw := cfg.KinkConfig.Release.Raw()
+	if cfg.KinkConfig.Helm.Native {
+		return newNativeCmd(ctx, func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+			client, err := nativeHelmClient(cfg)
+			if err != nil {
+				return err
+			}
+			return client.Delete(ctx, &raw, stdout)
+		})
+	}
+	return gosh.Command(helm.Delete(&cfg.KinkConfig.Helm, &cfg.KinkConfig.Chart, &raw, &cfg.KinkConfig.Kubernetes)...).WithContext(ctx)
+}
+
+func helmListReleases(ctx context.Context, cfg *resolvedConfigT, allNamespaces bool) ([]helm.ReleaseSummary, error) {
+	if cfg.KinkConfig.Helm.Native {
+		if allNamespaces {
+			// Helm only searches all namespaces if its storage is not scoped to one
+			client, err := helm.NewNativeClient(&cfg.KinkConfig.Chart, &cfg.KinkConfig.Kubernetes, "")
+			if err != nil {
+				return nil, err
+			}
+			return client.ListAllNamespaces(ctx)
+		}
+		client, err := nativeHelmClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return client.List(ctx)
+	}
+	list := helm.List
+	if allNamespaces {
+		list = helm.ListAllNamespaces
+	}
+	releases := make([]helm.ReleaseSummary, 0)
+	err := gosh.
+		Command(list(&cfg.KinkConfig.Helm, &cfg.KinkConfig.Kubernetes)...).
+		WithContext(ctx).
+		WithStreams(
+			gosh.ForwardErr,
+			gosh.FuncOut(gosh.SaveJSON(&releases)),
+		).
+		Run()
+	if err != nil {
+		return nil, err
+	}
+	return releases, nil
+}
+
+func helmReleaseHistory(ctx context.Context, cfg *resolvedConfigT) ([]helm.HistoryEntry, error) {
+	raw := cfg.KinkConfig.Release.Raw()
+	if cfg.KinkConfig.Helm.Native {
+		client, err := nativeHelmClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return client.History(ctx, &raw)
+	}
+	history := make([]helm.HistoryEntry, 0)
+	err := gosh.
+		Command(helm.History(&cfg.KinkConfig.Helm, &raw, &cfg.KinkConfig.Kubernetes)...).
+		WithContext(ctx).
+		WithStreams(
+			gosh.ForwardErr,
+			gosh.FuncOut(gosh.SaveJSON(&history)),
+		).
+		Run()
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func helmGetManifest(ctx context.Context, cfg *resolvedConfigT) gosh.Pipelineable {
+	raw := cfg.KinkConfig.Release.Raw()
+	if cfg.KinkConfig.Helm.Native {
+		return newNativeCmd(ctx, func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+			client, err := nativeHelmClient(cfg)
+			if err != nil {
+				return err
+			}
+			return client.GetManifest(ctx, &raw, stdout)
+		})
+	}
+	return gosh.Command(helm.GetManifest(&cfg.KinkConfig.Helm, &raw, &cfg.KinkConfig.Kubernetes)...).WithContext(ctx)
+}
+
+func hostClient(cfg *resolvedConfigT) (*kubernetes.Clientset, error) {
+	return kubernetes.NewForConfig(cfg.Kubeconfig)
+}
+
+func kubectlExec(ctx context.Context, cfg *resolvedConfigT, pod string, stdin, tty bool, exec ...string) gosh.Pipelineable {
+	return kubectlExecInContainer(ctx, cfg, pod, "", stdin, tty, exec...)
+}
+
+func kubectlExecInContainer(ctx context.Context, cfg *resolvedConfigT, pod, container string, stdin, tty bool, exec ...string) gosh.Pipelineable {
+	if cfg.KinkConfig.Kubectl.Native {
+		return newNativeCmd(ctx, func(ctx context.Context, stdinR io.Reader, stdout, stderr io.Writer) error {
+			opts := kubectl.ExecOptions{
+				Stdout:    stdout,
+				Stderr:    stderr,
+				TTY:       tty,
+				Container: container,
+			}
+			if stdin {
+				opts.Stdin = stdinR
+			}
+			if !tty {
+				return kubectl.NativeExec(ctx, cfg.Kubeconfig, cfg.ReleaseNamespace, pod, &opts, exec...)
+			}
+			// Like kubectl, put the local terminal, if any, into raw mode, and send it resizes
+			t := kubeterm.TTY{In: stdinR, Out: stdout, Raw: stdin}
+			opts.TerminalSizeQueue = t.MonitorSize(t.GetSize())
+			return t.Safe(func() error {
+				return kubectl.NativeExec(ctx, cfg.Kubeconfig, cfg.ReleaseNamespace, pod, &opts, exec...)
+			})
+		})
+	}
+	return gosh.Command(kubectl.ExecInContainer(&cfg.KinkConfig.Kubectl, &cfg.KinkConfig.Kubernetes, pod, container, stdin, tty, exec...)...).WithContext(ctx)
+}
+
+func kubectlLogs(ctx context.Context, cfg *resolvedConfigT, pod string, opts *kubectl.LogsOptions) gosh.Pipelineable {
+	if cfg.KinkConfig.Kubectl.Native {
+		return newNativeCmd(ctx, func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+			return kubectl.NativeLogs(ctx, cfg.Kubeconfig, cfg.ReleaseNamespace, pod, opts, stdout)
+		})
+	}
+	return gosh.Command(kubectl.Logs(&cfg.KinkConfig.Kubectl, &cfg.KinkConfig.Kubernetes, pod, opts)...).WithContext(ctx)
+}
+
+func kubectlCp(ctx context.Context, cfg *resolvedConfigT, pod, src, dest string) gosh.Pipelineable {
+	if cfg.KinkConfig.Kubectl.Native {
+		return newNativeCmd(ctx, func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+			f, err := os.Create(dest)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return kubectl.NativeCat(ctx, cfg.Kubeconfig, cfg.ReleaseNamespace, pod, src, f)
+		})
+	}
+	return gosh.Command(kubectl.Cp(&cfg.KinkConfig.Kubectl, &cfg.KinkConfig.Kubernetes, pod, src, dest)...).WithContext(ctx)
+}
+
+func kubectlGetPods(ctx context.Context, cfg *resolvedConfigT, labels map[string]string) (*corev1.PodList, error) {
+	if cfg.KinkConfig.Kubectl.Native {
+		client, err := hostClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return client.CoreV1().Pods(cfg.ReleaseNamespace).List(ctx, metav1.ListOptions{
+			LabelSelector: k8slabels.SelectorFromSet(labels).String(),
+		})
+	}
+	var pods corev1.PodList
+	err := gosh.
+		Command(kubectl.GetPods(&cfg.KinkConfig.Kubectl, &cfg.KinkConfig.Kubernetes, labels)...).
+		WithContext(ctx).
+		WithStreams(
+			gosh.ForwardErr,
+			gosh.FuncOut(gosh.SaveJSON(&pods)),
+		).
+		Run()
+	if err != nil {
+		return nil, err
+	}
+	return &pods, nil
+}
+
+func kubectlStatefulSetRolloutStatus(ctx context.Context, cfg *resolvedConfigT, name string) gosh.Pipelineable {
+	if cfg.KinkConfig.Kubectl.Native {
+		return newNativeCmd(ctx, func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+			client, err := hostClient(cfg)
+			if err != nil {
+				return err
+			}
+			return kubectl.NativeStatefulSetRolloutStatus(ctx, client, cfg.ReleaseNamespace, name)
+		})
+	}
+	return gosh.Command(kubectl.RolloutStatus(&cfg.KinkConfig.Kubectl, &cfg.KinkConfig.Kubernetes, "statefulset", name)...).WithContext(ctx)
+}
+
+func kubectlDeletePVCs(ctx context.Context, cfg *resolvedConfigT, labels map[string]string) gosh.Pipelineable {
+	selector := k8slabels.SelectorFromSet(labels).String()
+	if cfg.KinkConfig.Kubectl.Native {
+		return newNativeCmd(ctx, func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+			client, err := hostClient(cfg)
+			if err != nil {
+				return err
+			}
+			pvcs, err := client.CoreV1().PersistentVolumeClaims(cfg.ReleaseNamespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+			if err != nil {
+				return err
+			}
+			for _, pvc := range pvcs.Items {
+				err = client.CoreV1().PersistentVolumeClaims(cfg.ReleaseNamespace).Delete(ctx, pvc.Name, metav1.DeleteOptions{})
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(stdout, "persistentvolumeclaim \"%s\" deleted\n", pvc.Name)
+			}
+			return nil
+		})
+	}
+	return gosh.Command(kubectl.Delete(&cfg.KinkConfig.Kubectl, &cfg.KinkConfig.Kubernetes, "persistentvolumeclaim", "-l"+selector)...).WithContext(ctx)
+}
+
+func dockerSave(ctx context.Context, cfg *resolvedConfigT, images ...string) gosh.Pipelineable {
+	if cfg.KinkConfig.Docker.Native {
+		return newNativeCmd(ctx, func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+			return docker.NativeSave(ctx, &cfg.KinkConfig.Docker, stdout, images...)
+		})
+	}
+	return gosh.Command(docker.Save(&cfg.KinkConfig.Docker, images...)...).WithContext(ctx)
+}
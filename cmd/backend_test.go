@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNativeCmdDeferredAfterRunsWhenBeforeFails(t *testing.T) {
+	ran := false
+	cmd := newNativeCmd(context.Background(), func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+		ran = true
+		return nil
+	})
+	closed := 0
+	cmd.DeferBefore(func() error { return nil })
+	cmd.DeferAfter(func() error { closed++; return nil })
+	cmd.DeferBefore(func() error { return errors.New("before failed") })
+	cmd.DeferAfter(func() error { closed++; return errors.New("after failed") })
+	cmd.DeferAfter(func() error { closed++; return nil })
+
+	err := cmd.Start()
+	if err == nil {
+		t.Fatal("expected Start to fail")
+	}
+	for _, msg := range []string{"before failed", "after failed"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected error to contain %q, got %v", msg, err)
+		}
+	}
+	if closed != 3 {
+		t.Errorf("expected all 3 deferred after functions to run, got %d", closed)
+	}
+	if ran {
+		t.Error("function ran even though a deferred before function failed")
+	}
+}
+
+func TestNativeCmdDeferredAfterError(t *testing.T) {
+	closed := 0
+	cmd := newNativeCmd(context.Background(), func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+		return nil
+	})
+	cmd.DeferAfter(func() error { closed++; return errors.New("first") })
+	cmd.DeferAfter(func() error { closed++; return errors.New("second") })
+	err := cmd.Run()
+	if err == nil || err.Error() != "first" {
+		t.Errorf("expected the first deferred error, got %v", err)
+	}
+	if closed != 2 {
+		t.Errorf("expected both deferred after functions to run, got %d", closed)
+	}
+}
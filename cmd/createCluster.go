@@ -5,17 +5,25 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"time"
 
+	helmctlv1 "github.com/k3s-io/helm-controller/pkg/apis/helm.cattle.io/v1"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 
 	"github.com/spf13/cobra"
 
 	"github.com/meln5674/gosh"
 	"github.com/meln5674/rflag"
-
-	"github.com/meln5674/kink/pkg/helm"
-	"github.com/meln5674/kink/pkg/kubectl"
 )
 
 // createClusterCmd represents the create cluster command
@@ -29,13 +37,27 @@ var createClusterCmd = &cobra.Command{
 	},
 }
 
+const (
+	controlplaneRoleLabel = "node-role.kubernetes.io/control-plane"
+	// coreDNSSelector matches the CoreDNS deployment of both k3s (coredns) and rke2 (rke2-coredns-rke2-coredns)
+	coreDNSSelector              = "k8s-app=kube-dns"
+	localPathProvisionerName     = "local-path-provisioner"
+	systemNamespace              = "kube-system"
+	clusterReadyPollInterval     = 5 * time.Second
+	helmChartResourcePathPattern = "/apis/helm.cattle.io/v1/namespaces/%s/helmcharts/%s"
+)
+
 type createClusterArgsT struct {
 	ExportKubeconfigArgs exportKubeconfigArgsT `rflag:""`
+	WaitTimeout          time.Duration         `rflag:"usage=How long to wait for all nodes and core addons to become ready after the controlplane is healthy. Set to zero to not wait"`
+	SkipPreflight        bool                  `rflag:"usage=Do not run the checks of 'kink doctor' before deploying the chart"`
+	DryRun               bool                  `rflag:"usage=Print the changes deploying the chart would make to the host cluster,, and which are disruptive,, instead of making them"`
 }
 
 func (createClusterArgsT) Defaults() createClusterArgsT {
 	return createClusterArgsT{
 		ExportKubeconfigArgs: exportKubeconfigArgsT{}.Defaults(),
+		WaitTimeout:          10 * time.Minute,
 	}
 }
 
@@ -48,45 +70,41 @@ func init() {
 }
 
 func createCluster(ctx context.Context, args *createClusterArgsT, cfg *resolvedConfigT) error {
-	if cfg.KinkConfig.Chart.IsLocalChart() {
-		klog.Info("Using local chart, skipping `repo add`...")
-	} else {
-		klog.Info("Ensuring helm repo exists...")
-		repoAdd := helm.RepoAdd(&cfg.KinkConfig.Helm, &cfg.KinkConfig.Chart)
-		err := gosh.
-			Command(repoAdd...).
-			WithContext(ctx).
-			WithStreams(gosh.ForwardOutErr).
-			Run()
+	if args.DryRun {
+		return planCluster(ctx, os.Stdout, cfg)
+	}
+
+	if !args.SkipPreflight {
+		err := preflight(ctx, cfg)
 		if err != nil {
 			return err
 		}
-
+	} else {
+		klog.Info("Preflight checks skipped by flag")
 	}
 
-	klog.Info("Deploying chart...")
-	helmUpgrade := helm.UpgradeCluster(&cfg.KinkConfig.Helm, &cfg.KinkConfig.Chart, &cfg.KinkConfig.Release, &cfg.KinkConfig.Kubernetes)
-	err := gosh.
-		Command(helmUpgrade...).
-		WithContext(ctx).
-		WithStreams(gosh.ForwardOutErr).
-		Run()
+	err := deployCluster(ctx, cfg)
 	if err != nil {
 		return err
 	}
 	klog.Info("Deployed chart, waiting for controlplane to be healthy")
 
-	controlplaneRollout := kubectl.RolloutStatus(&cfg.KinkConfig.Kubectl, &cfg.KinkConfig.Kubernetes, "statefulset", cfg.ReleaseConfig.ControlplaneFullname)
-	err = gosh.
-		Command(controlplaneRollout...).
-		WithContext(ctx).
-		WithStreams(gosh.ForwardOutErr).
-		Run()
+	err = withStreams(kubectlStatefulSetRolloutStatus(ctx, cfg, cfg.ReleaseConfig.ControlplaneFullname), gosh.ForwardOutErr).Run()
 	if err != nil {
 		return err
 	}
 
-	klog.Info("Controlplane is healthy, your cluster is now ready to use")
+	if args.WaitTimeout != 0 {
+		klog.Info("Controlplane is healthy, waiting for nodes and core addons to be ready")
+		err = waitForClusterReady(ctx, args.WaitTimeout, &args.ExportKubeconfigArgs.Common, cfg)
+		if err != nil {
+			return err
+		}
+		klog.Info("All nodes and core addons are ready, your cluster is now ready to use")
+	} else {
+		klog.Info("Controlplane is healthy, your cluster is now ready to use")
+	}
+
 	if args.ExportKubeconfigArgs.KubeconfigToExportPath == "" {
 		return nil
 	}
@@ -96,3 +114,165 @@ func createCluster(ctx context.Context, args *createClusterArgsT, cfg *resolvedC
 	}
 	return nil
 }
+
+// deployCluster installs or upgrades the release for a cluster.
+// This waits for the release, but does not wait for the controlplane to become healthy.
+func deployCluster(ctx context.Context, cfg *resolvedConfigT) error {
+	if cfg.KinkConfig.Chart.IsLocalChart() {
+		klog.Info("Using local chart, skipping `repo add`...")
+	} else {
+		klog.Info("Ensuring helm repo exists...")
+		err := withStreams(helmRepoAdd(ctx, cfg), gosh.ForwardOutErr).Run()
+		if err != nil {
+			return err
+		}
+
+	}
+
+	klog.Info("Deploying chart...")
+	return withStreams(helmUpgradeCluster(ctx, cfg), gosh.ForwardOutErr).Run()
+}
+
+// waitForClusterReady connects to the cluster and waits until every worker has registered as a node, every node is
+// ready, and the bundled CoreDNS and local-path-provisioner are available.
+func waitForClusterReady(ctx context.Context, timeout time.Duration, args *exportKubeconfigCommonArgsT, cfg *resolvedConfigT) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	host, err := hostClient(cfg)
+	if err != nil {
+		return err
+	}
+	workers, err := host.AppsV1().StatefulSets(cfg.ReleaseNamespace).Get(ctx, cfg.ReleaseConfig.WorkerFullname, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "Failed to get worker statefulset")
+	}
+	expectedWorkers := statefulSetReplicas(workers)
+
+	guest, stop, err := guestClient(ctx, &execArgsT{ExportKubeconfig: *args, PortForward: !args.InCluster}, cfg)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	checks := []struct {
+		name  string
+		check func(context.Context, kubernetes.Interface) (bool, string, error)
+	}{
+		{name: "nodes", check: func(ctx context.Context, guest kubernetes.Interface) (bool, string, error) {
+			return checkNodesReady(ctx, guest, expectedWorkers)
+		}},
+		{name: "CoreDNS", check: checkCoreDNSReady},
+		{name: "local-path-provisioner", check: checkLocalPathProvisionerReady},
+	}
+	for _, check := range checks {
+		err = wait.PollUntilContextCancel(ctx, clusterReadyPollInterval, true, func(ctx context.Context) (bool, error) {
+			ready, progress, err := check.check(ctx, guest)
+			if err != nil {
+				// The API may be briefly unavailable while nodes join, so errors are only logged
+				klog.Warningf("Failed to check %s: %s", check.name, err)
+				return false, nil
+			}
+			if !ready {
+				klog.Infof("Waiting for %s: %s", check.name, progress)
+			}
+			return ready, nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "%s did not become ready within %s", check.name, timeout)
+		}
+		klog.Infof("%s ready", check.name)
+	}
+	return nil
+}
+
+// checkNodesReady checks that at least the expected number of workers have registered, and that all nodes,
+// including the controlplane, are ready. Cordoned nodes count as ready, since an upgrade cordons them.
+func checkNodesReady(ctx context.Context, guest kubernetes.Interface, expectedWorkers int32) (bool, string, error) {
+	nodes, err := guest.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, "", err
+	}
+	var ready, readyWorkers int32
+	for ix := range nodes.Items {
+		node := &nodes.Items[ix]
+		if !nodeReady(node) {
+			continue
+		}
+		ready++
+		if _, isControlplane := node.Labels[controlplaneRoleLabel]; !isControlplane {
+			readyWorkers++
+		}
+	}
+	progress := fmt.Sprintf("%d of %d registered nodes are ready, %d of %d workers are ready", ready, len(nodes.Items), readyWorkers, expectedWorkers)
+	return ready == int32(len(nodes.Items)) && readyWorkers >= expectedWorkers, progress, nil
+}
+
+func checkCoreDNSReady(ctx context.Context, guest kubernetes.Interface) (bool, string, error) {
+	deploys, err := guest.AppsV1().Deployments(systemNamespace).List(ctx, metav1.ListOptions{LabelSelector: coreDNSSelector})
+	if err != nil {
+		return false, "", err
+	}
+	if len(deploys.Items) == 0 {
+		return false, "deployment has not been created yet", nil
+	}
+	for ix := range deploys.Items {
+		ready, progress, err := deploymentAvailable(&deploys.Items[ix])
+		if err != nil || !ready {
+			return ready, fmt.Sprintf("%s: %s", deploys.Items[ix].Name, progress), err
+		}
+	}
+	return true, "", nil
+}
+
+// checkLocalPathProvisionerReady checks that the HelmChart for the local-path-provisioner has been installed, and that
+// its deployment is available
+func checkLocalPathProvisionerReady(ctx context.Context, guest kubernetes.Interface) (bool, string, error) {
+	chartJSON, err := guest.
+		Discovery().
+		RESTClient().
+		Get().
+		AbsPath(fmt.Sprintf(helmChartResourcePathPattern, systemNamespace, localPathProvisionerName)).
+		DoRaw(ctx)
+	if kerrors.IsNotFound(err) {
+		return false, "HelmChart has not been created yet", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	var chart helmctlv1.HelmChart
+	err = json.Unmarshal(chartJSON, &chart)
+	if err != nil {
+		return false, "", err
+	}
+	if chart.Status.JobName == "" {
+		return false, "HelmChart has not been picked up by the helm controller yet", nil
+	}
+	job, err := guest.BatchV1().Jobs(systemNamespace).Get(ctx, chart.Status.JobName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return false, fmt.Sprintf("install job %s has not been created yet", chart.Status.JobName), nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	if job.Status.Succeeded == 0 {
+		return false, fmt.Sprintf("install job %s has not completed", chart.Status.JobName), nil
+	}
+	deploy, err := guest.AppsV1().Deployments(systemNamespace).Get(ctx, localPathProvisionerName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return false, "deployment has not been created yet", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	return deploymentAvailable(deploy)
+}
+
+func deploymentAvailable(deploy *appsv1.Deployment) (bool, string, error) {
+	for _, condition := range deploy.Status.Conditions {
+		if condition.Type == appsv1.DeploymentAvailable && condition.Status == corev1.ConditionTrue {
+			return true, "", nil
+		}
+	}
+	return false, fmt.Sprintf("%d of %d pods are available", deploy.Status.AvailableReplicas, deploy.Status.Replicas), nil
+}
@@ -0,0 +1,625 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	planActionCreate    = "create"
+	planActionUpdate    = "update"
+	planActionDelete    = "delete"
+	planActionUnchanged = "unchanged"
+
+	helmReleaseNameAnnotation = "meta.helm.sh/release-name"
+
+	planDiffContextLines = 3
+)
+
+// planChange is the change that deploying the chart would make to a single resource in the host cluster
+type planChange struct {
+	kind      string
+	namespace string
+	name      string
+	action    string
+	// diff is a unified diff from the live resource, or the previous release's copy if it does not exist, to the
+	// rendered resource
+	diff []string
+	// disruptions are changes which will interrupt the cluster, such as restarting the controlplane
+	disruptions []string
+	// failures are changes which the host cluster will reject, causing the upgrade to fail
+	failures []string
+}
+
+type planKey struct {
+	group, kind, namespace, name string
+}
+
+func planKeyOf(obj *unstructured.Unstructured) planKey {
+	gvk := obj.GroupVersionKind()
+	return planKey{group: gvk.Group, kind: gvk.Kind, namespace: obj.GetNamespace(), name: obj.GetName()}
+}
+
+// planCluster renders the chart, compares it with the previous release and the live resources in the host cluster,
+// and prints what deploying it would change, without changing anything
+func planCluster(ctx context.Context, out io.Writer, cfg *resolvedConfigT) error {
+	rendered, err := renderClusterManifests(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("Failed to render chart: %w", err)
+	}
+	previous, err := previousReleaseManifests(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	host, err := hostClient(cfg)
+	if err != nil {
+		return err
+	}
+	dyn, err := dynamic.NewForConfig(cfg.Kubeconfig)
+	if err != nil {
+		return err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(host.Discovery()))
+	releaseName := cfg.KinkConfig.Release.Raw().Name
+
+	// helm template does not set the namespace of resources which do not set it themselves, but helm upgrade does
+	resolveMapping := func(obj *unstructured.Unstructured) (*meta.RESTMapping, error) {
+		gvk := obj.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return nil, err
+		}
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace && obj.GetNamespace() == "" {
+			obj.SetNamespace(cfg.ReleaseNamespace)
+		}
+		return mapping, nil
+	}
+	getLive := func(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+		mapping, err := resolveMapping(obj)
+		if err != nil {
+			return nil, err
+		}
+		var resource dynamic.ResourceInterface = dyn.Resource(mapping.Resource)
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			resource = dyn.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+		}
+		live, err := resource.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get %s %s", obj.GetKind(), obj.GetName())
+		}
+		return live, nil
+	}
+
+	previousByKey := make(map[planKey]*unstructured.Unstructured, len(previous))
+	for ix := range previous {
+		obj := &previous[ix]
+		if _, err := resolveMapping(obj); err != nil && !meta.IsNoMatchError(err) {
+			return err
+		}
+		previousByKey[planKeyOf(obj)] = obj
+	}
+
+	changes := make([]planChange, 0, len(rendered))
+	for ix := range rendered {
+		obj := &rendered[ix]
+		live, err := getLive(obj)
+		if meta.IsNoMatchError(err) {
+			changes = append(changes, planChange{
+				kind:     obj.GetKind(),
+				name:     obj.GetName(),
+				action:   planActionCreate,
+				failures: []string{fmt.Sprintf("The host cluster does not serve %s", obj.GroupVersionKind())},
+			})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		key := planKeyOf(obj)
+		prev := previousByKey[key]
+		delete(previousByKey, key)
+		change, err := planResource(obj, prev, live, releaseName)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, *change)
+	}
+	for ix := range previous {
+		obj := &previous[ix]
+		if _, ok := previousByKey[planKeyOf(obj)]; !ok {
+			continue
+		}
+		change, err := planResource(nil, obj, nil, releaseName)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, *change)
+	}
+
+	if len(previous) == 0 {
+		fmt.Fprintf(out, "Release %s does not exist, it will be installed\n\n", releaseName)
+	}
+	printPlan(out, changes)
+	return nil
+}
+
+// previousReleaseManifests returns the resources of the current revision of the release, or none if it has not
+// been installed
+func previousReleaseManifests(ctx context.Context, cfg *resolvedConfigT) ([]unstructured.Unstructured, error) {
+	releaseName := cfg.KinkConfig.Release.Raw().Name
+	releases, err := helmListReleases(ctx, cfg, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list releases")
+	}
+	found := false
+	for _, release := range releases {
+		if release.Name == releaseName {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	manifests, err := decodeManifests(helmGetManifest(ctx, cfg))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get manifest of release %s", releaseName)
+	}
+	return manifests, nil
+}
+
+// planResource determines the change to a single resource. rendered is nil if the resource will be deleted, previous
+// is nil if it was not part of the previous release, and live is nil if it does not exist in the host cluster.
+func planResource(rendered, previous, live *unstructured.Unstructured, releaseName string) (*planChange, error) {
+	change := planChange{}
+	if rendered == nil {
+		change.kind, change.namespace, change.name = previous.GetKind(), previous.GetNamespace(), previous.GetName()
+		change.action = planActionDelete
+		from, err := yaml.Marshal(maskSecretData(previous.Object))
+		if err != nil {
+			return nil, err
+		}
+		change.diff = unifiedDiff(string(from), "", "previous", "(none)")
+		switch previous.GetKind() {
+		case "StatefulSet":
+			change.disruptions = append(change.disruptions, "The statefulset and its pods will be deleted, but not its persistent volume claims")
+		case "PersistentVolumeClaim":
+			change.disruptions = append(change.disruptions, "The persistent volume claim will be deleted, along with its data if its reclaim policy is Delete")
+		case "Service":
+			change.disruptions = append(change.disruptions, "The service will be deleted, and anything which uses its address will no longer be able to reach the cluster")
+		}
+		return &change, nil
+	}
+
+	change.kind, change.namespace, change.name = rendered.GetKind(), rendered.GetNamespace(), rendered.GetName()
+	from, to := map[string]interface{}{}, rendered.Object
+	fromName := "previous"
+	switch {
+	case live != nil:
+		// Only the fields which the chart sets are compared, as the live resource also has defaults and status
+		liveAligned, renderedAligned := alignToLive(rendered.Object, live.Object)
+		from, to = liveAligned.(map[string]interface{}), renderedAligned.(map[string]interface{})
+		fromName = "live"
+		if previous == nil && live.GetAnnotations()[helmReleaseNameAnnotation] != releaseName {
+			change.failures = append(change.failures, fmt.Sprintf("%s %s already exists, but is not part of release %s, so helm will refuse to replace it", live.GetKind(), live.GetName(), releaseName))
+		}
+	case previous != nil:
+		from = previous.Object
+		change.disruptions = append(change.disruptions, "This was part of the previous release, but was deleted from the host cluster, and will be recreated")
+	}
+
+	fromYAML, err := yaml.Marshal(maskSecretData(from))
+	if err != nil {
+		return nil, err
+	}
+	toYAML, err := yaml.Marshal(maskSecretData(to))
+	if err != nil {
+		return nil, err
+	}
+	if live == nil && previous == nil {
+		fromYAML = nil
+		fromName = "(none)"
+	}
+	change.diff = unifiedDiff(string(fromYAML), string(toYAML), fromName, "rendered")
+	switch {
+	case live == nil:
+		change.action = planActionCreate
+	case len(change.diff) == 0:
+		change.action = planActionUnchanged
+	default:
+		change.action = planActionUpdate
+	}
+
+	if live != nil {
+		err = planDisruptions(&change, rendered, live, from, to)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &change, nil
+}
+
+// maskSecretData returns a copy of a resource which, if it is a Secret, has a hash of each of its values instead of the
+// value itself, so that the diff shows which keys change without printing them, such as the cluster token
+func maskSecretData(obj map[string]interface{}) map[string]interface{} {
+	if kind, _ := obj["kind"].(string); kind != "Secret" {
+		return obj
+	}
+	masked := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		masked[k] = v
+	}
+	for _, field := range []string{"data", "stringData"} {
+		values, ok := obj[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		maskedValues := make(map[string]interface{}, len(values))
+		for k, v := range values {
+			str, _ := v.(string)
+			value := []byte(str)
+			// Hash the decoded value, so that the same value in data and stringData has the same hash
+			if decoded, err := base64.StdEncoding.DecodeString(str); field == "data" && err == nil {
+				value = decoded
+			}
+			sum := sha256.Sum256(value)
+			maskedValues[k] = fmt.Sprintf("(redacted, sha256:%x)", sum[:8])
+		}
+		masked[field] = maskedValues
+	}
+	return masked
+}
+
+// planDisruptions determines which changes to an existing resource will interrupt the cluster, or be rejected by the
+// host cluster. liveAligned and renderedAligned are the results of alignToLive.
+func planDisruptions(change *planChange, rendered, live *unstructured.Unstructured, liveAligned, renderedAligned map[string]interface{}) error {
+	switch rendered.GetKind() {
+	case "StatefulSet":
+		var r, l appsv1.StatefulSet
+		err := fromUnstructuredPair(rendered, live, &r, &l)
+		if err != nil {
+			return err
+		}
+		if !yamlEqual(r.Spec.Selector, l.Spec.Selector) {
+			change.failures = append(change.failures, "spec.selector cannot be changed")
+		}
+		if r.Spec.ServiceName != l.Spec.ServiceName {
+			change.failures = append(change.failures, fmt.Sprintf("spec.serviceName cannot be changed from %s to %s", l.Spec.ServiceName, r.Spec.ServiceName))
+		}
+		if r.Spec.PodManagementPolicy != "" && r.Spec.PodManagementPolicy != l.Spec.PodManagementPolicy {
+			change.failures = append(change.failures, fmt.Sprintf("spec.podManagementPolicy cannot be changed from %s to %s", l.Spec.PodManagementPolicy, r.Spec.PodManagementPolicy))
+		}
+		if !claimTemplatesEqual(r.Spec.VolumeClaimTemplates, l.Spec.VolumeClaimTemplates) {
+			change.failures = append(change.failures, "spec.volumeClaimTemplates cannot be changed. To change them anyways, first run 'kubectl delete statefulset --cascade=orphan' to delete the statefulset without deleting its pods, and manually update its existing persistent volume claims")
+		}
+		liveTemplate, _, _ := unstructured.NestedFieldNoCopy(liveAligned, "spec", "template")
+		renderedTemplate, _, _ := unstructured.NestedFieldNoCopy(renderedAligned, "spec", "template")
+		if !yamlEqual(liveTemplate, renderedTemplate) {
+			replicas := statefulSetReplicas(&r)
+			msg := fmt.Sprintf("The pod template changes, so its pods (%d) will be restarted, one at a time", replicas)
+			if rendered.GetLabels()[nodeComponentLabel] == nodeRoleControlplane && replicas == 1 {
+				msg += ". There is only one controlplane node, so the API server will be unavailable while it restarts"
+			}
+			change.disruptions = append(change.disruptions, msg)
+		}
+		if renderedReplicas, liveReplicas := statefulSetReplicas(&r), statefulSetReplicas(&l); renderedReplicas < liveReplicas {
+			change.disruptions = append(change.disruptions, fmt.Sprintf("Scales down from %d to %d replicas, removing nodes. Their persistent volume claims are kept", liveReplicas, renderedReplicas))
+		}
+	case "Deployment":
+		var r, l appsv1.Deployment
+		err := fromUnstructuredPair(rendered, live, &r, &l)
+		if err != nil {
+			return err
+		}
+		if !yamlEqual(r.Spec.Selector, l.Spec.Selector) {
+			change.failures = append(change.failures, "spec.selector cannot be changed")
+		}
+	case "Service":
+		var r, l corev1.Service
+		err := fromUnstructuredPair(rendered, live, &r, &l)
+		if err != nil {
+			return err
+		}
+		renderedType := r.Spec.Type
+		if renderedType == "" {
+			renderedType = corev1.ServiceTypeClusterIP
+		}
+		if renderedType != l.Spec.Type {
+			change.disruptions = append(change.disruptions, fmt.Sprintf("The type changes from %s to %s, so the addresses and ports used to reach it may change", l.Spec.Type, renderedType))
+		}
+		if r.Spec.ClusterIP != "" && r.Spec.ClusterIP != l.Spec.ClusterIP {
+			change.failures = append(change.failures, fmt.Sprintf("spec.clusterIP cannot be changed from %s to %s", l.Spec.ClusterIP, r.Spec.ClusterIP))
+		}
+	case "PersistentVolumeClaim":
+		var r, l corev1.PersistentVolumeClaim
+		err := fromUnstructuredPair(rendered, live, &r, &l)
+		if err != nil {
+			return err
+		}
+		if r.Spec.StorageClassName != nil && (l.Spec.StorageClassName == nil || *r.Spec.StorageClassName != *l.Spec.StorageClassName) {
+			change.failures = append(change.failures, "spec.storageClassName cannot be changed")
+		}
+		if !yamlEqual(r.Spec.AccessModes, l.Spec.AccessModes) {
+			change.failures = append(change.failures, "spec.accessModes cannot be changed")
+		}
+		if r.Spec.Resources.Requests.Storage().Cmp(*l.Spec.Resources.Requests.Storage()) < 0 {
+			change.failures = append(change.failures, fmt.Sprintf("The requested storage cannot be reduced from %s to %s", l.Spec.Resources.Requests.Storage(), r.Spec.Resources.Requests.Storage()))
+		}
+	}
+	return nil
+}
+
+func fromUnstructuredPair(rendered, live *unstructured.Unstructured, r, l interface{}) error {
+	err := kruntime.DefaultUnstructuredConverter.FromUnstructured(rendered.Object, r)
+	if err != nil {
+		return fmt.Errorf("Invalid %s %s in chart: %w", rendered.GetKind(), rendered.GetName(), err)
+	}
+	err = kruntime.DefaultUnstructuredConverter.FromUnstructured(live.Object, l)
+	if err != nil {
+		return fmt.Errorf("Invalid %s %s in host cluster: %w", live.GetKind(), live.GetName(), err)
+	}
+	return nil
+}
+
+// claimTemplatesEqual compares the fields of persistent volume claim templates which the chart sets, ignoring those
+// which the API server defaults
+func claimTemplatesEqual(rendered, live []corev1.PersistentVolumeClaim) bool {
+	if len(rendered) != len(live) {
+		return false
+	}
+	for ix := range rendered {
+		r, l := &rendered[ix], &live[ix]
+		if r.Name != l.Name || !yamlEqual(r.Spec.AccessModes, l.Spec.AccessModes) {
+			return false
+		}
+		if r.Spec.StorageClassName != nil && (l.Spec.StorageClassName == nil || *r.Spec.StorageClassName != *l.Spec.StorageClassName) {
+			return false
+		}
+		if r.Spec.Resources.Requests.Storage().Cmp(*l.Spec.Resources.Requests.Storage()) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// yamlEqual compares two values by their YAML, which ignores differences such as int64 vs float64 and nil vs empty
+func yamlEqual(a, b interface{}) bool {
+	aYAML, err := yaml.Marshal(a)
+	if err != nil {
+		klog.V(1).Info(err)
+		return false
+	}
+	bYAML, err := yaml.Marshal(b)
+	if err != nil {
+		klog.V(1).Info(err)
+		return false
+	}
+	return string(aYAML) == string(bYAML)
+}
+
+// alignToLive returns the fields of a live resource which are also set by its rendered copy, so that the two can be
+// compared without the defaults and status the API server adds, as well as the rendered resource without any empty
+// fields which the API server would drop
+func alignToLive(rendered, live interface{}) (interface{}, interface{}) {
+	switch r := rendered.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return live, rendered
+		}
+		liveOut := make(map[string]interface{}, len(r))
+		renderedOut := make(map[string]interface{}, len(r))
+		for k, rv := range r {
+			lv, ok := l[k]
+			if !ok {
+				if isEmptyValue(rv) {
+					continue
+				}
+				renderedOut[k] = rv
+				continue
+			}
+			liveOut[k], renderedOut[k] = alignToLive(rv, lv)
+		}
+		return liveOut, renderedOut
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			return live, rendered
+		}
+		liveOut := make([]interface{}, len(l))
+		renderedOut := make([]interface{}, len(r))
+		for ix := range l {
+			if ix < len(r) {
+				liveOut[ix], renderedOut[ix] = alignToLive(r[ix], l[ix])
+			} else {
+				liveOut[ix] = l[ix]
+			}
+		}
+		for ix := len(l); ix < len(r); ix++ {
+			renderedOut[ix] = r[ix]
+		}
+		return liveOut, renderedOut
+	default:
+		return live, rendered
+	}
+}
+
+func isEmptyValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	case string:
+		return v == ""
+	default:
+		return false
+	}
+}
+
+func printPlan(out io.Writer, changes []planChange) {
+	counts := make(map[string]int)
+	disruptions, failures := 0, 0
+	for _, change := range changes {
+		counts[change.action]++
+		disruptions += len(change.disruptions)
+		failures += len(change.failures)
+		if change.action == planActionUnchanged && len(change.disruptions) == 0 && len(change.failures) == 0 {
+			continue
+		}
+		marker := "~"
+		switch change.action {
+		case planActionCreate:
+			marker = "+"
+		case planActionDelete:
+			marker = "-"
+		}
+		fmt.Fprintf(out, "%s %s %s (%s)\n", marker, change.kind, change.name, change.action)
+		for _, failure := range change.failures {
+			fmt.Fprintf(out, "  ! WILL FAIL: %s\n", failure)
+		}
+		for _, disruption := range change.disruptions {
+			fmt.Fprintf(out, "  ! DISRUPTIVE: %s\n", disruption)
+		}
+		for _, line := range change.diff {
+			fmt.Fprintf(out, "    %s\n", line)
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintf(out, "Plan: %d to create, %d to update, %d to delete, %d unchanged\n",
+		counts[planActionCreate], counts[planActionUpdate], counts[planActionDelete], counts[planActionUnchanged])
+	if disruptions != 0 {
+		fmt.Fprintf(out, "%d disruptive change(s)\n", disruptions)
+	}
+	if failures != 0 {
+		fmt.Fprintf(out, "%d change(s) will be rejected by the host cluster, causing the upgrade to fail\n", failures)
+	}
+}
+
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff returns the lines of a unified diff between two texts, or nil if they are the same
+func unifiedDiff(from, to, fromName, toName string) []string {
+	a, b := splitLines(from), splitLines(to)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	ops := make([]diffLine, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffLine{op: ' ', text: a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffLine{op: '-', text: a[i]})
+			i++
+		default:
+			ops = append(ops, diffLine{op: '+', text: b[j]})
+			j++
+		}
+	}
+
+	// aLines[k] and bLines[k] are the number of lines of each text before ops[k]
+	aLines, bLines := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for k, op := range ops {
+		aLines[k+1], bLines[k+1] = aLines[k], bLines[k]
+		if op.op != '+' {
+			aLines[k+1]++
+		}
+		if op.op != '-' {
+			bLines[k+1]++
+		}
+	}
+
+	var out []string
+	for k := 0; k < len(ops); {
+		if ops[k].op == ' ' {
+			k++
+			continue
+		}
+		start := k - planDiffContextLines
+		if start < 0 {
+			start = 0
+		}
+		// Extend the hunk until there are enough unchanged lines to separate it from the next change
+		end := k + 1
+		for l := k + 1; l < len(ops) && l-end < 2*planDiffContextLines; l++ {
+			if ops[l].op != ' ' {
+				end = l + 1
+			}
+		}
+		end += planDiffContextLines
+		if end > len(ops) {
+			end = len(ops)
+		}
+		if out == nil {
+			out = []string{"--- " + fromName, "+++ " + toName}
+		}
+		out = append(out, fmt.Sprintf("@@ -%s +%s @@", hunkRange(aLines[start], aLines[end]), hunkRange(bLines[start], bLines[end])))
+		for _, op := range ops[start:end] {
+			out = append(out, string(op.op)+op.text)
+		}
+		k = end
+	}
+	return out
+}
+
+func hunkRange(start, end int) string {
+	if start == end {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, end-start)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,302 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	cases := []struct {
+		name     string
+		from, to string
+		expected []string
+	}{
+		{
+			name:     "unchanged",
+			from:     "a\nb\n",
+			to:       "a\nb\n",
+			expected: nil,
+		},
+		{
+			name:     "created",
+			from:     "",
+			to:       "a\nb\n",
+			expected: []string{"--- from", "+++ to", "@@ -0,0 +1,2 @@", "+a", "+b"},
+		},
+		{
+			name:     "deleted",
+			from:     "a\n",
+			to:       "",
+			expected: []string{"--- from", "+++ to", "@@ -1,1 +0,0 @@", "-a"},
+		},
+		{
+			name:     "changed line with context",
+			from:     "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			to:       "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			expected: []string{"--- from", "+++ to", "@@ -2,7 +2,7 @@", " 2", " 3", " 4", "-5", "+five", " 6", " 7", " 8"},
+		},
+		{
+			name: "separate hunks",
+			from: "a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n",
+			to:   "A\n1\n2\n3\n4\n5\n6\n7\n8\nB\n",
+			expected: []string{
+				"--- from", "+++ to",
+				"@@ -1,4 +1,4 @@", "-a", "+A", " 1", " 2", " 3",
+				"@@ -7,4 +7,4 @@", " 6", " 7", " 8", "-b", "+B",
+			},
+		},
+		{
+			name: "nearby changes share a hunk",
+			from: "a\n1\n2\n3\nb\n",
+			to:   "A\n1\n2\n3\nB\n",
+			expected: []string{
+				"--- from", "+++ to",
+				"@@ -1,5 +1,5 @@", "-a", "+A", " 1", " 2", " 3", "-b", "+B",
+			},
+		},
+	}
+	for _, c := range cases {
+		actual := unifiedDiff(c.from, c.to, "from", "to")
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("%s: expected\n%s\ngot\n%s", c.name, strings.Join(c.expected, "\n"), strings.Join(actual, "\n"))
+		}
+	}
+}
+
+func testYAMLObject(t *testing.T, s string) map[string]interface{} {
+	obj := map[string]interface{}{}
+	err := yaml.Unmarshal([]byte(s), &obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestAlignToLive(t *testing.T) {
+	cases := []struct {
+		name                           string
+		rendered, live                 string
+		expectedLive, expectedRendered string
+	}{
+		{
+			name:             "defaults and status are ignored",
+			rendered:         "spec: {replicas: 1}",
+			live:             "spec: {replicas: 1, revisionHistoryLimit: 10}\nstatus: {readyReplicas: 1}",
+			expectedLive:     "spec: {replicas: 1}",
+			expectedRendered: "spec: {replicas: 1}",
+		},
+		{
+			name:             "empty rendered fields are dropped",
+			rendered:         "metadata: {name: a, annotations: {}, labels: null}",
+			live:             "metadata: {name: a}",
+			expectedLive:     "metadata: {name: a}",
+			expectedRendered: "metadata: {name: a}",
+		},
+		{
+			name:             "new rendered fields are kept",
+			rendered:         "spec: {replicas: 2, paused: true}",
+			live:             "spec: {replicas: 1}",
+			expectedLive:     "spec: {replicas: 1}",
+			expectedRendered: "spec: {replicas: 2, paused: true}",
+		},
+		{
+			name:             "lists are aligned by index",
+			rendered:         "ports: [{port: 80}, {port: 443}]",
+			live:             "ports: [{port: 80, protocol: TCP}]",
+			expectedLive:     "ports: [{port: 80}]",
+			expectedRendered: "ports: [{port: 80}, {port: 443}]",
+		},
+		{
+			name:             "removed list items are kept",
+			rendered:         "ports: [{port: 80}]",
+			live:             "ports: [{port: 80, protocol: TCP}, {port: 443, protocol: TCP}]",
+			expectedLive:     "ports: [{port: 80}, {port: 443, protocol: TCP}]",
+			expectedRendered: "ports: [{port: 80}]",
+		},
+		{
+			name:             "type changes",
+			rendered:         "value: {a: b}",
+			live:             "value: c",
+			expectedLive:     "value: c",
+			expectedRendered: "value: {a: b}",
+		},
+	}
+	for _, c := range cases {
+		live, rendered := alignToLive(testYAMLObject(t, c.rendered), testYAMLObject(t, c.live))
+		if !yamlEqual(live, testYAMLObject(t, c.expectedLive)) {
+			t.Errorf("%s: expected live %s, got %#v", c.name, c.expectedLive, live)
+		}
+		if !yamlEqual(rendered, testYAMLObject(t, c.expectedRendered)) {
+			t.Errorf("%s: expected rendered %s, got %#v", c.name, c.expectedRendered, rendered)
+		}
+	}
+}
+
+func TestPlanDisruptions(t *testing.T) {
+	const statefulSet = `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata: {name: kink-test-controlplane, labels: {app.kubernetes.io/component: controlplane}}
+spec:
+  replicas: 1
+  serviceName: kink-test-controlplane
+  selector: {matchLabels: {app: controlplane}}
+  template: {spec: {containers: [{name: node, image: k3s:v1}]}}
+  volumeClaimTemplates: [{metadata: {name: data}, spec: {accessModes: [ReadWriteOnce], resources: {requests: {storage: 1Gi}}}}]
+`
+	const service = `
+apiVersion: v1
+kind: Service
+metadata: {name: kink-test-lb}
+spec: {type: ClusterIP, clusterIP: 10.0.0.1, ports: [{port: 6443}]}
+`
+	const claim = `
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata: {name: kink-test-shared}
+spec: {storageClassName: standard, accessModes: [ReadWriteMany], resources: {requests: {storage: 2Gi}}}
+`
+	setImage := func(obj map[string]interface{}) {
+		containers, _, _ := unstructured.NestedSlice(obj, "spec", "template", "spec", "containers")
+		containers[0].(map[string]interface{})["image"] = "k3s:v2"
+		unstructured.SetNestedSlice(obj, containers, "spec", "template", "spec", "containers")
+	}
+	cases := []struct {
+		name string
+		base string
+		// editLive and editRendered change the live and rendered copies of base, respectively
+		editLive, editRendered func(obj map[string]interface{})
+		disruptions, failures  []string
+	}{
+		{
+			name: "unchanged statefulset",
+			base: statefulSet,
+		},
+		{
+			name:         "statefulset template",
+			base:         statefulSet,
+			editRendered: setImage,
+			disruptions:  []string{"pods (1) will be restarted", "only one controlplane node"},
+		},
+		{
+			name: "statefulset scale down",
+			base: statefulSet,
+			editLive: func(obj map[string]interface{}) {
+				unstructured.SetNestedField(obj, int64(3), "spec", "replicas")
+			},
+			disruptions: []string{"Scales down from 3 to 1"},
+		},
+		{
+			name: "statefulset immutable fields",
+			base: statefulSet,
+			editRendered: func(obj map[string]interface{}) {
+				unstructured.SetNestedField(obj, "other", "spec", "serviceName")
+				unstructured.SetNestedField(obj, map[string]interface{}{"app": "other"}, "spec", "selector", "matchLabels")
+				templates, _, _ := unstructured.NestedSlice(obj, "spec", "volumeClaimTemplates")
+				unstructured.SetNestedField(templates[0].(map[string]interface{}), "2Gi", "spec", "resources", "requests", "storage")
+				unstructured.SetNestedSlice(obj, templates, "spec", "volumeClaimTemplates")
+			},
+			failures: []string{"spec.serviceName", "spec.selector", "spec.volumeClaimTemplates"},
+		},
+		{
+			name: "service type",
+			base: service,
+			editRendered: func(obj map[string]interface{}) {
+				unstructured.SetNestedField(obj, "NodePort", "spec", "type")
+			},
+			disruptions: []string{"from ClusterIP to NodePort"},
+		},
+		{
+			name: "service cluster IP",
+			base: service,
+			editRendered: func(obj map[string]interface{}) {
+				unstructured.SetNestedField(obj, "10.0.0.2", "spec", "clusterIP")
+			},
+			failures: []string{"spec.clusterIP"},
+		},
+		{
+			name: "claim shrink and class",
+			base: claim,
+			editRendered: func(obj map[string]interface{}) {
+				unstructured.SetNestedField(obj, "1Gi", "spec", "resources", "requests", "storage")
+				unstructured.SetNestedField(obj, "fast", "spec", "storageClassName")
+			},
+			failures: []string{"spec.storageClassName", "cannot be reduced"},
+		},
+	}
+	for _, c := range cases {
+		live := &unstructured.Unstructured{Object: testYAMLObject(t, c.base)}
+		rendered := live.DeepCopy()
+		if c.editLive != nil {
+			c.editLive(live.Object)
+		}
+		if c.editRendered != nil {
+			c.editRendered(rendered.Object)
+		}
+		liveAligned, renderedAligned := alignToLive(rendered.Object, live.Object)
+		change := planChange{}
+		err := planDisruptions(&change, rendered, live, liveAligned.(map[string]interface{}), renderedAligned.(map[string]interface{}))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		checkMessages(t, c.name+" disruptions", change.disruptions, c.disruptions)
+		checkMessages(t, c.name+" failures", change.failures, c.failures)
+	}
+}
+
+// checkMessages checks that there is a message containing each of the expected substrings, and no messages if none
+// are expected
+func checkMessages(t *testing.T, name string, actual, expected []string) {
+	t.Helper()
+	all := strings.Join(actual, "\n")
+	for _, substr := range expected {
+		if !strings.Contains(all, substr) {
+			t.Errorf("%s: expected a message containing %q, got %q", name, substr, actual)
+		}
+	}
+	if len(expected) == 0 && len(actual) != 0 {
+		t.Errorf("%s: expected no messages, got %q", name, actual)
+	}
+}
+
+func TestPlanResourceMasksSecrets(t *testing.T) {
+	const secret = `
+apiVersion: v1
+kind: Secret
+metadata: {name: kink-test, annotations: {meta.helm.sh/release-name: kink-test}}
+data: {token: c2VjcmV0LXRva2Vu, other: dW5jaGFuZ2Vk}
+`
+	live := &unstructured.Unstructured{Object: testYAMLObject(t, secret)}
+	rendered := live.DeepCopy()
+	unstructured.SetNestedField(rendered.Object, "bmV3LXRva2Vu", "data", "token")
+
+	for _, change := range []struct {
+		name                     string
+		rendered, previous, live *unstructured.Unstructured
+	}{
+		{name: "update", rendered: rendered, previous: live, live: live},
+		{name: "create", rendered: rendered},
+		{name: "delete", previous: live},
+	} {
+		planned, err := planResource(change.rendered, change.previous, change.live, "kink-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		diff := strings.Join(planned.diff, "\n")
+		for _, leaked := range []string{"c2VjcmV0LXRva2Vu", "bmV3LXRva2Vu", "dW5jaGFuZ2Vk", "secret-token", "new-token"} {
+			if strings.Contains(diff, leaked) {
+				t.Errorf("%s: diff contains secret value %s:\n%s", change.name, leaked, diff)
+			}
+		}
+		if change.name == "update" && (!strings.Contains(diff, "-  token: (redacted") || strings.Contains(diff, "-  other:")) {
+			t.Errorf("%s: expected diff to show only the changed key:\n%s", change.name, diff)
+		}
+	}
+	if live.Object["data"].(map[string]interface{})["token"] != "c2VjcmV0LXRva2Vu" {
+		t.Error("masking modified the live resource")
+	}
+}
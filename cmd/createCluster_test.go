@@ -0,0 +1,143 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	helmctlv1 "github.com/k3s-io/helm-controller/pkg/apis/helm.cattle.io/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func testDeployment(name string, labels map[string]string, available bool) *appsv1.Deployment {
+	status := corev1.ConditionFalse
+	if available {
+		status = corev1.ConditionTrue
+	}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: systemNamespace, Labels: labels},
+		Status: appsv1.DeploymentStatus{
+			Replicas:   1,
+			Conditions: []appsv1.DeploymentCondition{{Type: appsv1.DeploymentAvailable, Status: status}},
+		},
+	}
+}
+
+func TestCheckCoreDNSReady(t *testing.T) {
+	kubeDNS := map[string]string{"k8s-app": "kube-dns"}
+	cases := []struct {
+		name     string
+		deploys  []*appsv1.Deployment
+		expected bool
+		progress string
+	}{
+		{name: "k3s", deploys: []*appsv1.Deployment{testDeployment("coredns", kubeDNS, true)}, expected: true},
+		{name: "rke2", deploys: []*appsv1.Deployment{
+			testDeployment("rke2-coredns-rke2-coredns", kubeDNS, true),
+			testDeployment("rke2-coredns-rke2-coredns-autoscaler", map[string]string{"k8s-app": "kube-dns-autoscaler"}, false),
+		}, expected: true},
+		{name: "not created", deploys: []*appsv1.Deployment{testDeployment("coredns", nil, true)}, progress: "not been created"},
+		{name: "unavailable", deploys: []*appsv1.Deployment{testDeployment("coredns", kubeDNS, false)}, progress: "coredns: 0 of 1 pods are available"},
+	}
+	for _, c := range cases {
+		guest := fake.NewSimpleClientset()
+		for _, deploy := range c.deploys {
+			_, err := guest.AppsV1().Deployments(systemNamespace).Create(context.Background(), deploy, metav1.CreateOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		ready, progress, err := checkCoreDNSReady(context.Background(), guest)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if ready != c.expected || !strings.Contains(progress, c.progress) {
+			t.Errorf("%s: expected ready=%t (%q), got %t (%q)", c.name, c.expected, c.progress, ready, progress)
+		}
+	}
+}
+
+// testAPIServer serves a fixed set of objects by path, and NotFound for everything else
+func testAPIServer(t *testing.T, objects map[string]interface{}) kubernetes.Interface {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		obj, ok := objects[req.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			obj = &metav1.Status{
+				TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+				Status:   metav1.StatusFailure,
+				Reason:   metav1.StatusReasonNotFound,
+				Code:     http.StatusNotFound,
+			}
+		}
+		err := json.NewEncoder(w).Encode(obj)
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(server.Close)
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestCheckLocalPathProvisionerReady(t *testing.T) {
+	const (
+		chartPath  = "/apis/helm.cattle.io/v1/namespaces/kube-system/helmcharts/local-path-provisioner"
+		jobPath    = "/apis/batch/v1/namespaces/kube-system/jobs/helm-install-local-path-provisioner"
+		deployPath = "/apis/apps/v1/namespaces/kube-system/deployments/local-path-provisioner"
+	)
+	pending := &helmctlv1.HelmChart{}
+	installing := &helmctlv1.HelmChart{Status: helmctlv1.HelmChartStatus{JobName: "helm-install-local-path-provisioner"}}
+	running := &batchv1.Job{}
+	succeeded := &batchv1.Job{Status: batchv1.JobStatus{Succeeded: 1}}
+	cases := []struct {
+		name     string
+		objects  map[string]interface{}
+		expected bool
+		progress string
+	}{
+		{name: "no chart", progress: "HelmChart has not been created"},
+		{name: "not picked up", objects: map[string]interface{}{chartPath: pending}, progress: "not been picked up"},
+		{name: "no job", objects: map[string]interface{}{chartPath: installing}, progress: "has not been created"},
+		{name: "job running", objects: map[string]interface{}{chartPath: installing, jobPath: running}, progress: "has not completed"},
+		{name: "no deployment", objects: map[string]interface{}{chartPath: installing, jobPath: succeeded}, progress: "deployment has not been created"},
+		{name: "unavailable", objects: map[string]interface{}{
+			chartPath:  installing,
+			jobPath:    succeeded,
+			deployPath: testDeployment(localPathProvisionerName, nil, false),
+		}, progress: "0 of 1 pods are available"},
+		{name: "available", objects: map[string]interface{}{
+			chartPath:  installing,
+			jobPath:    succeeded,
+			deployPath: testDeployment(localPathProvisionerName, nil, true),
+		}, expected: true},
+	}
+	for _, c := range cases {
+		guest := testAPIServer(t, c.objects)
+		ready, progress, err := checkLocalPathProvisionerReady(context.Background(), guest)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if ready != c.expected || !strings.Contains(progress, c.progress) {
+			t.Errorf("%s: expected ready=%t (%q), got %t (%q)", c.name, c.expected, c.progress, ready, progress)
+		}
+	}
+}
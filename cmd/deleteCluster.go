@@ -5,13 +5,11 @@ package cmd
 
 import (
 	"context"
-	"fmt"
 
 	"k8s.io/klog/v2"
 
 	"github.com/meln5674/gosh"
 	"github.com/meln5674/kink/pkg/helm"
-	"github.com/meln5674/kink/pkg/kubectl"
 	"github.com/meln5674/rflag"
 
 	"github.com/spf13/cobra"
@@ -46,13 +44,7 @@ func deleteCluster(ctx context.Context, args *deleteClusterArgsT, cfg *resolvedC
 	var err error
 	klog.Info("Deleting release...")
 	// TODO: Add flag to also delete PVCs
-	raw := cfg.KinkConfig.Release.Raw()
-	helmDelete := helm.Delete(&cfg.KinkConfig.Helm, &cfg.KinkConfig.Chart, &raw, &cfg.KinkConfig.Kubernetes)
-	err = gosh.
-		Command(helmDelete...).
-		WithContext(ctx).
-		WithStreams(gosh.ForwardOutErr).
-		Run()
+	err = withStreams(helmDeleteCluster(ctx, cfg), gosh.ForwardOutErr).Run()
 	if err != nil {
 		return err
 	}
@@ -62,12 +54,8 @@ func deleteCluster(ctx context.Context, args *deleteClusterArgsT, cfg *resolvedC
 		return nil
 	}
 	klog.Info("Deleting PVCs...")
-	deletePVCs := kubectl.Delete(&cfg.KinkConfig.Kubectl, &cfg.KinkConfig.Kubernetes, "persistentvolumeclaim", fmt.Sprintf("-l%s=%s", helm.ClusterLabel, cfg.KinkConfig.Release.ClusterName))
-	err = gosh.
-		Command(deletePVCs...).
-		WithContext(ctx).
-		WithStreams(gosh.ForwardOutErr).
-		Run()
+	deletePVCs := kubectlDeletePVCs(ctx, cfg, map[string]string{helm.ClusterLabel: cfg.KinkConfig.Release.ClusterName})
+	err = withStreams(deletePVCs, gosh.ForwardOutErr).Run()
 	if err != nil {
 		return err
 	}
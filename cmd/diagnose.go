@@ -0,0 +1,695 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/meln5674/gosh"
+	"github.com/meln5674/rflag"
+	"github.com/pkg/errors"
+	"github.com/rancher/wharfie/pkg/registries"
+	"github.com/spf13/cobra"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"github.com/meln5674/kink/pkg/helm"
+	"github.com/meln5674/kink/pkg/kubectl"
+)
+
+const (
+	k3sRegistriesPath  = "/etc/rancher/k3s/registries.yaml"
+	rke2RegistriesPath = "/etc/rancher/rke2/registries.yaml"
+
+	diagnoseRedacted = "REDACTED"
+	// diagnoseNodeLogLines is how much of the containerd log of each node is included
+	diagnoseNodeLogLines = 10000
+	diagnoseEtcdTimeout  = 10 * time.Second
+)
+
+var (
+	// diagnoseSecretKeys are the substrings of the keys of configuration fields which are redacted from the bundle
+	diagnoseSecretKeys = []string{"password", "token", "secret", "credential", "auth", "key"}
+)
+
+// diagnoseCmd represents the diagnose command
+var diagnoseCmd = &cobra.Command{
+	Use:   "diagnose",
+	Short: "Collect a support bundle for a cluster",
+	Long: `Collects everything that is usually needed to work out why a cluster is not working into a single
+.tar.gz file, which contains:
+* The resolved kink configuration and the release configuration
+* The history of the helm release
+* The pods, statefulsets, deployments, jobs, services, persistent volume claims, and events of the cluster in the host cluster
+* The logs of every container of the controlplane, worker, lb-manager, and kubeconfig job pods
+* The workloads, nodes, and events within the cluster
+* The images and containers of each node's containerd, and the end of its log
+* The etcd member list and the health of each member, if the cluster uses etcd
+* The generated registries.yaml
+
+Credentials are redacted from the configuration and registries.yaml, but logs and events are included as-is.
+
+Anything which cannot be collected, such as the contents of the cluster when its controlplane is down, is listed in
+errors.txt within the bundle, and does not prevent the rest from being collected.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return diagnose(context.Background(), &diagnoseArgs, &resolvedConfig)
+	},
+}
+
+type diagnoseArgsT struct {
+	ExecArgs  execArgsT     `rflag:""`
+	Out       string        `rflag:"usage=Path to write the bundle to. If -,, write to stdout. Default is kink-diagnose-<cluster>-<time>.tar.gz"`
+	LogsSince time.Duration `rflag:"usage=Only include logs newer than this. If zero,, include logs of any age"`
+	SkipGuest bool          `rflag:"usage=Do not collect anything from within the cluster,, e.g. if its controlplane is known to be down"`
+}
+
+func (diagnoseArgsT) Defaults() diagnoseArgsT {
+	return diagnoseArgsT{
+		ExecArgs: execArgsT{}.Defaults(),
+	}
+}
+
+var diagnoseArgs = diagnoseArgsT{}.Defaults()
+
+// diagnoseEtcdStatusCmd represents the diagnose etcd-status command
+var diagnoseEtcdStatusCmd = &cobra.Command{
+	Use:               "etcd-status",
+	Short:             "Write the etcd member list and endpoint health to stdout",
+	Long:              `This command is used by 'kink diagnose' within a KinK controlplane container, you should not use it outside of one`,
+	Hidden:            true,
+	PersistentPreRunE: noConfigPreRun,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return etcdStatus(context.Background(), os.Stdout, &diagnoseEtcdStatusArgs)
+	},
+}
+
+var diagnoseEtcdStatusArgs snapshotEtcdSaveArgsT
+
+func init() {
+	rootCmd.AddCommand(diagnoseCmd)
+	rflag.MustRegister(rflag.ForPFlag(diagnoseCmd.Flags()), "", &diagnoseArgs)
+
+	diagnoseCmd.AddCommand(diagnoseEtcdStatusCmd)
+	rflag.MustRegister(rflag.ForPFlag(diagnoseEtcdStatusCmd.Flags()), "", &diagnoseEtcdStatusArgs)
+}
+
+// diagnoseBundle writes files to a .tar.gz, and keeps track of anything that could not be collected
+type diagnoseBundle struct {
+	gz      *gzip.Writer
+	tw      *tar.Writer
+	dir     string
+	created time.Time
+	errors  []string
+}
+
+func newDiagnoseBundle(w io.Writer, dir string) *diagnoseBundle {
+	gz := gzip.NewWriter(w)
+	return &diagnoseBundle{
+		gz:      gz,
+		tw:      tar.NewWriter(gz),
+		dir:     dir,
+		created: time.Now(),
+		errors:  make([]string, 0),
+	}
+}
+
+func (b *diagnoseBundle) addFile(name string, contents []byte) error {
+	err := b.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Join(b.dir, name),
+		Mode:     0644,
+		Size:     int64(len(contents)),
+		ModTime:  b.created,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = b.tw.Write(contents)
+	return err
+}
+
+func (b *diagnoseBundle) addYAML(name string, v interface{}) error {
+	vYAML, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.addFile(name, vYAML)
+}
+
+// addOutput runs a command and adds its stdout as a file. If it fails, whatever it did output is still added.
+func (b *diagnoseBundle) addOutput(name string, cmd gosh.Pipelineable) error {
+	var stdout, stderr bytes.Buffer
+	cmdErr := withStreams(cmd, gosh.WriterOut(&stdout), gosh.WriterErr(&stderr)).Run()
+	if stdout.Len() != 0 || cmdErr == nil {
+		err := b.addFile(name, stdout.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	if cmdErr != nil {
+		return fmt.Errorf("%v: %s", cmdErr, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// collect records the error of a step, if any, so that the remaining steps can continue
+func (b *diagnoseBundle) collect(what string, err error) {
+	if err == nil {
+		return
+	}
+	klog.Warningf("Failed to collect %s: %v", what, err)
+	b.errors = append(b.errors, fmt.Sprintf("%s: %v", what, err))
+}
+
+func (b *diagnoseBundle) Close() error {
+	if len(b.errors) != 0 {
+		err := b.addFile("errors.txt", []byte(strings.Join(b.errors, "\n")+"\n"))
+		if err != nil {
+			return err
+		}
+	}
+	err := b.tw.Close()
+	if err != nil {
+		return err
+	}
+	return b.gz.Close()
+}
+
+func diagnose(ctx context.Context, args *diagnoseArgsT, cfg *resolvedConfigT) error {
+	dir := fmt.Sprintf("kink-diagnose-%s-%s", cfg.KinkConfig.Release.ClusterName, time.Now().Format("20060102-150405"))
+	var out io.Writer
+	outPath := args.Out
+	switch outPath {
+	case "-":
+		out = os.Stdout
+	case "":
+		outPath = dir + ".tar.gz"
+		fallthrough
+	default:
+		f, err := os.Create(outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	host, err := hostClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	bundle := newDiagnoseBundle(out, dir)
+	bundle.collect("configuration", diagnoseConfig(bundle, cfg))
+	bundle.collect("release configuration", diagnoseReleaseConfig(ctx, bundle, host, cfg))
+	bundle.collect("helm history", diagnoseHelmHistory(ctx, bundle, cfg))
+	diagnoseHostResources(ctx, bundle, host, cfg)
+	diagnoseLogs(ctx, bundle, args, cfg)
+	nodePods, err := kubectlGetPods(ctx, cfg, map[string]string{
+		helm.ClusterLabel:     cfg.KinkConfig.Release.ClusterName,
+		helm.ClusterNodeLabel: "true",
+	})
+	bundle.collect("node pods", err)
+	if err == nil {
+		diagnoseNodes(ctx, bundle, nodePods.Items, cfg)
+		bundle.collect("etcd status", diagnoseEtcd(ctx, bundle, nodePods.Items, cfg))
+		bundle.collect("registries.yaml", diagnoseRegistries(ctx, bundle, nodePods.Items, cfg))
+	}
+	if !args.SkipGuest {
+		diagnoseGuest(ctx, bundle, args, cfg)
+	}
+	err = bundle.Close()
+	if err != nil {
+		return err
+	}
+	if outPath != "-" {
+		klog.Infof("Wrote %s", outPath)
+	}
+	if len(bundle.errors) != 0 {
+		klog.Warningf("%d items could not be collected, see errors.txt in the bundle", len(bundle.errors))
+	}
+	return nil
+}
+
+func diagnoseConfig(bundle *diagnoseBundle, cfg *resolvedConfigT) error {
+	redacted, err := redactSecrets(&cfg.KinkConfig)
+	if err != nil {
+		return err
+	}
+	return bundle.addYAML("config.yaml", redacted)
+}
+
+// redactSecrets converts a value to its generic JSON form, and replaces any non-empty string fields whose keys look
+// like they hold credentials, as well as the values of any such flags or --set values in lists of arguments, such as
+// the upgrade flags of the release
+func redactSecrets(v interface{}) (interface{}, error) {
+	vJSON, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	err = json.Unmarshal(vJSON, &generic)
+	if err != nil {
+		return nil, err
+	}
+	return redactValue(generic), nil
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if s, ok := value.(string); ok && s != "" && isSecretKey(key) {
+				v[key] = diagnoseRedacted
+				continue
+			}
+			v[key] = redactValue(value)
+		}
+	case []interface{}:
+		redactArgs(v)
+	}
+	return v
+}
+
+// redactArgs redacts the items of a list which look like command line arguments holding credentials, such as
+// --password=x, --token x, and --set token.value=x, as well as redacting any other values within it
+func redactArgs(args []interface{}) {
+	redactNext := false
+	for ix, arg := range args {
+		s, ok := arg.(string)
+		if !ok {
+			redactNext = false
+			args[ix] = redactValue(arg)
+			continue
+		}
+		if redactNext {
+			redactNext = false
+			args[ix] = diagnoseRedacted
+			continue
+		}
+		if !strings.HasPrefix(s, "-") {
+			// Values of separate --set flags, as well as anything else that happens to look like one
+			args[ix] = redactAssignments(s)
+			continue
+		}
+		end := strings.IndexAny(s, "= ")
+		if end == -1 {
+			redactNext = isSecretKey(s)
+			continue
+		}
+		flag, value := s[:end+1], s[end+1:]
+		switch {
+		case strings.HasPrefix(strings.TrimLeft(flag, "-"), "set"):
+			// --set, --set-string, --set-file, --set-json, and --set-literal
+			args[ix] = flag + redactAssignments(value)
+		case isSecretKey(flag):
+			args[ix] = flag + diagnoseRedacted
+		}
+	}
+}
+
+// redactAssignments redacts the values of a comma-separated list of key=value pairs, as passed to helm --set, whose
+// keys look like they hold credentials
+func redactAssignments(s string) string {
+	if !strings.Contains(s, "=") {
+		return s
+	}
+	assignments := strings.Split(s, ",")
+	redacting := false
+	for ix, assignment := range assignments {
+		key, _, isAssignment := strings.Cut(assignment, "=")
+		if !isAssignment {
+			// The rest of a value which contained a comma
+			if redacting {
+				assignments[ix] = diagnoseRedacted
+			}
+			continue
+		}
+		redacting = isSecretKey(key)
+		if redacting {
+			assignments[ix] = key + "=" + diagnoseRedacted
+		}
+	}
+	return strings.Join(assignments, ",")
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secretKey := range diagnoseSecretKeys {
+		if strings.Contains(key, secretKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func diagnoseReleaseConfig(ctx context.Context, bundle *diagnoseBundle, host kubernetes.Interface, cfg *resolvedConfigT) error {
+	err := bundle.addYAML("release-config.yaml", &cfg.ReleaseConfig)
+	if err != nil {
+		return err
+	}
+	configMap, err := host.CoreV1().ConfigMaps(cfg.ReleaseNamespace).Get(ctx, cfg.ReleaseConfig.Fullname, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	return bundle.addYAML("host/release-configmap.yaml", configMap)
+}
+
+func diagnoseHelmHistory(ctx context.Context, bundle *diagnoseBundle, cfg *resolvedConfigT) error {
+	history, err := helmReleaseHistory(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	return bundle.addYAML("helm/history.yaml", history)
+}
+
+func diagnoseHostResources(ctx context.Context, bundle *diagnoseBundle, host kubernetes.Interface, cfg *resolvedConfigT) {
+	ns := cfg.ReleaseNamespace
+	opts := metav1.ListOptions{LabelSelector: k8slabels.SelectorFromSet(k8slabels.Set(cfg.ReleaseConfig.SelectorLabels)).String()}
+	lists := []struct {
+		name string
+		list func() (interface{}, error)
+	}{
+		{"pods", func() (interface{}, error) { return host.CoreV1().Pods(ns).List(ctx, opts) }},
+		{"statefulsets", func() (interface{}, error) { return host.AppsV1().StatefulSets(ns).List(ctx, opts) }},
+		{"deployments", func() (interface{}, error) { return host.AppsV1().Deployments(ns).List(ctx, opts) }},
+		{"jobs", func() (interface{}, error) { return host.BatchV1().Jobs(ns).List(ctx, opts) }},
+		{"services", func() (interface{}, error) { return host.CoreV1().Services(ns).List(ctx, opts) }},
+		{"persistentvolumeclaims", func() (interface{}, error) { return host.CoreV1().PersistentVolumeClaims(ns).List(ctx, opts) }},
+	}
+	for _, list := range lists {
+		bundle.collect("host "+list.name, func() error {
+			objs, err := list.list()
+			if err != nil {
+				return err
+			}
+			return bundle.addYAML(fmt.Sprintf("host/%s.yaml", list.name), objs)
+		}())
+	}
+
+	bundle.collect("host events", func() error {
+		events, err := host.CoreV1().Events(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return err
+		}
+		// Events cannot be selected by the labels of their objects, but every object of the release is prefixed with its
+		// name
+		matching := make([]corev1.Event, 0, len(events.Items))
+		for _, event := range events.Items {
+			if strings.HasPrefix(event.InvolvedObject.Name, cfg.ReleaseConfig.Fullname) {
+				matching = append(matching, event)
+			}
+		}
+		events.Items = matching
+		return bundle.addYAML("host/events.yaml", events)
+	}())
+}
+
+func diagnoseLogs(ctx context.Context, bundle *diagnoseBundle, args *diagnoseArgsT, cfg *resolvedConfigT) {
+	for _, component := range []string{logsComponentControlplane, logsComponentWorker, logsComponentLBManager, logsComponentKubeconfigJob} {
+		labels, err := componentSelectorLabels(cfg, component)
+		if err != nil {
+			panic(fmt.Sprintf("BUG: %v", err))
+		}
+		pods, err := kubectlGetPods(ctx, cfg, labels)
+		if err != nil {
+			bundle.collect(component+" pods", err)
+			continue
+		}
+		for ix := range pods.Items {
+			pod := &pods.Items[ix]
+			restarts := make(map[string]int32, len(pod.Status.ContainerStatuses))
+			for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+				restarts[status.Name] = status.RestartCount
+			}
+			for _, container := range startedContainers(pod) {
+				opts := kubectl.LogsOptions{Container: container, Tail: -1, Since: args.LogsSince}
+				bundle.collect(
+					fmt.Sprintf("logs of %s/%s", pod.Name, container),
+					bundle.addOutput(fmt.Sprintf("logs/%s/%s.log", pod.Name, container), kubectlLogs(ctx, cfg, pod.Name, &opts)),
+				)
+				if restarts[container] == 0 {
+					continue
+				}
+				opts.Previous = true
+				bundle.collect(
+					fmt.Sprintf("previous logs of %s/%s", pod.Name, container),
+					bundle.addOutput(fmt.Sprintf("logs/%s/%s.previous.log", pod.Name, container), kubectlLogs(ctx, cfg, pod.Name, &opts)),
+				)
+			}
+		}
+	}
+}
+
+func diagnoseNodes(ctx context.Context, bundle *diagnoseBundle, pods []corev1.Pod, cfg *resolvedConfigT) {
+	crictl := k3sCrictlCommand
+	if cfg.ReleaseConfig.RKE2Enabled {
+		crictl = rke2CrictlCommand
+	}
+	ctr := (&loadArgsT{}).parseImportImageFlags(cfg)
+	containerdLog := path.Join(clusterDataDir(cfg), nodeContainerdLogFile)
+	for ix := range pods {
+		pod := &pods[ix]
+		if pod.Status.Phase != corev1.PodRunning {
+			bundle.collect(fmt.Sprintf("node %s", pod.Name), fmt.Errorf("Pod is %s", pod.Status.Phase))
+			continue
+		}
+		container := nodeContainer(pod)
+		commands := []struct {
+			file    string
+			command []string
+		}{
+			{"images.txt", ctr.Ctr("images", "ls")},
+			{"containers.txt", append(append([]string{}, crictl...), "ps", "--all")},
+			{"containerd.log", []string{"tail", "-n", fmt.Sprintf("%d", diagnoseNodeLogLines), containerdLog}},
+		}
+		for _, command := range commands {
+			bundle.collect(
+				fmt.Sprintf("%s of node %s", command.file, pod.Name),
+				bundle.addOutput(
+					fmt.Sprintf("nodes/%s/%s", pod.Name, command.file),
+					kubectlExecInContainer(ctx, cfg, pod.Name, container, false, false, command.command...),
+				),
+			)
+		}
+	}
+}
+
+// firstControlplanePod returns the first running controlplane pod, if any
+func firstControlplanePod(pods []corev1.Pod) *corev1.Pod {
+	for _, pod := range nodePodsWithRole(pods, nodeRoleControlplane) {
+		if pod.Status.Phase == corev1.PodRunning {
+			return pod
+		}
+	}
+	return nil
+}
+
+func diagnoseEtcd(ctx context.Context, bundle *diagnoseBundle, pods []corev1.Pod, cfg *resolvedConfigT) error {
+	pod := firstControlplanePod(pods)
+	if pod == nil {
+		return errors.New("No controlplane pods are running")
+	}
+	return bundle.addOutput("etcd/status.yaml", kubectlEtcdStatus(ctx, cfg, pod))
+}
+
+// kubectlEtcdStatus runs `kink diagnose etcd-status` within a controlplane pod, which outputs an EtcdStatus
+func kubectlEtcdStatus(ctx context.Context, cfg *resolvedConfigT, pod *corev1.Pod) gosh.Pipelineable {
+	return kubectlExecInContainer(
+		ctx, cfg, pod.Name, nodeContainer(pod), false, false,
+		"kink", "diagnose", "etcd-status",
+		"--etcd-config-path", path.Join(clusterDataDir(cfg), etcdConfigFile),
+		"--etcd-endpoint", etcdEndpoint,
+	)
+}
+
+func diagnoseRegistries(ctx context.Context, bundle *diagnoseBundle, pods []corev1.Pod, cfg *resolvedConfigT) error {
+	pod := firstControlplanePod(pods)
+	if pod == nil {
+		return errors.New("No controlplane pods are running")
+	}
+	registriesPath := k3sRegistriesPath
+	if cfg.ReleaseConfig.RKE2Enabled {
+		registriesPath = rke2RegistriesPath
+	}
+	var registriesYAML bytes.Buffer
+	err := withStreams(
+		kubectlExecInContainer(ctx, cfg, pod.Name, nodeContainer(pod), false, false, "cat", registriesPath),
+		gosh.WriterOut(&registriesYAML),
+		gosh.ForwardErr,
+	).Run()
+	if err != nil {
+		return err
+	}
+	var config registries.Registry
+	err = yaml.Unmarshal(registriesYAML.Bytes(), &config)
+	if err != nil {
+		return err
+	}
+	for _, registry := range config.Configs {
+		redactRegistryAuth(registry.Auth)
+	}
+	for name, auth := range config.Auths {
+		redactRegistryAuth(&auth)
+		config.Auths[name] = auth
+	}
+	return bundle.addYAML("registries.yaml", &config)
+}
+
+func redactRegistryAuth(auth *registries.AuthConfig) {
+	if auth == nil {
+		return
+	}
+	for _, field := range []*string{&auth.Username, &auth.Password, &auth.Auth, &auth.IdentityToken} {
+		if *field != "" {
+			*field = diagnoseRedacted
+		}
+	}
+}
+
+func diagnoseGuest(ctx context.Context, bundle *diagnoseBundle, args *diagnoseArgsT, cfg *resolvedConfigT) {
+	guest, stop, err := guestClient(ctx, &args.ExecArgs, cfg)
+	if err != nil {
+		bundle.collect("guest cluster", err)
+		return
+	}
+	defer stop()
+
+	ns := metav1.NamespaceAll
+	opts := metav1.ListOptions{}
+	// These are the same kinds as `kubectl get all`, plus nodes and events
+	lists := []struct {
+		name string
+		list func() (interface{}, error)
+	}{
+		{"nodes", func() (interface{}, error) { return guest.CoreV1().Nodes().List(ctx, opts) }},
+		{"pods", func() (interface{}, error) { return guest.CoreV1().Pods(ns).List(ctx, opts) }},
+		{"services", func() (interface{}, error) { return guest.CoreV1().Services(ns).List(ctx, opts) }},
+		{"daemonsets", func() (interface{}, error) { return guest.AppsV1().DaemonSets(ns).List(ctx, opts) }},
+		{"deployments", func() (interface{}, error) { return guest.AppsV1().Deployments(ns).List(ctx, opts) }},
+		{"replicasets", func() (interface{}, error) { return guest.AppsV1().ReplicaSets(ns).List(ctx, opts) }},
+		{"statefulsets", func() (interface{}, error) { return guest.AppsV1().StatefulSets(ns).List(ctx, opts) }},
+		{"jobs", func() (interface{}, error) { return guest.BatchV1().Jobs(ns).List(ctx, opts) }},
+		{"cronjobs", func() (interface{}, error) { return guest.BatchV1().CronJobs(ns).List(ctx, opts) }},
+		{"events", func() (interface{}, error) { return guest.CoreV1().Events(ns).List(ctx, opts) }},
+	}
+	for _, list := range lists {
+		bundle.collect("guest "+list.name, func() error {
+			objs, err := list.list()
+			if err != nil {
+				return err
+			}
+			return bundle.addYAML(fmt.Sprintf("guest/%s.yaml", list.name), objs)
+		}())
+	}
+}
+
+// EtcdStatus is the output of `kink diagnose etcd-status`
+type EtcdStatus struct {
+	// Members are the members of the etcd cluster
+	Members []EtcdMemberStatus `json:"members"`
+}
+
+// EtcdMemberStatus is the information about, and health of, a single etcd member
+type EtcdMemberStatus struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	PeerURLs   []string `json:"peerURLs"`
+	ClientURLs []string `json:"clientURLs"`
+	IsLearner  bool     `json:"isLearner"`
+	// Healthy is true if a read from the member succeeded, the same as `etcdctl endpoint health`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+	// Version, DBSize, Leader, and RaftIndex are from the status of the member, if it could be reached
+	Version   string `json:"version,omitempty"`
+	DBSize    int64  `json:"dbSize,omitempty"`
+	IsLeader  bool   `json:"isLeader,omitempty"`
+	RaftIndex uint64 `json:"raftIndex,omitempty"`
+}
+
+func etcdStatus(ctx context.Context, out io.Writer, args *snapshotEtcdSaveArgsT) error {
+	config, err := loadEtcdConfig(args.ConfigPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Etcd config %s does not exist. Only clusters with more than one controlplane replica, or which use rke2, use etcd", args.ConfigPath)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open etcd config at path %s", args.ConfigPath)
+	}
+	etcdClient, err := newEtcdClient(config, args.Endpoint)
+	if err != nil {
+		return err
+	}
+	defer etcdClient.Close()
+	members, err := etcdClient.MemberList(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Failed to list etcd members")
+	}
+
+	status := EtcdStatus{Members: make([]EtcdMemberStatus, 0, len(members.Members))}
+	for _, member := range members.Members {
+		memberStatus := EtcdMemberStatus{
+			ID:         fmt.Sprintf("%x", member.ID),
+			Name:       member.Name,
+			PeerURLs:   member.PeerURLs,
+			ClientURLs: member.ClientURLs,
+			IsLearner:  member.IsLearner,
+		}
+		if len(member.ClientURLs) == 0 {
+			memberStatus.Error = "Member has not started"
+		} else {
+			err = etcdMemberHealth(ctx, config, member.ClientURLs[0], &memberStatus)
+			if err != nil {
+				memberStatus.Error = err.Error()
+			}
+		}
+		status.Members = append(status.Members, memberStatus)
+	}
+	statusYAML, err := yaml.Marshal(&status)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(statusYAML)
+	return err
+}
+
+func etcdMemberHealth(ctx context.Context, config *ETCDConfig, endpoint string, status *EtcdMemberStatus) error {
+	ctx, cancel := context.WithTimeout(ctx, diagnoseEtcdTimeout)
+	defer cancel()
+	etcdClient, err := newEtcdClient(config, endpoint)
+	if err != nil {
+		return err
+	}
+	defer etcdClient.Close()
+	// A permission error still means the member was able to serve the read
+	_, err = etcdClient.Get(ctx, "health")
+	if err != nil && !errors.Is(err, rpctypes.ErrPermissionDenied) {
+		return err
+	}
+	status.Healthy = true
+	memberStatus, err := etcdClient.Status(ctx, endpoint)
+	if err != nil {
+		return err
+	}
+	status.Version = memberStatus.Version
+	status.DBSize = memberStatus.DbSize
+	status.IsLeader = memberStatus.Leader == memberStatus.Header.MemberId
+	status.RaftIndex = memberStatus.RaftIndex
+	return nil
+}
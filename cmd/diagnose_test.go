@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rancher/wharfie/pkg/registries"
+	"sigs.k8s.io/yaml"
+
+	cfg "github.com/meln5674/kink/pkg/config"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	config := cfg.Config{}
+	config.Helm.Command = []string{"helm", "--password", "hunter2", "--kube-token=hunter3"}
+	config.Release.ClusterName = "test"
+	config.Release.Set = map[string]string{"token.value": "hunter4", "worker.replicaCount": "3"}
+	config.Release.UpgradeFlags = []string{
+		"--set", "token.value=hunter5,worker.replicaCount=3",
+		"--set-string=registry.password=hunter6",
+		"--set token.value=hunter7",
+		"--set", "token.value=hunter8,hunter8\\,hunter9,controlplane.replicaCount=1",
+		"--timeout", "10m",
+		"--wait",
+	}
+
+	redacted, err := redactSecrets(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redactedYAML, err := yaml.Marshal(redacted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "hunter3", "hunter4", "hunter5", "hunter6", "hunter7", "hunter8", "hunter9"} {
+		if strings.Contains(string(redactedYAML), secret) {
+			t.Errorf("%s was not redacted:\n%s", secret, redactedYAML)
+		}
+	}
+	for _, kept := range []string{"clusterName: test", "worker.replicaCount=3", "controlplane.replicaCount=1", "10m", "--wait", "worker.replicaCount: \"3\""} {
+		if !strings.Contains(string(redactedYAML), kept) {
+			t.Errorf("%s was redacted:\n%s", kept, redactedYAML)
+		}
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	cases := []struct {
+		args, expected []interface{}
+	}{
+		{
+			args:     []interface{}{"--token", "abc", "--namespace", "default"},
+			expected: []interface{}{"--token", diagnoseRedacted, "--namespace", "default"},
+		},
+		{
+			args:     []interface{}{"--client-key=/tmp/key", "--server=https://example.com"},
+			expected: []interface{}{"--client-key=" + diagnoseRedacted, "--server=https://example.com"},
+		},
+		{
+			args:     []interface{}{"--set", "a=b,auth.password=c", "--set-json", `secret={"a":1}`},
+			expected: []interface{}{"--set", "a=b,auth.password=" + diagnoseRedacted, "--set-json", "secret=" + diagnoseRedacted},
+		},
+		{
+			args:     []interface{}{"plain", map[string]interface{}{"password": "x"}, "after"},
+			expected: []interface{}{"plain", map[string]interface{}{"password": diagnoseRedacted}, "after"},
+		},
+	}
+	for _, c := range cases {
+		actual := append([]interface{}{}, c.args...)
+		redactArgs(actual)
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("%v: expected %v, got %v", c.args, c.expected, actual)
+		}
+	}
+}
+
+func TestRedactRegistryAuth(t *testing.T) {
+	auth := registries.AuthConfig{
+		Username:      "user",
+		Password:      "password",
+		Auth:          "dXNlcjpwYXNzd29yZA==",
+		IdentityToken: "token",
+	}
+	redactRegistryAuth(&auth)
+	expected := registries.AuthConfig{
+		Username:      diagnoseRedacted,
+		Password:      diagnoseRedacted,
+		Auth:          diagnoseRedacted,
+		IdentityToken: diagnoseRedacted,
+	}
+	if auth != expected {
+		t.Errorf("expected %#v, got %#v", expected, auth)
+	}
+
+	empty := registries.AuthConfig{Username: "user"}
+	redactRegistryAuth(&empty)
+	if empty.Password != "" || empty.Username != diagnoseRedacted {
+		t.Errorf("expected only set fields to be redacted, got %#v", empty)
+	}
+	redactRegistryAuth(nil)
+}
@@ -0,0 +1,771 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/meln5674/gosh"
+	"github.com/meln5674/rflag"
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/klog/v2"
+
+	"github.com/meln5674/kink/pkg/helm"
+)
+
+const (
+	doctorStatusPass = "PASS"
+	doctorStatusWarn = "WARN"
+	doctorStatusFail = "FAIL"
+
+	podSecurityEnforceLabel           = "pod-security.kubernetes.io/enforce"
+	podSecurityLevelPrivileged        = "privileged"
+	defaultStorageClassAnnotation     = "storageclass.kubernetes.io/is-default-class"
+	betaDefaultStorageClassAnnotation = "storageclass.beta.kubernetes.io/is-default-class"
+	legacyIngressClassAnnotation      = "kubernetes.io/ingress.class"
+
+	// These match hack/inotify-check.sh
+	inotifyMaxUserInstancesPath = "/proc/sys/fs/inotify/max_user_instances"
+	minInotifyMaxUserInstances  = 512
+)
+
+var (
+	// doctorVerbs are the verbs needed on each resource of the chart to install, upgrade, and delete it
+	doctorVerbs = []string{"get", "create", "patch", "delete"}
+	// doctorOptionalResources are not part of the chart, but are used by other commands, such as the secrets helm
+	// stores releases in, and the subresources used by `kink exec` and `kink load`
+	doctorOptionalResources = []struct {
+		resource, subresource, verb, usedBy string
+		required                            bool
+	}{
+		{resource: "secrets", verb: "list", usedBy: "helm, to store releases", required: true},
+		{resource: "pods", verb: "list", usedBy: "kink, to find node pods", required: true},
+		{resource: "pods", subresource: "exec", verb: "create", usedBy: "kink load, kink node, and kink snapshot"},
+		{resource: "pods", subresource: "portforward", verb: "create", usedBy: "kink exec and kink port-forward"},
+	}
+)
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that a cluster can be created in the host cluster",
+	Long: `Render the chart for a cluster, and check the host cluster and local machine for common problems which
+would prevent it from being created, printing a hint for how to fix each one. The checks are:
+* binaries: The helm, kubectl, and docker commands are present, unless the native backend is used for each
+* inotify: The inotify instance limit of the local machine is at least 512. k3s and rke2 need this on each host node.
+* permissions: The current user can create each resource in the chart, as determined by SelfSubjectAccessReviews
+* pod-security: The pod security admission level of the release namespace allows privileged pods
+* storage: A default StorageClass exists, or the named StorageClass does, for each persistent volume claim
+* ingress: An IngressClass exists for each ingress
+
+The same checks are run by 'kink create cluster' before deploying the chart, unless --skip-preflight is given.
+This exits with an error if any check fails, but not if any only warn.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return doctor(context.Background(), os.Stdout, &doctorArgs, &resolvedConfig)
+	},
+}
+
+type doctorArgsT struct {
+	Output string `rflag:"usage=Output format. One of table|json|yaml"`
+}
+
+func (doctorArgsT) Defaults() doctorArgsT {
+	return doctorArgsT{
+		Output: getOutputTable,
+	}
+}
+
+var doctorArgs = doctorArgsT{}.Defaults()
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	rflag.MustRegister(rflag.ForPFlag(doctorCmd.Flags()), "", &doctorArgs)
+}
+
+// DoctorResult is the outcome of a single preflight check
+type DoctorResult struct {
+	// Check is the name of the check, e.g. storage
+	Check string `json:"check"`
+	// Status is PASS, WARN, or FAIL
+	Status string `json:"status"`
+	// Message describes what was found
+	Message string `json:"message"`
+	// Hint describes how to fix a warning or failure
+	Hint string `json:"hint,omitempty"`
+}
+
+func doctor(ctx context.Context, out io.Writer, args *doctorArgsT, cfg *resolvedConfigT) error {
+	switch args.Output {
+	case getOutputTable, getOutputJSON, getOutputYAML:
+	default:
+		return fmt.Errorf("Unknown output format %s, must be one of table, json, yaml", args.Output)
+	}
+
+	results, err := preflightChecks(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	if ok, err := writeStructuredOutput(out, args.Output, results); !ok {
+		err = printDoctorTable(out, results)
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return preflightError(results)
+}
+
+// preflightError returns an error summarizing the failed checks, if any
+func preflightError(results []DoctorResult) error {
+	failed := make([]string, 0)
+	seen := make(map[string]struct{})
+	count := 0
+	for _, result := range results {
+		if result.Status != doctorStatusFail {
+			continue
+		}
+		count++
+		if _, ok := seen[result.Check]; !ok {
+			seen[result.Check] = struct{}{}
+			failed = append(failed, result.Check)
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d preflight check(s) failed: %s", count, strings.Join(failed, ", "))
+}
+
+// preflight runs the preflight checks before creating a cluster, logging any warnings or failures
+func preflight(ctx context.Context, cfg *resolvedConfigT) error {
+	klog.Info("Running preflight checks...")
+	results, err := preflightChecks(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		switch result.Status {
+		case doctorStatusWarn:
+			klog.Warningf("%s: %s. %s", result.Check, result.Message, result.Hint)
+		case doctorStatusFail:
+			klog.Errorf("%s: %s. %s", result.Check, result.Message, result.Hint)
+		default:
+			klog.V(1).Infof("%s: %s", result.Check, result.Message)
+		}
+	}
+	err = preflightError(results)
+	if err != nil {
+		return fmt.Errorf("%w. Run 'kink doctor' for details, or use --skip-preflight to create the cluster anyways", err)
+	}
+	return nil
+}
+
+// preflightChecks renders the chart and runs every check against it. An error is only returned if the chart
+// cannot be rendered or the host cluster cannot be contacted at all, failures of individual checks are results instead.
+func preflightChecks(ctx context.Context, cfg *resolvedConfigT) ([]DoctorResult, error) {
+	results := checkBinaries(cfg)
+	if runtime.GOOS == "linux" {
+		results = append(results, checkInotify())
+	}
+
+	manifests, err := renderClusterManifests(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to render chart: %w", err)
+	}
+	host, err := hostClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	checks := []struct {
+		name  string
+		check func(context.Context, kubernetes.Interface, []unstructured.Unstructured, *resolvedConfigT) ([]DoctorResult, error)
+	}{
+		{name: "permissions", check: checkPermissions},
+		{name: "pod-security", check: checkPodSecurity},
+		{name: "storage", check: checkStorageClasses},
+		{name: "ingress", check: checkIngressClasses},
+	}
+	for _, check := range checks {
+		checkResults, err := check.check(ctx, host, manifests, cfg)
+		if err != nil {
+			checkResults = append(checkResults, DoctorResult{
+				Check:   check.name,
+				Status:  doctorStatusFail,
+				Message: err.Error(),
+				Hint:    "Check that the host cluster is reachable and that you are allowed to perform this check",
+			})
+		}
+		results = append(results, checkResults...)
+	}
+	return results, nil
+}
+
+// renderClusterManifests renders the chart, the same as is done to determine the release configuration, and decodes
+// every resource in it
+func renderClusterManifests(ctx context.Context, cfg *resolvedConfigT) ([]unstructured.Unstructured, error) {
+	return decodeManifests(helmTemplateCluster(ctx, cfg))
+}
+
+// decodeManifests runs a command which outputs a stream of YAML documents, such as helm template, and decodes each
+// non-empty one
+func decodeManifests(cmd gosh.Pipelineable) ([]unstructured.Unstructured, error) {
+	manifests := make([]unstructured.Unstructured, 0)
+	err := withStreams(
+		cmd,
+		gosh.ForwardErr,
+		gosh.FuncOut(func(r io.Reader) error {
+			decoder := yaml.NewYAMLOrJSONDecoder(r, 1024)
+			for {
+				// Decoding as JSON first produces the same types, e.g. int64 instead of float64, as objects from the
+				// API server do
+				raw := json.RawMessage{}
+				err := decoder.Decode(&raw)
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				if err == nil && (len(raw) == 0 || string(raw) == "null") {
+					continue
+				}
+				obj := unstructured.Unstructured{}
+				if err == nil {
+					err = obj.UnmarshalJSON(raw)
+				}
+				if err != nil {
+					// If we don't flush its stdout, the helm template process never exits on windows
+					io.Copy(devNull, r)
+					return err
+				}
+				manifests = append(manifests, obj)
+			}
+		}),
+	).Run()
+	if err != nil {
+		return nil, err
+	}
+	return manifests, nil
+}
+
+func checkBinaries(cfg *resolvedConfigT) []DoctorResult {
+	binaries := []struct {
+		name     string
+		command  []string
+		native   bool
+		required bool
+		flag     string
+	}{
+		{name: "helm", command: cfg.KinkConfig.Helm.Command, native: cfg.KinkConfig.Helm.Native, required: true, flag: "--helm-native"},
+		{name: "kubectl", command: cfg.KinkConfig.Kubectl.Command, native: cfg.KinkConfig.Kubectl.Native, required: true, flag: "--kubectl-native"},
+		// Docker is only used to load images from a local daemon, so a cluster can be created without it
+		{name: "docker", command: cfg.KinkConfig.Docker.Command, native: cfg.KinkConfig.Docker.Native, flag: "--docker-native"},
+	}
+	results := make([]DoctorResult, 0, len(binaries))
+	for _, binary := range binaries {
+		result := DoctorResult{Check: "binaries", Status: doctorStatusPass}
+		if binary.native {
+			result.Message = fmt.Sprintf("%s is not needed, the native backend is used", binary.name)
+			results = append(results, result)
+			continue
+		}
+		command := binary.name
+		if len(binary.command) != 0 {
+			command = binary.command[0]
+		}
+		path, err := exec.LookPath(command)
+		if err != nil {
+			result.Status = doctorStatusFail
+			if !binary.required {
+				result.Status = doctorStatusWarn
+			}
+			result.Message = fmt.Sprintf("%s command %s was not found: %s", binary.name, command, err)
+			result.Hint = fmt.Sprintf("Install %s, or set %s to use the native backend instead", binary.name, binary.flag)
+		} else {
+			result.Message = fmt.Sprintf("%s command found at %s", binary.name, path)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func checkInotify() DoctorResult {
+	result := DoctorResult{Check: "inotify"}
+	hint := fmt.Sprintf("Run 'sysctl -w fs.inotify.max_user_instances=%d' on this machine and on each node of the host cluster", minInotifyMaxUserInstances)
+	raw, err := os.ReadFile(inotifyMaxUserInstancesPath)
+	if err != nil {
+		result.Status = doctorStatusWarn
+		result.Message = fmt.Sprintf("Could not read %s: %s", inotifyMaxUserInstancesPath, err)
+		result.Hint = hint
+		return result
+	}
+	instances, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil {
+		result.Status = doctorStatusWarn
+		result.Message = fmt.Sprintf("Could not parse %s: %s", inotifyMaxUserInstancesPath, err)
+		result.Hint = hint
+		return result
+	}
+	if instances < minInotifyMaxUserInstances {
+		// This is only a warning, as the host cluster is not necessarily running on this machine
+		result.Status = doctorStatusWarn
+		result.Message = fmt.Sprintf("fs.inotify.max_user_instances is %d on this machine, nodes may fail with \"too many open files\"", instances)
+		result.Hint = hint
+		return result
+	}
+	result.Status = doctorStatusPass
+	result.Message = fmt.Sprintf("fs.inotify.max_user_instances is %d on this machine", instances)
+	return result
+}
+
+func checkPermissions(ctx context.Context, host kubernetes.Interface, manifests []unstructured.Unstructured, cfg *resolvedConfigT) ([]DoctorResult, error) {
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(host.Discovery()))
+	results := make([]DoctorResult, 0)
+
+	type resourceKey struct {
+		group, resource, namespace string
+	}
+	seen := make(map[resourceKey]struct{})
+	checked := 0
+	for _, obj := range manifests {
+		gvk := obj.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if meta.IsNoMatchError(err) {
+			results = append(results, DoctorResult{
+				Check:   "permissions",
+				Status:  doctorStatusFail,
+				Message: fmt.Sprintf("The host cluster does not serve %s, which is needed for %s %s", gvk, gvk.Kind, obj.GetName()),
+				Hint:    "Install the CRD or API which provides it, or disable the feature of the chart which uses it",
+			})
+			continue
+		}
+		if err != nil {
+			return results, err
+		}
+		key := resourceKey{group: mapping.Resource.Group, resource: mapping.Resource.Resource}
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			key.namespace = obj.GetNamespace()
+			if key.namespace == "" {
+				key.namespace = cfg.ReleaseNamespace
+			}
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		checked++
+
+		denied := make([]string, 0)
+		for _, verb := range doctorVerbs {
+			allowed, err := accessAllowed(ctx, host, &authorizationv1.ResourceAttributes{
+				Group:     key.group,
+				Resource:  key.resource,
+				Namespace: key.namespace,
+				Verb:      verb,
+			})
+			if err != nil {
+				return results, err
+			}
+			if !allowed {
+				denied = append(denied, verb)
+			}
+		}
+		if len(denied) != 0 {
+			results = append(results, DoctorResult{
+				Check:   "permissions",
+				Status:  doctorStatusFail,
+				Message: fmt.Sprintf("Not allowed to %s %s", strings.Join(denied, "/"), describeResource(key.group, key.resource, key.namespace)),
+				Hint:    "Ask the administrator of the host cluster for a Role which grants these, or use a different namespace",
+			})
+		}
+	}
+
+	for _, optional := range doctorOptionalResources {
+		allowed, err := accessAllowed(ctx, host, &authorizationv1.ResourceAttributes{
+			Resource:    optional.resource,
+			Subresource: optional.subresource,
+			Namespace:   cfg.ReleaseNamespace,
+			Verb:        optional.verb,
+		})
+		if err != nil {
+			return results, err
+		}
+		checked++
+		if allowed {
+			continue
+		}
+		resource := optional.resource
+		if optional.subresource != "" {
+			resource += "/" + optional.subresource
+		}
+		result := DoctorResult{
+			Check:   "permissions",
+			Status:  doctorStatusWarn,
+			Message: fmt.Sprintf("Not allowed to %s %s, which is used by %s", optional.verb, describeResource("", resource, cfg.ReleaseNamespace), optional.usedBy),
+			Hint:    "Ask the administrator of the host cluster for a Role which grants this",
+		}
+		if optional.required {
+			result.Status = doctorStatusFail
+		}
+		results = append(results, result)
+	}
+
+	if len(results) == 0 {
+		results = append(results, DoctorResult{
+			Check:   "permissions",
+			Status:  doctorStatusPass,
+			Message: fmt.Sprintf("Allowed to manage all %d resource types used by the cluster", checked),
+		})
+	}
+	return results, nil
+}
+
+func accessAllowed(ctx context.Context, host kubernetes.Interface, attrs *authorizationv1.ResourceAttributes) (bool, error) {
+	review, err := host.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: attrs},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("Failed to review access: %w", err)
+	}
+	return review.Status.Allowed, nil
+}
+
+func describeResource(group, resource, namespace string) string {
+	gr := schema.GroupResource{Group: group, Resource: resource}.String()
+	if namespace == "" {
+		return gr
+	}
+	return fmt.Sprintf("%s in namespace %s", gr, namespace)
+}
+
+// renderedPodSpec returns the pod spec of a rendered resource, if it has one
+func renderedPodSpec(obj *unstructured.Unstructured) (*corev1.PodSpec, bool, error) {
+	var path []string
+	switch obj.GetKind() {
+	case "Pod":
+		path = []string{"spec"}
+	case "StatefulSet", "Deployment", "DaemonSet", "ReplicaSet", "Job":
+		path = []string{"spec", "template", "spec"}
+	case "CronJob":
+		path = []string{"spec", "jobTemplate", "spec", "template", "spec"}
+	default:
+		return nil, false, nil
+	}
+	raw, ok, err := unstructured.NestedMap(obj.Object, path...)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	spec := corev1.PodSpec{}
+	err = kruntime.DefaultUnstructuredConverter.FromUnstructured(raw, &spec)
+	if err != nil {
+		return nil, false, fmt.Errorf("Invalid pod spec in %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	return &spec, true, nil
+}
+
+// podSpecIsPrivileged returns true if a pod would be rejected by the baseline pod security standard for any of the
+// reasons that kink pods typically are
+func podSpecIsPrivileged(spec *corev1.PodSpec) bool {
+	if spec.HostNetwork || spec.HostPID || spec.HostIPC {
+		return true
+	}
+	for _, volume := range spec.Volumes {
+		if volume.HostPath != nil {
+			return true
+		}
+	}
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, container := range containers {
+			if container.SecurityContext != nil && container.SecurityContext.Privileged != nil && *container.SecurityContext.Privileged {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func checkPodSecurity(ctx context.Context, host kubernetes.Interface, manifests []unstructured.Unstructured, cfg *resolvedConfigT) ([]DoctorResult, error) {
+	results := make([]DoctorResult, 0)
+	privileged := make([]string, 0)
+	for ix := range manifests {
+		obj := &manifests[ix]
+		spec, ok, err := renderedPodSpec(obj)
+		if err != nil {
+			return results, err
+		}
+		if !ok {
+			continue
+		}
+		if podSpecIsPrivileged(spec) {
+			privileged = append(privileged, fmt.Sprintf("%s/%s", obj.GetKind(), obj.GetName()))
+			continue
+		}
+		// k3s and rke2 cannot run containers without being privileged, but the chart does not require it, as some
+		// runtimes (e.g. sysbox) make it unnecessary
+		if obj.GetKind() == "StatefulSet" && obj.GetLabels()[helm.ClusterNodeLabel] == "true" {
+			results = append(results, DoctorResult{
+				Check:   "pod-security",
+				Status:  doctorStatusWarn,
+				Message: fmt.Sprintf("The node containers of %s/%s are not privileged, k3s/rke2 will likely fail to start", obj.GetKind(), obj.GetName()),
+				Hint:    "Set controlplane.securityContext.privileged=true and worker.securityContext.privileged=true, unless your container runtime does not need it",
+			})
+		}
+	}
+
+	ns, err := host.CoreV1().Namespaces().Get(ctx, cfg.ReleaseNamespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return append(results, DoctorResult{
+			Check:   "pod-security",
+			Status:  doctorStatusFail,
+			Message: fmt.Sprintf("Namespace %s does not exist", cfg.ReleaseNamespace),
+			Hint:    fmt.Sprintf("Run 'kubectl create namespace %s', or use a different namespace", cfg.ReleaseNamespace),
+		}), nil
+	}
+	if err != nil {
+		return results, fmt.Errorf("Failed to get namespace %s: %w", cfg.ReleaseNamespace, err)
+	}
+	if len(privileged) == 0 {
+		return append(results, DoctorResult{
+			Check:   "pod-security",
+			Status:  doctorStatusPass,
+			Message: "No privileged pods are used",
+		}), nil
+	}
+	level, ok := ns.Labels[podSecurityEnforceLabel]
+	if !ok {
+		return append(results, DoctorResult{
+			Check:   "pod-security",
+			Status:  doctorStatusPass,
+			Message: fmt.Sprintf("Namespace %s does not set a pod security level, the cluster-wide default applies", ns.Name),
+		}), nil
+	}
+	if level != podSecurityLevelPrivileged {
+		return append(results, DoctorResult{
+			Check:   "pod-security",
+			Status:  doctorStatusFail,
+			Message: fmt.Sprintf("Namespace %s enforces the %s pod security level, which forbids %s", ns.Name, level, strings.Join(privileged, ", ")),
+			Hint:    fmt.Sprintf("Run 'kubectl label namespace %s --overwrite %s=%s', or use a different namespace", ns.Name, podSecurityEnforceLabel, podSecurityLevelPrivileged),
+		}), nil
+	}
+	return append(results, DoctorResult{
+		Check:   "pod-security",
+		Status:  doctorStatusPass,
+		Message: fmt.Sprintf("Namespace %s allows privileged pods", ns.Name),
+	}), nil
+}
+
+// renderedClaim is a persistent volume claim from the chart, either on its own, or as the template of a statefulset
+type renderedClaim struct {
+	name             string
+	storageClassName *string
+}
+
+func renderedClaims(manifests []unstructured.Unstructured) ([]renderedClaim, error) {
+	claims := make([]renderedClaim, 0)
+	for ix := range manifests {
+		obj := &manifests[ix]
+		switch obj.GetKind() {
+		case "PersistentVolumeClaim":
+			pvc := corev1.PersistentVolumeClaim{}
+			err := kruntime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &pvc)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid PersistentVolumeClaim %s: %w", obj.GetName(), err)
+			}
+			claims = append(claims, renderedClaim{name: pvc.Name, storageClassName: pvc.Spec.StorageClassName})
+		case "StatefulSet":
+			sts := appsv1.StatefulSet{}
+			err := kruntime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &sts)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid StatefulSet %s: %w", obj.GetName(), err)
+			}
+			for _, pvc := range sts.Spec.VolumeClaimTemplates {
+				claims = append(claims, renderedClaim{name: fmt.Sprintf("%s-%s", pvc.Name, sts.Name), storageClassName: pvc.Spec.StorageClassName})
+			}
+		}
+	}
+	return claims, nil
+}
+
+func checkStorageClasses(ctx context.Context, host kubernetes.Interface, manifests []unstructured.Unstructured, cfg *resolvedConfigT) ([]DoctorResult, error) {
+	claims, err := renderedClaims(manifests)
+	if err != nil {
+		return nil, err
+	}
+	if len(claims) == 0 {
+		return []DoctorResult{{
+			Check:   "storage",
+			Status:  doctorStatusPass,
+			Message: "Persistence is disabled, no StorageClass is needed",
+		}}, nil
+	}
+
+	classes, err := host.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list storage classes: %w", err)
+	}
+	exists := make(map[string]struct{}, len(classes.Items))
+	defaults := make([]string, 0)
+	for _, class := range classes.Items {
+		exists[class.Name] = struct{}{}
+		if class.Annotations[defaultStorageClassAnnotation] == "true" || class.Annotations[betaDefaultStorageClassAnnotation] == "true" {
+			defaults = append(defaults, class.Name)
+		}
+	}
+
+	results := make([]DoctorResult, 0)
+	usesDefault := make([]string, 0)
+	for _, claim := range claims {
+		if claim.storageClassName == nil {
+			usesDefault = append(usesDefault, claim.name)
+			continue
+		}
+		if *claim.storageClassName == "" {
+			// An explicitly empty class binds to a pre-provisioned volume, which we can't check
+			continue
+		}
+		if _, ok := exists[*claim.storageClassName]; ok {
+			continue
+		}
+		results = append(results, DoctorResult{
+			Check:   "storage",
+			Status:  doctorStatusFail,
+			Message: fmt.Sprintf("StorageClass %s, used by %s, does not exist", *claim.storageClassName, claim.name),
+			Hint:    "Set the storageClassName of the persistence for it to one listed by 'kubectl get storageclass'",
+		})
+	}
+	if len(usesDefault) != 0 {
+		switch len(defaults) {
+		case 0:
+			results = append(results, DoctorResult{
+				Check:   "storage",
+				Status:  doctorStatusFail,
+				Message: fmt.Sprintf("There is no default StorageClass, which is needed by %s", strings.Join(usesDefault, ", ")),
+				Hint:    fmt.Sprintf("Annotate a StorageClass with %s=true, or set the storageClassName of the persistence for each", defaultStorageClassAnnotation),
+			})
+		case 1:
+		default:
+			sort.Strings(defaults)
+			results = append(results, DoctorResult{
+				Check:   "storage",
+				Status:  doctorStatusWarn,
+				Message: fmt.Sprintf("There are multiple default StorageClasses: %s", strings.Join(defaults, ", ")),
+				Hint:    "Remove the default annotation from all but one, or set the storageClassName of the persistence for each",
+			})
+		}
+	}
+	if len(results) == 0 {
+		results = append(results, DoctorResult{
+			Check:   "storage",
+			Status:  doctorStatusPass,
+			Message: fmt.Sprintf("A StorageClass exists for all %d persistent volume claims", len(claims)),
+		})
+	}
+	return results, nil
+}
+
+func checkIngressClasses(ctx context.Context, host kubernetes.Interface, manifests []unstructured.Unstructured, cfg *resolvedConfigT) ([]DoctorResult, error) {
+	ingresses := make([]*unstructured.Unstructured, 0)
+	for ix := range manifests {
+		if manifests[ix].GetKind() == "Ingress" {
+			ingresses = append(ingresses, &manifests[ix])
+		}
+	}
+	if len(ingresses) == 0 {
+		return []DoctorResult{{
+			Check:   "ingress",
+			Status:  doctorStatusPass,
+			Message: "Ingress is disabled, no IngressClass is needed",
+		}}, nil
+	}
+
+	classes, err := host.NetworkingV1().IngressClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list ingress classes: %w", err)
+	}
+	exists := make(map[string]struct{}, len(classes.Items))
+	hasDefault := false
+	for _, class := range classes.Items {
+		exists[class.Name] = struct{}{}
+		if class.Annotations[networkingv1.AnnotationIsDefaultIngressClass] == "true" {
+			hasDefault = true
+		}
+	}
+
+	results := make([]DoctorResult, 0)
+	for _, ingress := range ingresses {
+		className, _, err := unstructured.NestedString(ingress.Object, "spec", "ingressClassName")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Ingress %s: %w", ingress.GetName(), err)
+		}
+		if className == "" {
+			className = ingress.GetAnnotations()[legacyIngressClassAnnotation]
+		}
+		if className == "" {
+			if !hasDefault {
+				results = append(results, DoctorResult{
+					Check:   "ingress",
+					Status:  doctorStatusWarn,
+					Message: fmt.Sprintf("Ingress %s does not set a class, and there is no default IngressClass", ingress.GetName()),
+					Hint:    "Set the className of the ingress to one listed by 'kubectl get ingressclass'",
+				})
+			}
+			continue
+		}
+		if _, ok := exists[className]; ok {
+			continue
+		}
+		results = append(results, DoctorResult{
+			Check:   "ingress",
+			Status:  doctorStatusFail,
+			Message: fmt.Sprintf("IngressClass %s, used by Ingress %s, does not exist", className, ingress.GetName()),
+			Hint:    "Set the className of the ingress, e.g. controlplane.ingress.className, to one listed by 'kubectl get ingressclass'",
+		})
+	}
+	if len(results) == 0 {
+		results = append(results, DoctorResult{
+			Check:   "ingress",
+			Status:  doctorStatusPass,
+			Message: fmt.Sprintf("An IngressClass exists for all %d ingresses", len(ingresses)),
+		})
+	}
+	return results, nil
+}
+
+func printDoctorTable(out io.Writer, results []DoctorResult) error {
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tCHECK\tMESSAGE")
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", result.Status, result.Check, result.Message)
+		if result.Hint != "" {
+			fmt.Fprintf(w, "\t\t  Hint: %s\n", result.Hint)
+		}
+	}
+	return w.Flush()
+}
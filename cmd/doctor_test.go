@@ -0,0 +1,319 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testManifests(t *testing.T, docs ...string) []unstructured.Unstructured {
+	manifests := make([]unstructured.Unstructured, 0, len(docs))
+	for _, doc := range docs {
+		manifests = append(manifests, unstructured.Unstructured{Object: testYAMLObject(t, doc)})
+	}
+	return manifests
+}
+
+// checkDoctorResults checks that there is exactly one result for each expected status and message substring
+func checkDoctorResults(t *testing.T, name string, results []DoctorResult, expected [][2]string) {
+	t.Helper()
+	if len(results) != len(expected) {
+		t.Errorf("%s: expected %d results, got %#v", name, len(expected), results)
+		return
+	}
+	for ix, result := range results {
+		if result.Status != expected[ix][0] || !strings.Contains(result.Message, expected[ix][1]) {
+			t.Errorf("%s: expected result %d to be %s %q, got %s %q", name, ix, expected[ix][0], expected[ix][1], result.Status, result.Message)
+		}
+		if result.Status != doctorStatusPass && result.Hint == "" {
+			t.Errorf("%s: expected result %d to have a hint", name, ix)
+		}
+	}
+}
+
+func TestPreflightError(t *testing.T) {
+	if err := preflightError([]DoctorResult{{Check: "storage", Status: doctorStatusWarn}, {Check: "binaries", Status: doctorStatusPass}}); err != nil {
+		t.Errorf("expected warnings not to fail, got %v", err)
+	}
+	err := preflightError([]DoctorResult{
+		{Check: "permissions", Status: doctorStatusFail},
+		{Check: "storage", Status: doctorStatusWarn},
+		{Check: "permissions", Status: doctorStatusFail},
+		{Check: "pod-security", Status: doctorStatusFail},
+	})
+	if err == nil || err.Error() != "3 preflight check(s) failed: permissions, pod-security" {
+		t.Errorf("expected each failed check to be listed once, got %v", err)
+	}
+}
+
+const (
+	testStatefulSetManifest = `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: kink-test-controlplane
+`
+	testServiceManifest = `
+apiVersion: v1
+kind: Service
+metadata:
+  name: kink-test-controlplane
+  namespace: other
+`
+	testUnservedManifest = `
+apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+metadata:
+  name: kink-test
+`
+)
+
+func TestCheckPermissions(t *testing.T) {
+	cases := []struct {
+		name      string
+		manifests []string
+		denied    map[string]bool
+		expected  [][2]string
+	}{
+		{
+			name:      "allowed",
+			manifests: []string{testStatefulSetManifest, testServiceManifest, testStatefulSetManifest},
+			// statefulsets, services in other namespace, and the 4 optional resources
+			expected: [][2]string{{doctorStatusPass, "all 6 resource types"}},
+		},
+		{
+			name:      "denied",
+			manifests: []string{testStatefulSetManifest, testServiceManifest, testUnservedManifest},
+			denied:    map[string]bool{"delete statefulsets": true, "create pods/portforward": true, "list secrets": true},
+			expected: [][2]string{
+				{doctorStatusFail, "Not allowed to delete statefulsets.apps in namespace default"},
+				{doctorStatusFail, "does not serve monitoring.coreos.com/v1, Kind=ServiceMonitor"},
+				{doctorStatusFail, "Not allowed to list secrets in namespace default"},
+				{doctorStatusWarn, "Not allowed to create pods/portforward in namespace default, which is used by kink exec"},
+			},
+		},
+	}
+	for _, c := range cases {
+		host := fake.NewSimpleClientset()
+		host.Fake.Resources = []*metav1.APIResourceList{
+			{GroupVersion: "v1", APIResources: []metav1.APIResource{
+				{Name: "services", Namespaced: true, Kind: "Service"},
+				{Name: "secrets", Namespaced: true, Kind: "Secret"},
+				{Name: "pods", Namespaced: true, Kind: "Pod"},
+			}},
+			{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{
+				{Name: "statefulsets", Namespaced: true, Kind: "StatefulSet"},
+			}},
+		}
+		host.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+			attrs := review.Spec.ResourceAttributes
+			resource := attrs.Resource
+			if attrs.Subresource != "" {
+				resource += "/" + attrs.Subresource
+			}
+			review.Status.Allowed = !c.denied[attrs.Verb+" "+resource]
+			return true, review, nil
+		})
+		config := resolvedConfigT{ReleaseNamespace: "default"}
+		results, err := checkPermissions(context.Background(), host, testManifests(t, c.manifests...), &config)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		checkDoctorResults(t, c.name, results, c.expected)
+	}
+}
+
+func TestCheckPodSecurity(t *testing.T) {
+	const privileged = `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: kink-test-controlplane
+  labels:
+    kink.meln5674.github.com/cluster-node: "true"
+spec:
+  template:
+    spec:
+      containers:
+      - name: node
+        securityContext:
+          privileged: true
+`
+	const unprivilegedNode = `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: kink-test-worker
+  labels:
+    kink.meln5674.github.com/cluster-node: "true"
+spec:
+  template:
+    spec:
+      containers:
+      - name: node
+`
+	const hostPath = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: kink-test-lb-manager
+spec:
+  template:
+    spec:
+      volumes:
+      - name: host
+        hostPath:
+          path: /var/run
+`
+	cases := []struct {
+		name      string
+		manifests []string
+		namespace *corev1.Namespace
+		expected  [][2]string
+	}{
+		{
+			name:      "missing namespace",
+			manifests: []string{privileged},
+			expected:  [][2]string{{doctorStatusFail, "Namespace default does not exist"}},
+		},
+		{
+			name:      "no privileged pods",
+			manifests: []string{testServiceManifest},
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{podSecurityEnforceLabel: "restricted"}}},
+			expected:  [][2]string{{doctorStatusPass, "No privileged pods"}},
+		},
+		{
+			name:      "unprivileged node",
+			manifests: []string{unprivilegedNode},
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			expected: [][2]string{
+				{doctorStatusWarn, "node containers of StatefulSet/kink-test-worker are not privileged"},
+				{doctorStatusPass, "No privileged pods"},
+			},
+		},
+		{
+			name:      "no level",
+			manifests: []string{privileged},
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			expected:  [][2]string{{doctorStatusPass, "does not set a pod security level"}},
+		},
+		{
+			name:      "baseline",
+			manifests: []string{privileged, hostPath},
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{podSecurityEnforceLabel: "baseline"}}},
+			expected:  [][2]string{{doctorStatusFail, "enforces the baseline pod security level, which forbids StatefulSet/kink-test-controlplane, Deployment/kink-test-lb-manager"}},
+		},
+		{
+			name:      "privileged",
+			manifests: []string{privileged},
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{podSecurityEnforceLabel: podSecurityLevelPrivileged}}},
+			expected:  [][2]string{{doctorStatusPass, "allows privileged pods"}},
+		},
+	}
+	for _, c := range cases {
+		host := fake.NewSimpleClientset()
+		if c.namespace != nil {
+			host = fake.NewSimpleClientset(c.namespace)
+		}
+		config := resolvedConfigT{ReleaseNamespace: "default"}
+		results, err := checkPodSecurity(context.Background(), host, testManifests(t, c.manifests...), &config)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		checkDoctorResults(t, c.name, results, c.expected)
+	}
+}
+
+func TestCheckStorageClasses(t *testing.T) {
+	const claims = `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: kink-test-controlplane
+spec:
+  volumeClaimTemplates:
+  - metadata:
+      name: data
+    spec: {}
+  - metadata:
+      name: preprovisioned
+    spec:
+      storageClassName: ""
+`
+	const namedClaim = `
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: kink-test-shared
+spec:
+  storageClassName: nfs
+`
+	testClass := func(name string, isDefault bool) *storagev1.StorageClass {
+		class := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if isDefault {
+			class.Annotations = map[string]string{defaultStorageClassAnnotation: "true"}
+		}
+		return class
+	}
+	cases := []struct {
+		name      string
+		manifests []string
+		classes   []runtime.Object
+		expected  [][2]string
+	}{
+		{
+			name:      "no persistence",
+			manifests: []string{testServiceManifest},
+			expected:  [][2]string{{doctorStatusPass, "Persistence is disabled"}},
+		},
+		{
+			name:      "default",
+			manifests: []string{claims, namedClaim},
+			classes:   []runtime.Object{testClass("standard", true), testClass("nfs", false)},
+			expected:  [][2]string{{doctorStatusPass, "for all 3 persistent volume claims"}},
+		},
+		{
+			name:      "no default",
+			manifests: []string{claims},
+			classes:   []runtime.Object{testClass("standard", false)},
+			expected:  [][2]string{{doctorStatusFail, "no default StorageClass, which is needed by data-kink-test-controlplane"}},
+		},
+		{
+			name:      "multiple defaults",
+			manifests: []string{claims},
+			classes:   []runtime.Object{testClass("b", true), testClass("a", true)},
+			expected:  [][2]string{{doctorStatusWarn, "multiple default StorageClasses: a, b"}},
+		},
+		{
+			name:      "missing class",
+			manifests: []string{namedClaim},
+			classes:   []runtime.Object{testClass("standard", true)},
+			expected:  [][2]string{{doctorStatusFail, "StorageClass nfs, used by kink-test-shared, does not exist"}},
+		},
+	}
+	for _, c := range cases {
+		host := fake.NewSimpleClientset(c.classes...)
+		config := resolvedConfigT{ReleaseNamespace: "default"}
+		results, err := checkStorageClasses(context.Background(), host, testManifests(t, c.manifests...), &config)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		checkDoctorResults(t, c.name, results, c.expected)
+	}
+}
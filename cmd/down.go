@@ -0,0 +1,85 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/meln5674/rflag"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+
+	cfg "github.com/meln5674/kink/pkg/config"
+)
+
+// downCmd represents the down command
+var downCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Delete all clusters in an environment file",
+	Long: `Deletes every cluster in an environment file created by 'kink up', and removes their exported kubeconfigs.
+Clusters which do not exist are skipped.
+
+Clusters are deleted in parallel, and an error deleting one cluster does not stop the others. All errors are reported
+once every cluster has finished.`,
+	SilenceUsage:      true,
+	PersistentPreRunE: loadEnvironment,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return environmentDown(context.Background(), &downArgs, &resolvedEnvironment)
+	},
+}
+
+type downArgsT struct {
+	Delete deleteClusterArgsT `rflag:""`
+}
+
+func (downArgsT) Defaults() downArgsT {
+	return downArgsT{
+		Delete: deleteClusterArgsT{}.Defaults(),
+	}
+}
+
+var downArgs = downArgsT{}.Defaults()
+
+func init() {
+	rootCmd.AddCommand(downCmd)
+	rflag.MustRegister(rflag.ForPFlag(downCmd.Flags()), "", &environmentArgs)
+	rflag.MustRegister(rflag.ForPFlag(downCmd.Flags()), "", &downArgs)
+}
+
+func environmentDown(ctx context.Context, args *downArgsT, env *resolvedEnvironmentT) error {
+	return env.forEachCluster(environmentArgs.ParallelClusters, func(ix int, cluster *cfg.EnvironmentCluster) error {
+		clusterCfg := env.clusterConfig(ix)
+		releases, err := helmListReleases(ctx, clusterCfg, false)
+		if err != nil {
+			return err
+		}
+		releaseName := clusterCfg.KinkConfig.Release.Raw().Name
+		exists := false
+		for _, release := range releases {
+			if release.Name == releaseName {
+				exists = true
+				break
+			}
+		}
+		if exists {
+			klog.Infof("Deleting cluster %s...", cluster.Name)
+			err = deleteCluster(ctx, &args.Delete, clusterCfg)
+			if err != nil {
+				return err
+			}
+		} else {
+			klog.Infof("Cluster %s does not exist, skipping", cluster.Name)
+		}
+
+		if cluster.Kubeconfig != "" {
+			err = os.Remove(cluster.Kubeconfig)
+			if err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "Failed to remove exported kubeconfig")
+			}
+		}
+		return nil
+	})
+}
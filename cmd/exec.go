@@ -7,12 +7,14 @@ import (
 	"context"
 	"os"
 	"os/exec"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/meln5674/gosh"
 	"github.com/meln5674/rflag"
 	"github.com/spf13/cobra"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 var (
@@ -37,6 +39,8 @@ access to that controlplane. This command sets up that port forwarding, sets the
 to a temporary file that will connect to it, and executes your shell command, then stop forwarding and
 clean up the temporary kubeconfig once it has exited.
 
+Signals such as SIGINT and SIGTERM are relayed to your command, and port forwarding continues until it has exited.
+
 This command does not perform variable replacements or glob expansions. To do this, use 'kink sh'`,
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -60,12 +64,14 @@ type execArgsT struct {
 	ExportKubeconfig       exportKubeconfigCommonArgsT `rflag:""`
 	PortForward            bool                        `rflag:"usage=Set up a localhost port forward for the controlplane during execution. Set to false if using a background 'kink port-forward' command or running in-cluster"`
 	ExportedKubeconfigPath string                      `rflag:"name=exported-kubeconfig,usage=Path to kubeconfig exported during 'create cluster' or 'export kubeconfig' instead of copying it again"`
+	SignalGracePeriod      time.Duration               `rflag:"usage=How long to wait for the command to exit after relaying a signal to it before killing it"`
 }
 
 func (execArgsT) Defaults() execArgsT {
 	return execArgsT{
-		ExportKubeconfig: exportKubeconfigCommonArgsT{}.Defaults(),
-		PortForward:      true,
+		ExportKubeconfig:  exportKubeconfigCommonArgsT{}.Defaults(),
+		PortForward:       true,
+		SignalGracePeriod: 10 * time.Second,
 	}
 }
 
@@ -77,69 +83,44 @@ func init() {
 }
 
 func execWithGateway(ctx context.Context, toExec *gosh.Cmd, args *execArgsT, cfg *resolvedConfigT) (exitCode *int, err error) {
-	exportedKubeconfigPath := args.ExportedKubeconfigPath
-	if exportedKubeconfigPath == "" {
-		kubeconfig, err := os.CreateTemp("", "kink-kubeconfig-*")
-		if err != nil {
-			return nil, err
-		}
-		defer kubeconfig.Close()
-		defer os.Remove(kubeconfig.Name())
-		err = fetchKubeconfig(ctx, cfg, kubeconfig.Name())
+	if args.PortForward {
+		// This is started first so that the kubeconfig uses the local port that was picked, if any
+		stopPortForward, err := startOrReusePortForward(ctx, &args.ExportKubeconfig.PortForward, cfg)
 		if err != nil {
 			return nil, err
 		}
-		kubeconfig.Close()
-		exportedKubeconfigPath = kubeconfig.Name()
-		modifiedKubeconfig, err := buildCompleteKubeconfig(
-			ctx, cfg,
-			exportedKubeconfigPath,
-			&kubeconfigBuilderArgs{
-				errName:           "controlplane",
-				externalHostname:  cfg.ReleaseConfig.ControlplaneHostname,
-				inClusterPort:     int(cfg.ReleaseConfig.ControlplanePort),
-				portForwardPort:   args.ExportKubeconfig.PortForward.ControlplanePort,
-				serverURLOverride: args.ExportKubeconfig.ControlplaneIngressURL,
-				nodeportName:      "api",
-			},
-		)
+		defer stopPortForward()
+	}
+
+	exportedKubeconfigPath := args.ExportedKubeconfigPath
+	if exportedKubeconfigPath == "" {
+		modifiedKubeconfig, err := buildExecKubeconfig(ctx, args, cfg)
 		if err != nil {
 			return nil, err
 		}
-		if args.PortForward {
-			modifiedKubeconfig.CurrentContext = "default"
-		}
-		kubeconfig, err = os.Create(exportedKubeconfigPath)
+		kubeconfig, err := os.CreateTemp("", "kink-kubeconfig-*")
 		if err != nil {
 			return nil, err
 		}
+		defer kubeconfig.Close()
+		defer os.Remove(kubeconfig.Name())
+		exportedKubeconfigPath = kubeconfig.Name()
 		err = saveKubeconfig(kubeconfig, modifiedKubeconfig)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	if args.PortForward {
-		portForwardCtx, cancelPortForward := context.WithCancel(ctx)
-		stopPortForward, err := startPortForward(portForwardCtx, true, &args.ExportKubeconfig.PortForward, cfg)
-		if err != nil {
-			cancelPortForward()
-			return nil, err
-		}
-		defer stopPortForward()
-		defer cancelPortForward()
-	}
-
-	err = toExec.
+	toExec = toExec.
 		WithContext(ctx).
 		WithParentEnvAnd(map[string]string{
 			"KUBECONFIG": exportedKubeconfigPath,
 		}).
-		WithStreams(gosh.ForwardAll).
-		Run()
+		WithStreams(gosh.ForwardAll)
+	err = runForwardingSignals(toExec, args.SignalGracePeriod)
 	var exitError *exec.ExitError
 	if errors.As(err, &exitError) {
-		ec := exitError.ProcessState.ExitCode()
+		ec := commandExitCode(exitError.ProcessState)
 		return &ec, nil
 	}
 	if err != nil {
@@ -147,3 +128,38 @@ func execWithGateway(ctx context.Context, toExec *gosh.Cmd, args *execArgsT, cfg
 	}
 	return nil, nil
 }
+
+// buildExecKubeconfig fetches the kubeconfig from the controlplane and adds the contexts for accessing it, selecting
+// the port-forwarded context if port-forwarding is enabled
+func buildExecKubeconfig(ctx context.Context, args *execArgsT, cfg *resolvedConfigT) (*clientcmdapi.Config, error) {
+	kubeconfig, err := os.CreateTemp("", "kink-kubeconfig-*")
+	if err != nil {
+		return nil, err
+	}
+	defer kubeconfig.Close()
+	defer os.Remove(kubeconfig.Name())
+	err = fetchKubeconfig(ctx, cfg, kubeconfig.Name())
+	if err != nil {
+		return nil, err
+	}
+	kubeconfig.Close()
+	modifiedKubeconfig, err := buildCompleteKubeconfig(
+		ctx, cfg,
+		kubeconfig.Name(),
+		&kubeconfigBuilderArgs{
+			errName:           "controlplane",
+			externalHostname:  cfg.ReleaseConfig.ControlplaneHostname,
+			inClusterPort:     int(cfg.ReleaseConfig.ControlplanePort),
+			portForwardPort:   args.ExportKubeconfig.PortForward.ControlplanePort,
+			serverURLOverride: args.ExportKubeconfig.ControlplaneIngressURL,
+			nodeportName:      "api",
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if args.PortForward {
+		modifiedKubeconfig.CurrentContext = "default"
+	}
+	return modifiedKubeconfig, nil
+}
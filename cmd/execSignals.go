@@ -0,0 +1,69 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/meln5674/gosh"
+	"golang.org/x/term"
+	"k8s.io/klog/v2"
+)
+
+// runForwardingSignals runs a command, relaying any signals kink receives to it instead of exiting, so that anything
+// kink is doing on its behalf, such as port-forwarding, continues until it exits. If it has not exited within the grace
+// period of the first relayed signal, it is killed.
+//
+// If stdin is a terminal, the command stays in the foreground process group, so that it can use the terminal, and
+// receives keyboard signals and terminal resizes directly, so only the remaining signals are relayed, and the command is
+// left to decide for itself how to react to the signals it received directly. Otherwise, it is
+// started in its own process group, and every signal is relayed to that group.
+func runForwardingSignals(cmd *gosh.Cmd, grace time.Duration) error {
+	interactive := term.IsTerminal(int(os.Stdin.Fd()))
+	if !interactive {
+		useOwnProcessGroup(cmd.Cmd)
+	}
+
+	signals := make(chan os.Signal, len(forwardedSignals))
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var killAfter <-chan time.Time
+	for {
+		select {
+		case err := <-done:
+			return err
+		case sig := <-signals:
+			if interactive && isTerminalSignal(sig) {
+				// The command may handle this itself, e.g. a shell ignoring Ctrl-C, so it must not start the grace period
+				klog.V(4).Infof("Got %s, which was also sent to the command by the terminal", sig)
+				continue
+			}
+			if killAfter == nil {
+				killAfter = time.After(grace)
+			}
+			klog.V(4).Infof("Forwarding %s to the command", sig)
+			err := signalProcessGroup(cmd.Cmd, !interactive, sig)
+			if err != nil {
+				klog.Warningf("Failed to forward %s: %v", sig, err)
+			}
+		case <-killAfter:
+			klog.Warningf("Command did not exit within %s of being signaled, killing it", grace)
+			err := killProcessGroup(cmd.Cmd, !interactive)
+			if err != nil {
+				klog.Warningf("Failed to kill command: %v", err)
+			}
+			killAfter = nil
+		}
+	}
+}
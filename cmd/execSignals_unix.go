@@ -0,0 +1,47 @@
+//go:build !windows
+
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+// isTerminalSignal checks if a signal is one that the terminal sends to the whole foreground process group
+func isTerminalSignal(sig os.Signal) bool {
+	return sig == syscall.SIGINT || sig == syscall.SIGQUIT
+}
+
+func useOwnProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+func signalProcessGroup(cmd *exec.Cmd, ownGroup bool, sig os.Signal) error {
+	if !ownGroup {
+		return cmd.Process.Signal(sig)
+	}
+	// A process group started with Setpgid has the same ID as its leader
+	return syscall.Kill(-cmd.Process.Pid, sig.(syscall.Signal))
+}
+
+func killProcessGroup(cmd *exec.Cmd, ownGroup bool) error {
+	return signalProcessGroup(cmd, ownGroup, syscall.SIGKILL)
+}
+
+// commandExitCode is the exit code of a command, using the shell convention of 128 plus the signal number for commands
+// which were killed by a signal
+func commandExitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
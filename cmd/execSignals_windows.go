@@ -0,0 +1,32 @@
+//go:build windows
+
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Console control events are sent to every process attached to the console, so the command receives them directly
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+func isTerminalSignal(sig os.Signal) bool {
+	return true
+}
+
+func useOwnProcessGroup(cmd *exec.Cmd) {}
+
+func signalProcessGroup(cmd *exec.Cmd, ownGroup bool, sig os.Signal) error {
+	return nil
+}
+
+func killProcessGroup(cmd *exec.Cmd, ownGroup bool) error {
+	return cmd.Process.Kill()
+}
+
+func commandExitCode(state *os.ProcessState) int {
+	return state.ExitCode()
+}
@@ -19,7 +19,6 @@ import (
 	"github.com/meln5674/gosh"
 	"github.com/meln5674/rflag"
 
-	"github.com/meln5674/kink/pkg/kubectl"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -267,12 +266,8 @@ func fetchKubeconfig(ctx context.Context, cfg *resolvedConfigT, path string) err
 		path = rel
 	}
 	// TODO: Find a live pod first
-	kubectlCp := kubectl.Cp(&cfg.KinkConfig.Kubectl, &cfg.KinkConfig.Kubernetes, fmt.Sprintf("%s-0", cfg.ReleaseConfig.ControlplaneFullname), kubeconfigPath, path)
-	err := gosh.
-		Command(kubectlCp...).
-		WithContext(ctx).
-		WithStreams(gosh.ForwardOutErr).
-		Run()
+	copyKubeconfig := kubectlCp(ctx, cfg, fmt.Sprintf("%s-0", cfg.ReleaseConfig.ControlplaneFullname), kubeconfigPath, path)
+	err := withStreams(copyKubeconfig, gosh.ForwardOutErr).Run()
 	if err != nil {
 		return errors.Wrap(err, "Could not extract kubeconfig from controlplane pod, make sure controlplane is healthy")
 	}
@@ -0,0 +1,143 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/meln5674/rflag"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+// fileGatewayFetchCmd represents the file-gateway fetch command
+var fileGatewayFetchCmd = &cobra.Command{
+	Use:   "fetch [flags...] path",
+	Short: "Fetch files from the cluster",
+	Long: `Fetch a file or directory from the cluster via the filesystem gateway, such as test artifacts written to shared persistence. Like send, this avoids relying on kubectl cp/exec, which uses the controlplane.
+
+	The file or directory is extracted into the destination directory with the same name, analagous to kubectl exec -- tar c -C "$(dirname path)" "$(basename path)" | tar x -C dest.
+
+	Only paths mounted from the shared persistence volume can be fetched.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !resolvedConfig.ReleaseConfig.FileGatewayEnabled {
+			return errors.New("The file gateway is not enabled for this cluster")
+		}
+		return fetchFromFileGateway(context.Background(), &fileGatewayFetchArgs, &resolvedConfig, args[0])
+	},
+}
+
+type fileGatewayFetchArgsT struct {
+	ExportKubeconfig exportKubeconfigCommonArgsT `rflag:""`
+	Dest             string                      `rflag:"name=fetch-dest,usage=local directory to extract into (equivalent to tar's -C)"`
+	Gzip             bool                        `rflag:"name=fetch-gzip,usage=Have the file gateway compress the archive while sending it"`
+	Exclude          []string                    `rflag:"name=fetch-exclude,usage=Do not fetch paths which match this glob. Paths are matched starting with the name of the fetched file or directory"`
+	PreserveOwners   bool                        `rflag:"name=fetch-preserve-owners,usage=Set the uid and gid of fetched files to those within the cluster"`
+	PreserveTimes    bool                        `rflag:"name=fetch-preserve-times,usage=Set the modification times of fetched files to those within the cluster"`
+	IngressURL       string                      `rflag:"name=file-gateway-ingress-url,usage=If ingress is used for the file gateway,, instead use this URL,, and set the tls-server-name to the expected ingress hostname. Ignored if controlplane ingress is not used."`
+	PortForward      bool                        `rflag:"usage=Set up a localhost port forward for the file gateway during execution if no ingress or nodeport was set. Set to false if using a background 'kink port-forward' command. Ignored if using an ingress or nodeport for the file gateway."`
+}
+
+func (fileGatewayFetchArgsT) Defaults() fileGatewayFetchArgsT {
+	return fileGatewayFetchArgsT{
+		ExportKubeconfig: exportKubeconfigCommonArgsT{}.Defaults(),
+		Dest:             ".",
+		Exclude:          []string{},
+		PortForward:      true,
+	}
+}
+
+var fileGatewayFetchArgs = fileGatewayFetchArgsT{}.Defaults()
+
+func init() {
+	fileGatewayCmd.AddCommand(fileGatewayFetchCmd)
+	rflag.MustRegister(rflag.ForPFlag(fileGatewayFetchCmd.Flags()), "", &fileGatewayFetchArgs)
+}
+
+func fetchFromFileGateway(ctx context.Context, args *fileGatewayFetchArgsT, cfg *resolvedConfigT, path string) error {
+	conn, err := connectToFileGateway(ctx, &args.ExportKubeconfig, args.IngressURL, args.PortForward, cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	query := url.Values{}
+	if args.Gzip {
+		query.Set("compress", "gzip")
+	}
+	for _, exclude := range args.Exclude {
+		query.Add("exclude", exclude)
+	}
+
+	resp, err := conn.client.Get(conn.urlFor(path, query).String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fileGatewayError(resp)
+	}
+
+	var archiveContents io.Reader = resp.Body
+	if args.Gzip {
+		gzipReader, err := gzip.NewReader(archiveContents)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		archiveContents = gzipReader
+	}
+	return extractTarArchive(tar.NewReader(archiveContents), args.Dest, args.PreserveOwners, args.PreserveTimes)
+}
+
+// extractTarArchive extracts a tar archive into a local directory, refusing any entries or links which would be
+// outside of it, including through symlinks extracted earlier from the same archive
+func extractTarArchive(archive *tar.Reader, dest string, preserveOwners, preserveTimes bool) error {
+	dest, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+	isWithinDest := func(path string) bool {
+		return containsResolved(dest, path)
+	}
+	opts := extractOptions{
+		RootDir:        dest,
+		IsAllowed:      isWithinDest,
+		PreserveOwners: preserveOwners,
+		PreserveTimes:  preserveTimes,
+	}
+	err = os.MkdirAll(dest, 0755)
+	if err != nil {
+		return err
+	}
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fullpath := filepath.Join(dest, filepath.FromSlash(header.Name))
+		if !isWithinDest(fullpath) {
+			return fmt.Errorf("%s is outside of %s", header.Name, dest)
+		}
+		err = extractFromArchive(archive, fullpath, header, &opts)
+		if err != nil {
+			return err
+		}
+		klog.V(4).Infof("Fetched: %s", header.Name)
+	}
+}
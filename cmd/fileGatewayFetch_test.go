@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testTarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	contents string
+}
+
+func testTarArchive(t *testing.T, entries []testTarEntry) *tar.Reader {
+	return tar.NewReader(bytes.NewReader(testTarBytes(t, entries)))
+}
+
+func testTarBytes(t *testing.T, entries []testTarEntry) []byte {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Linkname: entry.linkname,
+			Mode:     0644,
+			Size:     int64(len(entry.contents)),
+		}
+		if entry.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		err := w.WriteHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write([]byte(entry.contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractTarArchiveSymlinkEscape(t *testing.T) {
+	cases := []struct {
+		name    string
+		entries []testTarEntry
+	}{
+		{
+			// z is lexically dest/y/x/../.. == dest, but physically, y is dest, so z is the parent of dest
+			name: "through earlier symlink in target",
+			entries: []testTarEntry{
+				{name: "x/", typeflag: tar.TypeDir},
+				{name: "y", typeflag: tar.TypeSymlink, linkname: "."},
+				{name: "z", typeflag: tar.TypeSymlink, linkname: "y/x/../.."},
+				{name: "z/pwned", typeflag: tar.TypeReg, contents: "pwned"},
+			},
+		},
+		{
+			// l2 is lexically dest/l1/l2 -> dest, but physically, l1 is dest, so l2 is dest/l2 -> the parent of dest
+			name: "through earlier symlink in name",
+			entries: []testTarEntry{
+				{name: "l1", typeflag: tar.TypeSymlink, linkname: "."},
+				{name: "l1/l2", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "l2/pwned", typeflag: tar.TypeReg, contents: "pwned"},
+			},
+		},
+	}
+	for _, c := range cases {
+		root := t.TempDir()
+		dest := filepath.Join(root, "dest")
+		err := extractTarArchive(testTarArchive(t, c.entries), dest, false, false)
+		if err == nil {
+			t.Errorf("%s: expected extraction to fail", c.name)
+		}
+		if _, err := os.Lstat(filepath.Join(root, "pwned")); !os.IsNotExist(err) {
+			t.Errorf("%s: file was written outside of the destination: %v", c.name, err)
+		}
+		if _, err := os.Lstat(filepath.Join(dest, "l2")); !os.IsNotExist(err) {
+			t.Errorf("%s: symlink outside of the destination was created: %v", c.name, err)
+		}
+	}
+}
+
+func TestExtractTarArchive(t *testing.T) {
+	dest := t.TempDir()
+	archive := testTarArchive(t, []testTarEntry{
+		{name: "dir/", typeflag: tar.TypeDir},
+		{name: "dir/file", typeflag: tar.TypeReg, contents: "contents"},
+		{name: "link", typeflag: tar.TypeSymlink, linkname: "dir"},
+		{name: "link/other", typeflag: tar.TypeReg, contents: "other"},
+	})
+	err := extractTarArchive(archive, dest, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{"dir/file": "contents", "dir/other": "other"} {
+		actual, err := os.ReadFile(filepath.Join(dest, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != expected {
+			t.Errorf("%s: expected %q, got %q", path, expected, string(actual))
+		}
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// hardlinkID identifies the file a hardlink points to
+type hardlinkID struct {
+	dev uint64
+	ino uint64
+}
+
+// getHardlinkID returns which file a path is a hardlink to, if it has more than one link
+func getHardlinkID(info fs.FileInfo) (hardlinkID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return hardlinkID{}, false
+	}
+	return hardlinkID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
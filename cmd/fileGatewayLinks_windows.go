@@ -0,0 +1,17 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"io/fs"
+)
+
+// hardlinkID identifies the file a hardlink points to
+type hardlinkID struct{}
+
+// getHardlinkID always reports no hardlinks, as they cannot be detected from a FileInfo on windows, so every link is
+// sent as a separate file
+func getHardlinkID(info fs.FileInfo) (hardlinkID, bool) {
+	return hardlinkID{}, false
+}
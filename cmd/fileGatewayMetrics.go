@@ -0,0 +1,131 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+)
+
+const (
+	fileGatewayMetricsNamespace = "kink_file_gateway"
+)
+
+var (
+	fileGatewayMetricsRegistry = prometheus.NewRegistry()
+
+	fileGatewayRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: fileGatewayMetricsNamespace,
+		Name:      "requests_total",
+		Help:      "Number of requests, by method and status code",
+	}, []string{"method", "code"})
+	fileGatewayRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: fileGatewayMetricsNamespace,
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to handle requests, by method",
+		Buckets:   []float64{0.01, 0.1, 0.5, 1, 5, 15, 30, 60, 300, 900},
+	}, []string{"method"})
+	fileGatewayExtractedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: fileGatewayMetricsNamespace,
+		Name:      "extracted_bytes_total",
+		Help:      "Number of bytes of file contents extracted from archives",
+	})
+	fileGatewayExtractedFiles = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: fileGatewayMetricsNamespace,
+		Name:      "extracted_files_total",
+		Help:      "Number of files, directories, and links extracted from archives",
+	})
+	fileGatewayRequestBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: fileGatewayMetricsNamespace,
+		Name:      "request_extracted_bytes",
+		Help:      "Number of bytes of file contents extracted by each request which extracts an archive",
+		Buckets:   prometheus.ExponentialBuckets(1024, 8, 10),
+	})
+	fileGatewayErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: fileGatewayMetricsNamespace,
+		Name:      "errors_total",
+		Help:      "Number of failed requests, by kind of error",
+	}, []string{"kind"})
+)
+
+func init() {
+	fileGatewayMetricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		fileGatewayRequests,
+		fileGatewayRequestDuration,
+		fileGatewayExtractedBytes,
+		fileGatewayExtractedFiles,
+		fileGatewayRequestBytes,
+		fileGatewayErrors,
+	)
+}
+
+// fileGatewayErrorKind is the label used to count an error response
+func fileGatewayErrorKind(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusNotFound:
+		return "not-found"
+	case http.StatusRequestEntityTooLarge:
+		return "too-large"
+	default:
+		return "internal"
+	}
+}
+
+// instrumentFileGateway records the count and duration of every request to a handler
+func instrumentFileGateway(handler http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerCounter(
+		fileGatewayRequests,
+		promhttp.InstrumentHandlerDuration(fileGatewayRequestDuration, handler),
+	)
+}
+
+// checkWritable checks that a file can be created in each allowed directory
+func (f *fileGatewayServer) checkWritable() error {
+	for _, dir := range f.args.AllowedDirs {
+		probe, err := os.CreateTemp(dir, ".kink-healthz-*")
+		if err != nil {
+			return fmt.Errorf("%s is not writable: %v", dir, err)
+		}
+		probe.Close()
+		err = os.Remove(probe.Name())
+		if err != nil {
+			return fmt.Errorf("%s is not writable: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+// serveHealth responds OK if every allowed directory is writable
+func (f *fileGatewayServer) serveHealth(w http.ResponseWriter, req *http.Request) {
+	err := f.checkWritable()
+	if err != nil {
+		klog.Warningf("Health check failed: %v", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Write([]byte("OK"))
+}
+
+// metricsHandler serves metrics, as well as the health endpoints. The main listener requires a client certificate,
+// which probes cannot present, so they are only served here.
+func (f *fileGatewayServer) metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(fileGatewayMetricsRegistry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", f.serveHealth)
+	mux.HandleFunc("/readyz", f.serveHealth)
+	return mux
+}
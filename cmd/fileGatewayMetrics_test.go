@@ -0,0 +1,40 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileGatewayHealthEndpoints(t *testing.T) {
+	_, data, _ := testGatewayDirs(t)
+	cases := []struct {
+		name        string
+		allowedDirs []string
+		status      int
+	}{
+		{"writable", []string{data}, http.StatusOK},
+		{"missing", []string{data, filepath.Join(data, "missing")}, http.StatusServiceUnavailable},
+	}
+	for _, c := range cases {
+		server := &fileGatewayServer{args: fileGatewayRecvArgsT{AllowedDirs: c.allowedDirs}}
+		for _, path := range []string{"/healthz", "/readyz"} {
+			resp := httptest.NewRecorder()
+			server.metricsHandler().ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "http://kink"+path, nil))
+			if resp.Code != c.status {
+				t.Errorf("%s: %s: expected status %d, got %d: %s", c.name, path, c.status, resp.Code, resp.Body.String())
+			}
+
+			// The main listener treats these as ordinary paths, which are not within an allowed directory
+			resp = httptest.NewRecorder()
+			server.handler().ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "http://kink"+path, nil))
+			if resp.Code != http.StatusForbidden {
+				t.Errorf("%s: %s: expected the main listener to not serve health checks, got %d: %s", c.name, path, resp.Code, resp.Body.String())
+			}
+		}
+	}
+}
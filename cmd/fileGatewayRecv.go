@@ -6,31 +6,44 @@ package cmd
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/bmatcuk/doublestar/v4"
 	"github.com/meln5674/rflag"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+	"k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// fileGatewayShutdownTimeout is how long in-flight requests are given to finish when the file gateway is stopped
+	fileGatewayShutdownTimeout = 10 * time.Second
 )
 
 // recvCmd represents the file-gateway recv command
 var recvCmd = &cobra.Command{
 	Use:          "recv",
-	Short:        "Run an HTTP(s) server which accepts tar archives and extracts them onto specified directories",
+	Short:        "Run an HTTP(s) server which accepts tar archives and extracts them onto specified directories, and serves tar archives of them",
 	Long:         ``,
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return fileGatewayServer{args: fileGatewayRecvArgs}.ListenAndServe()
+		return fileGatewayServer{args: fileGatewayRecvArgs}.ListenAndServe(ctrl.SetupSignalHandler())
 	},
 }
 
@@ -40,11 +53,15 @@ type fileGatewayRecvArgsT struct {
 	CertPath    string   `rflag:"name=cert,usage=TLS server cert file path"`
 	CAPath      string   `rflag:"name=ca,usage=mTLS client CA cert file path"`
 	AllowedDirs []string `rflag:"name=allowed-dir,usage=Allow directory to be extracted to"`
+	MaxBytes    int      `rflag:"usage=Maximum total size of the files in an extracted archive. 0 is unlimited"`
+	MaxEntries  int      `rflag:"usage=Maximum number of entries in an extracted archive. 0 is unlimited"`
+	MetricsAddr string   `rflag:"name=metrics-bind-address,usage=The address the metrics and health endpoints bind to. These are served over plain HTTP without authentication. Set to empty to disable"`
 }
 
 func (fileGatewayRecvArgsT) Defaults() fileGatewayRecvArgsT {
 	return fileGatewayRecvArgsT{
 		AllowedDirs: []string{},
+		MetricsAddr: ":8080",
 	}
 }
 
@@ -87,23 +104,165 @@ type fileGatewayServer struct {
 
 func (f *fileGatewayServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
-	rootDir := req.URL.Path
 	query, err := url.ParseQuery(req.URL.RawQuery)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("Invalid query: %v", err)))
+		writeFileGatewayError(w, http.StatusBadRequest, "", fmt.Sprintf("Invalid query: %v", err))
 		return
 	}
 
-	wipeDirs := query.Get("wipe-dirs") == "true"
+	switch req.Method {
+	case http.MethodGet:
+		if query.Get("manifest") == "true" {
+			f.serveManifest(w, req)
+			return
+		}
+		f.serveArchive(w, req, query)
+	case http.MethodDelete:
+		f.deletePaths(w, req)
+	case http.MethodPost:
+		if query.Get("hash") == "true" {
+			f.serveHashes(w, req)
+			return
+		}
+		if query.Get("attrs") == "true" {
+			f.setAttrs(w, req)
+			return
+		}
+		f.extractArchive(w, req, query)
+	default:
+		f.extractArchive(w, req, query)
+	}
+}
+
+// fileGatewayErrorResponse is the body of every failed file gateway request
+type fileGatewayErrorResponse struct {
+	Error string `json:"error"`
+	// Entry is the archive entry or path which caused the error, if any
+	Entry string `json:"entry,omitempty"`
+}
+
+func writeFileGatewayError(w http.ResponseWriter, status int, entry string, msg string) {
+	fileGatewayErrors.WithLabelValues(fileGatewayErrorKind(status)).Inc()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(fileGatewayErrorResponse{Error: msg, Entry: entry})
+	if err != nil {
+		klog.Errorf("Failed to write error response: %v", err)
+	}
+}
+
+// pathContains checks if a path is a directory or within it, by whole path components, i.e. /data contains
+// /data/foo, but not /data-foo
+func pathContains(dir, path string) bool {
+	dir = filepath.Clean(dir)
+	path = filepath.Clean(path)
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// resolveExistingPath resolves any symlinks in the longest prefix of a path which exists, so that a path can be
+// checked for containment even if it is about to be created
+func resolveExistingPath(path string) (string, error) {
+	path = filepath.Clean(path)
+	rest := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return filepath.Join(path, rest), nil
+		}
+		rest = filepath.Join(filepath.Base(path), rest)
+		path = parent
+	}
+}
+
+// containsResolved checks if a path is within a directory, both as written, and after resolving any symlinks which
+// already exist in the directories containing it. The path itself is not resolved, as any file or link there is
+// replaced rather than written through. The path may be written relative to either the directory or its resolved form.
+func containsResolved(dir, fullpath string) bool {
+	fullpath = filepath.Clean(fullpath)
+	resolvedDir, err := resolveExistingPath(dir)
+	if err != nil {
+		klog.Warningf("Could not resolve %s: %v", dir, err)
+		return false
+	}
+	if !pathContains(dir, fullpath) && !pathContains(resolvedDir, fullpath) {
+		return false
+	}
+	if fullpath == filepath.Clean(dir) || fullpath == resolvedDir {
+		return true
+	}
+	resolvedParent, err := resolveExistingPath(filepath.Dir(fullpath))
+	if err != nil {
+		klog.Warningf("Could not resolve %s: %v", fullpath, err)
+		return false
+	}
+	return pathContains(resolvedDir, resolvedParent)
+}
+
+// isAllowed checks if a path is within an allowed directory, including after resolving any symlinks which already
+// exist on the volume, such as those created by pods
+func (f *fileGatewayServer) isAllowed(fullpath string) bool {
+	for _, allowed := range f.args.AllowedDirs {
+		if containsResolved(allowed, fullpath) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowedDir checks if a path is strictly within an allowed directory, and not an allowed directory itself
+func (f *fileGatewayServer) isAllowedDir(fullpath string) bool {
+	for _, allowed := range f.args.AllowedDirs {
+		if filepath.Clean(allowed) == filepath.Clean(fullpath) {
+			return false
+		}
+	}
+	return f.isAllowed(fullpath)
+}
+
+// extractArchive extracts the tar archive in the request body into the requested directory. In atomic mode, the
+// archive is extracted into a staging directory next to the requested directory, which then replaces it only if the
+// entire archive is extracted successfully.
+func (f *fileGatewayServer) extractArchive(w http.ResponseWriter, req *http.Request, query url.Values) {
+	rootDir := filepath.Clean(req.URL.Path)
+
 	gzipped := query.Get("compress") == "gzip"
+	atomic := query.Get("atomic") == "true"
+	opts := extractOptions{
+		RootDir:        rootDir,
+		IsAllowed:      f.isAllowed,
+		WipeDirs:       query.Get("wipe-dirs") == "true",
+		PreserveOwners: query.Get("preserve-owners") == "true",
+		PreserveTimes:  query.Get("preserve-times") == "true",
+	}
+
+	if atomic {
+		if !f.isAllowedDir(rootDir) {
+			writeFileGatewayError(w, http.StatusForbidden, "", fmt.Sprintf("%s is not strictly within an allowed directory, which is required for atomic extraction", rootDir))
+			return
+		}
+		stagingDir := filepath.Join(filepath.Dir(rootDir), fmt.Sprintf(".%s.kink-staging-%s", filepath.Base(rootDir), rand.String(8)))
+		err := os.MkdirAll(stagingDir, 0755)
+		if err != nil {
+			writeFileGatewayError(w, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+		// If the staging directory is committed, this will do nothing
+		defer os.RemoveAll(stagingDir)
+		opts.RootDir = stagingDir
+	}
 
 	archiveContents := req.Body
 	if gzipped {
 		gzipReader, err := gzip.NewReader(archiveContents)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(fmt.Sprintf("Failed to read next tar header: %v", err)))
+			writeFileGatewayError(w, http.StatusBadRequest, "", fmt.Sprintf("Failed to read next tar header: %v", err))
 			return
 		}
 		defer gzipReader.Close()
@@ -112,88 +271,525 @@ func (f *fileGatewayServer) ServeHTTP(w http.ResponseWriter, req *http.Request)
 
 	archive := tar.NewReader(archiveContents)
 
-	extractFromArchive := func(fullpath string, header *tar.Header) error {
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if wipeDirs {
-				err = os.RemoveAll(fullpath)
-				if err != nil {
-					return err
+	entries := 0
+	var totalBytes int64
+	var extractedBytes int64
+	defer func() { fileGatewayRequestBytes.Observe(float64(extractedBytes)) }()
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			writeFileGatewayError(w, http.StatusBadRequest, "", fmt.Sprintf("Failed to read next tar header: %v", err))
+			return
+		}
+		entries++
+		if f.args.MaxEntries > 0 && entries > f.args.MaxEntries {
+			writeFileGatewayError(w, http.StatusRequestEntityTooLarge, header.Name, fmt.Sprintf("Archive has more than the maximum of %d entries", f.args.MaxEntries))
+			return
+		}
+		totalBytes += header.Size
+		if f.args.MaxBytes > 0 && totalBytes > int64(f.args.MaxBytes) {
+			writeFileGatewayError(w, http.StatusRequestEntityTooLarge, header.Name, fmt.Sprintf("Archive has more than the maximum of %d bytes", f.args.MaxBytes))
+			return
+		}
+		fullpath := filepath.Join(opts.RootDir, header.Name)
+		if atomic && !pathContains(opts.RootDir, fullpath) {
+			writeFileGatewayError(w, http.StatusForbidden, header.Name, fmt.Sprintf("%s is not within %s, which is required for atomic extraction", filepath.Join(rootDir, header.Name), rootDir))
+			return
+		}
+		if !f.isAllowed(fullpath) {
+			writeFileGatewayError(w, http.StatusForbidden, header.Name, fmt.Sprintf("%s is not within an allowed directory", fullpath))
+			return
+		}
+		err = extractFromArchive(archive, fullpath, header, &opts)
+		if err != nil {
+			writeFileGatewayError(w, http.StatusBadRequest, header.Name, err.Error())
+			return
+		}
+		fileGatewayExtractedFiles.Inc()
+		if header.Typeflag == tar.TypeReg {
+			fileGatewayExtractedBytes.Add(float64(header.Size))
+			extractedBytes += header.Size
+		}
+		klog.Infof("Recv: %s %s", rootDir, header.Name)
+	}
+
+	if atomic {
+		err := replaceDir(opts.RootDir, rootDir)
+		if err != nil {
+			writeFileGatewayError(w, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+	}
+
+	w.Write([]byte("OK"))
+}
+
+// replaceDir moves a directory into place of another, which is removed. The original directory is moved aside rather
+// than removed first, so that it can be restored if the new one cannot be moved into place.
+func replaceDir(src, dest string) error {
+	oldDir := filepath.Join(filepath.Dir(dest), fmt.Sprintf(".%s.kink-old-%s", filepath.Base(dest), rand.String(8)))
+	err := os.Rename(dest, oldDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return os.Rename(src, dest)
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(src, dest)
+	if err != nil {
+		if restoreErr := os.Rename(oldDir, dest); restoreErr != nil {
+			klog.Errorf("Failed to restore %s from %s: %v", dest, oldDir, restoreErr)
+		}
+		return err
+	}
+	return os.RemoveAll(oldDir)
+}
+
+// serveArchive streams a tar archive of the requested file or directory. Entries are named relative to the parent
+// of the requested path, like tar -C "$(dirname path)" "$(basename path)".
+func (f *fileGatewayServer) serveArchive(w http.ResponseWriter, req *http.Request, query url.Values) {
+	rootPath := filepath.Clean(req.URL.Path)
+	gzipped := query.Get("compress") == "gzip"
+	excludes := query["exclude"]
+
+	if !f.isAllowed(rootPath) {
+		writeFileGatewayError(w, http.StatusForbidden, "", fmt.Sprintf("%s is not within an allowed directory", rootPath))
+		return
+	}
+	for _, exclude := range excludes {
+		if !doublestar.ValidatePattern(exclude) {
+			writeFileGatewayError(w, http.StatusBadRequest, "", fmt.Sprintf("Invalid exclude pattern: %s", exclude))
+			return
+		}
+	}
+	_, err := os.Stat(rootPath)
+	if errors.Is(err, os.ErrNotExist) {
+		writeFileGatewayError(w, http.StatusNotFound, "", fmt.Sprintf("%s does not exist", rootPath))
+		return
+	}
+	if err != nil {
+		writeFileGatewayError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	var archiveContents io.Writer = w
+	var gzipWriter *gzip.Writer
+	if gzipped {
+		gzipWriter = gzip.NewWriter(w)
+		archiveContents = gzipWriter
+	}
+	archive := tar.NewWriter(archiveContents)
+
+	parentDir := filepath.Dir(rootPath)
+	hardlinks := make(map[hardlinkID]string)
+	err = filepath.WalkDir(rootPath, func(fullpath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(parentDir, fullpath)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		for _, exclude := range excludes {
+			if matches, _ := doublestar.Match(exclude, name); matches {
+				if entry.IsDir() {
+					return filepath.SkipDir
 				}
+				return nil
 			}
-			err = os.MkdirAll(fullpath, os.FileMode(header.Mode))
-			if err != nil {
-				return err
-			}
-		case tar.TypeReg:
-			f, err := os.Create(fullpath)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-			f.Chmod(os.FileMode(header.Mode))
-			_, err = io.Copy(f, archive)
-			if err != nil {
-				return err
-			}
-		default:
-			if err != nil {
-				return fmt.Errorf("%s: Unsupported type, only directories and regular files are permitted", header.Name)
-			}
 		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return addFileToArchive(archive, fullpath, name, info, hardlinks)
+	})
+	if err == nil {
+		err = archive.Close()
+	}
+	if err == nil && gzipWriter != nil {
+		err = gzipWriter.Close()
+	}
+	if err != nil {
+		// The status has already been sent, so the only way to signal the client is to abort the response, which
+		// it will see as a truncated archive, so the archive must not be closed, as that would make it look complete
+		klog.Errorf("Send: %s: %v", rootPath, err)
+		panic(http.ErrAbortHandler)
+	}
+	klog.Infof("Send: %s", rootPath)
+}
 
-		return nil
+// serveManifest lists every file and directory within the requested directory, so that a client can determine
+// which files it needs to send. A directory which does not exist has an empty manifest.
+func (f *fileGatewayServer) serveManifest(w http.ResponseWriter, req *http.Request) {
+	rootDir := filepath.Clean(req.URL.Path)
+	if !f.isAllowed(rootDir) {
+		writeFileGatewayError(w, http.StatusForbidden, "", fmt.Sprintf("%s is not within an allowed directory", rootDir))
+		return
 	}
+	manifest, err := buildFileGatewayManifest(rootDir)
+	if err != nil {
+		writeFileGatewayError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(manifest)
+	if err != nil {
+		klog.Errorf("Manifest: %s: %v", rootDir, err)
+		return
+	}
+	klog.Infof("Manifest: %s", rootDir)
+}
 
-	for {
-		header, err := archive.Next()
-		if errors.Is(err, io.EOF) {
-			break
+// serveHashes computes the sha256 of the files listed in the request body, relative to the requested directory, so
+// that a client can compare files whose size matches but whose modification time does not. Paths which do not exist,
+// or are not regular files, are omitted.
+func (f *fileGatewayServer) serveHashes(w http.ResponseWriter, req *http.Request) {
+	rootDir := filepath.Clean(req.URL.Path)
+	var paths []string
+	err := json.NewDecoder(req.Body).Decode(&paths)
+	if err != nil {
+		writeFileGatewayError(w, http.StatusBadRequest, "", fmt.Sprintf("Invalid list of paths: %v", err))
+		return
+	}
+	hashes := make(map[string]string, len(paths))
+	for _, path := range paths {
+		fullpath := filepath.Clean(filepath.Join(rootDir, path))
+		if !pathContains(rootDir, fullpath) || !f.isAllowed(fullpath) {
+			writeFileGatewayError(w, http.StatusForbidden, path, fmt.Sprintf("%s is not within an allowed directory", fullpath))
+			return
+		}
+		info, err := os.Lstat(fullpath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
 		}
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(fmt.Sprintf("Failed to read next tar header: %v", err)))
+			writeFileGatewayError(w, http.StatusInternalServerError, path, err.Error())
 			return
 		}
-		fullpath := filepath.Clean(filepath.Join(rootDir, header.Name))
-		isAllowed := false
-		for _, allowed := range f.args.AllowedDirs {
-			if strings.HasPrefix(fullpath, allowed) {
-				isAllowed = true
-				break
-			}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		hashes[path], err = sha256File(fullpath)
+		if err != nil {
+			writeFileGatewayError(w, http.StatusInternalServerError, path, err.Error())
+			return
 		}
-		if !isAllowed {
-			w.WriteHeader(http.StatusForbidden)
-			w.Write([]byte(fmt.Sprintf("%s is not within an allowed directory", fullpath)))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(hashes)
+	if err != nil {
+		klog.Errorf("Hash: %s: %v", rootDir, err)
+		return
+	}
+	klog.Infof("Hash: %s: %d files", rootDir, len(hashes))
+}
+
+// deletePaths removes the files and directories listed in the request body, relative to the requested directory
+func (f *fileGatewayServer) deletePaths(w http.ResponseWriter, req *http.Request) {
+	rootDir := filepath.Clean(req.URL.Path)
+	var paths []string
+	err := json.NewDecoder(req.Body).Decode(&paths)
+	if err != nil {
+		writeFileGatewayError(w, http.StatusBadRequest, "", fmt.Sprintf("Invalid list of paths: %v", err))
+		return
+	}
+	for _, path := range paths {
+		fullpath := filepath.Clean(filepath.Join(rootDir, path))
+		if fullpath == rootDir || !pathContains(rootDir, fullpath) || !f.isAllowed(fullpath) {
+			writeFileGatewayError(w, http.StatusForbidden, path, fmt.Sprintf("%s is not within an allowed directory", fullpath))
 			return
 		}
-		err = extractFromArchive(fullpath, header)
+	}
+	for _, path := range paths {
+		fullpath := filepath.Join(rootDir, path)
+		err = os.RemoveAll(fullpath)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
+			writeFileGatewayError(w, http.StatusInternalServerError, "", err.Error())
 			return
 		}
-		klog.Infof("Recv: %s %s", rootDir, header.Name)
+		klog.Infof("Delete: %s %s", rootDir, path)
 	}
+	w.Write([]byte("OK"))
+}
 
+// setAttrs sets the mode and modification time of the files and directories listed in the request body, relative to
+// the requested directory, so that a client can update those whose contents already match without sending them.
+// Symlinks are refused, as setting their mode would set that of their target instead.
+func (f *fileGatewayServer) setAttrs(w http.ResponseWriter, req *http.Request) {
+	rootDir := filepath.Clean(req.URL.Path)
+	var entries []fileGatewayManifestEntry
+	err := json.NewDecoder(req.Body).Decode(&entries)
+	if err != nil {
+		writeFileGatewayError(w, http.StatusBadRequest, "", fmt.Sprintf("Invalid list of entries: %v", err))
+		return
+	}
+	for _, entry := range entries {
+		fullpath := filepath.Clean(filepath.Join(rootDir, entry.Path))
+		if fullpath == rootDir || !pathContains(rootDir, fullpath) || !f.isAllowed(fullpath) {
+			writeFileGatewayError(w, http.StatusForbidden, entry.Path, fmt.Sprintf("%s is not within an allowed directory", fullpath))
+			return
+		}
+	}
+	for _, entry := range entries {
+		fullpath := filepath.Join(rootDir, entry.Path)
+		info, err := os.Lstat(fullpath)
+		if errors.Is(err, os.ErrNotExist) {
+			writeFileGatewayError(w, http.StatusNotFound, entry.Path, fmt.Sprintf("%s does not exist", fullpath))
+			return
+		}
+		if err != nil {
+			writeFileGatewayError(w, http.StatusInternalServerError, entry.Path, err.Error())
+			return
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			writeFileGatewayError(w, http.StatusBadRequest, entry.Path, fmt.Sprintf("%s is a symlink", fullpath))
+			return
+		}
+		err = os.Chmod(fullpath, entry.Mode.Perm())
+		if err != nil {
+			writeFileGatewayError(w, http.StatusInternalServerError, entry.Path, err.Error())
+			return
+		}
+		err = os.Chtimes(fullpath, entry.ModTime, entry.ModTime)
+		if err != nil {
+			writeFileGatewayError(w, http.StatusInternalServerError, entry.Path, err.Error())
+			return
+		}
+	}
+	klog.Infof("Attrs: %s: %d paths", rootDir, len(entries))
 	w.Write([]byte("OK"))
 }
 
-func (f fileGatewayServer) ListenAndServe() error {
+// addFileToArchive adds a single file, directory, or link, but not the contents of a directory, to a tar archive.
+// Regular files which are hardlinks to a file already in the archive, as tracked by hardlinks, are added as hardlinks.
+func addFileToArchive(archive *tar.Writer, fullpath, name string, info fs.FileInfo, hardlinks map[hardlinkID]string) error {
+	mode := info.Mode()
+	if mode&(fs.ModeDevice|fs.ModeNamedPipe|fs.ModeSocket|fs.ModeCharDevice|fs.ModeIrregular) != 0 {
+		klog.Warningf("%s: Unsupported type, only directories, regular files, and links are sent, skipping", fullpath)
+		return nil
+	}
+	var link string
+	if mode&fs.ModeSymlink != 0 {
+		var err error
+		link, err = os.Readlink(fullpath)
+		if err != nil {
+			return err
+		}
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	// PAX preserves sub-second modification times, which sync relies on to detect changes without hashing
+	header.Format = tar.FormatPAX
+	if header.Typeflag == tar.TypeReg {
+		if id, ok := getHardlinkID(info); ok {
+			if target, ok := hardlinks[id]; ok {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = target
+				header.Size = 0
+			} else {
+				hardlinks[id] = name
+			}
+		}
+	}
+	err = archive.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+	f, err := os.Open(fullpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(archive, f, header.Size)
+	return err
+}
+
+// extractOptions control how entries of a tar archive are extracted
+type extractOptions struct {
+	// RootDir is the directory the archive is being extracted into, which hardlink targets are relative to
+	RootDir string
+	// IsAllowed checks if a path may be written to, or be the target of a link
+	IsAllowed      func(string) bool
+	WipeDirs       bool
+	PreserveOwners bool
+	PreserveTimes  bool
+}
+
+// extractFromArchive extracts the current entry of a tar archive to a path
+func extractFromArchive(archive *tar.Reader, fullpath string, header *tar.Header, opts *extractOptions) error {
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if opts.WipeDirs {
+			err := os.RemoveAll(fullpath)
+			if err != nil {
+				return err
+			}
+		}
+		err := os.MkdirAll(fullpath, os.FileMode(header.Mode))
+		if err != nil {
+			return err
+		}
+	case tar.TypeReg:
+		// Like tar, replace rather than write through existing files, which may be links
+		err := removeNonDir(fullpath)
+		if err != nil {
+			return err
+		}
+		f, err := os.Create(fullpath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		f.Chmod(os.FileMode(header.Mode))
+		_, err = io.Copy(f, archive)
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		// A relative target is relative to where the link actually ends up, which, if an earlier entry was a symlink
+		// to a directory, is not the directory it appears to be in
+		target := header.Linkname
+		if !filepath.IsAbs(target) {
+			parent, err := resolveExistingPath(filepath.Dir(fullpath))
+			if err != nil {
+				return err
+			}
+			target = filepath.Join(parent, target)
+		}
+		target = filepath.Clean(target)
+		if !opts.IsAllowed(target) {
+			return fmt.Errorf("%s: Symlink target %s is not within an allowed directory", header.Name, header.Linkname)
+		}
+		err := removeNonDir(fullpath)
+		if err != nil {
+			return err
+		}
+		err = os.Symlink(header.Linkname, fullpath)
+		if err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		target := filepath.Clean(filepath.Join(opts.RootDir, filepath.FromSlash(header.Linkname)))
+		if !opts.IsAllowed(target) {
+			return fmt.Errorf("%s: Hardlink target %s is not within an allowed directory", header.Name, header.Linkname)
+		}
+		err := removeNonDir(fullpath)
+		if err != nil {
+			return err
+		}
+		err = os.Link(target, fullpath)
+		if err != nil {
+			return err
+		}
+	default:
+		klog.Warningf("%s: Unsupported type, only directories, regular files, and links are permitted, skipping", header.Name)
+		return nil
+	}
+
+	if opts.PreserveOwners {
+		err := os.Lchown(fullpath, header.Uid, header.Gid)
+		if err != nil {
+			return err
+		}
+	}
+	// There is no portable way to set the times of a symlink itself, and a hardlink shares its times with its target
+	if opts.PreserveTimes && (header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeDir) {
+		err := os.Chtimes(fullpath, header.ModTime, header.ModTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeNonDir removes a file or link, if it exists, so that it can be replaced
+func removeNonDir(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// handler serves the file gateway API on the main listener. The health endpoints are not included, so that every path
+// on this listener requires a client certificate.
+func (f *fileGatewayServer) handler() http.Handler {
 	mux := http.NewServeMux()
-	mux.Handle("/", &f)
+	mux.Handle("/", instrumentFileGateway(f))
+	return mux
+}
 
+// ListenAndServe serves the file gateway, and, if enabled, its metrics, until either server fails, or the context is
+// cancelled, at which point both are shut down, allowing in-flight requests to finish
+func (f fileGatewayServer) ListenAndServe(ctx context.Context) error {
 	tlsConfig, err := f.args.loadTLS()
 	if err != nil {
 		return err
 	}
 
+	servers := make([]*http.Server, 0, 2)
 	server := &http.Server{
 		Addr:      f.args.Listen,
 		TLSConfig: tlsConfig,
-		Handler:   mux,
+		Handler:   f.handler(),
+	}
+	servers = append(servers, server)
+	var metricsServer *http.Server
+	if f.args.MetricsAddr != "" {
+		metricsServer = &http.Server{
+			Addr:    f.args.MetricsAddr,
+			Handler: f.metricsHandler(),
+		}
+		servers = append(servers, metricsServer)
+	}
+
+	group, ctx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		return ignoreServerClosed(server.ListenAndServeTLS(f.args.CertPath, f.args.KeyPath))
+	})
+	if metricsServer != nil {
+		group.Go(func() error {
+			return errors.Wrap(ignoreServerClosed(metricsServer.ListenAndServe()), "Metrics server failed")
+		})
 	}
+	group.Go(func() error {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), fileGatewayShutdownTimeout)
+		defer cancel()
+		for _, server := range servers {
+			err := server.Shutdown(shutdownCtx)
+			if err != nil {
+				klog.Warningf("Failed to shut down %s: %v", server.Addr, err)
+			}
+		}
+		return nil
+	})
+	return group.Wait()
+}
 
-	return server.ListenAndServeTLS(f.args.CertPath, f.args.KeyPath)
+// ignoreServerClosed treats a server being shut down as a clean exit
+func ignoreServerClosed(err error) error {
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
 }
@@ -0,0 +1,492 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPathContains(t *testing.T) {
+	cases := []struct {
+		dir, path string
+		expected  bool
+	}{
+		{"/data", "/data", true},
+		{"/data", "/data/", true},
+		{"/data/", "/data/x", true},
+		{"/data", "/data/x/y", true},
+		{"/data", "/data-evil", false},
+		{"/data", "/data-evil/x", false},
+		{"/data", "/dat", false},
+		{"/data", "/data/../etc", false},
+		{"/data", "/", false},
+		{"/", "/data", true},
+	}
+	for _, c := range cases {
+		if actual := pathContains(c.dir, c.path); actual != c.expected {
+			t.Errorf("pathContains(%s, %s): expected %v, got %v", c.dir, c.path, c.expected, actual)
+		}
+	}
+}
+
+// testGatewayDirs creates an allowed directory, data, and a directory next to it, outside, which must never be written
+// to, along with symlinks within data which point to each
+func testGatewayDirs(t *testing.T) (root, data, outside string) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = filepath.Join(root, "data")
+	outside = filepath.Join(root, "data-evil")
+	for _, dir := range []string{filepath.Join(data, "sub"), outside} {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.Symlink(outside, filepath.Join(data, "escape"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink("sub", filepath.Join(data, "inner"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root, data, outside
+}
+
+func TestResolveExistingPath(t *testing.T) {
+	_, data, outside := testGatewayDirs(t)
+	cases := map[string]string{
+		filepath.Join(data, "sub", "new", "file"):   filepath.Join(data, "sub", "new", "file"),
+		filepath.Join(data, "inner", "new"):         filepath.Join(data, "sub", "new"),
+		filepath.Join(data, "escape", "new", "x"):   filepath.Join(outside, "new", "x"),
+		filepath.Join(data, "missing", "..", "sub"): filepath.Join(data, "sub"),
+	}
+	for path, expected := range cases {
+		actual, err := resolveExistingPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != expected {
+			t.Errorf("%s: expected %s, got %s", path, expected, actual)
+		}
+	}
+}
+
+func TestFileGatewayIsAllowed(t *testing.T) {
+	root, data, outside := testGatewayDirs(t)
+	server := fileGatewayServer{args: fileGatewayRecvArgsT{AllowedDirs: []string{data}}}
+	cases := []struct {
+		path     string
+		expected bool
+	}{
+		{data, true},
+		{filepath.Join(data, "sub", "file"), true},
+		{filepath.Join(data, "inner", "file"), true},
+		// The link itself may be replaced, but not written through
+		{filepath.Join(data, "escape"), true},
+		{filepath.Join(data, "escape", "file"), false},
+		{filepath.Join(outside, "file"), false},
+		{outside, false},
+		{filepath.Join(data, "..", "file"), false},
+		{root, false},
+	}
+	for _, c := range cases {
+		if actual := server.isAllowed(c.path); actual != c.expected {
+			t.Errorf("isAllowed(%s): expected %v, got %v", c.path, c.expected, actual)
+		}
+	}
+	if server.isAllowedDir(data) {
+		t.Errorf("isAllowedDir(%s): an allowed directory itself must not be replaced", data)
+	}
+	if !server.isAllowedDir(filepath.Join(data, "sub")) {
+		t.Errorf("isAllowedDir(%s): expected true", filepath.Join(data, "sub"))
+	}
+}
+
+// testExtract POSTs an archive to a file gateway server which only allows extracting into data
+func testExtract(t *testing.T, args fileGatewayRecvArgsT, dir, query string, entries []testTarEntry) *httptest.ResponseRecorder {
+	server := fileGatewayServer{args: args}
+	req := httptest.NewRequest(http.MethodPost, "http://kink"+filepath.ToSlash(dir)+"?"+query, bytes.NewReader(testTarBytes(t, entries)))
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	return resp
+}
+
+func checkFileGatewayError(t *testing.T, name string, resp *httptest.ResponseRecorder, status int, entry string) {
+	t.Helper()
+	if resp.Code != status {
+		t.Errorf("%s: expected status %d, got %d: %s", name, status, resp.Code, resp.Body.String())
+		return
+	}
+	if contentType := resp.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("%s: expected a JSON error, got %s", name, contentType)
+	}
+	var body fileGatewayErrorResponse
+	err := json.Unmarshal(resp.Body.Bytes(), &body)
+	if err != nil {
+		t.Errorf("%s: invalid error body %q: %v", name, resp.Body.String(), err)
+		return
+	}
+	if body.Error == "" || body.Entry != entry {
+		t.Errorf("%s: expected an error for entry %q, got %#v", name, entry, body)
+	}
+}
+
+func checkEmptyDir(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected %s to be empty, found %v", dir, entries)
+	}
+}
+
+func TestFileGatewayExtractArchive(t *testing.T) {
+	cases := []struct {
+		name    string
+		args    fileGatewayRecvArgsT
+		entries []testTarEntry
+		status  int
+		entry   string
+	}{
+		{
+			name:    "parent directory",
+			entries: []testTarEntry{{name: "../data-evil/file", typeflag: tar.TypeReg, contents: "x"}},
+			status:  http.StatusForbidden,
+			entry:   "../data-evil/file",
+		},
+		{
+			name:    "write through existing symlink",
+			entries: []testTarEntry{{name: "escape/file", typeflag: tar.TypeReg, contents: "x"}},
+			status:  http.StatusForbidden,
+			entry:   "escape/file",
+		},
+		{
+			name: "symlink then write",
+			entries: []testTarEntry{
+				{name: "x/", typeflag: tar.TypeDir},
+				{name: "y", typeflag: tar.TypeSymlink, linkname: "."},
+				// This is data lexically, but its parent physically
+				{name: "z", typeflag: tar.TypeSymlink, linkname: "y/x/../.."},
+				{name: "z/data-evil/file", typeflag: tar.TypeReg, contents: "x"},
+			},
+			status: http.StatusForbidden,
+			entry:  "z/data-evil/file",
+		},
+		{
+			name: "symlink relative to symlink",
+			entries: []testTarEntry{
+				{name: "l1", typeflag: tar.TypeSymlink, linkname: "."},
+				// This is data lexically, but its parent physically, as it is created as data/l2
+				{name: "l1/l2", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "l2/data-evil/file", typeflag: tar.TypeReg, contents: "x"},
+			},
+			status: http.StatusBadRequest,
+			entry:  "l1/l2",
+		},
+		{
+			name:    "symlink outside",
+			entries: []testTarEntry{{name: "link", typeflag: tar.TypeSymlink, linkname: "../data-evil"}},
+			status:  http.StatusBadRequest,
+			entry:   "link",
+		},
+		{
+			name:    "hardlink outside",
+			entries: []testTarEntry{{name: "link", typeflag: tar.TypeLink, linkname: "../data-evil/file"}},
+			status:  http.StatusBadRequest,
+			entry:   "link",
+		},
+		{
+			name: "max entries",
+			args: fileGatewayRecvArgsT{MaxEntries: 1},
+			entries: []testTarEntry{
+				{name: "a", typeflag: tar.TypeReg, contents: "a"},
+				{name: "b", typeflag: tar.TypeReg, contents: "b"},
+			},
+			status: http.StatusRequestEntityTooLarge,
+			entry:  "b",
+		},
+		{
+			name: "max bytes",
+			args: fileGatewayRecvArgsT{MaxBytes: 4},
+			entries: []testTarEntry{
+				{name: "a", typeflag: tar.TypeReg, contents: "aaa"},
+				{name: "b", typeflag: tar.TypeReg, contents: "bb"},
+			},
+			status: http.StatusRequestEntityTooLarge,
+			entry:  "b",
+		},
+	}
+	for _, c := range cases {
+		_, data, outside := testGatewayDirs(t)
+		c.args.AllowedDirs = []string{data}
+		resp := testExtract(t, c.args, data, "", c.entries)
+		checkFileGatewayError(t, c.name, resp, c.status, c.entry)
+		checkEmptyDir(t, outside)
+	}
+}
+
+func TestFileGatewayExtractAbsoluteNames(t *testing.T) {
+	_, data, outside := testGatewayDirs(t)
+	resp := testExtract(t, fileGatewayRecvArgsT{AllowedDirs: []string{data}}, filepath.Join(data, "sub"), "", []testTarEntry{
+		{name: filepath.ToSlash(outside) + "/", typeflag: tar.TypeDir},
+		{name: filepath.ToSlash(filepath.Join(outside, "file")), typeflag: tar.TypeReg, contents: "x"},
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	// Absolute names are relative to the requested directory, like tar does by default
+	_, err := os.Stat(filepath.Join(data, "sub", outside, "file"))
+	if err != nil {
+		t.Error(err)
+	}
+	checkEmptyDir(t, outside)
+}
+
+func TestFileGatewayExtractAtomic(t *testing.T) {
+	_, data, outside := testGatewayDirs(t)
+	target := filepath.Join(data, "sub")
+	err := os.WriteFile(filepath.Join(target, "original"), []byte("original"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := fileGatewayRecvArgsT{AllowedDirs: []string{data}, MaxEntries: 2}
+
+	// A failure partway through leaves the original directory and nothing else
+	resp := testExtract(t, args, target, "atomic=true", []testTarEntry{
+		{name: "new", typeflag: tar.TypeReg, contents: "new"},
+		{name: "../escape", typeflag: tar.TypeReg, contents: "x"},
+	})
+	checkFileGatewayError(t, "atomic escape", resp, http.StatusForbidden, "../escape")
+	resp = testExtract(t, args, target, "atomic=true", []testTarEntry{
+		{name: "a", typeflag: tar.TypeReg, contents: "a"},
+		{name: "b", typeflag: tar.TypeReg, contents: "b"},
+		{name: "c", typeflag: tar.TypeReg, contents: "c"},
+	})
+	checkFileGatewayError(t, "atomic max entries", resp, http.StatusRequestEntityTooLarge, "c")
+	checkDirContents(t, target, map[string]string{"original": "original"})
+	checkDirContents(t, data, map[string]string{"sub": "", "escape": "", "inner": ""})
+	checkEmptyDir(t, outside)
+
+	// The root of the allowed directory cannot be replaced
+	resp = testExtract(t, args, data, "atomic=true", nil)
+	checkFileGatewayError(t, "atomic allowed dir", resp, http.StatusForbidden, "")
+
+	// Success replaces the directory entirely
+	resp = testExtract(t, args, target, "atomic=true", []testTarEntry{
+		{name: "new", typeflag: tar.TypeReg, contents: "new"},
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	checkDirContents(t, target, map[string]string{"new": "new"})
+	checkDirContents(t, data, map[string]string{"sub": "", "escape": "", "inner": ""})
+}
+
+// checkDirContents checks that a directory has exactly the given entries, and, if not empty, the contents of each
+func checkDirContents(t *testing.T, dir string, expected map[string]string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(expected) {
+		t.Errorf("%s: expected %d entries, got %v", dir, len(expected), entries)
+	}
+	for name, contents := range expected {
+		if contents == "" {
+			_, err := os.Lstat(filepath.Join(dir, name))
+			if err != nil {
+				t.Error(err)
+			}
+			continue
+		}
+		actual, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(actual) != contents {
+			t.Errorf("%s/%s: expected %q, got %q", dir, name, contents, string(actual))
+		}
+	}
+}
+
+func TestReplaceDir(t *testing.T) {
+	root := t.TempDir()
+	src, dest := filepath.Join(root, "src"), filepath.Join(root, "dest")
+	for dir, file := range map[string]string{src: "new", dest: "old"} {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(dir, file), []byte(file), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// If the new directory cannot be moved into place, the original is restored
+	err := replaceDir(filepath.Join(root, "missing"), dest)
+	if err == nil {
+		t.Error("expected an error replacing with a directory which does not exist")
+	}
+	checkDirContents(t, dest, map[string]string{"old": "old"})
+	checkDirContents(t, root, map[string]string{"src": "", "dest": ""})
+
+	err = replaceDir(src, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDirContents(t, dest, map[string]string{"new": "new"})
+	checkDirContents(t, root, map[string]string{"dest": ""})
+
+	// A directory which does not exist yet is created
+	err = replaceDir(dest, filepath.Join(root, "created"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDirContents(t, root, map[string]string{"created": ""})
+}
+
+func TestFileGatewayGzip(t *testing.T) {
+	_, data, _ := testGatewayDirs(t)
+	server := fileGatewayServer{args: fileGatewayRecvArgsT{AllowedDirs: []string{data}}}
+
+	// Both directions use the same query parameter
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, err := gzipWriter.Write(testTarBytes(t, []testTarEntry{{name: "file", typeflag: tar.TypeReg, contents: "x"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gzipWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://kink"+filepath.ToSlash(filepath.Join(data, "sub"))+"?compress=gzip", &compressed)
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	checkDirContents(t, filepath.Join(data, "sub"), map[string]string{"file": "x"})
+
+	req = httptest.NewRequest(http.MethodGet, "http://kink"+filepath.ToSlash(filepath.Join(data, "sub", "file"))+"?compress=gzip", nil)
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	gzipReader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("expected a gzipped archive: %v", err)
+	}
+	header, err := tar.NewReader(gzipReader).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Name != "file" {
+		t.Errorf("expected file, got %s", header.Name)
+	}
+}
+
+// testGatewayTLS writes a self-signed certificate, which is also used as the client CA, and its key
+func testGatewayTLS(t *testing.T) fileGatewayRecvArgsT {
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kink"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := fileGatewayRecvArgsT{
+		Listen:   "127.0.0.1:0",
+		CertPath: filepath.Join(dir, "tls.crt"),
+		KeyPath:  filepath.Join(dir, "tls.key"),
+		CAPath:   filepath.Join(dir, "tls.crt"),
+	}
+	for path, block := range map[string]*pem.Block{
+		args.CertPath: {Type: "CERTIFICATE", Bytes: cert},
+		args.KeyPath:  {Type: "EC PRIVATE KEY", Bytes: keyBytes},
+	} {
+		err = os.WriteFile(path, pem.EncodeToMemory(block), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return args
+}
+
+func TestFileGatewayListenAndServeShutdown(t *testing.T) {
+	args := testGatewayTLS(t)
+	args.MetricsAddr = "127.0.0.1:0"
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- fileGatewayServer{args: args}.ListenAndServe(ctx) }()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(fileGatewayShutdownTimeout):
+		t.Fatal("server did not shut down")
+	}
+}
+
+func TestFileGatewayListenAndServeMetricsError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	args := testGatewayTLS(t)
+	args.MetricsAddr = listener.Addr().String()
+	done := make(chan error, 1)
+	go func() { done <- fileGatewayServer{args: args}.ListenAndServe(context.Background()) }()
+	select {
+	case err := <-done:
+		// The main server must also have been shut down for this to return
+		if err == nil {
+			t.Error("expected the metrics listener error to be returned")
+		}
+	case <-time.After(fileGatewayShutdownTimeout):
+		t.Fatal("server did not exit after the metrics listener failed")
+	}
+}
@@ -6,13 +6,15 @@ package cmd
 import (
 	"archive/tar"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
-	"io/fs"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/bmatcuk/doublestar/v4"
@@ -36,7 +38,9 @@ var fileGatewaySendCmd = &cobra.Command{
 
 	The file gateway uses the same TLS setup as the api server, so data is not visible to eavesdroppers.
 
-	File permissions (mode) are preserved, but owners and groups are not, all files will be owned by the user executing the file gateway (typically root).
+	File permissions (mode) are preserved. Owners and groups are not unless --send-preserve-owners is set, otherwise all files will be owned by the user executing the file gateway (typically root). Modification times are likewise only preserved with --send-preserve-times.
+
+	Symlinks are sent as symlinks, and hardlinks to the same file are sent once and re-linked. The file gateway refuses any link which would point outside of the shared persistence volume.
 
 	For security and simplicity reasons, only paths mounted from the shared peristence volume can be valid destinations. This also means that shared peristence must be enabled for the file gateway to be used. 
 	If no file paths are specified, send expects a tar-formatted archive to be piped into standard input`,
@@ -92,7 +96,10 @@ type fileGatewaySendArgsT struct {
 	Dest             string                      `rflag:"name=send-dest,usage=directory within the file gateway to expand into (equivalent to tar's -C)"`
 	Gzip             bool                        `rflag:"name=send-gzip,usage=If filepaths are provided,, compress them when sending. If reading from standard input,, expect it to be compressed. (equivalent to tar's -x)"`
 	WipeDirs         bool                        `rflag:"name=send-wipe-dirs,usage=Instruct the file gateway to wipe and re-create any directories that appear in the tar archive"`
+	Atomic           bool                        `rflag:"name=send-atomic,usage=Instruct the file gateway to extract into a staging directory,, and only replace the destination directory with it once the entire archive has been extracted. The destination must be a directory within a shared persistence mount,, and its previous contents are removed"`
 	Exclude          []string                    `rflag:"name=send-exclude,usage=Do not send paths which match this glob"`
+	PreserveOwners   bool                        `rflag:"name=send-preserve-owners,usage=Instruct the file gateway to set the uid and gid of extracted files to those of the sent files"`
+	PreserveTimes    bool                        `rflag:"name=send-preserve-times,usage=Instruct the file gateway to set the modification times of extracted files to those of the sent files"`
 	IngressURL       string                      `rflag:"name=file-gateway-ingress-url,usage=If ingress is used for the file gateway,, instead use this URL,, and set the tls-server-name to the expected ingress hostname. Ignored if controlplane ingress is not used."`
 	PortForward      bool                        `rflag:"usage=Set up a localhost port forward for the file gateway during execution if no ingress or nodeport was set. Set to false if using a background 'kink port-forward' command. Ignored if using an ingress or nodeport for the file gateway."`
 }
@@ -112,15 +119,37 @@ func init() {
 	rflag.MustRegister(rflag.ForPFlag(fileGatewaySendCmd.Flags()), "", &fileGatewaySendArgs)
 }
 
-func sendToFileGateway(ctx context.Context, args *fileGatewaySendArgsT, cfg *resolvedConfigT, tarStream io.Reader) error {
+// fileGatewayConnection is an mTLS client for the file gateway, using the same credentials as the exported kubeconfig
+type fileGatewayConnection struct {
+	client *http.Client
+	url    *url.URL
+	stop   func() error
+}
+
+// Close stops the port-forward to the file gateway, if one was started
+func (f *fileGatewayConnection) Close() {
+	if f.stop != nil {
+		f.stop()
+	}
+}
+
+// urlFor returns the URL of a path within the file gateway
+func (f *fileGatewayConnection) urlFor(dest string, query url.Values) *url.URL {
+	u := *f.url
+	u.Path = filepath.ToSlash(filepath.Join(u.Path, dest))
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+func connectToFileGateway(ctx context.Context, exportArgs *exportKubeconfigCommonArgsT, ingressURL string, portForward bool, cfg *resolvedConfigT) (*fileGatewayConnection, error) {
 	tmpKubeconfigFile, err := os.CreateTemp("", "*")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer os.Remove(tmpKubeconfigFile.Name())
 	err = fetchKubeconfig(ctx, cfg, tmpKubeconfigFile.Name())
 	if err != nil {
-		return err
+		return nil, err
 	}
 	tmpKubeconfig, err := buildCompleteKubeconfig(
 		ctx, cfg,
@@ -130,74 +159,99 @@ func sendToFileGateway(ctx context.Context, args *fileGatewaySendArgsT, cfg *res
 			externalHostname:  cfg.ReleaseConfig.FileGatewayHostname,
 			nodeportName:      "file-gateway",
 			inClusterPort:     int(cfg.ReleaseConfig.FileGatewayContainerPort),
-			portForwardPort:   args.ExportKubeconfig.PortForward.FileGatewayPort,
-			serverURLOverride: args.IngressURL,
-			inCluster:         args.ExportKubeconfig.InCluster,
+			portForwardPort:   exportArgs.PortForward.FileGatewayPort,
+			serverURLOverride: ingressURL,
+			inCluster:         exportArgs.InCluster,
 		},
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	klog.V(4).InfoS("Generated file gateway config", "config", tmpKubeconfig)
 
 	tmpRestConfig, err := clientcmd.NewDefaultClientConfig(*tmpKubeconfig, nil).ClientConfig()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	baseURLString := tmpKubeconfig.Clusters[tmpKubeconfig.Contexts[tmpKubeconfig.CurrentContext].Cluster].Server
+	baseURL, err := url.Parse(baseURLString)
+	if err != nil {
+		panic(fmt.Sprintf("BUG: Generated kubeconfig had invalid URL %s", baseURLString))
+	}
+
+	client, err := k8srest.HTTPClientFor(tmpRestConfig)
+	if err != nil {
+		return nil, err
 	}
 
+	conn := &fileGatewayConnection{client: client, url: baseURL}
 	if tmpKubeconfig.CurrentContext == "default" {
-		if args.PortForward {
-			stopPortForward, err := startPortForward(ctx, true, &args.ExportKubeconfig.PortForward, cfg)
+		if portForward {
+			conn.stop, err = startOrReusePortForward(ctx, &exportArgs.PortForward, cfg)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			defer stopPortForward()
+			// The local port may have been picked when port-forwarding started
+			conn.url.Host = net.JoinHostPort(conn.url.Hostname(), strconv.Itoa(exportArgs.PortForward.FileGatewayPort))
 		} else {
 			klog.V(4).Info("Port-forward explicitly disabled by flag")
 		}
 	} else {
 		klog.V(4).Infof("Current context is %s, port-forwarding not required", tmpKubeconfig.CurrentContext)
 	}
+	return conn, nil
+}
 
-	query := url.Values{}
-	if args.Gzip {
-		query.Set("gzip", "true")
-	}
-	if args.WipeDirs {
-		query.Set("wipe-dirs", "true")
+// fileGatewayError reads the body of a failed file gateway response as an error
+func fileGatewayError(resp *http.Response) error {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		var body fileGatewayErrorResponse
+		err := json.NewDecoder(resp.Body).Decode(&body)
+		if err == nil && body.Entry != "" {
+			return fmt.Errorf("%s: %s: %s", resp.Status, body.Entry, body.Error)
+		}
+		if err == nil {
+			return fmt.Errorf("%s: %s", resp.Status, body.Error)
+		}
 	}
-
-	tarURLString := tmpKubeconfig.Clusters[tmpKubeconfig.Contexts[tmpKubeconfig.CurrentContext].Cluster].Server
-	tarURL, err := url.Parse(tarURLString)
+	errMsg := strings.Builder{}
+	errMsg.WriteString(fmt.Sprintf("%d %s: ", resp.StatusCode, resp.Status))
+	_, err := io.Copy(&errMsg, resp.Body)
 	if err != nil {
-		panic(fmt.Sprintf("BUG: Generated kubeconfig had invalid URL %s", tarURLString))
+		errMsg.WriteString("<could not read response body: ")
+		errMsg.WriteString(err.Error())
+		errMsg.WriteString(">")
 	}
+	return fmt.Errorf("%s", errMsg.String())
+}
 
-	tarURL.Path = filepath.ToSlash(filepath.Join(tarURL.Path, args.Dest))
-	tarURL.RawQuery = query.Encode()
-
-	client, err := k8srest.HTTPClientFor(tmpRestConfig)
+func sendToFileGateway(ctx context.Context, args *fileGatewaySendArgsT, cfg *resolvedConfigT, tarStream io.Reader) error {
+	conn, err := connectToFileGateway(ctx, &args.ExportKubeconfig, args.IngressURL, args.PortForward, cfg)
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
 
-	resp, err := client.Post(tarURL.String(), "", tarStream)
-	if err != nil {
-		return err
+	query := url.Values{}
+	if args.Gzip {
+		query.Set("compress", "gzip")
 	}
-	if resp.StatusCode == http.StatusOK {
-		return nil
+	if args.WipeDirs {
+		query.Set("wipe-dirs", "true")
 	}
-	errMsg := strings.Builder{}
-	errMsg.WriteString(fmt.Sprintf("%d %s: ", resp.StatusCode, resp.Status))
-	_, err = io.Copy(&errMsg, resp.Body)
-	if err != nil {
-		errMsg.WriteString("<could not read response body: ")
-		errMsg.WriteString(err.Error())
-		errMsg.WriteString(">")
+	if args.Atomic {
+		query.Set("atomic", "true")
 	}
-	return fmt.Errorf("%s", errMsg.String())
+	if args.PreserveOwners {
+		query.Set("preserve-owners", "true")
+	}
+	if args.PreserveTimes {
+		query.Set("preserve-times", "true")
+	}
+
+	return conn.send(args.Dest, query, tarStream)
 }
 
 func writeTarArchive(args *fileGatewaySendArgsT, w io.Writer, paths ...string) error {
@@ -205,12 +259,13 @@ func writeTarArchive(args *fileGatewaySendArgsT, w io.Writer, paths ...string) e
 	archive := tar.NewWriter(w)
 	defer archive.Close()
 
+	hardlinks := make(map[hardlinkID]string)
 	for _, path := range paths {
 		info, err := os.Stat(path)
 		if err != nil {
 			return err
 		}
-		err = addToArchive(args, archive, path, info)
+		err = addToArchive(args, archive, hardlinks, path, info)
 		if err != nil {
 			return err
 		}
@@ -219,7 +274,7 @@ func writeTarArchive(args *fileGatewaySendArgsT, w io.Writer, paths ...string) e
 	return nil
 }
 
-func addToArchive(args *fileGatewaySendArgsT, archive *tar.Writer, path string, info os.FileInfo) error {
+func addToArchive(args *fileGatewaySendArgsT, archive *tar.Writer, hardlinks map[hardlinkID]string, path string, info os.FileInfo) error {
 	for _, exclude := range args.Exclude {
 		matches, err := doublestar.PathMatch(exclude, path)
 		if err != nil {
@@ -233,23 +288,11 @@ func addToArchive(args *fileGatewaySendArgsT, archive *tar.Writer, path string,
 	// Tar always has /, this should fix windows paths
 	path = strings.Join(strings.Split(path, string(filepath.Separator)), "/")
 
-	var flag byte
-	mode := info.Mode()
-	if mode&fs.ModeDir != 0 {
-		flag = tar.TypeDir
-	} else if mode&(fs.ModeSymlink|fs.ModeDevice|fs.ModeNamedPipe|fs.ModeSocket|fs.ModeCharDevice|fs.ModeIrregular) != 0 {
-		return fmt.Errorf("%s: Unsupported file type", path)
-	} else {
-		flag = tar.TypeReg
-	}
 	klog.V(4).Infof("Sending: %s", path)
-	archive.WriteHeader(&tar.Header{
-		Typeflag: flag,
-		Name:     path,
-		Size:     info.Size(),
-		Mode:     int64(info.Mode()),
-		ModTime:  info.ModTime(),
-	})
+	err := addFileToArchive(archive, path, path, info, hardlinks)
+	if err != nil {
+		return err
+	}
 
 	if info.IsDir() {
 		entries, err := os.ReadDir(path)
@@ -263,20 +306,11 @@ func addToArchive(args *fileGatewaySendArgsT, archive *tar.Writer, path string,
 				return err
 			}
 			// TODO: Replace this recursion with a queue
-			err = addToArchive(args, archive, filepath.ToSlash(filepath.Join(path, entry.Name())), info)
+			err = addToArchive(args, archive, hardlinks, filepath.ToSlash(filepath.Join(path, entry.Name())), info)
 			if err != nil {
 				return err
 			}
 		}
-	} else {
-		f, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		_, err = io.Copy(archive, f)
-		if err != nil {
-			return err
-		}
 	}
 	klog.V(4).Infof("Sent: %s", path)
 
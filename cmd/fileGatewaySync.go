@@ -0,0 +1,519 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/meln5674/rflag"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+// fileGatewaySyncCmd represents the file-gateway sync command
+var fileGatewaySyncCmd = &cobra.Command{
+	Use:   "sync [flags...] dir",
+	Short: "Incrementally sync a directory to the cluster",
+	Long: `Make a directory within the cluster match a local directory via the file gateway, sending only the files which have changed, and deleting files which no longer exist locally.
+
+	The file gateway is first asked for a manifest of the size, mode, and modification time of each file in the destination directory, which is compared with the local directory. Files are only hashed, both locally and by the file gateway, if their size matches but their modification time does not. Files whose contents match, but whose mode or modification time do not, have only those updated, rather than being sent again.
+
+	Paths matching an exclude glob are neither sent nor deleted.
+
+	With --watch, the local directory is polled for changes, and synced again each time one is found, until interrupted.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !resolvedConfig.ReleaseConfig.FileGatewayEnabled {
+			return errors.New("The file gateway is not enabled for this cluster")
+		}
+		return syncToFileGateway(context.Background(), &fileGatewaySyncArgs, &resolvedConfig, args[0])
+	},
+}
+
+type fileGatewaySyncArgsT struct {
+	ExportKubeconfig exportKubeconfigCommonArgsT `rflag:""`
+	Dest             string                      `rflag:"name=sync-dest,usage=directory within the file gateway to make match the local directory"`
+	Exclude          []string                    `rflag:"name=sync-exclude,usage=Do not send or delete paths which match this glob. Paths are relative to the synced directory"`
+	Watch            bool                        `rflag:"usage=Keep running,, and sync again whenever the local directory changes"`
+	WatchInterval    time.Duration               `rflag:"usage=How often to check the local directory for changes when watching"`
+	IngressURL       string                      `rflag:"name=file-gateway-ingress-url,usage=If ingress is used for the file gateway,, instead use this URL,, and set the tls-server-name to the expected ingress hostname. Ignored if controlplane ingress is not used."`
+	PortForward      bool                        `rflag:"usage=Set up a localhost port forward for the file gateway during execution if no ingress or nodeport was set. Set to false if using a background 'kink port-forward' command. Ignored if using an ingress or nodeport for the file gateway."`
+}
+
+func (fileGatewaySyncArgsT) Defaults() fileGatewaySyncArgsT {
+	return fileGatewaySyncArgsT{
+		ExportKubeconfig: exportKubeconfigCommonArgsT{}.Defaults(),
+		Exclude:          []string{},
+		WatchInterval:    time.Second,
+		PortForward:      true,
+	}
+}
+
+var fileGatewaySyncArgs = fileGatewaySyncArgsT{}.Defaults()
+
+func init() {
+	fileGatewayCmd.AddCommand(fileGatewaySyncCmd)
+	rflag.MustRegister(rflag.ForPFlag(fileGatewaySyncCmd.Flags()), "", &fileGatewaySyncArgs)
+}
+
+// fileGatewayManifestEntry describes a file or directory within a synced directory
+type fileGatewayManifestEntry struct {
+	// Path is relative to the synced directory, and always uses forward slashes
+	Path    string      `json:"path"`
+	Dir     bool        `json:"dir,omitempty"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mtime"`
+	Mode    fs.FileMode `json:"mode"`
+	// Link is the target of a symlink
+	Link string `json:"link,omitempty"`
+}
+
+func (f *fileGatewayManifestEntry) sameType(other *fileGatewayManifestEntry) bool {
+	return f.Dir == other.Dir && (f.Link == "") == (other.Link == "")
+}
+
+// walkSyncDir lists every directory, regular file, and symlink in a directory, in lexical order, skipping any which
+// match an exclude glob
+func walkSyncDir(rootDir string, excludes []string) ([]fileGatewayManifestEntry, error) {
+	entries := make([]fileGatewayManifestEntry, 0)
+	err := filepath.WalkDir(rootDir, func(fullpath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if fullpath == rootDir {
+			return nil
+		}
+		name, err := filepath.Rel(rootDir, fullpath)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		for _, exclude := range excludes {
+			if matches, _ := doublestar.Match(exclude, name); matches {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		isLink := info.Mode()&fs.ModeSymlink != 0
+		if !info.IsDir() && !info.Mode().IsRegular() && !isLink {
+			klog.Warningf("%s: Unsupported type, only directories, regular files, and symlinks are synced, skipping", fullpath)
+			return nil
+		}
+		manifestEntry := fileGatewayManifestEntry{
+			Path:    name,
+			Dir:     info.IsDir(),
+			ModTime: info.ModTime(),
+			Mode:    info.Mode().Perm(),
+		}
+		if isLink {
+			manifestEntry.Link, err = os.Readlink(fullpath)
+			if err != nil {
+				return err
+			}
+		} else if !info.IsDir() {
+			manifestEntry.Size = info.Size()
+		}
+		entries = append(entries, manifestEntry)
+		return nil
+	})
+	return entries, err
+}
+
+// buildFileGatewayManifest lists every file within a directory on the server. Files are not hashed, as that would
+// read the entire directory on every sync, instead, the client requests hashes for only the files it cannot otherwise
+// compare.
+func buildFileGatewayManifest(rootDir string) ([]fileGatewayManifestEntry, error) {
+	_, err := os.Stat(rootDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []fileGatewayManifestEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return walkSyncDir(rootDir, nil)
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fileGatewaySyncPlan is what must be done to make a remote directory match a local one
+type fileGatewaySyncPlan struct {
+	// Send are the local directories and files which are missing or different remotely
+	Send []fileGatewayManifestEntry
+	// Update are the local directories and files whose contents already match remotely, but whose mode or
+	// modification time do not
+	Update []fileGatewayManifestEntry
+	// Delete are the remote paths which do not exist locally. If a directory is deleted, its contents are not listed.
+	Delete []string
+	// Unchanged is the number of local files and directories which already match
+	Unchanged int
+}
+
+// planFileGatewaySync compares a local directory with a remote manifest. Remote paths which match an exclude glob are
+// left alone. Files whose size matches but whose modification time does not are compared by sha256, which is
+// requested with remoteHashes, in a single call, for only those files. Files whose contents match, but whose mode or
+// modification time do not, are updated in place rather than sent, so that they are not hashed again on every sync.
+// Directories are compared only by mode, as their modification times change whenever their contents do.
+func planFileGatewaySync(localDir string, local, remote []fileGatewayManifestEntry, excludes []string, remoteHashes func(paths []string) (map[string]string, error)) (*fileGatewaySyncPlan, error) {
+	plan := &fileGatewaySyncPlan{
+		Send:   make([]fileGatewayManifestEntry, 0),
+		Update: make([]fileGatewayManifestEntry, 0),
+		Delete: make([]string, 0),
+	}
+	localByPath := make(map[string]fileGatewayManifestEntry, len(local))
+	for _, entry := range local {
+		localByPath[entry.Path] = entry
+	}
+	remoteByPath := make(map[string]fileGatewayManifestEntry, len(remote))
+	for _, entry := range remote {
+		remoteByPath[entry.Path] = entry
+	}
+
+	sort.Slice(remote, func(i, j int) bool { return remote[i].Path < remote[j].Path })
+	deleted := make(map[string]bool)
+	for _, entry := range remote {
+		if isSyncExcluded(entry.Path, excludes) {
+			continue
+		}
+		if localEntry, ok := localByPath[entry.Path]; ok && localEntry.sameType(&entry) {
+			continue
+		}
+		if hasDeletedParent(entry.Path, deleted) {
+			continue
+		}
+		deleted[entry.Path] = true
+		plan.Delete = append(plan.Delete, entry.Path)
+	}
+
+	send := make([]bool, len(local))
+	update := make([]bool, len(local))
+	localHashes := make(map[string]string)
+	toHash := make([]string, 0)
+	for ix, entry := range local {
+		remoteEntry, ok := remoteByPath[entry.Path]
+		switch {
+		case !ok || !remoteEntry.sameType(&entry):
+			send[ix] = true
+		case entry.Link != "":
+			send[ix] = entry.Link != remoteEntry.Link
+		case entry.Dir || (entry.Size == remoteEntry.Size && entry.ModTime.Equal(remoteEntry.ModTime)):
+			update[ix] = entry.Mode != remoteEntry.Mode
+		case entry.Size == remoteEntry.Size:
+			hash, err := sha256File(filepath.Join(localDir, filepath.FromSlash(entry.Path)))
+			if err != nil {
+				return nil, err
+			}
+			localHashes[entry.Path] = hash
+			toHash = append(toHash, entry.Path)
+		default:
+			send[ix] = true
+		}
+	}
+
+	if len(toHash) != 0 {
+		hashes, err := remoteHashes(toHash)
+		if err != nil {
+			return nil, err
+		}
+		for ix, entry := range local {
+			hash, ok := localHashes[entry.Path]
+			if !ok {
+				continue
+			}
+			if hash != hashes[entry.Path] {
+				send[ix] = true
+			} else {
+				update[ix] = true
+			}
+		}
+	}
+
+	for ix, entry := range local {
+		switch {
+		case send[ix]:
+			plan.Send = append(plan.Send, entry)
+		case update[ix]:
+			plan.Update = append(plan.Update, entry)
+		default:
+			plan.Unchanged++
+		}
+	}
+	return plan, nil
+}
+
+// isSyncExcluded checks if a path, or any directory containing it, matches an exclude glob, as excluded directories
+// are not walked locally
+func isSyncExcluded(path string, excludes []string) bool {
+	for ; path != "."; path = pathpkg.Dir(path) {
+		for _, exclude := range excludes {
+			if matches, _ := doublestar.Match(exclude, path); matches {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasDeletedParent(path string, deleted map[string]bool) bool {
+	for dir := pathpkg.Dir(path); dir != "."; dir = pathpkg.Dir(dir) {
+		if deleted[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+func syncToFileGateway(ctx context.Context, args *fileGatewaySyncArgsT, cfg *resolvedConfigT, localDir string) error {
+	conn, err := connectToFileGateway(ctx, &args.ExportKubeconfig, args.IngressURL, args.PortForward, cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = syncOnceToFileGateway(conn, args, localDir)
+	if !args.Watch {
+		return err
+	}
+	if err != nil {
+		klog.Error(err)
+	}
+
+	lastFingerprint, err := syncDirFingerprint(localDir, args.Exclude)
+	if err != nil {
+		return err
+	}
+	klog.Infof("Watching %s for changes...", localDir)
+	ticker := time.NewTicker(args.WatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		fingerprint, err := syncDirFingerprint(localDir, args.Exclude)
+		if err != nil {
+			klog.Error(err)
+			continue
+		}
+		if fingerprint == lastFingerprint {
+			continue
+		}
+		lastFingerprint = fingerprint
+		err = syncOnceToFileGateway(conn, args, localDir)
+		if err != nil {
+			klog.Error(err)
+		}
+	}
+}
+
+// syncDirFingerprint summarizes the paths, sizes, and modification times in a directory, so that changes can be
+// detected without hashing each file
+func syncDirFingerprint(localDir string, excludes []string) (string, error) {
+	entries, err := walkSyncDir(localDir, excludes)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	for _, entry := range entries {
+		fmt.Fprintf(hash, "%s\x00%t\x00%s\x00%d\x00%d\x00%o\n", entry.Path, entry.Dir, entry.Link, entry.Size, entry.ModTime.UnixNano(), entry.Mode)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func syncOnceToFileGateway(conn *fileGatewayConnection, args *fileGatewaySyncArgsT, localDir string) error {
+	remote, err := conn.fetchManifest(args.Dest)
+	if err != nil {
+		return errors.Wrap(err, "Failed to fetch manifest")
+	}
+	local, err := walkSyncDir(localDir, args.Exclude)
+	if err != nil {
+		return err
+	}
+	plan, err := planFileGatewaySync(localDir, local, remote, args.Exclude, func(paths []string) (map[string]string, error) {
+		hashes, err := conn.fetchHashes(args.Dest, paths)
+		return hashes, errors.Wrap(err, "Failed to fetch hashes")
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(plan.Delete) != 0 {
+		err = conn.deletePaths(args.Dest, plan.Delete)
+		if err != nil {
+			return errors.Wrap(err, "Failed to delete removed paths")
+		}
+	}
+	if len(plan.Send) != 0 {
+		r, w := io.Pipe()
+		tarErrChan := make(chan error, 1)
+		go func() {
+			err := writeSyncArchive(w, localDir, plan.Send)
+			w.CloseWithError(err)
+			tarErrChan <- err
+		}()
+		// Modification times must be preserved to detect changes on the next sync
+		reqErr := conn.send(args.Dest, url.Values{"preserve-times": []string{"true"}}, r)
+		r.Close()
+		tarErr := <-tarErrChan
+		if tarErr != nil {
+			return tarErr
+		}
+		if reqErr != nil {
+			return reqErr
+		}
+	}
+	if len(plan.Update) != 0 {
+		err = conn.setAttrs(args.Dest, plan.Update)
+		if err != nil {
+			return errors.Wrap(err, "Failed to update modes and modification times")
+		}
+	}
+	klog.Infof("Synced %s to %s: %d sent, %d updated, %d deleted, %d unchanged", localDir, args.Dest, len(plan.Send), len(plan.Update), len(plan.Delete), plan.Unchanged)
+	return nil
+}
+
+// writeSyncArchive writes the files to send as a tar archive. The synced directory itself is always included, so that
+// it is created if it does not yet exist, with its local mode and modification time, as times are preserved.
+func writeSyncArchive(w io.Writer, localDir string, entries []fileGatewayManifestEntry) error {
+	rootInfo, err := os.Stat(localDir)
+	if err != nil {
+		return err
+	}
+	archive := tar.NewWriter(w)
+	err = archive.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     "./",
+		Mode:     int64(rootInfo.Mode().Perm()),
+		ModTime:  rootInfo.ModTime(),
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	hardlinks := make(map[hardlinkID]string)
+	for _, entry := range entries {
+		fullpath := filepath.Join(localDir, filepath.FromSlash(entry.Path))
+		info, err := os.Lstat(fullpath)
+		if err != nil {
+			return err
+		}
+		klog.V(4).Infof("Sending: %s", entry.Path)
+		err = addFileToArchive(archive, fullpath, entry.Path, info, hardlinks)
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+func (f *fileGatewayConnection) send(dest string, query url.Values, body io.Reader) error {
+	resp, err := f.client.Post(f.urlFor(dest, query).String(), "", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	return fileGatewayError(resp)
+}
+
+func (f *fileGatewayConnection) fetchManifest(dest string) ([]fileGatewayManifestEntry, error) {
+	resp, err := f.client.Get(f.urlFor(dest, url.Values{"manifest": []string{"true"}}).String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fileGatewayError(resp)
+	}
+	var manifest []fileGatewayManifestEntry
+	err = json.NewDecoder(resp.Body).Decode(&manifest)
+	return manifest, err
+}
+
+func (f *fileGatewayConnection) deletePaths(dest string, paths []string) error {
+	body, err := json.Marshal(paths)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodDelete, f.urlFor(dest, url.Values{}).String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	return fileGatewayError(resp)
+}
+
+// setAttrs sets the mode and modification time of files and directories within a remote directory to those of the
+// entries
+func (f *fileGatewayConnection) setAttrs(dest string, entries []fileGatewayManifestEntry) error {
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return f.send(dest, url.Values{"attrs": []string{"true"}}, bytes.NewReader(body))
+}
+
+// fetchHashes requests the sha256 of files within a remote directory. Paths which are not regular files are omitted.
+func (f *fileGatewayConnection) fetchHashes(dest string, paths []string) (map[string]string, error) {
+	body, err := json.Marshal(paths)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Post(f.urlFor(dest, url.Values{"hash": []string{"true"}}).String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fileGatewayError(resp)
+	}
+	hashes := make(map[string]string, len(paths))
+	err = json.NewDecoder(resp.Body).Decode(&hashes)
+	return hashes, err
+}
@@ -0,0 +1,372 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSHA256(contents string) string {
+	sum := sha256.Sum256([]byte(contents))
+	return hex.EncodeToString(sum[:])
+}
+
+func testWriteFile(t *testing.T, path, contents string, modTime time.Time) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(path, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlanFileGatewaySync(t *testing.T) {
+	localDir := t.TempDir()
+	oldTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newTime := oldTime.Add(time.Hour)
+	testWriteFile(t, filepath.Join(localDir, "same"), "aaa", oldTime)
+	testWriteFile(t, filepath.Join(localDir, "touched"), "bbb", newTime)
+	testWriteFile(t, filepath.Join(localDir, "edited"), "ccc", newTime)
+	testWriteFile(t, filepath.Join(localDir, "grown"), "dddd", newTime)
+	testWriteFile(t, filepath.Join(localDir, "new"), "eee", newTime)
+	testWriteFile(t, filepath.Join(localDir, "dir", "file"), "fff", oldTime)
+	testWriteFile(t, filepath.Join(localDir, "typechange", "file"), "ggg", oldTime)
+	testWriteFile(t, filepath.Join(localDir, "cache", "file"), "hhh", newTime)
+	testWriteFile(t, filepath.Join(localDir, "chmodded"), "iii", oldTime)
+	testWriteFile(t, filepath.Join(localDir, "locked", "file"), "jjj", oldTime)
+	for path, mode := range map[string]os.FileMode{"chmodded": 0600, "locked": 0700} {
+		err := os.Chmod(filepath.Join(localDir, path), mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{"retargeted": "same", "linked": "same"} {
+		err := os.Symlink(target, filepath.Join(localDir, link))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	excludes := []string{"cache", "**/*.log"}
+	local, err := walkSyncDir(localDir, excludes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := []fileGatewayManifestEntry{
+		{Path: "same", Size: 3, ModTime: oldTime, Mode: 0644},
+		{Path: "touched", Size: 3, ModTime: oldTime, Mode: 0644},
+		{Path: "edited", Size: 3, ModTime: oldTime, Mode: 0644},
+		{Path: "grown", Size: 3, ModTime: oldTime, Mode: 0644},
+		{Path: "chmodded", Size: 3, ModTime: oldTime, Mode: 0644},
+		// Directory modification times are not compared
+		{Path: "dir", Dir: true, ModTime: newTime, Mode: 0755},
+		{Path: "dir/file", Size: 3, ModTime: oldTime, Mode: 0644},
+		{Path: "locked", Dir: true, ModTime: oldTime, Mode: 0755},
+		{Path: "locked/file", Size: 3, ModTime: oldTime, Mode: 0644},
+		{Path: "typechange", Size: 3, ModTime: oldTime, Mode: 0644},
+		{Path: "retargeted", Link: "old"},
+		{Path: "linked", Link: "same"},
+		{Path: "gone", Dir: true, ModTime: oldTime, Mode: 0755},
+		{Path: "gone/file", Size: 3, ModTime: oldTime, Mode: 0644},
+		{Path: "gone/sub", Dir: true, ModTime: oldTime, Mode: 0755},
+		{Path: "cache/remote-only", Size: 3, ModTime: oldTime, Mode: 0644},
+		{Path: "dir/remote.log", Size: 3, ModTime: oldTime, Mode: 0644},
+	}
+
+	var requested []string
+	plan, err := planFileGatewaySync(localDir, local, remote, excludes, func(paths []string) (map[string]string, error) {
+		requested = append(requested, paths...)
+		return map[string]string{"touched": testSHA256("bbb"), "edited": testSHA256("xxx")}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only files whose size matches but whose modification time does not should be hashed
+	if expected := []string{"edited", "touched"}; !reflect.DeepEqual(requested, expected) {
+		t.Errorf("expected hashes to be requested for %v, got %v", expected, requested)
+	}
+	sent := make([]string, 0, len(plan.Send))
+	for _, entry := range plan.Send {
+		sent = append(sent, entry.Path)
+	}
+	if expected := []string{"edited", "grown", "new", "retargeted", "typechange", "typechange/file"}; !reflect.DeepEqual(sent, expected) {
+		t.Errorf("expected to send %v, got %v", expected, sent)
+	}
+	// Files whose contents match are updated in place, whether the mode or only the modification time differs
+	updated := make([]string, 0, len(plan.Update))
+	for _, entry := range plan.Update {
+		updated = append(updated, entry.Path)
+	}
+	if expected := []string{"chmodded", "locked", "touched"}; !reflect.DeepEqual(updated, expected) {
+		t.Errorf("expected to update %v, got %v", expected, updated)
+	}
+	// Excluded remote paths are kept, and contents of deleted directories are not listed
+	if expected := []string{"gone", "typechange"}; !reflect.DeepEqual(plan.Delete, expected) {
+		t.Errorf("expected to delete %v, got %v", expected, plan.Delete)
+	}
+	// dir, dir/file, linked, locked/file, same
+	if plan.Unchanged != 5 {
+		t.Errorf("expected 5 unchanged, got %d", plan.Unchanged)
+	}
+}
+
+func TestPlanFileGatewaySyncNoHashes(t *testing.T) {
+	localDir := t.TempDir()
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	testWriteFile(t, filepath.Join(localDir, "file"), "aaa", modTime)
+	local, err := walkSyncDir(localDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := []fileGatewayManifestEntry{{Path: "file", Size: 3, ModTime: modTime, Mode: 0644}}
+	plan, err := planFileGatewaySync(localDir, local, remote, nil, func(paths []string) (map[string]string, error) {
+		t.Errorf("hashes should not be requested, requested %v", paths)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Send) != 0 || len(plan.Update) != 0 || len(plan.Delete) != 0 || plan.Unchanged != 1 {
+		t.Errorf("expected no changes, got %#v", plan)
+	}
+}
+
+func TestSyncDirFingerprint(t *testing.T) {
+	localDir := t.TempDir()
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	testWriteFile(t, filepath.Join(localDir, "file"), "aaa", modTime)
+	testWriteFile(t, filepath.Join(localDir, "cache", "file"), "aaa", modTime)
+	excludes := []string{"cache"}
+
+	fingerprint := func() string {
+		t.Helper()
+		fingerprint, err := syncDirFingerprint(localDir, excludes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fingerprint
+	}
+	last := fingerprint()
+	if again := fingerprint(); again != last {
+		t.Errorf("expected fingerprint to be stable, got %s then %s", last, again)
+	}
+
+	changes := []struct {
+		name    string
+		change  func()
+		changed bool
+	}{
+		{"excluded file", func() { testWriteFile(t, filepath.Join(localDir, "cache", "file"), "bbbb", modTime.Add(time.Hour)) }, false},
+		{"same size and time", func() { testWriteFile(t, filepath.Join(localDir, "file"), "bbb", modTime) }, false},
+		{"modification time", func() { testWriteFile(t, filepath.Join(localDir, "file"), "bbb", modTime.Add(time.Nanosecond)) }, true},
+		{"size", func() { testWriteFile(t, filepath.Join(localDir, "file"), "bbbb", modTime.Add(time.Nanosecond)) }, true},
+		{"new file", func() { testWriteFile(t, filepath.Join(localDir, "new"), "", modTime) }, true},
+		{"mode", func() {
+			err := os.Chmod(filepath.Join(localDir, "new"), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}, true},
+		{"removed file", func() {
+			err := os.Remove(filepath.Join(localDir, "new"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}, true},
+	}
+	for _, c := range changes {
+		c.change()
+		next := fingerprint()
+		if (next != last) != c.changed {
+			t.Errorf("%s: expected changed=%t, got %s then %s", c.name, c.changed, last, next)
+		}
+		last = next
+	}
+}
+
+func TestWriteSyncArchiveRootTime(t *testing.T) {
+	localDir := t.TempDir()
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 123456789, time.UTC)
+	testWriteFile(t, filepath.Join(localDir, "file"), "aaa", modTime)
+	err := os.Chmod(localDir, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(localDir, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := walkSyncDir(localDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = writeSyncArchive(&buf, localDir, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := tar.NewReader(&buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Name != "./" || header.Typeflag != tar.TypeDir {
+		t.Fatalf("expected the synced directory first, got %s", header.Name)
+	}
+	// Times are preserved when syncing, so the root must not be reset to the zero time
+	if !header.ModTime.Equal(modTime) {
+		t.Errorf("expected root modification time %v, got %v", modTime, header.ModTime)
+	}
+	if header.Mode != 0750 {
+		t.Errorf("expected root mode 0750, got %o", header.Mode)
+	}
+}
+
+func testFileGatewayHashes(t *testing.T, server *fileGatewayServer, dir string, paths []string) *httptest.ResponseRecorder {
+	body, err := json.Marshal(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://kink"+filepath.ToSlash(dir)+"?hash=true", bytes.NewReader(body))
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestFileGatewayServeHashes(t *testing.T) {
+	_, data, outside := testGatewayDirs(t)
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	testWriteFile(t, filepath.Join(data, "sub", "file"), "aaa", modTime)
+	testWriteFile(t, filepath.Join(outside, "file"), "bbb", modTime)
+	server := &fileGatewayServer{args: fileGatewayRecvArgsT{AllowedDirs: []string{data}}}
+
+	resp := testFileGatewayHashes(t, server, data, []string{"sub/file", "inner/file", "sub", "inner", "missing"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	var hashes map[string]string
+	err := json.Unmarshal(resp.Body.Bytes(), &hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Directories, symlinks, and missing files are omitted
+	expected := map[string]string{"sub/file": testSHA256("aaa"), "inner/file": testSHA256("aaa")}
+	if !reflect.DeepEqual(hashes, expected) {
+		t.Errorf("expected %v, got %v", expected, hashes)
+	}
+
+	for _, path := range []string{"../data-evil/file", "escape/file"} {
+		resp = testFileGatewayHashes(t, server, data, []string{path})
+		checkFileGatewayError(t, path, resp, http.StatusForbidden, path)
+		if strings.Contains(resp.Body.String(), testSHA256("bbb")) {
+			t.Errorf("%s: hash of a file outside of the allowed directories was returned", path)
+		}
+	}
+}
+
+func TestFileGatewayManifestNoHashes(t *testing.T) {
+	_, data, _ := testGatewayDirs(t)
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	testWriteFile(t, filepath.Join(data, "sub", "file"), "aaa", modTime)
+	server := &fileGatewayServer{args: fileGatewayRecvArgsT{AllowedDirs: []string{data}}}
+
+	req := httptest.NewRequest(http.MethodGet, "http://kink"+filepath.ToSlash(filepath.Join(data, "sub"))+"?manifest=true", nil)
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	var manifest []fileGatewayManifestEntry
+	err := json.Unmarshal(resp.Body.Bytes(), &manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 1 || manifest[0].Path != "file" || manifest[0].Size != 3 || !manifest[0].ModTime.Equal(modTime) {
+		t.Errorf("unexpected manifest %#v", manifest)
+	}
+	if strings.Contains(resp.Body.String(), testSHA256("aaa")) {
+		t.Errorf("manifest should not include hashes: %s", resp.Body.String())
+	}
+}
+
+func testFileGatewaySetAttrs(t *testing.T, server *fileGatewayServer, dir string, entries []fileGatewayManifestEntry) *httptest.ResponseRecorder {
+	body, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://kink"+filepath.ToSlash(dir)+"?attrs=true", bytes.NewReader(body))
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestFileGatewaySetAttrs(t *testing.T) {
+	_, data, outside := testGatewayDirs(t)
+	oldTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newTime := oldTime.Add(time.Hour)
+	testWriteFile(t, filepath.Join(data, "sub", "file"), "aaa", oldTime)
+	testWriteFile(t, filepath.Join(outside, "file"), "bbb", oldTime)
+	server := &fileGatewayServer{args: fileGatewayRecvArgsT{AllowedDirs: []string{data}}}
+
+	resp := testFileGatewaySetAttrs(t, server, data, []fileGatewayManifestEntry{
+		{Path: "sub", Dir: true, ModTime: newTime, Mode: 0700},
+		{Path: "sub/file", Size: 3, ModTime: newTime, Mode: 0600},
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	for path, mode := range map[string]os.FileMode{"sub": 0700, "sub/file": 0600} {
+		info, err := os.Stat(filepath.Join(data, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode || !info.ModTime().Equal(newTime) {
+			t.Errorf("%s: expected mode %o and time %v, got %o and %v", path, mode, newTime, info.Mode().Perm(), info.ModTime())
+		}
+	}
+
+	cases := []struct {
+		path   string
+		status int
+	}{
+		{"../data-evil/file", http.StatusForbidden},
+		{"escape/file", http.StatusForbidden},
+		// Setting the mode of a symlink would set that of its target
+		{"escape", http.StatusBadRequest},
+		{"missing", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		resp = testFileGatewaySetAttrs(t, server, data, []fileGatewayManifestEntry{{Path: c.path, ModTime: newTime, Mode: 0777}})
+		checkFileGatewayError(t, c.path, resp, c.status, c.path)
+	}
+	for _, path := range []string{outside, filepath.Join(outside, "file")} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() == 0777 || info.ModTime().Equal(newTime) {
+			t.Errorf("%s: attributes were set outside of the allowed directories", path)
+		}
+	}
+}
@@ -4,15 +4,47 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"encoding/json"
+	"io"
+
 	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	getOutputName  = "name"
+	getOutputTable = "table"
+	getOutputWide  = "wide"
+	getOutputJSON  = "json"
+	getOutputYAML  = "yaml"
 )
 
 // getCmd represents the get command
 var getCmd = &cobra.Command{
 	Use:   "get",
-	Short: "Gets one of [cluster]",
+	Short: "Gets one of [cluster, node, kubeconfig]",
 }
 
 func init() {
 	rootCmd.AddCommand(getCmd)
 }
+
+// writeStructuredOutput writes a value as JSON or YAML if that output format is requested, and returns false if
+// the output format is not one of those
+func writeStructuredOutput(out io.Writer, format string, v interface{}) (bool, error) {
+	switch format {
+	case getOutputJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return true, encoder.Encode(v)
+	case getOutputYAML:
+		vYAML, err := yaml.Marshal(v)
+		if err != nil {
+			return true, err
+		}
+		_, err = out.Write(vYAML)
+		return true, err
+	default:
+		return false, nil
+	}
+}
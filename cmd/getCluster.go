@@ -6,50 +6,246 @@ package cmd
 import (
 	"context"
 	"fmt"
-
-	"github.com/meln5674/gosh"
-	"github.com/meln5674/kink/pkg/helm"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	cfg "github.com/meln5674/kink/pkg/config"
+	"github.com/meln5674/kink/pkg/helm"
+	"github.com/meln5674/rflag"
 )
 
 // getClusterCmd represents the get cluster command
 var getClusterCmd = &cobra.Command{
-	Use:          "cluster",
-	Aliases:      []string{"clusters"},
-	Short:        "Lists existing kink clusters by their name",
+	Use:     "cluster",
+	Aliases: []string{"clusters"},
+	Short:   "Lists existing kink clusters",
+	Long: `Lists existing kink clusters.
+
+By default, only the names of clusters in the current namespace are printed, one per line.
+Use --output to instead print a table or a JSON or YAML list of cluster summaries.`,
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return getClusters(context.Background(), &resolvedConfig)
+		return getClusters(context.Background(), os.Stdout, &getClusterArgs, &resolvedConfig)
 	},
 }
 
+type getClusterArgsT struct {
+	Output        string `rflag:"shorthand=o,usage=Output format. One of name|table|wide|json|yaml"`
+	AllNamespaces bool   `rflag:"shorthand=A,usage=List clusters in all namespaces instead of only the current namespace"`
+}
+
+func (getClusterArgsT) Defaults() getClusterArgsT {
+	return getClusterArgsT{
+		Output: getOutputName,
+	}
+}
+
+var getClusterArgs = getClusterArgsT{}.Defaults()
+
 func init() {
 	getCmd.AddCommand(getClusterCmd)
+	rflag.MustRegister(rflag.ForPFlag(getClusterCmd.Flags()), "", &getClusterArgs)
+}
+
+// ClusterSummary is the information about a cluster printed by `kink get cluster`
+type ClusterSummary struct {
+	// Name is the name of the cluster, as would be provided to the --name flag
+	Name string `json:"name"`
+	// Namespace is the namespace the cluster is deployed to
+	Namespace string `json:"namespace"`
+	// Release is the name of the helm release for the cluster
+	Release string `json:"release"`
+	// Revision is the revision of the helm release
+	Revision string `json:"revision"`
+	// Status is the status of the helm release
+	Status string `json:"status"`
+	// Updated is the time the helm release was last deployed
+	Updated string `json:"updated"`
+	// Chart is the name of the chart the cluster was deployed with
+	Chart string `json:"chart"`
+	// ChartVersion is the version of the chart the cluster was deployed with
+	ChartVersion string `json:"chartVersion"`
+	// Distribution is the kubernetes distribution of the cluster, either k3s or rke2
+	Distribution string `json:"distribution,omitempty"`
+	// Controlplane are the replica counts of the controlplane nodes
+	Controlplane ReplicaSummary `json:"controlplane"`
+	// Workers are the replica counts of the worker nodes
+	Workers ReplicaSummary `json:"workers"`
+	// FileGatewayEnabled is true if the file gateway is deployed
+	FileGatewayEnabled bool `json:"fileGatewayEnabled"`
+	// LBManagerEnabled is true if the load balancer manager is deployed
+	LBManagerEnabled bool `json:"lbManagerEnabled"`
+}
+
+// ReplicaSummary are the replica counts of a statefulset of cluster nodes
+type ReplicaSummary struct {
+	Desired int32 `json:"desired"`
+	Ready   int32 `json:"ready"`
 }
 
-func getClusters(ctx context.Context, cfg *resolvedConfigT) error {
-	releases := make([]map[string]interface{}, 0)
-	helmList := helm.List(&cfg.KinkConfig.Helm, &cfg.KinkConfig.Kubernetes)
-	err := gosh.
-		Command(helmList...).
-		WithContext(ctx).
-		WithStreams(
-			gosh.ForwardErr,
-			gosh.FuncOut(gosh.SaveJSON(&releases)),
-		).
-		Run()
+func (r ReplicaSummary) String() string {
+	return fmt.Sprintf("%d/%d", r.Ready, r.Desired)
+}
+
+func getClusters(ctx context.Context, out io.Writer, args *getClusterArgsT, cfg *resolvedConfigT) error {
+	switch args.Output {
+	case getOutputName, getOutputTable, getOutputWide, getOutputJSON, getOutputYAML:
+	default:
+		return fmt.Errorf("Unknown output format %s, must be one of name, table, wide, json, yaml", args.Output)
+	}
+
+	releases, err := helmListReleases(ctx, cfg, args.AllNamespaces)
 	if err != nil {
 		return err
 	}
 
+	if args.Output == getOutputName {
+		for _, release := range releases {
+			clusterName, isCluster := helm.GetReleaseClusterName(release.Name)
+			if !isCluster {
+				continue
+			}
+			if args.AllNamespaces {
+				fmt.Fprintf(out, "%s/%s\n", release.Namespace, clusterName)
+			} else {
+				fmt.Fprintln(out, clusterName)
+			}
+		}
+		return nil
+	}
+
+	client, err := hostClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	summaries := make([]ClusterSummary, 0, len(releases))
 	for _, release := range releases {
-		clusterName, isCluster := helm.GetReleaseClusterName(release["name"].(string))
+		clusterName, isCluster := helm.GetReleaseClusterName(release.Name)
 		if !isCluster {
 			continue
 		}
-		fmt.Println(clusterName)
+		summary, err := summarizeCluster(ctx, client, clusterName, &release)
+		if err != nil {
+			return err
+		}
+		summaries = append(summaries, *summary)
+	}
+
+	if ok, err := writeStructuredOutput(out, args.Output, summaries); ok {
+		return err
+	}
+	return printClusterTable(out, summaries, args.Output == getOutputWide)
+}
+
+// summarizeCluster combines the helm release for a cluster with its release configuration and the state of its
+// statefulsets. Missing resources, such as for a release that failed to install, are left as zero values.
+func summarizeCluster(ctx context.Context, client kubernetes.Interface, clusterName string, release *helm.ReleaseSummary) (*ClusterSummary, error) {
+	chart, chartVersion := helm.SplitChart(release.Chart)
+	summary := ClusterSummary{
+		Name:         clusterName,
+		Namespace:    release.Namespace,
+		Release:      release.Name,
+		Revision:     release.Revision,
+		Status:       release.Status,
+		Updated:      release.Updated,
+		Chart:        chart,
+		ChartVersion: chartVersion,
+	}
+
+	configMaps, err := client.CoreV1().ConfigMaps(release.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{helm.ClusterLabel: clusterName}).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var releaseConfig cfg.ReleaseConfig
+	loadedConfig := false
+	for ix := range configMaps.Items {
+		ok, err := releaseConfig.LoadFromConfigMap(&configMaps.Items[ix])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			loadedConfig = true
+			break
+		}
+	}
+	if !loadedConfig {
+		klog.Warningf("Could not find release configuration for cluster %s/%s, it may not be fully deployed", release.Namespace, clusterName)
+		return &summary, nil
+	}
+
+	summary.Distribution = "k3s"
+	if releaseConfig.RKE2Enabled {
+		summary.Distribution = "rke2"
+	}
+	summary.FileGatewayEnabled = bool(releaseConfig.FileGatewayEnabled)
+	summary.LBManagerEnabled = bool(releaseConfig.LBManagerEnabled)
+
+	summary.Controlplane, err = summarizeStatefulSet(ctx, client, release.Namespace, releaseConfig.ControlplaneFullname)
+	if err != nil {
+		return nil, err
 	}
+	summary.Workers, err = summarizeStatefulSet(ctx, client, release.Namespace, releaseConfig.WorkerFullname)
+	if err != nil {
+		return nil, err
+	}
+
+	return &summary, nil
+}
 
-	return nil
+func summarizeStatefulSet(ctx context.Context, client kubernetes.Interface, namespace, name string) (ReplicaSummary, error) {
+	sts, err := client.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return ReplicaSummary{}, nil
+	}
+	if err != nil {
+		return ReplicaSummary{}, err
+	}
+	return ReplicaSummary{Desired: statefulSetReplicas(sts), Ready: sts.Status.ReadyReplicas}, nil
+}
+
+func statefulSetReplicas(sts *appsv1.StatefulSet) int32 {
+	if sts.Spec.Replicas == nil {
+		return 1
+	}
+	return *sts.Spec.Replicas
+}
+
+func printClusterTable(out io.Writer, summaries []ClusterSummary, wide bool) error {
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	header := "NAME\tNAMESPACE\tSTATUS\tVERSION\tCONTROLPLANE\tWORKERS"
+	if wide {
+		header += "\tDISTRIBUTION\tFILE GATEWAY\tLB MANAGER\tREVISION\tUPDATED"
+	}
+	fmt.Fprintln(w, header)
+	for _, summary := range summaries {
+		row := fmt.Sprintf(
+			"%s\t%s\t%s\t%s\t%s\t%s",
+			summary.Name, summary.Namespace, summary.Status, summary.ChartVersion, summary.Controlplane, summary.Workers,
+		)
+		if wide {
+			row += fmt.Sprintf(
+				"\t%s\t%s\t%s\t%s\t%s",
+				summary.Distribution,
+				strconv.FormatBool(summary.FileGatewayEnabled),
+				strconv.FormatBool(summary.LBManagerEnabled),
+				summary.Revision,
+				summary.Updated,
+			)
+		}
+		fmt.Fprintln(w, row)
+	}
+	return w.Flush()
 }
@@ -0,0 +1,169 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/meln5674/kink/pkg/helm"
+)
+
+func testClusterRelease() *helm.ReleaseSummary {
+	return &helm.ReleaseSummary{
+		Name:      "kink-test",
+		Namespace: "default",
+		Revision:  "2",
+		Status:    "deployed",
+		Updated:   "2024-01-02 03:04:05",
+		Chart:     "kink-0.3.0",
+	}
+}
+
+func TestSummarizeCluster(t *testing.T) {
+	const (
+		configMapsPath   = "/api/v1/namespaces/default/configmaps"
+		controlplanePath = "/apis/apps/v1/namespaces/default/statefulsets/kink-test-controlplane"
+	)
+	releaseConfig := &corev1.ConfigMapList{
+		TypeMeta: metav1.TypeMeta{Kind: "ConfigMapList", APIVersion: "v1"},
+		Items: []corev1.ConfigMap{
+			{ObjectMeta: metav1.ObjectMeta{Name: "kink-test-scripts"}, Data: map[string]string{"entrypoint.sh": "#!/bin/sh"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "kink-test-config"}, Data: map[string]string{"config.json": `{
+				"controlplane.fullname": "kink-test-controlplane",
+				"worker.fullname": "kink-test-worker",
+				"rke2.enabled": "true",
+				"file-gateway.enabled": "true",
+				"lb-manager.enabled": "false"
+			}`}},
+		},
+	}
+	replicas := int32(3)
+	controlplane := &appsv1.StatefulSet{
+		TypeMeta: metav1.TypeMeta{Kind: "StatefulSet", APIVersion: "apps/v1"},
+		Spec:     appsv1.StatefulSetSpec{Replicas: &replicas},
+		Status:   appsv1.StatefulSetStatus{ReadyReplicas: 2},
+	}
+	released := ClusterSummary{
+		Name:         "test",
+		Namespace:    "default",
+		Release:      "kink-test",
+		Revision:     "2",
+		Status:       "deployed",
+		Updated:      "2024-01-02 03:04:05",
+		Chart:        "kink",
+		ChartVersion: "0.3.0",
+	}
+	deployed := released
+	deployed.Distribution = "rke2"
+	deployed.FileGatewayEnabled = true
+	// The worker statefulset is missing, so its replicas are left as zero
+	deployed.Controlplane = ReplicaSummary{Desired: 3, Ready: 2}
+
+	cases := []struct {
+		name     string
+		objects  map[string]interface{}
+		expected ClusterSummary
+	}{
+		{
+			name: "not fully deployed",
+			objects: map[string]interface{}{
+				configMapsPath: &corev1.ConfigMapList{TypeMeta: metav1.TypeMeta{Kind: "ConfigMapList", APIVersion: "v1"}},
+			},
+			expected: released,
+		},
+		{
+			name: "deployed",
+			objects: map[string]interface{}{
+				configMapsPath:   releaseConfig,
+				controlplanePath: controlplane,
+			},
+			expected: deployed,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := testAPIServer(t, c.objects)
+			summary, err := summarizeCluster(context.Background(), client, "test", testClusterRelease())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *summary != c.expected {
+				t.Errorf("expected %#v, got %#v", c.expected, *summary)
+			}
+		})
+	}
+}
+
+func TestStatefulSetReplicas(t *testing.T) {
+	if replicas := statefulSetReplicas(&appsv1.StatefulSet{}); replicas != 1 {
+		t.Errorf("expected a statefulset without replicas to default to 1, got %d", replicas)
+	}
+	zero := int32(0)
+	if replicas := statefulSetReplicas(&appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Replicas: &zero}}); replicas != 0 {
+		t.Errorf("expected a scaled down statefulset to have 0 replicas, got %d", replicas)
+	}
+}
+
+func TestPrintClusterTable(t *testing.T) {
+	summaries := []ClusterSummary{
+		{
+			Name:               "test",
+			Namespace:          "default",
+			Revision:           "2",
+			Status:             "deployed",
+			Updated:            "2024-01-02",
+			ChartVersion:       "0.3.0",
+			Distribution:       "k3s",
+			Controlplane:       ReplicaSummary{Desired: 1, Ready: 1},
+			Workers:            ReplicaSummary{Desired: 2, Ready: 0},
+			FileGatewayEnabled: true,
+		},
+	}
+	cases := []struct {
+		name     string
+		wide     bool
+		expected []string
+	}{
+		{
+			name: "table",
+			expected: []string{
+				"NAME NAMESPACE STATUS VERSION CONTROLPLANE WORKERS",
+				"test default deployed 0.3.0 1/1 0/2",
+			},
+		},
+		{
+			name: "wide",
+			wide: true,
+			expected: []string{
+				"NAME NAMESPACE STATUS VERSION CONTROLPLANE WORKERS DISTRIBUTION FILE GATEWAY LB MANAGER REVISION UPDATED",
+				"test default deployed 0.3.0 1/1 0/2 k3s true false 2 2024-01-02",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := printClusterTable(&out, summaries, c.wide)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			if len(lines) != len(c.expected) {
+				t.Fatalf("expected %d lines, got %q", len(c.expected), out.String())
+			}
+			for ix, line := range lines {
+				if fields := strings.Join(strings.Fields(line), " "); fields != c.expected[ix] {
+					t.Errorf("line %d: expected %q, got %q", ix, c.expected[ix], fields)
+				}
+			}
+		})
+	}
+}
@@ -5,40 +5,57 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 
-	"github.com/meln5674/gosh"
 	"github.com/meln5674/rflag"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2"
+
+	"github.com/meln5674/kink/pkg/helm"
+)
+
+const (
+	nodeRoleLabelPrefix = "node-role.kubernetes.io/"
 )
 
 // getNodeCmd represents the get node command
 var getNodeCmd = &cobra.Command{
 	Use:     "node",
 	Aliases: []string{"nodes"},
-	Short:   "Lists existing kink nodes by their name",
-	Long: `This is functionally equivalent to running
+	Short:   "Lists existing kink nodes and the pods they run in",
+	Long: `Lists the nodes of the cluster, along with the pod in the host cluster that runs each node,
+the host node it is scheduled to, its IP, restart count, and persistent volume claims.
 
-kink exec -- kubectl get nodes`,
+Pods which have not yet registered as a node are listed without a node name.`,
+	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		maybeExitCode, err := execWithGateway(context.Background(), gosh.Command("kubectl", "get", "nodes"), &getNodeArgs.ExecArgs, &resolvedConfig)
-		if err != nil {
-			return err
-		}
-		if maybeExitCode != nil {
-			return errors.New("Failed list nodes")
-		}
-		return nil
+		return getNodes(context.Background(), os.Stdout, &getNodeArgs, &resolvedConfig)
 	},
 }
 
 type getNodeArgsT struct {
 	ExecArgs execArgsT `rflag:""`
+	Output   string    `rflag:"shorthand=o,usage=Output format. One of table|json|yaml"`
 }
 
 func (getNodeArgsT) Defaults() getNodeArgsT {
 	return getNodeArgsT{
 		ExecArgs: execArgsT{}.Defaults(),
+		Output:   getOutputTable,
 	}
 }
 
@@ -48,3 +65,254 @@ func init() {
 	getCmd.AddCommand(getNodeCmd)
 	rflag.MustRegister(rflag.ForPFlag(getNodeCmd.Flags()), "", &getNodeArgs)
 }
+
+// NodeSummary is the information about a node printed by `kink get node`
+type NodeSummary struct {
+	// Name is the name of the node in the cluster, or empty if the pod has not registered as a node
+	Name string `json:"name"`
+	// Status is Ready or NotReady, based on the node's Ready condition
+	Status string `json:"status"`
+	// Roles are the roles of the node, from its node-role.kubernetes.io/ labels
+	Roles []string `json:"roles"`
+	// Version is the kubelet version of the node
+	Version string `json:"version"`
+	// Created is when the node was registered
+	Created *metav1.Time `json:"created,omitempty"`
+	// Pod is the pod in the host cluster that runs the node
+	Pod *NodePodSummary `json:"pod,omitempty"`
+}
+
+// NodePodSummary is the information about the host pod which runs a node
+type NodePodSummary struct {
+	// Name is the name of the pod
+	Name string `json:"name"`
+	// Phase is the phase of the pod
+	Phase corev1.PodPhase `json:"phase"`
+	// HostNode is the node in the host cluster the pod is scheduled to
+	HostNode string `json:"hostNode"`
+	// IP is the IP of the pod
+	IP string `json:"ip"`
+	// Restarts is the total number of restarts of the pod's containers
+	Restarts int32 `json:"restarts"`
+	// PVCs are the names of the persistent volume claims mounted by the pod
+	PVCs []string `json:"pvcs"`
+}
+
+func getNodes(ctx context.Context, out io.Writer, args *getNodeArgsT, cfg *resolvedConfigT) error {
+	switch args.Output {
+	case getOutputTable, getOutputJSON, getOutputYAML:
+	default:
+		return fmt.Errorf("Unknown output format %s, must be one of table, json, yaml", args.Output)
+	}
+
+	guest, stop, err := guestClient(ctx, &args.ExecArgs, cfg)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	nodes, err := guest.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "Failed to list nodes")
+	}
+
+	host, err := hostClient(cfg)
+	if err != nil {
+		return err
+	}
+	pods, err := host.CoreV1().Pods(cfg.ReleaseNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{
+			helm.ClusterLabel:     cfg.KinkConfig.Release.ClusterName,
+			helm.ClusterNodeLabel: "true",
+		}).String(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "Failed to list node pods")
+	}
+
+	summaries := summarizeNodes(nodes.Items, pods.Items)
+
+	if ok, err := writeStructuredOutput(out, args.Output, summaries); ok {
+		return err
+	}
+	return printNodeTable(out, summaries)
+}
+
+// guestClient builds a client for the cluster using the same kubeconfig as `kink exec`. If port-forwarding is enabled,
+// it is done in-process, and stopped by the returned function.
+func guestClient(ctx context.Context, args *execArgsT, cfg *resolvedConfigT) (*kubernetes.Clientset, func(), error) {
+	stopPortForward := func() error { return nil }
+	if args.PortForward {
+		// This is started first so that the kubeconfig uses the local port that was picked, if any
+		var err error
+		stopPortForward, err = startOrReusePortForward(ctx, &args.ExportKubeconfig.PortForward, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	stop := func() {
+		err := stopPortForward()
+		if err != nil {
+			klog.Warningf("Port-forward failed: %v", err)
+		}
+	}
+
+	client, err := func() (*kubernetes.Clientset, error) {
+		var kubeconfig *clientcmdapi.Config
+		var err error
+		if args.ExportedKubeconfigPath != "" {
+			kubeconfig, err = clientcmd.LoadFromFile(args.ExportedKubeconfigPath)
+		} else {
+			kubeconfig, err = buildExecKubeconfig(ctx, args, cfg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		restConfig, err := clientcmd.NewDefaultClientConfig(*kubeconfig, &clientcmd.ConfigOverrides{}).ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		return kubernetes.NewForConfig(restConfig)
+	}()
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	return client, stop, nil
+}
+
+// summarizeNodes matches each node to the pod that runs it. Because the node pods are from statefulsets, their
+// hostnames, and therefore node names, are the same as their pod names.
+func summarizeNodes(nodes []corev1.Node, pods []corev1.Pod) []NodeSummary {
+	podsByName := make(map[string]*corev1.Pod, len(pods))
+	for ix := range pods {
+		podsByName[pods[ix].Name] = &pods[ix]
+	}
+	podsByIP := make(map[string]*corev1.Pod, len(pods))
+	for ix := range pods {
+		if pods[ix].Status.PodIP != "" {
+			podsByIP[pods[ix].Status.PodIP] = &pods[ix]
+		}
+	}
+
+	summaries := make([]NodeSummary, 0, len(nodes))
+	matched := make(map[string]bool, len(pods))
+	for ix := range nodes {
+		node := &nodes[ix]
+		summary := NodeSummary{
+			Name:    node.Name,
+			Status:  nodeStatus(node),
+			Roles:   nodeRoles(node),
+			Version: node.Status.NodeInfo.KubeletVersion,
+			Created: node.CreationTimestamp.DeepCopy(),
+		}
+		pod, ok := podsByName[node.Name]
+		if !ok {
+			for _, addr := range node.Status.Addresses {
+				if addr.Type != corev1.NodeInternalIP {
+					continue
+				}
+				if pod, ok = podsByIP[addr.Address]; ok {
+					break
+				}
+			}
+		}
+		if ok {
+			summary.Pod = summarizeNodePod(pod)
+			matched[pod.Name] = true
+		}
+		summaries = append(summaries, summary)
+	}
+	for ix := range pods {
+		if matched[pods[ix].Name] {
+			continue
+		}
+		summaries = append(summaries, NodeSummary{
+			Status: "NotRegistered",
+			Pod:    summarizeNodePod(&pods[ix]),
+		})
+	}
+	return summaries
+}
+
+func nodeStatus(node *corev1.Node) string {
+	status := "NotReady"
+	if nodeReady(node) {
+		status = "Ready"
+	}
+	if node.Spec.Unschedulable {
+		status += ",SchedulingDisabled"
+	}
+	return status
+}
+
+// nodeReady is true if the Ready condition of a node is true, regardless of whether it is cordoned
+func nodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func nodeRoles(node *corev1.Node) []string {
+	roles := make([]string, 0)
+	for label := range node.Labels {
+		if strings.HasPrefix(label, nodeRoleLabelPrefix) {
+			roles = append(roles, strings.TrimPrefix(label, nodeRoleLabelPrefix))
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+func summarizeNodePod(pod *corev1.Pod) *NodePodSummary {
+	summary := NodePodSummary{
+		Name:     pod.Name,
+		Phase:    pod.Status.Phase,
+		HostNode: pod.Spec.NodeName,
+		IP:       pod.Status.PodIP,
+		PVCs:     make([]string, 0),
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		summary.Restarts += status.RestartCount
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil {
+			summary.PVCs = append(summary.PVCs, volume.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	return &summary
+}
+
+func printNodeTable(out io.Writer, summaries []NodeSummary) error {
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tROLES\tAGE\tVERSION\tPOD\tHOST NODE\tPOD IP\tRESTARTS\tPVCS")
+	for _, summary := range summaries {
+		name := summary.Name
+		if name == "" {
+			name = "<none>"
+		}
+		roles := strings.Join(summary.Roles, ",")
+		if roles == "" {
+			roles = "<none>"
+		}
+		age := "<unknown>"
+		if summary.Created != nil && !summary.Created.IsZero() {
+			age = duration.HumanDuration(time.Since(summary.Created.Time))
+		}
+		pod, hostNode, podIP, restarts, pvcs := "<none>", "<none>", "<none>", "", "<none>"
+		if summary.Pod != nil {
+			pod = summary.Pod.Name
+			hostNode = summary.Pod.HostNode
+			podIP = summary.Pod.IP
+			restarts = fmt.Sprintf("%d", summary.Pod.Restarts)
+			if len(summary.Pod.PVCs) != 0 {
+				pvcs = strings.Join(summary.Pod.PVCs, ",")
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", name, summary.Status, roles, age, summary.Version, pod, hostNode, podIP, restarts, pvcs)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func testNodeWithIP(name, ip string, ready corev1.ConditionStatus, unschedulable bool, labels map[string]string) corev1.Node {
+	node := testNode(name, ready, unschedulable, labels)
+	node.Status.NodeInfo.KubeletVersion = "v1.29.0+k3s1"
+	if ip != "" {
+		node.Status.Addresses = []corev1.NodeAddress{
+			{Type: corev1.NodeHostName, Address: name},
+			{Type: corev1.NodeInternalIP, Address: ip},
+		}
+	}
+	return *node
+}
+
+func testNodeSummaryPod(name, host, ip string, restarts []int32, pvcs ...string) corev1.Pod {
+	pod := testNodePod(name, host)
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.PodIP = ip
+	for _, count := range restarts {
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{RestartCount: count})
+	}
+	for _, pvc := range pvcs {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name:         pvc,
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvc}},
+		})
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}})
+	return pod
+}
+
+func TestSummarizeNodes(t *testing.T) {
+	nodes := []corev1.Node{
+		testNodeWithIP("kink-test-controlplane-0", "10.0.0.1", corev1.ConditionTrue, false, map[string]string{
+			nodeRoleLabelPrefix + "control-plane": "true",
+			nodeRoleLabelPrefix + "master":        "true",
+			"kubernetes.io/os":                    "linux",
+		}),
+		testNodeWithIP("kink-test-worker-0", "10.0.0.2", corev1.ConditionFalse, true, nil),
+		// A node whose name does not match its pod, e.g. because --node-name was overridden, is matched by IP
+		testNodeWithIP("renamed", "10.0.0.3", corev1.ConditionTrue, false, nil),
+		// A node with no pod, e.g. because the pod was deleted after it registered
+		testNodeWithIP("orphan", "10.0.0.9", corev1.ConditionTrue, false, nil),
+	}
+	pods := []corev1.Pod{
+		testNodeSummaryPod("kink-test-controlplane-0", "host-a", "10.0.0.1", []int32{1, 2}, "data-kink-test-controlplane-0"),
+		testNodeSummaryPod("kink-test-worker-0", "host-b", "10.0.0.2", nil),
+		testNodeSummaryPod("kink-test-worker-1", "host-b", "10.0.0.3", nil),
+		// A pod which has not registered as a node yet
+		testNodeSummaryPod("kink-test-worker-2", "host-a", "", nil),
+	}
+
+	summaries := summarizeNodes(nodes, pods)
+	type row struct {
+		name, status, pod, hostNode, ip string
+		roles                           []string
+		restarts                        int32
+		pvcs                            []string
+	}
+	expected := []row{
+		{"kink-test-controlplane-0", "Ready", "kink-test-controlplane-0", "host-a", "10.0.0.1", []string{"control-plane", "master"}, 3, []string{"data-kink-test-controlplane-0"}},
+		{"kink-test-worker-0", "NotReady,SchedulingDisabled", "kink-test-worker-0", "host-b", "10.0.0.2", []string{}, 0, []string{}},
+		{"renamed", "Ready", "kink-test-worker-1", "host-b", "10.0.0.3", []string{}, 0, []string{}},
+		{"orphan", "Ready", "", "", "", []string{}, 0, nil},
+		{"", "NotRegistered", "kink-test-worker-2", "host-a", "", nil, 0, []string{}},
+	}
+	if len(summaries) != len(expected) {
+		t.Fatalf("expected %d summaries, got %#v", len(expected), summaries)
+	}
+	for ix, summary := range summaries {
+		actual := row{name: summary.Name, status: summary.Status, roles: summary.Roles}
+		if summary.Pod != nil {
+			actual.pod = summary.Pod.Name
+			actual.hostNode = summary.Pod.HostNode
+			actual.ip = summary.Pod.IP
+			actual.restarts = summary.Pod.Restarts
+			actual.pvcs = summary.Pod.PVCs
+		}
+		if !reflect.DeepEqual(actual, expected[ix]) {
+			t.Errorf("summary %d: expected %#v, got %#v", ix, expected[ix], actual)
+		}
+		if summary.Name != "" && summary.Version != "v1.29.0+k3s1" {
+			t.Errorf("summary %d: expected the kubelet version, got %q", ix, summary.Version)
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -25,12 +26,17 @@ import (
 	etcdtypes "go.etcd.io/etcd/api/v3/etcdserverpb"
 	etcd "go.etcd.io/etcd/client/v3"
 
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	yamlwriter "sigs.k8s.io/yaml"
 
 	"k8s.io/klog/v2"
 )
 
+const (
+	etcdRestorePollInterval = 5 * time.Second
+)
+
 // initCmd represents the init command
 var initCmd = &cobra.Command{
 	Use:   "init",
@@ -46,10 +52,13 @@ type initEtcdArgsT struct {
 	MemberNamePath string `rflag:"usage=Path to file containing etcd member name"`
 	ConfigPath     string `rflag:"usage=Path to etcd config file to mutate"`
 	Endpoint       string `rflag:"usage=Endpoint to connect to etcd cluster"`
+	RestorePath    string `rflag:"usage=If present,, and this is the pod named by --etcd-restore-pod-name,, and etcd has not yet been initialized,, wait for a snapshot to be uploaded to this path. The controlplane will reset the cluster from it on startup"`
+	RestorePodName string `rflag:"usage=Name of the pod which should wait for a snapshot to restore"`
 }
 
 type initPodArgsT struct {
-	IP string `rflag:"usage=IP of the pod this is executing in"`
+	IP   string `rflag:"usage=IP of the pod this is executing in"`
+	Name string `rflag:"usage=Name of the pod this is executing in"`
 }
 
 type initExtraManifestsArgsT struct {
@@ -96,10 +105,18 @@ func init() {
 func runInit(ctx context.Context, args *initArgsT) error {
 	var err error
 	if args.IsControlPlane {
-		err = resetEtcdMember(ctx, &args.Etcd, &args.Pod)
+		var restoring bool
+		restoring, err = waitForEtcdRestore(ctx, &args.Etcd, &args.Pod)
 		if err != nil {
 			return err
 		}
+		// A restored cluster is reset to a single member with the current IP, so the previous members are irrelevant
+		if !restoring {
+			err = resetEtcdMember(ctx, &args.Etcd, &args.Pod)
+			if err != nil {
+				return err
+			}
+		}
 		err = copyExtraManifests(ctx, &args.ExtraManifests)
 		if err != nil {
 			return err
@@ -153,6 +170,50 @@ func resetEtcdMember(ctx context.Context, args *initEtcdArgsT, pod *initPodArgsT
 
 	klog.InfoS("Resetting etcd member", "member", memberName)
 
+	etcdClient, err := newEtcdClient(&config, args.Endpoint)
+	if err != nil {
+		return err
+	}
+	defer etcdClient.Close()
+	members, err := etcdClient.MemberList(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Failed to list etcd members")
+	}
+	var member *etcdtypes.Member
+	for ix := range members.Members {
+		if members.Members[ix].Name == string(memberName) {
+			member = members.Members[ix]
+			break
+		}
+	}
+	if member == nil {
+		klog.InfoS("No etcd member matched name, assuming not part of the cluster, not resetting member", "name", memberName, "members", members.Members)
+		return nil
+	}
+	_, err = etcdClient.MemberUpdate(ctx, member.ID, []string{fmt.Sprintf("https://%s:2380", pod.IP)})
+	if err != nil {
+		return errors.Wrap(err, "Failed to update etcd member to new pod IP")
+	}
+	return nil
+}
+
+// loadEtcdConfig reads the etcd configuration written by k3s or rke2
+func loadEtcdConfig(path string) (*ETCDConfig, error) {
+	configF, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer configF.Close()
+	var config ETCDConfig
+	err = yaml.NewYAMLOrJSONDecoder(configF, 1024).Decode(&config)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// newEtcdClient connects to etcd using the client certificates from its configuration
+func newEtcdClient(config *ETCDConfig, endpoint string) (*etcd.Client, error) {
 	yc := config.ServerTrust
 	tlscfg := tls.Config{
 		MinVersion: tls.VersionTLS12,
@@ -161,7 +222,7 @@ func resetEtcdMember(ctx context.Context, args *initEtcdArgsT, pod *initPodArgsT
 		tlscfg.RootCAs = x509.NewCertPool()
 		caPEM, err := os.ReadFile(yc.TrustedCAFile)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		tlscfg.RootCAs.AppendCertsFromPEM(caPEM)
 	}
@@ -169,39 +230,50 @@ func resetEtcdMember(ctx context.Context, args *initEtcdArgsT, pod *initPodArgsT
 	if yc.CertFile != "" && yc.KeyFile != "" {
 		cert, err := tls.LoadX509KeyPair(yc.CertFile, yc.KeyFile)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		tlscfg.Certificates = []tls.Certificate{cert}
 	}
 
 	etcdCfg := etcd.Config{
-		Endpoints: []string{args.Endpoint},
+		Endpoints: []string{endpoint},
 		TLS:       &tlscfg,
 	}
 	etcdClient, err := etcd.New(etcdCfg)
 	if err != nil {
-		return errors.Wrap(err, "Failed to build etcd client")
+		return nil, errors.Wrap(err, "Failed to build etcd client")
 	}
-	members, err := etcdClient.MemberList(ctx)
-	if err != nil {
-		return errors.Wrap(err, "Failed to list etcd members")
-	}
-	var member *etcdtypes.Member
-	for ix := range members.Members {
-		if members.Members[ix].Name == string(memberName) {
-			member = members.Members[ix]
-			break
+	return etcdClient, nil
+}
+
+// waitForEtcdRestore waits for `kink snapshot restore` to upload a snapshot, if this pod is the one to restore it,
+// and etcd has not already been initialized, e.g. by a previous restore.
+func waitForEtcdRestore(ctx context.Context, args *initEtcdArgsT, pod *initPodArgsT) (restoring bool, err error) {
+	if args.RestorePath == "" || pod.Name != args.RestorePodName {
+		return false, nil
+	}
+	etcdDataDir := filepath.Join(filepath.Dir(args.RestorePath), "etcd")
+	_, err = os.Stat(etcdDataDir)
+	if err == nil {
+		klog.InfoS("Etcd has already been initialized, not waiting for snapshot", "path", etcdDataDir)
+		return false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	klog.InfoS("Waiting for snapshot to be uploaded", "path", args.RestorePath)
+	err = wait.PollUntilContextCancel(ctx, etcdRestorePollInterval, true, func(context.Context) (bool, error) {
+		_, err := os.Stat(args.RestorePath)
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
 		}
-	}
-	if member == nil {
-		klog.InfoS("No etcd member matched name, assuming not part of the cluster, not resetting member", "name", memberName, "members", members.Members)
-		return nil
-	}
-	_, err = etcdClient.MemberUpdate(ctx, member.ID, []string{fmt.Sprintf("https://%s:2380", pod.IP)})
+		return err == nil, err
+	})
 	if err != nil {
-		return errors.Wrap(err, "Failed to update etcd member to new pod IP")
+		return false, err
 	}
-	return nil
+	klog.InfoS("Snapshot uploaded, cluster will be reset from it", "path", args.RestorePath)
+	return true, nil
 }
 
 func copyFile(dest, src string) error {
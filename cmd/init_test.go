@@ -0,0 +1,67 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWaitForEtcdRestore(t *testing.T) {
+	cases := []struct {
+		name      string
+		podName   string
+		noPath    bool
+		etcdDir   bool
+		uploaded  bool
+		restoring bool
+		err       bool
+	}{
+		{name: "restore disabled", podName: "kink-controlplane-0", noPath: true},
+		{name: "other pod", podName: "kink-controlplane-1", uploaded: true},
+		{name: "etcd initialized", podName: "kink-controlplane-0", etcdDir: true, uploaded: true},
+		{name: "uploaded", podName: "kink-controlplane-0", uploaded: true, restoring: true},
+		{name: "not uploaded", podName: "kink-controlplane-0", err: true},
+	}
+	for _, c := range cases {
+		dbDir := filepath.Join(t.TempDir(), "server", "db")
+		err := os.MkdirAll(dbDir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args := initEtcdArgsT{
+			RestorePath:    filepath.Join(dbDir, "kink-restore.db"),
+			RestorePodName: "kink-controlplane-0",
+		}
+		if c.noPath {
+			args.RestorePath = ""
+		}
+		if c.etcdDir {
+			err = os.Mkdir(filepath.Join(dbDir, "etcd"), 0700)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if c.uploaded {
+			err = os.WriteFile(filepath.Join(dbDir, "kink-restore.db"), []byte("snapshot"), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// The first check is immediate, so this only expires if the snapshot is not yet uploaded
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		restoring, err := waitForEtcdRestore(ctx, &args, &initPodArgsT{Name: c.podName})
+		cancel()
+		if (err != nil) != c.err {
+			t.Errorf("%s: expected error=%t, got %v", c.name, c.err, err)
+		}
+		if restoring != c.restoring {
+			t.Errorf("%s: expected restoring=%t, got %t", c.name, c.restoring, restoring)
+		}
+	}
+}
@@ -39,6 +39,9 @@ var lbManagerCmd = &cobra.Command{
 	Long: `While running, NodePort and LoadBalancer services in the guest cluster will
 manifest as extra ports on a dynamically managed service within the host cluster. LoadBalancer
 type services will also have their ingress IPs set to this service IP.
+
+Guest nodes whose controlplane or worker pod no longer exists, such as after scaling down the workers,
+will also be deleted once they have been NotReady for --node-cleanup-grace-period, unless --node-cleanup=false.
 	`,
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -68,6 +71,8 @@ type lbManagerArgsT struct {
 	LeaderElectionEnabled bool                         `rflag:"name=leader-election,usage=Enable leader election. Required if more than one replica is running"`
 	LeaderElection        lbManagerLeaderElectionArgsT `rflag:"prefix=leader-election-"`
 	RequeueDelay          time.Duration                `rflag:"usage=Time to wait between retries for reconciliation errors due to e.g. kube api server errors"`
+	NodeCleanup           bool                         `rflag:"usage=Delete guest nodes whose controlplane or worker pod no longer exists"`
+	NodeCleanupGrace      time.Duration                `rflag:"name=node-cleanup-grace-period,usage=How long a guest node must be NotReady before it is deleted by --node-cleanup"`
 
 	MetricsAddr string `rflag:"name=metrics-bind-address,usage=The address the metric endpoint binds to."`
 	ProbeAddr   string `rflag:"name=health-probe-bind-address,usage=The address the probe endpoint binds to."`
@@ -78,10 +83,12 @@ type lbManagerArgsT struct {
 
 func (lbManagerArgsT) Defaults() lbManagerArgsT {
 	return lbManagerArgsT{
-		LeaderElection: lbManagerLeaderElectionArgsT{}.Defaults(),
-		MetricsAddr:    ":8080",
-		ProbeAddr:      ":8081",
-		RequeueDelay:   5 * time.Second,
+		LeaderElection:   lbManagerLeaderElectionArgsT{}.Defaults(),
+		MetricsAddr:      ":8080",
+		ProbeAddr:        ":8081",
+		RequeueDelay:     5 * time.Second,
+		NodeCleanup:      true,
+		NodeCleanupGrace: 1 * time.Minute,
 
 		zap: zap.Options{
 			Development: true,
@@ -212,6 +219,25 @@ func runLBManager(ctx context.Context, args *lbManagerArgsT, cfg *resolvedConfig
 		return err
 	}
 
+	if args.NodeCleanup {
+		nodeController := lbmanager.NodeController{
+			Guest:            mgr.GetClient(),
+			Host:             hostClient,
+			Log:              ctrl.Log.WithName("node-ctrl"),
+			GracePeriod:      args.NodeCleanupGrace,
+			RequeueDelay:     args.RequeueDelay,
+			ReleaseNamespace: cfg.ReleaseNamespace,
+			ReleaseConfig:    cfg.ReleaseConfig,
+		}
+		err = builder.
+			ControllerManagedBy(mgr).
+			For(&corev1.Node{}).
+			Complete(&nodeController)
+		if err != nil {
+			return err
+		}
+	}
+
 	if err = mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		return err
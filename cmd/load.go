@@ -15,7 +15,6 @@ import (
 
 	"github.com/meln5674/kink/pkg/containerd"
 	"github.com/meln5674/kink/pkg/helm"
-	"github.com/meln5674/kink/pkg/kubectl"
 )
 
 var (
@@ -48,11 +47,14 @@ var loadCmd = &cobra.Command{
 }
 
 type loadArgsT struct {
-	ParallelLoads     int      `rflag:"usage=How many image/artifact loads to run in parallel"`
-	OnlyLoadToWorkers bool     `rflag:"name=only-load-workers,usage=If true,, only load images to worker nodes,, if false,, also load to controlplane nodes"`
-	CtrCommand        []string `rflag:"slice-type=slice,usage=Command to run within node pods to load images. Default is based on which distribution is used"`
-	CtrNamespace      string   `rflag:"usage=Containerd namespace to to load images to. Default is based on which distribution is used"`
-	CtrAddress        string   `rflag:"usage=Containerd socket address to to load images to. Default is based on which distribution is used"`
+	ParallelLoads     int                  `rflag:"usage=How many image/artifact loads to run in parallel"`
+	OnlyLoadToWorkers bool                 `rflag:"name=only-load-workers,usage=If true,, only load images to worker nodes,, if false,, also load to controlplane nodes"`
+	CtrCommand        []string             `rflag:"slice-type=slice,usage=Command to run within node pods to load images. Default is based on which distribution is used"`
+	CtrNamespace      string               `rflag:"usage=Containerd namespace to to load images to. Default is based on which distribution is used"`
+	CtrAddress        string               `rflag:"usage=Containerd socket address to to load images to. Default is based on which distribution is used"`
+	FileGateway       loadFileGatewayArgsT `rflag:""`
+	Output            string               `rflag:"shorthand=o,usage=Output format of the load report. One of table|json|yaml"`
+	ContinueOnError   bool                 `rflag:"usage=If a load fails,, continue loading to the remaining pods,, and exit non-zero with a summary once all have finished"`
 }
 
 func (loadArgsT) Defaults() loadArgsT {
@@ -60,6 +62,8 @@ func (loadArgsT) Defaults() loadArgsT {
 		ParallelLoads:     1,
 		OnlyLoadToWorkers: true,
 		CtrCommand:        []string{},
+		FileGateway:       loadFileGatewayArgsT{}.Defaults(),
+		Output:            getOutputTable,
 	}
 }
 
@@ -78,9 +82,10 @@ func (l *loadArgsT) parseImportImageFlags(cfg *resolvedConfigT) *containerd.CtrF
 	} else {
 		defaults = &k3sDefaultImportImageFlags
 	}
-	l.importImageOverrides().Override(defaults)
+	overrides := l.importImageOverrides()
+	overrides.Override(defaults)
 
-	return defaults
+	return overrides
 }
 
 var loadArgs = loadArgsT{}.Defaults()
@@ -91,7 +96,6 @@ func init() {
 }
 
 func getPods(ctx context.Context, args *loadArgsT, cfg *resolvedConfigT) (*corev1.PodList, error) {
-	var pods corev1.PodList
 	labels := map[string]string{
 		helm.ClusterLabel: cfg.KinkConfig.Release.ClusterName,
 	}
@@ -100,19 +104,14 @@ func getPods(ctx context.Context, args *loadArgsT, cfg *resolvedConfigT) (*corev
 	} else {
 		labels[helm.ClusterNodeLabel] = "true"
 	}
-	getPods := kubectl.GetPods(&cfg.KinkConfig.Kubectl, &cfg.KinkConfig.Kubernetes, labels)
-	err := gosh.
-		Command(getPods...).
-		WithContext(ctx).
-		WithStreams(
-			gosh.ForwardErr,
-			gosh.FuncOut(gosh.SaveJSON(&pods)),
-		).
-		Run()
+	pods, err := kubectlGetPods(ctx, cfg, labels)
+	if err != nil {
+		return nil, err
+	}
 	if len(pods.Items) == 0 {
 		return nil, fmt.Errorf("No cluster pods matched, this is likely a bug")
 	}
-	return &pods, err
+	return pods, nil
 }
 
 func importParallel(args *loadArgsT, imports ...gosh.Commander) error {
@@ -6,13 +6,12 @@ package cmd
 import (
 	"bytes"
 	"context"
+	"os"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/meln5674/gosh"
 	"github.com/meln5674/kink/pkg/containerd"
-	"github.com/meln5674/kink/pkg/kubectl"
 	"github.com/meln5674/rflag"
 )
 
@@ -49,6 +48,9 @@ func init() {
 }
 
 func loadArchives(ctx context.Context, args *loadArgsT, cfg *resolvedConfigT, archives ...string) (err error) {
+	if args.FileGateway.Enabled {
+		return loadArchivesViaFileGateway(ctx, args, cfg, archives, archives)
+	}
 	pods, err := getPods(ctx, args, cfg)
 	if err != nil {
 		return err
@@ -58,28 +60,22 @@ func loadArchives(ctx context.Context, args *loadArgsT, cfg *resolvedConfigT, ar
 	if err != nil {
 		return err
 	}
-	imports := make([]gosh.Commander, 0, len(pods.Items))
+	imports := make([]loadTask, 0, len(pods.Items)*len(archives))
 	for _, archive := range archives {
 		for _, pod := range pods.Items {
-			kubectlExec := kubectl.Exec(
-				&cfg.KinkConfig.Kubectl, &cfg.KinkConfig.Kubernetes,
+			importCmd := kubectlExec(
+				ctx, cfg,
 				pod.Name,
 				true, false,
 				"sh", "-c", ctrImport.String(),
 			)
-			cmd := gosh.
-				Command(kubectlExec...).
-				WithContext(ctx).
-				WithStreams(
-					gosh.FileIn(archive),
-					gosh.ForwardOutErr,
-				)
-			imports = append(imports, cmd)
+			imports = append(imports, loadTask{
+				Pod:   pod.Name,
+				Image: archive,
+				Cmd:   importCmd,
+				Input: fileInput(archive),
+			})
 		}
 	}
-	err = importParallel(args, imports...)
-	if err != nil {
-		return err
-	}
-	return nil
+	return runLoads(os.Stdout, args, imports...)
 }
@@ -6,6 +6,9 @@ package cmd
 import (
 	"bytes"
 	"context"
+	"io"
+	"os"
+	"strings"
 
 	"github.com/meln5674/gosh"
 	"github.com/meln5674/rflag"
@@ -13,8 +16,6 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/meln5674/kink/pkg/containerd"
-	"github.com/meln5674/kink/pkg/docker"
-	"github.com/meln5674/kink/pkg/kubectl"
 )
 
 // loadDockerImageCmd represents the load docker-image command
@@ -48,6 +49,10 @@ func init() {
 }
 
 func loadImages(ctx context.Context, args *loadArgsT, cfg *resolvedConfigT, images ...string) error {
+	if args.FileGateway.Enabled {
+		return loadImagesViaFileGateway(ctx, args, cfg, images...)
+	}
+	name := strings.Join(images, ",")
 	pods, err := getPods(ctx, args, cfg)
 	if err != nil {
 		return err
@@ -57,25 +62,48 @@ func loadImages(ctx context.Context, args *loadArgsT, cfg *resolvedConfigT, imag
 	if err != nil {
 		return err
 	}
-	imports := make([]gosh.Commander, 0, len(pods.Items))
+	imports := make([]loadTask, 0, len(pods.Items))
 	for _, pod := range pods.Items {
-		kubectlExec := kubectl.Exec(
-			&cfg.KinkConfig.Kubectl, &cfg.KinkConfig.Kubernetes,
+		importCmd := kubectlExec(
+			ctx, cfg,
 			pod.Name,
 			true, false,
 			"sh", "-c", ctrImport.String(),
 		)
-		dockerSave := docker.Save(&cfg.KinkConfig.Docker, images...)
-		pipeline := gosh.Pipeline(
-			gosh.Command(dockerSave...).WithContext(ctx).WithStreams(gosh.ForwardErr),
-			gosh.Command(kubectlExec...).WithContext(ctx).WithStreams(gosh.ForwardOutErr),
-		).WithStreams(gosh.ForwardErr)
-		imports = append(imports, pipeline)
+		imports = append(imports, loadTask{
+			Pod:   pod.Name,
+			Image: name,
+			Cmd:   importCmd,
+			Input: dockerSaveInput(ctx, cfg, images...),
+		})
 	}
 	// TODO: Replace this with a goroutine that copies from one docker save to each kubectl exec
-	err = importParallel(args, imports...)
+	return runLoads(os.Stdout, args, imports...)
+}
+
+// dockerSaveInput sends the output of a docker save to a loadTask
+func dockerSaveInput(ctx context.Context, cfg *resolvedConfigT, images ...string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		r, w := io.Pipe()
+		saveCmd := withStreams(dockerSave(ctx, cfg, images...), gosh.WriterOut(w), gosh.ForwardErr)
+		go func() {
+			w.CloseWithError(saveCmd.Run())
+		}()
+		return r, nil
+	}
+}
+
+// loadImagesViaFileGateway saves the images to a local archive once, and then loads it through the file gateway
+func loadImagesViaFileGateway(ctx context.Context, args *loadArgsT, cfg *resolvedConfigT, images ...string) error {
+	archive, err := os.CreateTemp("", "kink-docker-images-*.tar")
+	if err != nil {
+		return err
+	}
+	archive.Close()
+	defer os.Remove(archive.Name())
+	err = withStreams(dockerSave(ctx, cfg, images...), gosh.FileOut(archive.Name()), gosh.ForwardErr).Run()
 	if err != nil {
 		return err
 	}
-	return nil
+	return loadArchivesViaFileGateway(ctx, args, cfg, []string{archive.Name()}, []string{strings.Join(images, ",")})
 }
@@ -0,0 +1,183 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/klog/v2"
+
+	"github.com/meln5674/kink/pkg/containerd"
+)
+
+const (
+	// loadStagingDir is the directory within the first shared persistence mount that archives are uploaded to
+	// before being imported
+	loadStagingDir = ".kink-load"
+)
+
+type loadFileGatewayArgsT struct {
+	Enabled          bool                        `rflag:"name=via-file-gateway,usage=Upload each archive once through the file gateway onto shared persistence,, and import it from there on each node,, instead of streaming it to every node through kubectl exec. Requires the file gateway and shared persistence (with storage) to be enabled"`
+	ExportKubeconfig exportKubeconfigCommonArgsT `rflag:""`
+	IngressURL       string                      `rflag:"name=file-gateway-ingress-url,usage=If ingress is used for the file gateway,, instead use this URL,, and set the tls-server-name to the expected ingress hostname. Ignored if controlplane ingress is not used."`
+	PortForward      bool                        `rflag:"name=file-gateway-port-forward,usage=Set up a localhost port forward for the file gateway during execution if no ingress or nodeport was set. Set to false if using a background 'kink port-forward' command. Ignored if using an ingress or nodeport for the file gateway."`
+}
+
+func (loadFileGatewayArgsT) Defaults() loadFileGatewayArgsT {
+	return loadFileGatewayArgsT{
+		ExportKubeconfig: exportKubeconfigCommonArgsT{}.Defaults(),
+		PortForward:      true,
+	}
+}
+
+// stagedArchives are archives which have been uploaded to shared persistence, and are visible to all nodes
+type stagedArchives struct {
+	// Dir is the directory containing the archives, which is removed once they are imported
+	Dir string
+	// Paths are the paths of the archives, as seen by the nodes, in the same order they were provided
+	Paths []string
+	// conn is kept open until the archives are removed
+	conn *fileGatewayConnection
+}
+
+// stageArchives uploads a set of local archives through the file gateway into a uniquely named directory on the
+// first shared persistence mount. Each archive crosses the network once, regardless of how many nodes import it.
+func stageArchives(ctx context.Context, args *loadFileGatewayArgsT, cfg *resolvedConfigT, archives ...string) (*stagedArchives, error) {
+	if !cfg.ReleaseConfig.FileGatewayEnabled {
+		return nil, errors.New("The file gateway is not enabled for this cluster")
+	}
+	if !cfg.ReleaseConfig.SharedPersistenceEnabled || len(cfg.ReleaseConfig.SharedPersistenceMounts) == 0 {
+		return nil, errors.New("Shared persistence is not enabled for this cluster")
+	}
+	staged := &stagedArchives{
+		Dir:   path.Join(cfg.ReleaseConfig.SharedPersistenceMounts[0], loadStagingDir, rand.String(8)),
+		Paths: make([]string, 0, len(archives)),
+	}
+	for ix, archive := range archives {
+		staged.Paths = append(staged.Paths, path.Join(staged.Dir, fmt.Sprintf("%d-%s", ix, path.Base(archive))))
+	}
+
+	conn, err := connectToFileGateway(ctx, &args.ExportKubeconfig, args.IngressURL, args.PortForward, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	r, w := io.Pipe()
+	tarErrChan := make(chan error, 1)
+	go func() {
+		err := writeStagingArchive(w, staged, archives...)
+		w.CloseWithError(err)
+		tarErrChan <- err
+	}()
+
+	klog.Infof("Uploading %d archive(s) to %s through the file gateway...", len(archives), staged.Dir)
+	reqErr := conn.send("/", url.Values{}, r)
+	r.Close()
+	tarErr := <-tarErrChan
+	if reqErr != nil || tarErr != nil {
+		conn.Close()
+	}
+	if reqErr != nil && tarErr != nil {
+		return nil, fmt.Errorf("(While processing tarball: %v): %v", tarErr, reqErr)
+	}
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	if tarErr != nil {
+		return nil, tarErr
+	}
+	staged.conn = conn
+	return staged, nil
+}
+
+// writeStagingArchive writes the tar archive sent to the file gateway, which places each local archive at its
+// staged path. The file gateway does not create parent directories, so they are included as well.
+func writeStagingArchive(w io.Writer, staged *stagedArchives, archives ...string) error {
+	archive := tar.NewWriter(w)
+	stagingRoot := path.Dir(staged.Dir)
+	for _, dir := range []string{stagingRoot, staged.Dir} {
+		err := archive.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     strings.TrimPrefix(dir, "/"),
+			Mode:     0755,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for ix, src := range archives {
+		err := func() error {
+			f, err := os.Open(src)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			err = archive.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     strings.TrimPrefix(staged.Paths[ix], "/"),
+				Size:     info.Size(),
+				Mode:     0644,
+				ModTime:  info.ModTime(),
+			})
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(archive, f)
+			return err
+		}()
+		if err != nil {
+			return errors.Wrapf(err, "Failed to send %s", src)
+		}
+	}
+	return archive.Close()
+}
+
+// removeStagedArchives deletes the staging directory through the file gateway, and closes the connection to it
+func removeStagedArchives(staged *stagedArchives) {
+	defer staged.conn.Close()
+	err := staged.conn.deletePaths(path.Dir(staged.Dir), []string{path.Base(staged.Dir)})
+	if err != nil {
+		klog.Warningf("Failed to remove staged archives in %s, they must be removed manually: %v", staged.Dir, err)
+	}
+}
+
+// loadArchivesViaFileGateway is the equivalent of loadArchives, but uploads each archive only once, and then has every
+// node import it from shared persistence. names are how each archive is identified in the load report.
+func loadArchivesViaFileGateway(ctx context.Context, args *loadArgsT, cfg *resolvedConfigT, archives, names []string) error {
+	pods, err := getPods(ctx, args, cfg)
+	if err != nil {
+		return err
+	}
+	staged, err := stageArchives(ctx, &args.FileGateway, cfg, archives...)
+	if err != nil {
+		return err
+	}
+	defer removeStagedArchives(staged)
+
+	ctrFlags := args.parseImportImageFlags(cfg)
+	imports := make([]loadTask, 0, len(pods.Items)*len(staged.Paths))
+	for ix, archive := range staged.Paths {
+		for _, pod := range pods.Items {
+			imports = append(imports, loadTask{
+				Pod:   pod.Name,
+				Image: names[ix],
+				Cmd:   kubectlExec(ctx, cfg, pod.Name, false, false, containerd.ImportImage(ctrFlags, archive)...),
+			})
+		}
+	}
+	return runLoads(os.Stdout, args, imports...)
+}
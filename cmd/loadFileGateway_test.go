@@ -0,0 +1,96 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStageArchivesRequiresSharedPersistence(t *testing.T) {
+	cases := []struct {
+		name   string
+		config resolvedConfigT
+		err    string
+	}{
+		{name: "no file gateway", err: "file gateway is not enabled"},
+		{name: "no shared persistence", config: resolvedConfigT{}, err: "Shared persistence is not enabled"},
+		{name: "no mounts", config: resolvedConfigT{}, err: "Shared persistence is not enabled"},
+	}
+	cases[1].config.ReleaseConfig.FileGatewayEnabled = true
+	cases[2].config.ReleaseConfig.FileGatewayEnabled = true
+	cases[2].config.ReleaseConfig.SharedPersistenceEnabled = true
+	for _, c := range cases {
+		args := loadFileGatewayArgsT{}.Defaults()
+		_, err := stageArchives(context.Background(), &args, &c.config, "image.tar")
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected error containing %q, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+// testFileGatewayConnection starts a file gateway which allows a directory, without TLS, and connects to it
+func testFileGatewayConnection(t *testing.T, allowed string) *fileGatewayConnection {
+	server := httptest.NewServer(&fileGatewayServer{args: fileGatewayRecvArgsT{AllowedDirs: []string{allowed}}})
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fileGatewayConnection{client: server.Client(), url: u}
+}
+
+func TestWriteStagingArchive(t *testing.T) {
+	local := t.TempDir()
+	_, data, outside := testGatewayDirs(t)
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Archives with the same name in different directories must not overwrite each other
+	archives := []string{filepath.Join(local, "a", "image.tar"), filepath.Join(local, "b", "image.tar")}
+	testWriteFile(t, archives[0], "aaa", modTime)
+	testWriteFile(t, archives[1], "bbbb", modTime)
+	staged := &stagedArchives{
+		Dir:   path.Join(filepath.ToSlash(data), loadStagingDir, "abcd1234"),
+		Paths: []string{},
+	}
+	for ix, archive := range archives {
+		staged.Paths = append(staged.Paths, path.Join(staged.Dir, fmt.Sprintf("%d-%s", ix, filepath.Base(archive))))
+	}
+
+	conn := testFileGatewayConnection(t, data)
+	r, w := io.Pipe()
+	go func() { w.CloseWithError(writeStagingArchive(w, staged, archives...)) }()
+	err := conn.send("/", url.Values{}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDirContents(t, filepath.FromSlash(staged.Dir), map[string]string{"0-image.tar": "aaa", "1-image.tar": "bbbb"})
+
+	staged.conn = conn
+	removeStagedArchives(staged)
+	if _, err := os.Stat(filepath.FromSlash(staged.Dir)); !os.IsNotExist(err) {
+		t.Errorf("expected the staging directory to be removed: %v", err)
+	}
+	// Other staged loads may be using the staging root at the same time, so it is kept
+	if _, err := os.Stat(filepath.Join(data, loadStagingDir)); err != nil {
+		t.Errorf("expected the staging root to be kept: %v", err)
+	}
+	checkEmptyDir(t, outside)
+}
+
+func TestWriteStagingArchiveMissingFile(t *testing.T) {
+	staged := &stagedArchives{Dir: "/data/.kink-load/abcd1234", Paths: []string{"/data/.kink-load/abcd1234/0-missing.tar"}}
+	err := writeStagingArchive(io.Discard, staged, filepath.Join(t.TempDir(), "missing.tar"))
+	if err == nil || !strings.Contains(err.Error(), "missing.tar") {
+		t.Errorf("expected an error naming the missing archive, got %v", err)
+	}
+}
@@ -0,0 +1,324 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/meln5674/gosh"
+	"github.com/meln5674/rflag"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/meln5674/kink/pkg/containerd"
+)
+
+var (
+	// nativeImportImageFlags are the same for both k3s and rke2, as both use a containerd embedded in the node
+	nativeImportImageFlags = containerd.CtrFlags{
+		Namespace: "k8s.io",
+		Address:   "/run/k3s/containerd/containerd.sock",
+	}
+)
+
+// loadRegistryImageCmd represents the load registry-image command
+var loadRegistryImageCmd = &cobra.Command{
+	Use:   "registry-image",
+	Short: "Loads images from a registry to all nodes, without needing docker",
+	Long: `Pulls images from their registries, using credentials from your docker config file, and imports them into the
+containerd of every node, using the kink binary in the node image instead of ctr or a shell. The images are downloaded
+once, and layers which every node already has are not downloaded or sent at all.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(loadRegistryImageArgs.Images) == 0 {
+			return errors.New("No images specified")
+		}
+		return loadRegistryImages(context.Background(), &loadArgs, &loadRegistryImageArgs, &resolvedConfig)
+	},
+}
+
+type loadRegistryImageArgsT struct {
+	Images      []string `rflag:"name=image,usage=images to load"`
+	Platform    string   `rflag:"usage=Platform of the images to pull if they are multi-platform,, in the form os/arch[/variant]. Defaults to the platform of the nodes. Must match the platform of the nodes"`
+	Snapshotter string   `rflag:"usage=Containerd snapshotter to unpack images into"`
+}
+
+func (loadRegistryImageArgsT) Defaults() loadRegistryImageArgsT {
+	return loadRegistryImageArgsT{
+		Images:      []string{},
+		Snapshotter: "overlayfs",
+	}
+}
+
+var loadRegistryImageArgs = loadRegistryImageArgsT{}.Defaults()
+
+// loadContainerdHasBlobsCmd represents the load containerd-has-blobs command
+var loadContainerdHasBlobsCmd = &cobra.Command{
+	Use:               "containerd-has-blobs DIGEST...",
+	Short:             "Print which of a set of blobs are present in a node's containerd",
+	Long:              `This command is used by 'kink load registry-image' within a KinK node container, you should not use it outside of one`,
+	Hidden:            true,
+	PersistentPreRunE: noConfigPreRun,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return containerdHasBlobs(context.Background(), os.Stdout, &loadArgs, args...)
+	},
+}
+
+// loadContainerdImportCmd represents the load containerd-import command
+var loadContainerdImportCmd = &cobra.Command{
+	Use:               "containerd-import",
+	Short:             "Import an image archive from stdin or a file into a node's containerd",
+	Long:              `This command is used by 'kink load registry-image' within a KinK node container, you should not use it outside of one`,
+	Hidden:            true,
+	PersistentPreRunE: noConfigPreRun,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return containerdImport(context.Background(), os.Stdin, &loadArgs, &loadContainerdImportArgs)
+	},
+}
+
+type loadContainerdImportArgsT struct {
+	Snapshotter string `rflag:"usage=Containerd snapshotter to unpack images into"`
+	Archive     string `rflag:"usage=Path to the archive to import. If not set,, it is read from stdin"`
+}
+
+var loadContainerdImportArgs loadContainerdImportArgsT
+
+func init() {
+	loadCmd.AddCommand(loadRegistryImageCmd)
+	rflag.MustRegister(rflag.ForPFlag(loadRegistryImageCmd.Flags()), "", &loadRegistryImageArgs)
+
+	loadCmd.AddCommand(loadContainerdHasBlobsCmd)
+	loadCmd.AddCommand(loadContainerdImportCmd)
+	rflag.MustRegister(rflag.ForPFlag(loadContainerdImportCmd.Flags()), "", &loadContainerdImportArgs)
+}
+
+func (l *loadArgsT) parseNativeImportImageFlags() *containerd.CtrFlags {
+	overrides := l.importImageOverrides()
+	overrides.Override(&nativeImportImageFlags)
+	return overrides
+}
+
+// nodePodsPlatforms finds the distinct platforms of the host nodes which a set of node pods are running on
+func nodePodsPlatforms(ctx context.Context, host kubernetes.Interface, pods []corev1.Pod) ([]v1.Platform, error) {
+	platforms := make([]v1.Platform, 0, 1)
+	seen := make(map[string]bool)
+	for _, pod := range pods {
+		node, err := host.CoreV1().Nodes().Get(ctx, pod.Spec.NodeName, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get node %s of pod %s", pod.Spec.NodeName, pod.Name)
+		}
+		platform := v1.Platform{OS: node.Status.NodeInfo.OperatingSystem, Architecture: node.Status.NodeInfo.Architecture}
+		if !seen[platform.String()] {
+			seen[platform.String()] = true
+			platforms = append(platforms, platform)
+		}
+	}
+	return platforms, nil
+}
+
+// resolveLoadPlatform determines which platform to pull images for. The same archive is sent to every node, so the
+// nodes must all share a single platform, which must match the requested one, if any. Nodes report no variant, so
+// only the os and architecture are compared.
+func resolveLoadPlatform(requested string, nodePlatforms []v1.Platform, nodeErr error) (*v1.Platform, error) {
+	if requested == "" {
+		if nodeErr != nil {
+			return nil, errors.Wrap(nodeErr, "Failed to detect the platform of the nodes, use --platform to set it")
+		}
+		if len(nodePlatforms) != 1 {
+			names := make([]string, 0, len(nodePlatforms))
+			for _, nodePlatform := range nodePlatforms {
+				names = append(names, nodePlatform.String())
+			}
+			return nil, fmt.Errorf("Nodes run on multiple platforms (%s), the same images cannot be loaded to all of them", strings.Join(names, ", "))
+		}
+		return &nodePlatforms[0], nil
+	}
+	platform, err := v1.ParsePlatform(requested)
+	if err != nil {
+		return nil, err
+	}
+	if nodeErr != nil {
+		klog.Warningf("Could not check the platform of the nodes, assuming %s: %v", platform, nodeErr)
+		return platform, nil
+	}
+	for _, nodePlatform := range nodePlatforms {
+		if nodePlatform.OS != platform.OS || nodePlatform.Architecture != platform.Architecture {
+			return nil, fmt.Errorf("--platform %s does not match the platform of the nodes, %s", platform, nodePlatform.String())
+		}
+	}
+	return platform, nil
+}
+
+func loadRegistryImages(ctx context.Context, args *loadArgsT, registryArgs *loadRegistryImageArgsT, cfg *resolvedConfigT) error {
+	pods, err := getPods(ctx, args, cfg)
+	if err != nil {
+		return err
+	}
+	host, err := hostClient(cfg)
+	if err != nil {
+		return err
+	}
+	nodePlatforms, nodeErr := nodePodsPlatforms(ctx, host, pods.Items)
+	platform, err := resolveLoadPlatform(registryArgs.Platform, nodePlatforms, nodeErr)
+	if err != nil {
+		return err
+	}
+	klog.Infof("Pulling images for %s", platform)
+
+	images := make([]containerd.NamedImage, 0, len(registryArgs.Images))
+	for _, image := range registryArgs.Images {
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			return err
+		}
+		klog.Infof("Resolving %s...", ref)
+		img, err := remote.Image(
+			ref,
+			remote.WithContext(ctx),
+			remote.WithAuthFromKeychain(authn.DefaultKeychain),
+			remote.WithPlatform(*platform),
+		)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to resolve %s", ref)
+		}
+		images = append(images, containerd.NamedImage{Ref: ref, Image: img})
+	}
+
+	layers, err := containerd.LayerDigests(images)
+	if err != nil {
+		return err
+	}
+	ctrFlags := args.parseNativeImportImageFlags()
+	ctrArgs := []string{"--ctr-namespace", ctrFlags.Namespace, "--ctr-address", ctrFlags.Address}
+
+	klog.Info("Checking which layers are already present on nodes...")
+	hasBlobsArgs := append([]string{"kink", "load", "containerd-has-blobs"}, ctrArgs...)
+	for _, layer := range layers {
+		hasBlobsArgs = append(hasBlobsArgs, layer.String())
+	}
+	presentOutputs := make([]string, len(pods.Items))
+	checks := make([]gosh.Commander, 0, len(pods.Items))
+	for ix, pod := range pods.Items {
+		checks = append(checks, withStreams(
+			kubectlExecInContainer(ctx, cfg, pod.Name, pod.Spec.Containers[0].Name, false, false, hasBlobsArgs...),
+			gosh.FuncOut(gosh.SaveString(&presentOutputs[ix])),
+			gosh.ForwardErr,
+		))
+	}
+	err = importParallel(args, checks...)
+	if err != nil {
+		return errors.Wrap(err, "Failed to check for existing layers")
+	}
+	// A layer is only sent if at least one node is missing it, as the same archive is sent to every node
+	missing := make(map[string]bool, len(layers))
+	for _, output := range presentOutputs {
+		present := make(map[string]bool)
+		for _, line := range strings.Split(output, "\n") {
+			present[strings.TrimSpace(line)] = true
+		}
+		for _, layer := range layers {
+			if !present[layer.String()] {
+				missing[layer.String()] = true
+			}
+		}
+	}
+	klog.Infof("%d of %d layers are missing from at least one node", len(missing), len(layers))
+
+	archive, err := os.CreateTemp("", "kink-registry-images-*.tar")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	klog.Info("Pulling images...")
+	err = containerd.WriteArchive(archive, images, func(layer v1.Hash) bool { return !missing[layer.String()] })
+	if err != nil {
+		archive.Close()
+		return errors.Wrap(err, "Failed to pull images")
+	}
+	err = archive.Close()
+	if err != nil {
+		return err
+	}
+
+	importArgs := append([]string{"kink", "load", "containerd-import", "--snapshotter", registryArgs.Snapshotter}, ctrArgs...)
+	if args.FileGateway.Enabled {
+		staged, err := stageArchives(ctx, &args.FileGateway, cfg, archive.Name())
+		if err != nil {
+			return err
+		}
+		defer removeStagedArchives(staged)
+		importArgs = append(importArgs, "--archive", staged.Paths[0])
+	}
+
+	klog.Info("Importing images...")
+	imports := make([]loadTask, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		task := loadTask{
+			Pod:   pod.Name,
+			Image: strings.Join(registryArgs.Images, ","),
+			Cmd:   kubectlExecInContainer(ctx, cfg, pod.Name, pod.Spec.Containers[0].Name, !args.FileGateway.Enabled, false, importArgs...),
+		}
+		if !args.FileGateway.Enabled {
+			task.Input = fileInput(archive.Name())
+		}
+		imports = append(imports, task)
+	}
+	return runLoads(os.Stdout, args, imports...)
+}
+
+func containerdHasBlobs(ctx context.Context, out io.Writer, args *loadArgsT, digests ...string) error {
+	client, err := args.parseNativeImportImageFlags().NativeClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	parsed := make([]digest.Digest, 0, len(digests))
+	for _, dgst := range digests {
+		d, err := digest.Parse(dgst)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, d)
+	}
+	present, err := containerd.NativeHasBlobs(ctx, client, parsed)
+	if err != nil {
+		return err
+	}
+	for _, dgst := range present {
+		fmt.Fprintln(out, dgst)
+	}
+	return nil
+}
+
+func containerdImport(ctx context.Context, in io.Reader, args *loadArgsT, importArgs *loadContainerdImportArgsT) error {
+	client, err := args.parseNativeImportImageFlags().NativeClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	names, err := containerd.NativeImportImage(ctx, client, importArgs.Snapshotter, in)
+	// kubectl exec hangs if stdin is not fully consumed, and the tar reader stops at the end-of-archive marker,
+	// so any trailing padding is discarded, see ctrImportScriptTpl
+	io.Copy(io.Discard, in)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		klog.Infof("Imported %s", name)
+	}
+	return nil
+}
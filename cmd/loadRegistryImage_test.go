@@ -0,0 +1,126 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/meln5674/kink/pkg/containerd"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testHostNode(name, arch string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			NodeInfo: corev1.NodeSystemInfo{OperatingSystem: "linux", Architecture: arch},
+		},
+	}
+}
+
+func testNodePod(name, node string) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.PodSpec{NodeName: node},
+	}
+}
+
+func TestNodePodsPlatforms(t *testing.T) {
+	host := fake.NewSimpleClientset(
+		testHostNode("host-a", "amd64"),
+		testHostNode("host-b", "amd64"),
+		testHostNode("host-c", "arm64"),
+	)
+	ctx := context.Background()
+
+	platforms, err := nodePodsPlatforms(ctx, host, []corev1.Pod{testNodePod("kink-controlplane-0", "host-a"), testNodePod("kink-worker-0", "host-b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []v1.Platform{{OS: "linux", Architecture: "amd64"}}; !reflect.DeepEqual(platforms, expected) {
+		t.Errorf("expected %v, got %v", expected, platforms)
+	}
+
+	platforms, err = nodePodsPlatforms(ctx, host, []corev1.Pod{testNodePod("kink-controlplane-0", "host-a"), testNodePod("kink-worker-0", "host-c")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []v1.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}}; !reflect.DeepEqual(platforms, expected) {
+		t.Errorf("expected %v, got %v", expected, platforms)
+	}
+
+	_, err = nodePodsPlatforms(ctx, host, []corev1.Pod{testNodePod("kink-worker-0", "missing")})
+	if err == nil {
+		t.Error("expected an error for a missing node")
+	}
+}
+
+func TestResolveLoadPlatform(t *testing.T) {
+	amd64 := v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := v1.Platform{OS: "linux", Architecture: "arm64"}
+	forbidden := errors.New("forbidden")
+	cases := []struct {
+		name          string
+		requested     string
+		nodePlatforms []v1.Platform
+		nodeErr       error
+		expected      string
+		err           bool
+	}{
+		{name: "detected", nodePlatforms: []v1.Platform{arm64}, expected: "linux/arm64"},
+		{name: "detection failed", nodeErr: forbidden, err: true},
+		{name: "mixed nodes", nodePlatforms: []v1.Platform{amd64, arm64}, err: true},
+		{name: "requested matches", requested: "linux/amd64", nodePlatforms: []v1.Platform{amd64}, expected: "linux/amd64"},
+		{name: "requested variant", requested: "linux/arm64/v8", nodePlatforms: []v1.Platform{arm64}, expected: "linux/arm64/v8"},
+		{name: "requested mismatch", requested: "linux/amd64", nodePlatforms: []v1.Platform{arm64}, err: true},
+		{name: "requested mixed nodes", requested: "linux/amd64", nodePlatforms: []v1.Platform{amd64, arm64}, err: true},
+		{name: "requested unchecked", requested: "linux/amd64", nodeErr: forbidden, expected: "linux/amd64"},
+		{name: "requested invalid", requested: "linux/amd64/v1/x", nodePlatforms: []v1.Platform{amd64}, err: true},
+	}
+	for _, c := range cases {
+		platform, err := resolveLoadPlatform(c.requested, c.nodePlatforms, c.nodeErr)
+		if (err != nil) != c.err {
+			t.Errorf("%s: expected error=%t, got %v", c.name, c.err, err)
+			continue
+		}
+		if err == nil && platform.String() != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected, platform)
+		}
+	}
+}
+
+func TestParseNativeImportImageFlags(t *testing.T) {
+	cases := []struct {
+		name     string
+		args     loadArgsT
+		expected containerd.CtrFlags
+	}{
+		{name: "defaults", args: loadArgsT{}.Defaults(), expected: nativeImportImageFlags},
+		{
+			name:     "address flag",
+			args:     loadArgsT{CtrAddress: "/run/containerd/containerd.sock"},
+			expected: containerd.CtrFlags{Namespace: "k8s.io", Address: "/run/containerd/containerd.sock"},
+		},
+		{
+			name:     "namespace flag",
+			args:     loadArgsT{CtrNamespace: "default"},
+			expected: containerd.CtrFlags{Namespace: "default", Address: "/run/k3s/containerd/containerd.sock"},
+		},
+	}
+	for _, c := range cases {
+		actual := c.args.parseNativeImportImageFlags()
+		if actual.Namespace != c.expected.Namespace || actual.Address != c.expected.Address {
+			t.Errorf("%s: expected %#v, got %#v", c.name, c.expected, *actual)
+		}
+	}
+	if nativeImportImageFlags.Namespace != "k8s.io" || nativeImportImageFlags.Address != "/run/k3s/containerd/containerd.sock" {
+		t.Errorf("defaults were modified: %#v", nativeImportImageFlags)
+	}
+}
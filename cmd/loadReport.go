@@ -0,0 +1,321 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/meln5674/gosh"
+	"golang.org/x/term"
+	"k8s.io/klog/v2"
+)
+
+const (
+	loadStatusPending   = "Pending"
+	loadStatusRunning   = "Running"
+	loadStatusSucceeded = "Succeeded"
+	loadStatusFailed    = "Failed"
+	loadStatusSkipped   = "Skipped"
+
+	loadProgressInterval = 500 * time.Millisecond
+)
+
+// LoadResult is the outcome of loading one image or archive into one pod
+type LoadResult struct {
+	// Pod is the name of the node pod the image was loaded into
+	Pod string `json:"pod"`
+	// Image is the image or archive that was loaded
+	Image string `json:"image"`
+	// Status is one of Pending, Running, Succeeded, Failed, or Skipped, if a previous load failed
+	Status string `json:"status"`
+	// BytesSent is the number of bytes sent to the pod
+	BytesSent int64 `json:"bytesSent"`
+	// Duration is how long the load took
+	Duration string `json:"duration,omitempty"`
+	// Output is the combined output of the import command
+	Output string `json:"output,omitempty"`
+	// Error is the reason the load failed, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// LoadReport summarizes a kink load command
+type LoadReport struct {
+	Results   []LoadResult `json:"results"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Skipped   int          `json:"skipped"`
+}
+
+// loadTask is an import of one image or archive into one pod
+type loadTask struct {
+	Pod   string
+	Image string
+	// Cmd is the import command, without any streams set
+	Cmd gosh.Pipelineable
+	// Input opens what to send to the standard input of Cmd, or is nil if it does not read from it
+	Input func() (io.ReadCloser, error)
+}
+
+// fileInput sends a file to a loadTask
+func fileInput(path string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// syncBuffer allows the stdout and stderr of a command to be captured together
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.buf.String()
+}
+
+type loadTracker struct {
+	lock    sync.Mutex
+	results []LoadResult
+	started []time.Time
+	sent    []atomic.Int64
+}
+
+func (l *loadTracker) setStatus(ix int, status string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.results[ix].Status = status
+	if status == loadStatusRunning {
+		l.started[ix] = time.Now()
+	}
+}
+
+func (l *loadTracker) finish(ix int, output string, err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	result := &l.results[ix]
+	result.BytesSent = l.sent[ix].Load()
+	result.Duration = time.Since(l.started[ix]).Round(time.Millisecond).String()
+	result.Output = output
+	if err != nil {
+		result.Status = loadStatusFailed
+		result.Error = err.Error()
+	} else {
+		result.Status = loadStatusSucceeded
+	}
+}
+
+// snapshot returns a copy of the current results, with the bytes sent and durations of running loads filled in
+func (l *loadTracker) snapshot() []LoadResult {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	results := make([]LoadResult, len(l.results))
+	copy(results, l.results)
+	for ix := range results {
+		if results[ix].Status == loadStatusRunning {
+			results[ix].BytesSent = l.sent[ix].Load()
+			results[ix].Duration = time.Since(l.started[ix]).Round(time.Second).String()
+		}
+	}
+	return results
+}
+
+func (l *loadTracker) report() *LoadReport {
+	report := LoadReport{Results: l.snapshot()}
+	for _, result := range report.Results {
+		switch result.Status {
+		case loadStatusSucceeded:
+			report.Succeeded++
+		case loadStatusFailed:
+			report.Failed++
+		case loadStatusSkipped:
+			report.Skipped++
+		}
+	}
+	return &report
+}
+
+// runLoads runs a set of loads, up to --parallel-loads at a time, and writes a report of their results to out.
+// Unless --continue-on-error is set, no new loads are started once one fails.
+func runLoads(out io.Writer, args *loadArgsT, tasks ...loadTask) error {
+	switch args.Output {
+	case getOutputTable, getOutputJSON, getOutputYAML:
+	default:
+		return fmt.Errorf("Unknown output format %s, must be one of table, json, yaml", args.Output)
+	}
+
+	tracker := loadTracker{
+		results: make([]LoadResult, len(tasks)),
+		started: make([]time.Time, len(tasks)),
+		sent:    make([]atomic.Int64, len(tasks)),
+	}
+	for ix, task := range tasks {
+		tracker.results[ix] = LoadResult{Pod: task.Pod, Image: task.Image, Status: loadStatusPending}
+	}
+
+	progress := args.Output == getOutputTable && term.IsTerminal(int(os.Stderr.Fd()))
+	stopProgress := make(chan struct{})
+	progressDone := make(chan struct{})
+	if progress {
+		go func() {
+			defer close(progressDone)
+			drawLoadProgress(os.Stderr, &tracker, stopProgress)
+		}()
+	} else {
+		close(progressDone)
+	}
+
+	parallel := args.ParallelLoads
+	if parallel <= 0 {
+		parallel = len(tasks)
+	}
+	sem := make(chan struct{}, parallel)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for ix, task := range tasks {
+		sem <- struct{}{}
+		if failed.Load() && !args.ContinueOnError {
+			tracker.setStatus(ix, loadStatusSkipped)
+			<-sem
+			continue
+		}
+		tracker.setStatus(ix, loadStatusRunning)
+		wg.Add(1)
+		go func(ix int, task loadTask) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			output, err := runLoad(&tracker.sent[ix], task)
+			tracker.finish(ix, output, err)
+			if err != nil {
+				failed.Store(true)
+			}
+			if progress {
+				return
+			}
+			if err != nil {
+				klog.Errorf("Failed to load %s into %s: %v", task.Image, task.Pod, err)
+			} else {
+				klog.Infof("Loaded %s into %s", task.Image, task.Pod)
+			}
+		}(ix, task)
+	}
+	wg.Wait()
+	close(stopProgress)
+	<-progressDone
+
+	report := tracker.report()
+	if ok, err := writeStructuredOutput(out, args.Output, report); ok {
+		if err != nil {
+			return err
+		}
+	} else {
+		err := printLoadReport(out, report)
+		if err != nil {
+			return err
+		}
+	}
+	if report.Failed != 0 {
+		return fmt.Errorf("%d of %d loads failed", report.Failed, len(tasks))
+	}
+	return nil
+}
+
+func runLoad(sent *atomic.Int64, task loadTask) (string, error) {
+	var output syncBuffer
+	streams := []gosh.StreamSetter{gosh.WriterOut(&output), gosh.WriterErr(&output)}
+	if task.Input != nil {
+		in, err := task.Input()
+		if err != nil {
+			return "", err
+		}
+		defer in.Close()
+		streams = append(streams, gosh.ReaderIn(&countingReader{r: in, n: sent}))
+	}
+	err := withStreams(task.Cmd, streams...).Run()
+	return output.String(), err
+}
+
+// drawLoadProgress redraws the status of every load in place until stop is closed
+func drawLoadProgress(out io.Writer, tracker *loadTracker, stop <-chan struct{}) {
+	ticker := time.NewTicker(loadProgressInterval)
+	defer ticker.Stop()
+	lines := 0
+	draw := func() {
+		var frame bytes.Buffer
+		if lines != 0 {
+			fmt.Fprintf(&frame, "\x1b[%dA", lines)
+		}
+		results := tracker.snapshot()
+		for _, result := range results {
+			fmt.Fprintf(
+				&frame, "\x1b[2K%-10s %s -> %s %s %s\n",
+				result.Status, result.Image, result.Pod, units.BytesSize(float64(result.BytesSent)), result.Duration,
+			)
+		}
+		lines = len(results)
+		out.Write(frame.Bytes())
+	}
+	draw()
+	for {
+		select {
+		case <-stop:
+			draw()
+			return
+		case <-ticker.C:
+			draw()
+		}
+	}
+}
+
+func printLoadReport(out io.Writer, report *LoadReport) error {
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "POD\tIMAGE\tSTATUS\tSENT\tDURATION")
+	for _, result := range report.Results {
+		fmt.Fprintf(
+			w, "%s\t%s\t%s\t%s\t%s\n",
+			result.Pod, result.Image, result.Status, units.BytesSize(float64(result.BytesSent)), result.Duration,
+		)
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+	for _, result := range report.Results {
+		if result.Status != loadStatusFailed {
+			continue
+		}
+		fmt.Fprintf(out, "\n%s -> %s: %s\n", result.Image, result.Pod, result.Error)
+		if output := strings.TrimSpace(result.Output); output != "" {
+			fmt.Fprintln(out, output)
+		}
+	}
+	return nil
+}
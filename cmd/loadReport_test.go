@@ -0,0 +1,209 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testLoadTask is a load which reads its input, writes output, and then fails if fail is set
+func testLoadTask(pod, image, input string, delay time.Duration, fail bool) loadTask {
+	return loadTask{
+		Pod:   pod,
+		Image: image,
+		Cmd: newNativeCmd(context.Background(), func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+			n, err := io.Copy(io.Discard, stdin)
+			if err != nil {
+				return err
+			}
+			time.Sleep(delay)
+			if fail {
+				fmt.Fprintf(stderr, "ctr: failed to import %d bytes\n", n)
+				return errors.New("exit status 1")
+			}
+			fmt.Fprintf(stdout, "imported %d bytes\n", n)
+			return nil
+		}),
+		Input: func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(input)), nil
+		},
+	}
+}
+
+func testLoadTasks(failFirst bool) []loadTask {
+	return []loadTask{
+		testLoadTask("node-0", "image:1", "aaaa", 20*time.Millisecond, failFirst),
+		testLoadTask("node-1", "image:1", "bbbbbbbb", 0, false),
+		testLoadTask("node-2", "image:1", "cc", 0, false),
+	}
+}
+
+func TestRunLoads(t *testing.T) {
+	cases := []struct {
+		name            string
+		parallel        int
+		continueOnError bool
+		failFirst       bool
+		statuses        []string
+		counts          [3]int
+	}{
+		{
+			name:     "all succeed",
+			parallel: 2,
+			statuses: []string{loadStatusSucceeded, loadStatusSucceeded, loadStatusSucceeded},
+			counts:   [3]int{3, 0, 0},
+		},
+		{
+			name:      "one fails",
+			parallel:  1,
+			failFirst: true,
+			statuses:  []string{loadStatusFailed, loadStatusSkipped, loadStatusSkipped},
+			counts:    [3]int{0, 1, 2},
+		},
+		{
+			name:            "one fails and continue on error",
+			parallel:        1,
+			continueOnError: true,
+			failFirst:       true,
+			statuses:        []string{loadStatusFailed, loadStatusSucceeded, loadStatusSucceeded},
+			counts:          [3]int{2, 1, 0},
+		},
+	}
+	for _, c := range cases {
+		args := loadArgsT{}.Defaults()
+		args.Output = getOutputJSON
+		args.ParallelLoads = c.parallel
+		args.ContinueOnError = c.continueOnError
+		var out bytes.Buffer
+		err := runLoads(&out, &args, testLoadTasks(c.failFirst)...)
+		if (err != nil) != c.failFirst {
+			t.Errorf("%s: expected failed=%t, got %v", c.name, c.failFirst, err)
+		}
+		var report LoadReport
+		err = json.Unmarshal(out.Bytes(), &report)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		statuses := make([]string, 0, len(report.Results))
+		for _, result := range report.Results {
+			statuses = append(statuses, result.Status)
+		}
+		if !reflect.DeepEqual(statuses, c.statuses) {
+			t.Errorf("%s: expected statuses %v, got %v", c.name, c.statuses, statuses)
+		}
+		if counts := [3]int{report.Succeeded, report.Failed, report.Skipped}; counts != c.counts {
+			t.Errorf("%s: expected succeeded, failed, skipped %v, got %v", c.name, c.counts, counts)
+		}
+		for ix, result := range report.Results {
+			if result.Status == loadStatusSkipped {
+				if result.BytesSent != 0 || result.Duration != "" {
+					t.Errorf("%s: skipped load %d should not be accounted, got %#v", c.name, ix, result)
+				}
+				continue
+			}
+			if expected := []int64{4, 8, 2}[ix]; result.BytesSent != expected {
+				t.Errorf("%s: expected load %d to send %d bytes, got %d", c.name, ix, expected, result.BytesSent)
+			}
+			duration, err := time.ParseDuration(result.Duration)
+			if err != nil {
+				t.Errorf("%s: load %d: %v", c.name, ix, err)
+			} else if ix == 0 && duration < 20*time.Millisecond {
+				t.Errorf("%s: expected load 0 to take at least 20ms, got %s", c.name, duration)
+			}
+		}
+		if c.failFirst {
+			failed := report.Results[0]
+			if failed.Error != "exit status 1" || !strings.Contains(failed.Output, "failed to import 4 bytes") {
+				t.Errorf("%s: expected the error and output of the failing load, got %#v", c.name, failed)
+			}
+		}
+	}
+}
+
+func TestRunLoadsJSONShape(t *testing.T) {
+	args := loadArgsT{}.Defaults()
+	args.Output = getOutputJSON
+	args.ContinueOnError = true
+	var out bytes.Buffer
+	err := runLoads(&out, &args, testLoadTask("node-0", "image:1", "aaaa", 0, true), testLoadTask("node-1", "image:1", "bb", 0, false))
+	if err == nil {
+		t.Error("expected an error for the failing load")
+	}
+	var report map[string]interface{}
+	err = json.Unmarshal(out.Bytes(), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"succeeded": 1.0,
+		"failed":    1.0,
+		"skipped":   0.0,
+	}
+	for key, value := range expected {
+		if report[key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, report[key])
+		}
+	}
+	results, ok := report["results"].([]interface{})
+	if !ok || len(results) != 2 {
+		t.Fatalf("expected 2 results, got %v", report["results"])
+	}
+	failed := results[0].(map[string]interface{})
+	for _, key := range []string{"pod", "image", "status", "bytesSent", "duration", "output", "error"} {
+		if _, ok := failed[key]; !ok {
+			t.Errorf("expected failed result to have %s, got %v", key, failed)
+		}
+	}
+	if _, ok := results[1].(map[string]interface{})["error"]; ok {
+		t.Errorf("expected successful result to omit error, got %v", results[1])
+	}
+}
+
+func TestRunLoadsTable(t *testing.T) {
+	args := loadArgsT{}.Defaults()
+	args.Output = getOutputTable
+	var out bytes.Buffer
+	err := runLoads(&out, &args, testLoadTask("node-0", "image:1", "aaaa", 0, true), testLoadTask("node-1", "image:1", "bb", 0, false))
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 loads failed") {
+		t.Errorf("expected 1 of 2 loads to fail, got %v", err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	if !strings.HasPrefix(lines[0], "POD") {
+		t.Errorf("expected a table header, got %q", lines[0])
+	}
+	for _, expected := range []string{"node-0", "node-1"} {
+		found := false
+		for _, line := range lines[1:3] {
+			found = found || strings.HasPrefix(line, expected)
+		}
+		if !found {
+			t.Errorf("expected a row for %s, got %q", expected, out.String())
+		}
+	}
+	if !strings.Contains(lines[2], loadStatusSkipped) {
+		t.Errorf("expected node-1 to be skipped, got %q", lines[2])
+	}
+	if !strings.Contains(out.String(), "image:1 -> node-0: exit status 1\nctr: failed to import 4 bytes") {
+		t.Errorf("expected the error and output of the failing load, got %q", out.String())
+	}
+}
+
+func TestRunLoadsUnknownOutput(t *testing.T) {
+	args := loadArgsT{}.Defaults()
+	args.Output = "xml"
+	err := runLoads(io.Discard, &args, testLoadTask("node-0", "image:1", "", 0, false))
+	if err == nil {
+		t.Error("expected an error for an unknown output format")
+	}
+}
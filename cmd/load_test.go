@@ -0,0 +1,50 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	cfg "github.com/meln5674/kink/pkg/config"
+	"github.com/meln5674/kink/pkg/containerd"
+)
+
+func TestParseImportImageFlags(t *testing.T) {
+	cases := []struct {
+		name     string
+		rke2     bool
+		args     loadArgsT
+		expected containerd.CtrFlags
+	}{
+		{name: "k3s defaults", args: loadArgsT{}.Defaults(), expected: k3sDefaultImportImageFlags},
+		{name: "rke2 defaults", rke2: true, args: loadArgsT{}.Defaults(), expected: rke2DefaultImportImageFlags},
+		{
+			name:     "k3s command flag",
+			args:     loadArgsT{CtrCommand: []string{"ctr"}},
+			expected: containerd.CtrFlags{Command: []string{"ctr"}},
+		},
+		{
+			name: "rke2 namespace flag",
+			rke2: true,
+			args: loadArgsT{CtrNamespace: "default"},
+			expected: containerd.CtrFlags{
+				Command:   []string{"/var/lib/rancher/rke2/bin/ctr"},
+				Namespace: "default",
+				Address:   "/run/k3s/containerd/containerd.sock",
+			},
+		},
+	}
+	for _, c := range cases {
+		config := resolvedConfigT{}
+		config.ReleaseConfig.RKE2Enabled = cfg.Bool(c.rke2)
+		actual := c.args.parseImportImageFlags(&config)
+		if !reflect.DeepEqual(*actual, c.expected) {
+			t.Errorf("%s: expected %#v, got %#v", c.name, c.expected, *actual)
+		}
+	}
+	if !reflect.DeepEqual(k3sDefaultImportImageFlags, containerd.CtrFlags{Command: []string{"k3s", "ctr"}}) {
+		t.Errorf("k3s defaults were modified: %#v", k3sDefaultImportImageFlags)
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/meln5674/gosh"
+	"github.com/meln5674/rflag"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/meln5674/kink/pkg/kubectl"
+)
+
+const (
+	logsComponentControlplane  = "controlplane"
+	logsComponentWorker        = "worker"
+	logsComponentLBManager     = "lb-manager"
+	logsComponentKubeconfigJob = "kubeconfig-job"
+	logsComponentGuest         = "guest"
+)
+
+// logsCmd represents the logs command
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Print the logs of the pods of a cluster",
+	Long: `Print the logs of every container of the pods in the host cluster which make up a cluster, and, optionally,
+of the pods within the cluster itself. Each line is prefixed with the pod and container it came from.
+
+The components are:
+* controlplane: The controlplane node pods, i.e. the k3s/rke2 servers
+* worker: The worker node pods
+* lb-manager: The load balancer manager pods, if enabled
+* kubeconfig-job: The pods of the jobs which export the kubeconfig, if enabled
+* guest: The pods within the cluster. Use --guest-namespace and --guest-selector to limit which.
+
+Only the pods which exist when this command starts are included, even when following.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return clusterLogs(context.Background(), &logsArgs, &resolvedConfig)
+	},
+}
+
+type logsArgsT struct {
+	ExecArgs       execArgsT     `rflag:""`
+	Component      []string      `rflag:"slice-type=slice,usage=Components to print the logs of. Any of controlplane|worker|lb-manager|kubeconfig-job|guest"`
+	Follow         bool          `rflag:"usage=Continue printing new logs until interrupted"`
+	Since          time.Duration `rflag:"usage=Only print logs newer than this. If zero,, print logs of any age"`
+	Tail           int           `rflag:"usage=Number of most recent lines to print from each container. If negative,, print all lines"`
+	GuestNamespace string        `rflag:"usage=Namespace within the cluster to print the logs of pods from. If empty,, all namespaces are used"`
+	GuestSelector  string        `rflag:"usage=Label selector of the pods within the cluster to print the logs of"`
+}
+
+func (logsArgsT) Defaults() logsArgsT {
+	return logsArgsT{
+		ExecArgs: execArgsT{}.Defaults(),
+		Component: []string{
+			logsComponentControlplane,
+			logsComponentWorker,
+			logsComponentLBManager,
+			logsComponentKubeconfigJob,
+		},
+		Tail: -1,
+	}
+}
+
+var logsArgs = logsArgsT{}.Defaults()
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+	rflag.MustRegister(rflag.ForPFlag(logsCmd.Flags()), "", &logsArgs)
+}
+
+// componentSelectorLabels returns the labels which select the pods of a component in the host cluster.
+// Releases created by older charts do not include the selector labels for the lb-manager and kubeconfig job, so those
+// are built from the common selector labels instead, which is the same as what newer charts produce.
+func componentSelectorLabels(cfg *resolvedConfigT, component string) (map[string]string, error) {
+	var labels map[string]string
+	switch component {
+	case logsComponentControlplane:
+		return cfg.ReleaseConfig.ControlplaneSelectorLabels, nil
+	case logsComponentWorker:
+		return cfg.ReleaseConfig.WorkerSelectorLabels, nil
+	case logsComponentLBManager:
+		// LoadBalancerSelectorLabels selects the node pods behind the load balancer service, not the lb-manager itself
+		labels = cfg.ReleaseConfig.LBManagerSelectorLabels
+	case logsComponentKubeconfigJob:
+		labels = cfg.ReleaseConfig.KubeconfigSelectorLabels
+	default:
+		return nil, fmt.Errorf("Unknown component %s, must be one of %s, %s, %s, %s, %s", component, logsComponentControlplane, logsComponentWorker, logsComponentLBManager, logsComponentKubeconfigJob, logsComponentGuest)
+	}
+	if len(labels) != 0 {
+		return labels, nil
+	}
+	labels = make(map[string]string, len(cfg.ReleaseConfig.SelectorLabels)+1)
+	for k, v := range cfg.ReleaseConfig.SelectorLabels {
+		labels[k] = v
+	}
+	if component == logsComponentKubeconfigJob {
+		labels[nodeComponentLabel] = "kubeconfig"
+	} else {
+		labels[nodeComponentLabel] = component
+	}
+	return labels, nil
+}
+
+// startedContainers returns the names of the containers of a pod which have logs, i.e. which are running or have
+// terminated, in the order they were started
+func startedContainers(pod *corev1.Pod) []string {
+	names := make([]string, 0, len(pod.Status.InitContainerStatuses)+len(pod.Status.ContainerStatuses))
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			if status.State.Running == nil && status.State.Terminated == nil && status.LastTerminationState.Terminated == nil {
+				klog.V(1).Infof("Skipping container %s/%s, which has not started", pod.Name, status.Name)
+				continue
+			}
+			names = append(names, status.Name)
+		}
+	}
+	return names
+}
+
+func clusterLogs(ctx context.Context, args *logsArgsT, cfg *resolvedConfigT) error {
+	cmds := make([]gosh.Commander, 0)
+	guest := false
+	for _, component := range args.Component {
+		if component == logsComponentGuest {
+			guest = true
+			continue
+		}
+		labels, err := componentSelectorLabels(cfg, component)
+		if err != nil {
+			return err
+		}
+		pods, err := kubectlGetPods(ctx, cfg, labels)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to list %s pods", component)
+		}
+		for ix := range pods.Items {
+			pod := &pods.Items[ix]
+			for _, container := range startedContainers(pod) {
+				cmds = append(cmds, withStreams(
+					kubectlLogs(ctx, cfg, pod.Name, args.logsOptions(container)),
+					gosh.ForwardOutErrWithPrefix(fmt.Sprintf("[%s/%s] ", pod.Name, container)),
+				))
+			}
+		}
+	}
+
+	if guest {
+		client, stop, err := guestClient(ctx, &args.ExecArgs, cfg)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		pods, err := client.CoreV1().Pods(args.GuestNamespace).List(ctx, metav1.ListOptions{LabelSelector: args.GuestSelector})
+		if err != nil {
+			return errors.Wrap(err, "Failed to list pods within the cluster")
+		}
+		for ix := range pods.Items {
+			pod := &pods.Items[ix]
+			for _, container := range startedContainers(pod) {
+				opts := args.logsOptions(container)
+				cmds = append(cmds, withStreams(
+					newNativeCmd(ctx, func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+						return kubectl.StreamLogs(ctx, client, pod.Namespace, pod.Name, opts, stdout)
+					}),
+					gosh.ForwardOutErrWithPrefix(fmt.Sprintf("[%s/%s/%s/%s] ", logsComponentGuest, pod.Namespace, pod.Name, container)),
+				))
+			}
+		}
+	}
+
+	if len(cmds) == 0 {
+		klog.Warning("No containers matched")
+		return nil
+	}
+	return gosh.FanOut(cmds...).Run()
+}
+
+func (l *logsArgsT) logsOptions(container string) *kubectl.LogsOptions {
+	return &kubectl.LogsOptions{
+		Container: container,
+		Follow:    l.Follow,
+		Tail:      l.Tail,
+		Since:     l.Since,
+	}
+}
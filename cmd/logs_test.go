@@ -0,0 +1,89 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestComponentSelectorLabels(t *testing.T) {
+	common := map[string]string{"app.kubernetes.io/name": "kink", "app.kubernetes.io/instance": "kink-test"}
+	withComponent := func(component string) map[string]string {
+		labels := map[string]string{nodeComponentLabel: component}
+		for k, v := range common {
+			labels[k] = v
+		}
+		return labels
+	}
+	newer := resolvedConfigT{}
+	newer.ReleaseConfig.SelectorLabels = common
+	newer.ReleaseConfig.ControlplaneSelectorLabels = withComponent("controlplane")
+	newer.ReleaseConfig.WorkerSelectorLabels = withComponent("worker")
+	newer.ReleaseConfig.LBManagerSelectorLabels = withComponent("lb-manager")
+	newer.ReleaseConfig.LoadBalancerSelectorLabels = map[string]string{"selected": "true"}
+	newer.ReleaseConfig.KubeconfigSelectorLabels = withComponent("kubeconfig")
+	// Older charts do not provide selector labels for the lb-manager and kubeconfig job
+	older := newer
+	older.ReleaseConfig.LBManagerSelectorLabels = nil
+	older.ReleaseConfig.KubeconfigSelectorLabels = nil
+
+	for _, config := range []resolvedConfigT{newer, older} {
+		for component, expected := range map[string]map[string]string{
+			logsComponentControlplane:  withComponent("controlplane"),
+			logsComponentWorker:        withComponent("worker"),
+			logsComponentLBManager:     withComponent("lb-manager"),
+			logsComponentKubeconfigJob: withComponent("kubeconfig"),
+		} {
+			labels, err := componentSelectorLabels(&config, component)
+			if err != nil {
+				t.Errorf("%s: %v", component, err)
+				continue
+			}
+			if !reflect.DeepEqual(labels, expected) {
+				t.Errorf("%s: expected %v, got %v", component, expected, labels)
+			}
+		}
+	}
+	if len(common) != 2 {
+		t.Errorf("the common selector labels were modified: %v", common)
+	}
+
+	for _, component := range []string{logsComponentGuest, "nodes"} {
+		_, err := componentSelectorLabels(&newer, component)
+		if err == nil {
+			t.Errorf("%s: expected an error", component)
+		}
+	}
+}
+
+func TestStartedContainers(t *testing.T) {
+	running := corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	terminated := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1}}
+	waiting := corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "PodInitializing"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "kink-test-controlplane-0"},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: "init", State: terminated},
+			},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "node", State: running},
+				// A container waiting to restart still has the logs of its previous run
+				{Name: "crashing", State: waiting, LastTerminationState: terminated},
+				{Name: "file-gateway", State: waiting},
+			},
+		},
+	}
+	expected := []string{"init", "node", "crashing"}
+	if actual := startedContainers(pod); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if actual := startedContainers(&corev1.Pod{}); len(actual) != 0 {
+		t.Errorf("expected no containers for a pending pod, got %v", actual)
+	}
+}
@@ -0,0 +1,250 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meln5674/gosh"
+	"github.com/meln5674/rflag"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	utilexec "k8s.io/client-go/util/exec"
+	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/drain"
+
+	"github.com/meln5674/kink/pkg/helm"
+)
+
+const (
+	nodeComponentLabel   = "app.kubernetes.io/component"
+	nodeRoleControlplane = "controlplane"
+	nodeRoleWorker       = "worker"
+)
+
+var (
+	// nodeRoleIndexPattern matches node names such as worker, worker-2, or controlplane/1
+	nodeRoleIndexPattern = regexp.MustCompile(`^(controlplane|worker)s?(?:[-/](\d+))?$`)
+)
+
+// nodeCmd represents the node command
+var nodeCmd = &cobra.Command{
+	Use:   "node",
+	Short: "Run commands within, and view the logs of, the pods that run the nodes of a cluster",
+	Long: `Each node of a kink cluster runs in a pod in the host cluster. These commands find that pod,
+and then execute within it, as if you had run 'kubectl exec' or 'kubectl logs' against it.
+
+A node can be specified by any of the following:
+* The name of the node in the cluster, as listed by 'kink get node'
+* The name of the pod that runs it
+* Its role and index, e.g. 'worker-2', 'worker/2', or 'controlplane', which is the same as 'controlplane-0'
+* Just its index, e.g. '2', which refers to the workers, or the controlplane if there are no workers
+
+The node pods run k3s or rke2 directly, without systemd, so there is no journal. Instead, the logs of
+k3s/rke2, including the kubelet for k3s, are the logs of the pod, and the logs of containerd, and the kubelet for rke2,
+are files within it. 'kink node logs' can view either.`,
+}
+
+type nodeArgsT struct {
+	ExecArgs execArgsT `rflag:""`
+}
+
+func (nodeArgsT) Defaults() nodeArgsT {
+	return nodeArgsT{
+		ExecArgs: execArgsT{}.Defaults(),
+	}
+}
+
+var nodeArgs = nodeArgsT{}.Defaults()
+
+func init() {
+	rootCmd.AddCommand(nodeCmd)
+	rflag.MustRegister(rflag.ForPFlag(nodeCmd.PersistentFlags()), "", &nodeArgs)
+}
+
+// resolveNodePod finds the pod which runs a node, given any of the ways a node can be specified, as described by
+// `kink node --help`. The guest cluster is only contacted if the node cannot be found from the pods alone.
+func resolveNodePod(ctx context.Context, args *nodeArgsT, cfg *resolvedConfigT, node string) (*corev1.Pod, error) {
+	pods, err := kubectlGetPods(ctx, cfg, map[string]string{
+		helm.ClusterLabel:     cfg.KinkConfig.Release.ClusterName,
+		helm.ClusterNodeLabel: "true",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(pods.Items) == 0 {
+		return nil, fmt.Errorf("Cluster %s has no node pods", cfg.KinkConfig.Release.ClusterName)
+	}
+
+	pod, ok, err := findNodePod(pods.Items, node)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return pod, nil
+	}
+
+	// Node names are normally the same as pod names, so this is only needed if a node was registered by IP
+	guest, stop, err := guestClient(ctx, &args.ExecArgs, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+	guestNode, err := guest.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, unknownNodeError(pods.Items, node)
+	}
+	if err != nil {
+		return nil, err
+	}
+	summaries := summarizeNodes([]corev1.Node{*guestNode}, pods.Items)
+	if summaries[0].Pod == nil {
+		return nil, fmt.Errorf("Node %s does not match any node pod", node)
+	}
+	for ix := range pods.Items {
+		if pods.Items[ix].Name == summaries[0].Pod.Name {
+			return &pods.Items[ix], nil
+		}
+	}
+	panic("BUG: Node summary refers to a pod that was not provided")
+}
+
+// findNodePod finds the pod which runs a node by its pod name, role and index, or index
+func findNodePod(pods []corev1.Pod, node string) (*corev1.Pod, bool, error) {
+	for ix := range pods {
+		if pods[ix].Name == node {
+			return &pods[ix], true, nil
+		}
+	}
+
+	var role, index string
+	if match := nodeRoleIndexPattern.FindStringSubmatch(node); match != nil {
+		role, index = match[1], match[2]
+		if index == "" {
+			index = "0"
+		}
+	} else if _, err := strconv.Atoi(node); err == nil {
+		index = node
+		role = nodeRoleWorker
+		if len(nodePodsWithRole(pods, nodeRoleWorker)) == 0 {
+			role = nodeRoleControlplane
+		}
+	} else {
+		return nil, false, nil
+	}
+
+	for _, pod := range nodePodsWithRole(pods, role) {
+		if podOrdinal(pod) == index {
+			return pod, true, nil
+		}
+	}
+	return nil, false, unknownNodeError(pods, node)
+}
+
+func nodePodsWithRole(pods []corev1.Pod, role string) []*corev1.Pod {
+	matching := make([]*corev1.Pod, 0, len(pods))
+	for ix := range pods {
+		if pods[ix].Labels[nodeComponentLabel] == role {
+			matching = append(matching, &pods[ix])
+		}
+	}
+	return matching
+}
+
+// podOrdinal is the index of a statefulset pod, which is the suffix of its name
+func podOrdinal(pod *corev1.Pod) string {
+	return pod.Name[strings.LastIndex(pod.Name, "-")+1:]
+}
+
+func unknownNodeError(pods []corev1.Pod, node string) error {
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, fmt.Sprintf("%s (%s-%s)", pod.Name, pod.Labels[nodeComponentLabel], podOrdinal(&pod)))
+	}
+	sort.Strings(names)
+	return fmt.Errorf("No node matches %s, must be one of: %s", node, strings.Join(names, ", "))
+}
+
+// nodeContainer is the container within a node pod that runs k3s or rke2, as opposed to any sidecars
+func nodeContainer(pod *corev1.Pod) string {
+	return pod.Spec.Containers[0].Name
+}
+
+// runInNode runs a command within the node container of a node pod, and sets the exit code of kink to its exit code
+func runInNode(ctx context.Context, cfg *resolvedConfigT, pod *corev1.Pod, stdin, tty bool, command ...string) error {
+	err := withStreams(kubectlExecInContainer(ctx, cfg, pod.Name, nodeContainer(pod), stdin, tty, command...), gosh.ForwardAll).Run()
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		exitCode = commandExitCode(exitError.ProcessState)
+		return nil
+	}
+	var remoteExitError utilexec.ExitError
+	if errors.As(err, &remoteExitError) {
+		exitCode = remoteExitError.ExitStatus()
+		return nil
+	}
+	return err
+}
+
+func guestDrainHelper(ctx context.Context, guest kubernetes.Interface, timeout time.Duration) *drain.Helper {
+	return &drain.Helper{
+		Ctx:                 ctx,
+		Client:              guest,
+		GracePeriodSeconds:  -1,
+		IgnoreAllDaemonSets: true,
+		DeleteEmptyDirData:  true,
+		Timeout:             timeout,
+		Out:                 os.Stdout,
+		ErrOut:              os.Stderr,
+	}
+}
+
+// drainGuestNode cordons a node of the guest cluster, then evicts its pods, as 'kubectl drain' does.
+// Nodes which have not registered yet are skipped.
+func drainGuestNode(ctx context.Context, guest kubernetes.Interface, name string, timeout time.Duration) error {
+	node, err := guest.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		klog.Warningf("%s has not registered as a node, skipping drain", name)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get node %s", name)
+	}
+	helper := guestDrainHelper(ctx, guest, timeout)
+	klog.Infof("Draining node %s", name)
+	err = drain.RunCordonOrUncordon(helper, node, true)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to cordon node %s", name)
+	}
+	err = drain.RunNodeDrain(helper, name)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to drain node %s. It has been left cordoned", name)
+	}
+	return nil
+}
+
+// uncordonGuestNode allows pods to be scheduled to a node of the guest cluster again after drainGuestNode
+func uncordonGuestNode(ctx context.Context, guest kubernetes.Interface, name string) error {
+	node, err := guest.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get node %s", name)
+	}
+	klog.Infof("Uncordoning node %s", name)
+	return errors.Wrapf(drain.RunCordonOrUncordon(guestDrainHelper(ctx, guest, 0), node, false), "Failed to uncordon node %s", name)
+}
@@ -0,0 +1,157 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/meln5674/rflag"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var (
+	// nodeShellCommand prefers bash, but the node images do not always have it
+	nodeShellCommand = []string{"sh", "-c", "command -v bash >/dev/null && exec bash || exec sh"}
+
+	k3sCrictlCommand  = []string{"k3s", "crictl"}
+	rke2CrictlCommand = []string{"/var/lib/rancher/rke2/bin/crictl", "--runtime-endpoint", "unix:///run/k3s/containerd/containerd.sock"}
+
+	nodeCrictlDefaultArgs = []string{"ps"}
+	nodeCtrDefaultArgs    = []string{"images", "ls"}
+)
+
+// nodeExecCmd represents the node exec command
+var nodeExecCmd = &cobra.Command{
+	Use:   "exec NODE -- COMMAND [ARGS...]",
+	Short: "Execute a command within the pod of a node",
+	Long: `Execute a command within the pod that runs a node, the same as 'kubectl exec' would.
+
+See 'kink node --help' for how to specify a node.`,
+	Args:         cobra.MinimumNArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		pod, err := resolveNodePod(ctx, &nodeArgs, &resolvedConfig, args[0])
+		if err != nil {
+			return err
+		}
+		return runInNode(ctx, &resolvedConfig, pod, nodeExecArgs.Stdin, nodeExecArgs.TTY, args[1:]...)
+	},
+}
+
+type nodeExecArgsT struct {
+	Stdin bool
+	TTY   bool
+}
+
+func (nodeExecArgsT) Defaults() nodeExecArgsT {
+	return nodeExecArgsT{}
+}
+
+var nodeExecArgs = nodeExecArgsT{}.Defaults()
+
+// nodeShellCmd represents the node shell command
+var nodeShellCmd = &cobra.Command{
+	Use:   "shell NODE",
+	Short: "Start an interactive shell within the pod of a node",
+	Long: `Start an interactive shell within the pod that runs a node, using bash if it is available.
+A TTY is allocated if kink is run from a terminal.
+
+See 'kink node --help' for how to specify a node.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		pod, err := resolveNodePod(ctx, &nodeArgs, &resolvedConfig, args[0])
+		if err != nil {
+			return err
+		}
+		tty := term.IsTerminal(int(os.Stdin.Fd()))
+		return runInNode(ctx, &resolvedConfig, pod, true, tty, nodeShellCommand...)
+	},
+}
+
+// nodeCrictlCmd represents the node crictl command
+var nodeCrictlCmd = &cobra.Command{
+	Use:   "crictl NODE [-- ARGS...]",
+	Short: "Run crictl within the pod of a node",
+	Long: `Run crictl within the pod that runs a node, to inspect the containers and images of its kubelet.
+If no arguments are provided, this lists the running containers, i.e. 'crictl ps'.
+
+See 'kink node --help' for how to specify a node.`,
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		pod, err := resolveNodePod(ctx, &nodeArgs, &resolvedConfig, args[0])
+		if err != nil {
+			return err
+		}
+		crictl := k3sCrictlCommand
+		if resolvedConfig.ReleaseConfig.RKE2Enabled {
+			crictl = rke2CrictlCommand
+		}
+		crictlArgs := args[1:]
+		if len(crictlArgs) == 0 {
+			crictlArgs = nodeCrictlDefaultArgs
+		}
+		return runInNode(ctx, &resolvedConfig, pod, false, false, append(append([]string{}, crictl...), crictlArgs...)...)
+	},
+}
+
+// nodeCtrCmd represents the node ctr command
+var nodeCtrCmd = &cobra.Command{
+	Use:   "ctr NODE [-- ARGS...]",
+	Short: "Run ctr within the pod of a node",
+	Long: `Run ctr within the pod that runs a node, using the same command, namespace, and address as 'kink load'.
+If no arguments are provided, this lists the images, i.e. 'ctr images ls'.
+
+See 'kink node --help' for how to specify a node.`,
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		pod, err := resolveNodePod(ctx, &nodeArgs, &resolvedConfig, args[0])
+		if err != nil {
+			return err
+		}
+		ctrArgs := args[1:]
+		if len(ctrArgs) == 0 {
+			ctrArgs = nodeCtrDefaultArgs
+		}
+		ctrLoadArgs := loadArgsT{
+			CtrCommand:   nodeCtrArgs.CtrCommand,
+			CtrNamespace: nodeCtrArgs.CtrNamespace,
+			CtrAddress:   nodeCtrArgs.CtrAddress,
+		}
+		return runInNode(ctx, &resolvedConfig, pod, false, false, ctrLoadArgs.parseImportImageFlags(&resolvedConfig).Ctr(ctrArgs...)...)
+	},
+}
+
+type nodeCtrArgsT struct {
+	CtrCommand   []string `rflag:"slice-type=slice,usage=Command to run ctr within node pods. Default is based on which distribution is used"`
+	CtrNamespace string   `rflag:"usage=Containerd namespace to use. Default is based on which distribution is used"`
+	CtrAddress   string   `rflag:"usage=Containerd socket address to use. Default is based on which distribution is used"`
+}
+
+func (nodeCtrArgsT) Defaults() nodeCtrArgsT {
+	return nodeCtrArgsT{
+		CtrCommand: []string{},
+	}
+}
+
+var nodeCtrArgs = nodeCtrArgsT{}.Defaults()
+
+func init() {
+	nodeCmd.AddCommand(nodeExecCmd)
+	// These are registered directly so that they can have the same shorthands as kubectl exec, e.g. -it
+	nodeExecCmd.Flags().BoolVarP(&nodeExecArgs.Stdin, "stdin", "i", nodeExecArgs.Stdin, "Pass stdin to the command")
+	nodeExecCmd.Flags().BoolVarP(&nodeExecArgs.TTY, "tty", "t", nodeExecArgs.TTY, "Allocate a TTY for the command")
+	nodeCmd.AddCommand(nodeShellCmd)
+	nodeCmd.AddCommand(nodeCrictlCmd)
+	nodeCmd.AddCommand(nodeCtrCmd)
+	rflag.MustRegister(rflag.ForPFlag(nodeCtrCmd.Flags()), "", &nodeCtrArgs)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/meln5674/gosh"
+	"github.com/meln5674/rflag"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/meln5674/kink/pkg/kubectl"
+)
+
+const (
+	nodeLogsComponentServer     = "server"
+	nodeLogsComponentContainerd = "containerd"
+	nodeLogsComponentKubelet    = "kubelet"
+
+	// These are relative to the data dir
+	nodeContainerdLogFile = "agent/containerd/containerd.log"
+	rke2KubeletLogFile    = "agent/logs/kubelet.log"
+)
+
+// nodeLogsCmd represents the node logs command
+var nodeLogsCmd = &cobra.Command{
+	Use:   "logs NODE",
+	Short: "Print the logs of k3s/rke2, containerd, or the kubelet of a node",
+	Long: `Print the logs of a component of a node. The components are:
+* server: k3s or rke2 itself, which, for k3s, includes the kubelet. These are the logs of the node pod.
+* containerd: The containerd which runs the containers of the node, from its log file within the node pod.
+* kubelet: The kubelet of the node, from its log file within the node pod. This is only available for rke2.
+
+--since and --previous are only supported for the server component.
+
+See 'kink node --help' for how to specify a node.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		pod, err := resolveNodePod(ctx, &nodeArgs, &resolvedConfig, args[0])
+		if err != nil {
+			return err
+		}
+		return nodeLogs(ctx, &nodeLogsArgs, &resolvedConfig, pod)
+	},
+}
+
+type nodeLogsArgsT struct {
+	Component string        `rflag:"usage=Component to print the logs of. One of server|containerd|kubelet"`
+	Follow    bool          `rflag:"usage=Continue printing new logs until interrupted"`
+	Tail      int           `rflag:"usage=Number of most recent lines to print. If negative,, print all lines"`
+	Since     time.Duration `rflag:"usage=Only print logs newer than this. If zero,, print logs of any age"`
+	Previous  bool          `rflag:"usage=Print the logs of the previous instance of the node container,, if it has restarted"`
+}
+
+func (nodeLogsArgsT) Defaults() nodeLogsArgsT {
+	return nodeLogsArgsT{
+		Component: nodeLogsComponentServer,
+		Tail:      -1,
+	}
+}
+
+var nodeLogsArgs = nodeLogsArgsT{}.Defaults()
+
+func init() {
+	nodeCmd.AddCommand(nodeLogsCmd)
+	rflag.MustRegister(rflag.ForPFlag(nodeLogsCmd.Flags()), "", &nodeLogsArgs)
+}
+
+func nodeLogs(ctx context.Context, args *nodeLogsArgsT, cfg *resolvedConfigT, pod *corev1.Pod) error {
+	var logFile string
+	switch args.Component {
+	case nodeLogsComponentServer:
+		return withStreams(kubectlLogs(ctx, cfg, pod.Name, &kubectl.LogsOptions{
+			Container: nodeContainer(pod),
+			Follow:    args.Follow,
+			Previous:  args.Previous,
+			Tail:      args.Tail,
+			Since:     args.Since,
+		}), gosh.ForwardOutErr).Run()
+	case nodeLogsComponentContainerd:
+		logFile = nodeContainerdLogFile
+	case nodeLogsComponentKubelet:
+		if !cfg.ReleaseConfig.RKE2Enabled {
+			return fmt.Errorf("k3s runs the kubelet within the server, use --component=%s instead", nodeLogsComponentServer)
+		}
+		logFile = rke2KubeletLogFile
+	default:
+		return fmt.Errorf("Unknown component %s, must be one of %s, %s, %s", args.Component, nodeLogsComponentServer, nodeLogsComponentContainerd, nodeLogsComponentKubelet)
+	}
+	if args.Since != 0 || args.Previous {
+		return fmt.Errorf("--since and --previous are only supported for --component=%s", nodeLogsComponentServer)
+	}
+
+	tail := []string{"tail", "-n"}
+	if args.Tail < 0 {
+		tail = append(tail, "+1")
+	} else {
+		tail = append(tail, strconv.Itoa(args.Tail))
+	}
+	if args.Follow {
+		tail = append(tail, "-F")
+	}
+	tail = append(tail, path.Join(clusterDataDir(cfg), logFile))
+	return runInNode(ctx, cfg, pod, false, false, tail...)
+}
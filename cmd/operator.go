@@ -0,0 +1,242 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	goflag "flag"
+	"time"
+
+	"github.com/meln5674/gosh"
+	"github.com/spf13/cobra"
+
+	corev1 "k8s.io/api/core/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	cfg "github.com/meln5674/kink/pkg/config"
+	"github.com/meln5674/kink/pkg/helm"
+	"github.com/meln5674/kink/pkg/operator"
+	"github.com/meln5674/kink/pkg/operator/v1alpha1"
+	"github.com/meln5674/rflag"
+)
+
+// operatorCmd represents the operator command
+var operatorCmd = &cobra.Command{
+	Use:   "operator",
+	Short: "Deploy clusters requested by KinkCluster resources",
+	Long: `While running, each KinkCluster resource in the host cluster will be deployed as a cluster
+in the namespace of the operator, and the kubeconfig for that cluster will be published as a Secret in the
+namespace of the KinkCluster. Users who can create KinkClusters can then use clusters without any access to the
+namespace they are deployed in.
+
+The helm, kubectl, and chart flags and configuration file provided to this command are used for all clusters.
+	`,
+	SilenceUsage: true,
+	// Unlike other commands, the operator manages many clusters, so there is no single release configuration to load
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		gosh.GlobalLog = klog.Background()
+
+		resolved, err := loadBaseConfig(&kinkArgs)
+		if err != nil {
+			return err
+		}
+		resolvedConfig = *resolved
+
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctrl.SetLogger(zap.New(zap.UseFlagOptions(&operatorArgs.zap)))
+		ctx := ctrl.SetupSignalHandler()
+		return runOperator(ctx, &operatorArgs, &resolvedConfig)
+	},
+}
+
+type operatorArgsT struct {
+	LeaderElectionEnabled   bool                         `rflag:"name=leader-election,usage=Enable leader election. Required if more than one replica is running"`
+	LeaderElection          lbManagerLeaderElectionArgsT `rflag:"prefix=leader-election-"`
+	RequeueDelay            time.Duration                `rflag:"usage=Time to wait between checks for cluster readiness and retries for reconciliation errors"`
+	MaxConcurrentReconciles int                          `rflag:"usage=Maximum number of clusters to deploy or check at once"`
+	AllowCustomCharts       bool                         `rflag:"usage=Allow KinkClusters to override the chart to deploy. Because the chart runs privileged pods,, only enable this if all users who can create KinkClusters are trusted"`
+	DeletePVCs              bool                         `rflag:"name=delete-pvcs,usage=Delete the PVCs backing a cluster when its KinkCluster is deleted"`
+
+	MetricsAddr string `rflag:"name=metrics-bind-address,usage=The address the metric endpoint binds to."`
+	ProbeAddr   string `rflag:"name=health-probe-bind-address,usage=The address the probe endpoint binds to."`
+
+	zap zap.Options
+}
+
+func (operatorArgsT) Defaults() operatorArgsT {
+	return operatorArgsT{
+		LeaderElection:          lbManagerLeaderElectionArgsT{}.Defaults(),
+		RequeueDelay:            15 * time.Second,
+		MaxConcurrentReconciles: 4,
+		DeletePVCs:              true,
+		MetricsAddr:             ":8080",
+		ProbeAddr:               ":8081",
+
+		zap: zap.Options{
+			Development: true,
+		},
+	}
+}
+
+var operatorArgs = operatorArgsT{}.Defaults()
+
+func init() {
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+
+	rootCmd.AddCommand(operatorCmd)
+	rflag.MustRegister(rflag.ForPFlag(operatorCmd.Flags()), "", &operatorArgs)
+	zapFlags := goflag.NewFlagSet("", goflag.PanicOnError)
+	operatorArgs.zap.BindFlags(zapFlags)
+	operatorCmd.Flags().AddGoFlagSet(zapFlags)
+}
+
+func runOperator(ctx context.Context, args *operatorArgsT, cfg *resolvedConfigT) error {
+	setupLog := ctrl.Log.WithName("setup")
+
+	mgr, err := ctrl.NewManager(cfg.Kubeconfig, ctrl.Options{
+		Scheme:                  scheme,
+		MetricsBindAddress:      args.MetricsAddr,
+		Port:                    9443,
+		HealthProbeBindAddress:  args.ProbeAddr,
+		LeaderElection:          args.LeaderElectionEnabled,
+		LeaderElectionID:        "kink-operator",
+		LeaderElectionNamespace: cfg.ReleaseNamespace,
+		LeaseDuration:           &args.LeaderElection.Lease,
+		RenewDeadline:           &args.LeaderElection.Renew,
+		RetryPeriod:             &args.LeaderElection.Retry,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to start manager")
+		return err
+	}
+
+	clusterController := operator.KinkClusterController{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("cluster-ctrl"),
+		Backend: &operatorBackend{
+			base:              cfg,
+			allowCustomCharts: args.AllowCustomCharts,
+			deletePVCs:        args.DeletePVCs,
+		},
+		ReleaseNamespace: cfg.ReleaseNamespace,
+		RequeueDelay:     args.RequeueDelay,
+	}
+	err = builder.
+		ControllerManagedBy(mgr).
+		For(&v1alpha1.KinkCluster{}).
+		Owns(&corev1.Secret{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: args.MaxConcurrentReconciles}).
+		Complete(&clusterController)
+	if err != nil {
+		return err
+	}
+
+	if err = mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		return err
+	}
+	if err = mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		return err
+	}
+
+	setupLog.Info("starting manager")
+	if err = mgr.Start(ctx); err != nil {
+		setupLog.Error(err, "problem running manager")
+		return err
+	}
+
+	return nil
+}
+
+// operatorBackend implements operator.Backend using the same functions as the equivalent commands
+type operatorBackend struct {
+	base              *resolvedConfigT
+	allowCustomCharts bool
+	deletePVCs        bool
+}
+
+var _ = operator.Backend(&operatorBackend{})
+
+// clusterConfig produces the configuration that would be used if the operator's flags were used with a kink command
+// to manage the requested cluster
+func (o *operatorBackend) clusterConfig(cluster *v1alpha1.KinkCluster) *resolvedConfigT {
+	resolved := *o.base
+	spec := cluster.Spec.DeepCopy()
+	if o.allowCustomCharts {
+		resolved.KinkConfig.Chart.Override(&spec.Chart)
+	}
+	resolved.KinkConfig.Release = spec.Release
+	resolved.KinkConfig.Release.ClusterName = cluster.ClusterName()
+	// Helm and kubectl must target the operator's namespace, not whatever the default would be
+	resolved.KinkConfig.Kubernetes.ConfigOverrides.Context.Namespace = o.base.ReleaseNamespace
+	resolved.ReleaseConfig = cfg.ReleaseConfig{}
+	return &resolved
+}
+
+func (o *operatorBackend) Resolve(ctx context.Context, cluster *v1alpha1.KinkCluster) (*cfg.ReleaseConfig, error) {
+	resolved := o.clusterConfig(cluster)
+	err := resolveReleaseConfig(ctx, resolved, false)
+	if err != nil {
+		return nil, err
+	}
+	return &resolved.ReleaseConfig, nil
+}
+
+// Apply only deploys the release, without waiting for it to become ready. Unlike createCluster, it does not wait for
+// the controlplane, nodes, or CoreDNS, and neither does helm, as that would block the controller for minutes at a
+// time, instead, the controller polls the statefulsets and requeues until they are ready. Preflight checks are not run
+// either, as they check the binaries and permissions of the user running kink, which, for the operator, are fixed by
+// its image and service account, and any resulting failure is reported in the conditions of the KinkCluster instead.
+func (o *operatorBackend) Apply(ctx context.Context, cluster *v1alpha1.KinkCluster) error {
+	resolved := o.clusterConfig(cluster)
+	resolved.KinkConfig.Release.NoWait = true
+	return deployCluster(ctx, resolved)
+}
+
+func (o *operatorBackend) Kubeconfig(ctx context.Context, cluster *v1alpha1.KinkCluster, releaseConfig *cfg.ReleaseConfig) ([]byte, error) {
+	resolved := o.clusterConfig(cluster)
+	resolved.ReleaseConfig = *releaseConfig
+	var kubeconfig bytes.Buffer
+	args := exportKubeconfigCommonArgsT{}.Defaults()
+	args.InCluster = true
+	err := exportKubeconfig(ctx, &kubeconfig, &args, resolved)
+	if err != nil {
+		return nil, err
+	}
+	return kubeconfig.Bytes(), nil
+}
+
+func (o *operatorBackend) Delete(ctx context.Context, cluster *v1alpha1.KinkCluster) error {
+	resolved := o.clusterConfig(cluster)
+	releases, err := helmListReleases(ctx, resolved, false)
+	if err != nil {
+		return err
+	}
+	releaseName := resolved.KinkConfig.Release.Raw().Name
+	for _, release := range releases {
+		if release.Name != releaseName {
+			continue
+		}
+		err = withStreams(helmDeleteCluster(ctx, resolved), gosh.ForwardOutErr).Run()
+		if err != nil {
+			return err
+		}
+		break
+	}
+	if !o.deletePVCs {
+		return nil
+	}
+	deletePVCs := kubectlDeletePVCs(ctx, resolved, map[string]string{helm.ClusterLabel: resolved.KinkConfig.Release.ClusterName})
+	return withStreams(deletePVCs, gosh.ForwardOutErr).Run()
+}
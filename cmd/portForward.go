@@ -6,12 +6,11 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
-	"github.com/meln5674/gosh"
 	"github.com/meln5674/rflag"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -22,13 +21,23 @@ import (
 
 // portForwardCmd represents the port-forward command
 var portForwardCmd = &cobra.Command{
-	Use:          "port-forward",
-	Short:        "Forard the controlplane and (if enabled) file-gateway to local ports",
+	Use:   "port-forward",
+	Short: "Forard the controlplane and (if enabled) file-gateway to local ports",
+	Long: `Forward the controlplane and (if enabled) file-gateway to local ports until interrupted.
+
+With --detach, the port-forward instead runs in the background, and can be checked and stopped with 'kink port-forward status' and 'kink port-forward stop'. While it is running, 'kink exec', 'kink sh', and other commands which would otherwise start their own port-forward use it instead.`,
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx, stopSignals := WithCancelOnInterrupt(context.Background())
 		defer stopSignals()
 
+		if portForwardDetachArgs.Detach {
+			if os.Getenv(portForwardDaemonEnv) != "" {
+				return runPortForwardDaemon(ctx, &portForwardArgs, &resolvedConfig)
+			}
+			return detachPortForward(ctx, &resolvedConfig)
+		}
+
 		stopPortForward, err := startPortForward(ctx, true, &portForwardArgs, &resolvedConfig)
 		if err != nil {
 			return err
@@ -46,8 +55,8 @@ var portForwardCmd = &cobra.Command{
 }
 
 type portForwardArgsT struct {
-	ControlplanePort int `rflag:"usage=The local port to forward from for controlplane (api server) connections"`
-	FileGatewayPort  int `rflag:"usage=The local port to forward from for file gateway connections"`
+	ControlplanePort int `rflag:"usage=The local port to forward from for controlplane (api server) connections. 0 picks a free port,, which is only useful for commands which port-forward for their own duration,, or port-forward --detach"`
+	FileGatewayPort  int `rflag:"usage=The local port to forward from for file gateway connections. 0 picks a free port,, which is only useful for commands which port-forward for their own duration,, or port-forward --detach"`
 }
 
 func (portForwardArgsT) Defaults() portForwardArgsT {
@@ -59,10 +68,21 @@ func (portForwardArgsT) Defaults() portForwardArgsT {
 
 var portForwardArgs = portForwardArgsT{}.Defaults()
 
+type portForwardDetachArgsT struct {
+	Detach bool `rflag:"usage=Run the port-forward in the background,, and return once it is ready"`
+}
+
+func (portForwardDetachArgsT) Defaults() portForwardDetachArgsT {
+	return portForwardDetachArgsT{}
+}
+
+var portForwardDetachArgs = portForwardDetachArgsT{}.Defaults()
+
 func init() {
 	rootCmd.AddCommand(portForwardCmd)
 
 	rflag.MustRegister(rflag.ForPFlag(portForwardCmd.Flags()), "", &portForwardArgs)
+	rflag.MustRegister(rflag.ForPFlag(portForwardCmd.Flags()), "", &portForwardDetachArgs)
 }
 
 func WithCancelOnInterrupt(ctx context.Context) (_ context.Context, cancel func()) {
@@ -87,116 +107,46 @@ func WithCancelOnInterrupt(ctx context.Context) (_ context.Context, cancel func(
 	}
 }
 
-func makePortForwardCmd(ctx context.Context, args *portForwardArgsT, cfg *resolvedConfigT) (*gosh.Cmd, error) {
-	ports := map[string]string{
-		fmt.Sprintf("%d", args.ControlplanePort): fmt.Sprintf("%d", cfg.ReleaseConfig.ControlplanePort),
-	}
+// startPortForward forwards the controlplane, and the file gateway if enabled, to local ports. It returns once the
+// ports are listening, and replaces any ports in args which were 0 with the ports that were picked. If retry is true,
+// the forward is re-established, to another controlplane pod if possible, whenever it is lost, until the context is
+// cancelled.
+func startPortForward(ctx context.Context, retry bool, args *portForwardArgsT, cfg *resolvedConfigT) (stop func() error, err error) {
+	ports := []string{fmt.Sprintf("%d:%d", args.ControlplanePort, cfg.ReleaseConfig.ControlplanePort)}
 	if cfg.ReleaseConfig.FileGatewayEnabled {
-		ports[fmt.Sprintf("%d", args.FileGatewayPort)] = fmt.Sprintf("%d", cfg.ReleaseConfig.FileGatewayContainerPort)
-	}
-	kubectlPortForward := kubectl.PortForward(
-		&cfg.KinkConfig.Kubectl, &cfg.KinkConfig.Kubernetes,
-		fmt.Sprintf("svc/%s", cfg.ReleaseConfig.ControlplaneFullname),
-		ports,
-	)
-	cmd := gosh.
-		Command(kubectlPortForward...).
-		WithContext(ctx).
-		WithStreams(gosh.ForwardOutErr)
-	return cmd, cmd.Start()
-}
-
-func cmdRetryLoop(ctx context.Context, lock chan struct{}, logMsg string, cmdPtr **gosh.Cmd, mkCmd func() (*gosh.Cmd, error)) error {
-	for {
-		var err error
-		func() {
-			lock <- struct{}{}
-			defer func() { <-lock }()
-			if *cmdPtr != nil {
-				err = (*cmdPtr).Wait()
-			}
-		}()
-		select {
-		case _, ok := <-ctx.Done():
-			if !ok {
-				klog.Info("Context canceled, stopping retry loop")
-				return nil
-			}
-		default:
-			func() {
-				lock <- struct{}{}
-				defer func() { <-lock }()
-				if *cmdPtr != nil {
-					if err != nil {
-						klog.Warningf("%s failed, retrying...: %s", logMsg, err)
-					} else {
-						klog.Warningf("%s stopped without error, retrying...: %v", logMsg, err)
-					}
-				}
-				*cmdPtr, err = mkCmd()
-				if err != nil {
-					klog.Errorf("Failed to start %s: %s", logMsg, err)
-					*cmdPtr = nil
-				}
-			}()
-		}
-	}
-}
-
-func startAndRetryInBackground(ctx context.Context, retry bool, lock chan struct{}, logMsg string, cmdPtr **gosh.Cmd, mkCmd func() (*gosh.Cmd, error)) (stop func() error, err error) {
-	*cmdPtr, err = mkCmd()
-	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to %s", logMsg)
+		ports = append(ports, fmt.Sprintf("%d:%d", args.FileGatewayPort, cfg.ReleaseConfig.FileGatewayContainerPort))
 	}
-
-	if !retry {
-		return func() error { return nil }, nil
-	}
-
-	go cmdRetryLoop(ctx, lock, logMsg, cmdPtr, mkCmd)
-
-	return func() error {
-		lock <- struct{}{}
-		defer func() { <-lock }()
-		if *cmdPtr == nil {
-			return nil
-		}
-		klog.Infof("Stopping %s...", logMsg)
-		err := (*cmdPtr).Kill()
-		if err != nil {
-			klog.Warningf("Failed to kill %s, wait may never finish: %s", logMsg, err)
-		}
-		err = (*cmdPtr).Wait()
-		klog.Infof("Stopped %s", logMsg)
-		if err != nil {
-			return err
-		}
-		return nil
-	}, nil
-
-}
-
-func startPortForward(ctx context.Context, retry bool, args *portForwardArgsT, cfg *resolvedConfigT) (stop func() error, err error) {
-	lock := make(chan struct{}, 1)
-
-	var kubectlPortForwardCmd *gosh.Cmd
-	stop, err = startAndRetryInBackground(ctx, retry, lock, "Port-forwarding to controlplane", &kubectlPortForwardCmd, func() (*gosh.Cmd, error) {
-		return makePortForwardCmd(ctx, args, cfg)
+	forwarder, err := kubectl.NewServicePortForwarder(cfg.Kubeconfig, cfg.ReleaseNamespace, cfg.ReleaseConfig.ControlplaneFullname, &kubectl.ServicePortForwardOptions{
+		Ports:     ports,
+		Reconnect: retry,
+		Out:       io.Discard,
+		ErrOut:    os.Stderr,
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	klog.Info("Waiting for cluster to be accessible on localhost...")
-	kubectlVersion := kubectl.Version(&cfg.KinkConfig.Kubectl, &cfg.KinkConfig.Kubernetes)
-	for err = errors.New("dummy"); err != nil; err = gosh.
-		Command(kubectlVersion...).
-		WithContext(ctx).
-		WithStreams(gosh.ForwardOutErr).
-		Run() {
-		time.Sleep(5 * time.Second)
+	forwardCtx, cancel := context.WithCancel(ctx)
+	err = forwarder.Start(forwardCtx)
+	if err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "Failed to port-forward to controlplane")
+	}
+
+	bound := forwarder.Ports()
+	args.ControlplanePort = int(bound[0].Local)
+	if cfg.ReleaseConfig.FileGatewayEnabled {
+		args.FileGatewayPort = int(bound[1].Local)
+	}
+	klog.Infof("Forwarding controlplane from localhost:%d via pod %s", args.ControlplanePort, forwarder.Pod())
+	if cfg.ReleaseConfig.FileGatewayEnabled {
+		klog.Infof("Forwarding file gateway from localhost:%d via pod %s", args.FileGatewayPort, forwarder.Pod())
 	}
 	// TODO: Also forward ingress ports, if enabled, and any nodeport service ports
 
-	return stop, err
+	return func() error {
+		cancel()
+		return forwarder.Err()
+	}, nil
 }
@@ -0,0 +1,381 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/meln5674/rflag"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// portForwardDaemonEnv is set when kink port-forward --detach re-executes itself as the background process
+	portForwardDaemonEnv = "KINK_PORT_FORWARD_DAEMON"
+
+	portForwardPidFile    = "pid"
+	portForwardSocketFile = "control.sock"
+	portForwardLogFile    = "log"
+
+	portForwardDetachTimeout = 2 * time.Minute
+)
+
+// PortForwardStatus describes a background port-forward started with kink port-forward --detach
+type PortForwardStatus struct {
+	PID              int         `json:"pid"`
+	Cluster          string      `json:"cluster"`
+	Namespace        string      `json:"namespace"`
+	ControlplanePort int         `json:"controlplanePort"`
+	FileGatewayPort  int         `json:"fileGatewayPort,omitempty"`
+	Started          metav1.Time `json:"started"`
+	Log              string      `json:"log"`
+}
+
+// portForwardStatusCmd represents the port-forward status command
+var portForwardStatusCmd = &cobra.Command{
+	Use:          "status",
+	Short:        "Show the background port-forward for a cluster, if one is running",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		status, err := getPortForwardDaemonStatus(&resolvedConfig)
+		if err != nil {
+			return err
+		}
+		if status == nil {
+			return fmt.Errorf("No background port-forward is running for cluster %s", resolvedConfig.KinkConfig.Release.ClusterName)
+		}
+		if ok, err := writeStructuredOutput(os.Stdout, portForwardStatusArgs.Output, status); ok {
+			return err
+		}
+		return printPortForwardStatus(os.Stdout, status)
+	},
+}
+
+// portForwardStopCmd represents the port-forward stop command
+var portForwardStopCmd = &cobra.Command{
+	Use:          "stop",
+	Short:        "Stop the background port-forward for a cluster",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return stopPortForwardDaemon(context.Background(), &resolvedConfig)
+	},
+}
+
+type portForwardStatusArgsT struct {
+	Output string `rflag:"name=output,shorthand=o,usage=Output format. One of table,, json,, yaml"`
+}
+
+func (portForwardStatusArgsT) Defaults() portForwardStatusArgsT {
+	return portForwardStatusArgsT{
+		Output: getOutputTable,
+	}
+}
+
+var portForwardStatusArgs = portForwardStatusArgsT{}.Defaults()
+
+func init() {
+	portForwardCmd.AddCommand(portForwardStatusCmd)
+	portForwardCmd.AddCommand(portForwardStopCmd)
+	rflag.MustRegister(rflag.ForPFlag(portForwardStatusCmd.Flags()), "", &portForwardStatusArgs)
+}
+
+// portForwardStateDir is where the pidfile, control socket, and log of the background port-forward for a cluster are
+// kept. Clusters are identified by their host cluster, namespace, and name, which are hashed to keep the socket path
+// short enough to be valid.
+func portForwardStateDir(cfg *resolvedConfigT) (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(cfg.Kubeconfig.Host + "\x00" + cfg.ReleaseNamespace + "\x00" + cfg.KinkConfig.Release.ClusterName))
+	return filepath.Join(base, "kink", "port-forward", hex.EncodeToString(hash[:8])), nil
+}
+
+// portForwardControlClient returns an HTTP client which sends all requests to a control socket
+func portForwardControlClient(socket string) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+// getPortForwardDaemonStatus asks the background port-forward for a cluster for its status, returning nil if none is
+// running. Any pidfile and socket left behind by a port-forward which did not exit cleanly are removed.
+func getPortForwardDaemonStatus(cfg *resolvedConfigT) (*PortForwardStatus, error) {
+	dir, err := portForwardStateDir(cfg)
+	if err != nil {
+		return nil, err
+	}
+	socket := filepath.Join(dir, portForwardSocketFile)
+	if _, err := os.Stat(socket); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	resp, err := portForwardControlClient(socket).Get("http://kink/status")
+	if err != nil {
+		// A daemon which is running, but busy, must not lose its socket, or it could no longer be stopped
+		if !portForwardStateIsStale(dir, err) {
+			return nil, errors.Wrapf(err, "Background port-forward did not respond. If it is no longer running, remove %s", dir)
+		}
+		klog.V(4).Infof("Removing stale port-forward state in %s: %v", dir, err)
+		os.Remove(socket)
+		os.Remove(filepath.Join(dir, portForwardPidFile))
+		return nil, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Background port-forward returned %s: %s", resp.Status, string(body))
+	}
+	var status PortForwardStatus
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// portForwardStateIsStale checks if the control socket could not be reached because nothing is listening on it, or
+// because the process in the pidfile is no longer running
+func portForwardStateIsStale(dir string, err error) bool {
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ENOENT) {
+		return true
+	}
+	pidBytes, readErr := os.ReadFile(filepath.Join(dir, portForwardPidFile))
+	if readErr != nil {
+		// The pidfile is written before the socket is created, so a daemon which is running always has one
+		return true
+	}
+	pid, parseErr := strconv.Atoi(strings.TrimSpace(string(pidBytes)))
+	if parseErr != nil {
+		return true
+	}
+	return !processAlive(pid)
+}
+
+// stopPortForwardDaemon asks the background port-forward for a cluster to stop, and waits for it to do so
+func stopPortForwardDaemon(ctx context.Context, cfg *resolvedConfigT) error {
+	status, err := getPortForwardDaemonStatus(cfg)
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		klog.Infof("No background port-forward is running for cluster %s", cfg.KinkConfig.Release.ClusterName)
+		return nil
+	}
+	dir, err := portForwardStateDir(cfg)
+	if err != nil {
+		return err
+	}
+	socket := filepath.Join(dir, portForwardSocketFile)
+	resp, err := portForwardControlClient(socket).Post("http://kink/stop", "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	err = wait.PollUntilContextTimeout(ctx, 100*time.Millisecond, 30*time.Second, true, func(context.Context) (bool, error) {
+		_, err := os.Stat(socket)
+		return errors.Is(err, os.ErrNotExist), nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Background port-forward (pid %d) did not stop", status.PID)
+	}
+	klog.Infof("Stopped background port-forward (pid %d)", status.PID)
+	return nil
+}
+
+// startOrReusePortForward is startPortForward, unless a background port-forward is already running for the cluster,
+// in which case its ports are used instead
+func startOrReusePortForward(ctx context.Context, args *portForwardArgsT, cfg *resolvedConfigT) (stop func() error, err error) {
+	status, err := getPortForwardDaemonStatus(cfg)
+	if err != nil {
+		klog.Warningf("Could not check for a background port-forward, starting a new one: %v", err)
+	}
+	if status == nil {
+		return startPortForward(ctx, true, args, cfg)
+	}
+	klog.Infof("Using background port-forward (pid %d) on localhost:%d", status.PID, status.ControlplanePort)
+	args.ControlplanePort = status.ControlplanePort
+	if status.FileGatewayPort != 0 {
+		args.FileGatewayPort = status.FileGatewayPort
+	}
+	return func() error { return nil }, nil
+}
+
+// detachPortForward re-executes the current command as a background process, and waits for it to start forwarding
+func detachPortForward(ctx context.Context, cfg *resolvedConfigT) error {
+	status, err := getPortForwardDaemonStatus(cfg)
+	if err != nil {
+		return err
+	}
+	if status != nil {
+		return fmt.Errorf("A background port-forward (pid %d) is already running for cluster %s on localhost:%d", status.PID, status.Cluster, status.ControlplanePort)
+	}
+
+	dir, err := portForwardStateDir(cfg)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	logPath := filepath.Join(dir, portForwardLogFile)
+	log, err := os.Create(logPath)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	daemon := exec.Command(self, os.Args[1:]...)
+	daemon.Env = append(os.Environ(), portForwardDaemonEnv+"=true")
+	daemon.Stdout = log
+	daemon.Stderr = log
+	daemon.SysProcAttr = detachedProcAttr()
+	err = daemon.Start()
+	if err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- daemon.Wait() }()
+
+	klog.Infof("Started background port-forward (pid %d), waiting for it to be ready...", daemon.Process.Pid)
+	ctx, cancel := context.WithTimeout(ctx, portForwardDetachTimeout)
+	defer cancel()
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("Background port-forward exited (%v), see %s", err, logPath)
+		case <-ctx.Done():
+			return fmt.Errorf("Background port-forward did not become ready, see %s", logPath)
+		case <-ticker.C:
+		}
+		status, err := getPortForwardDaemonStatus(cfg)
+		if err != nil {
+			return err
+		}
+		if status != nil {
+			klog.Infof("Background port-forward (pid %d) is forwarding controlplane from localhost:%d", status.PID, status.ControlplanePort)
+			if status.FileGatewayPort != 0 {
+				klog.Infof("Background port-forward (pid %d) is forwarding file gateway from localhost:%d", status.PID, status.FileGatewayPort)
+			}
+			klog.Info("Run 'kink port-forward stop' to stop it")
+			return nil
+		}
+	}
+}
+
+// runPortForwardDaemon is the background process started by detachPortForward. It forwards ports like kink
+// port-forward, and serves its status on a control socket until stopped through it, or by a signal.
+func runPortForwardDaemon(ctx context.Context, args *portForwardArgsT, cfg *resolvedConfigT) error {
+	dir, err := portForwardStateDir(cfg)
+	if err != nil {
+		return err
+	}
+	pidPath := filepath.Join(dir, portForwardPidFile)
+	socketPath := filepath.Join(dir, portForwardSocketFile)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopPortForward, err := startPortForward(ctx, true, args, cfg)
+	if err != nil {
+		return err
+	}
+	defer stopPortForward()
+
+	err = os.WriteFile(pidPath, []byte(strconv.Itoa(os.Getpid())), 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(pidPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	// Closing the listener removes the socket
+	defer listener.Close()
+
+	status := PortForwardStatus{
+		PID:              os.Getpid(),
+		Cluster:          cfg.KinkConfig.Release.ClusterName,
+		Namespace:        cfg.ReleaseNamespace,
+		ControlplanePort: args.ControlplanePort,
+		Started:          metav1.Now(),
+		Log:              filepath.Join(dir, portForwardLogFile),
+	}
+	if cfg.ReleaseConfig.FileGatewayEnabled {
+		status.FileGatewayPort = args.FileGatewayPort
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+	mux.HandleFunc("/stop", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		klog.Info("Stop requested through control socket")
+		w.Write([]byte("OK"))
+		cancel()
+	})
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	klog.Infof("Serving port-forward status on %s", socketPath)
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func printPortForwardStatus(out io.Writer, status *PortForwardStatus) error {
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tNAMESPACE\tPID\tCONTROLPLANE\tFILE GATEWAY\tSTARTED")
+	fileGateway := "<none>"
+	if status.FileGatewayPort != 0 {
+		fileGateway = fmt.Sprintf("localhost:%d", status.FileGatewayPort)
+	}
+	fmt.Fprintf(
+		w, "%s\t%s\t%d\tlocalhost:%d\t%s\t%s\n",
+		status.Cluster, status.Namespace, status.PID, status.ControlplanePort, fileGateway, status.Started.Format(time.RFC3339),
+	)
+	return w.Flush()
+}
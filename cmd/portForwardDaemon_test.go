@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"k8s.io/client-go/rest"
+
+	cfg "github.com/meln5674/kink/pkg/config"
+)
+
+// testPortForwardState creates the state directory of a background port-forward, with a pidfile containing pid and an
+// empty control socket
+func testPortForwardState(t *testing.T, pid int) (*resolvedConfigT, string) {
+	// t.TempDir() is too long for a socket path
+	cacheDir, err := os.MkdirTemp("", "kink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(cacheDir) })
+	// These are where os.UserCacheDir looks on linux, mac, and windows, respectively
+	t.Setenv("XDG_CACHE_HOME", cacheDir)
+	t.Setenv("HOME", cacheDir)
+	t.Setenv("LocalAppData", cacheDir)
+	config := &resolvedConfigT{
+		KinkConfig:       cfg.Config{},
+		ReleaseNamespace: "default",
+		Kubeconfig:       &rest.Config{Host: "https://example.com"},
+	}
+	config.KinkConfig.Release.ClusterName = "test"
+	dir, err := portForwardStateDir(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, portForwardPidFile), []byte(strconv.Itoa(pid)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config, dir
+}
+
+// listenAndHangUp listens on the control socket, and closes every connection without responding, like a daemon which
+// is too busy to respond would appear to
+func listenAndHangUp(t *testing.T, dir string) {
+	listener, err := net.Listen("unix", filepath.Join(dir, portForwardSocketFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+}
+
+func deadPid(t *testing.T) int {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	err := cmd.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func checkPortForwardState(t *testing.T, dir string, expected bool) {
+	t.Helper()
+	for _, file := range []string{portForwardPidFile, portForwardSocketFile} {
+		_, err := os.Stat(filepath.Join(dir, file))
+		if exists := err == nil; exists != expected {
+			t.Errorf("%s: expected exists=%v, got %v", file, expected, err)
+		}
+	}
+}
+
+func TestPortForwardDaemonStatusKeepsLiveDaemon(t *testing.T) {
+	config, dir := testPortForwardState(t, os.Getpid())
+	listenAndHangUp(t, dir)
+	status, err := getPortForwardDaemonStatus(config)
+	if err == nil {
+		t.Errorf("expected an error from a daemon which did not respond, got %#v", status)
+	}
+	checkPortForwardState(t, dir, true)
+}
+
+func TestPortForwardDaemonStatusRemovesDeadDaemon(t *testing.T) {
+	config, dir := testPortForwardState(t, deadPid(t))
+	listenAndHangUp(t, dir)
+	status, err := getPortForwardDaemonStatus(config)
+	if err != nil || status != nil {
+		t.Errorf("expected no daemon, got %#v, %v", status, err)
+	}
+	checkPortForwardState(t, dir, false)
+}
+
+func TestPortForwardDaemonStatusRemovesStaleSocket(t *testing.T) {
+	config, dir := testPortForwardState(t, os.Getpid())
+	// Closing the listener does not remove the socket if it is unlinked first, which is what is left behind when a
+	// daemon is killed
+	listener, err := net.Listen("unix", filepath.Join(dir, portForwardSocketFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	status, err := getPortForwardDaemonStatus(config)
+	if err != nil || status != nil {
+		t.Errorf("expected no daemon, got %#v, %v", status, err)
+	}
+	checkPortForwardState(t, dir, false)
+}
@@ -42,6 +42,10 @@ type kinkArgsT struct {
 	KubectlCommand []string `rflag:"usage=Command to execute for kubectl"`
 	DockerCommand  []string `rflag:"usage=Command to execute for docker"`
 
+	HelmNative    bool `rflag:"usage=Use the built-in helm client instead of executing helm"`
+	KubectlNative bool `rflag:"usage=Use the built-in kubernetes client instead of executing kubectl"`
+	DockerNative  bool `rflag:"usage=Use the built-in docker client instead of executing docker"`
+
 	ChartName              string `rflag:"name=chart,usage=Name of KinK Helm Chart"`
 	ChartVersion           string `rflag:"usage=Version of the chart to install"`
 	ChartRepository        string `rflag:"name=repository-url,usage=URL of KinK Helm Chart repository"`
@@ -102,12 +106,15 @@ func (k kinkArgsT) ConfigOverrides() cfg.Config {
 	return cfg.Config{
 		Helm: helm.HelmFlags{
 			Command: k.HelmCommand,
+			Native:  k.HelmNative,
 		},
 		Kubectl: kubectl.KubectlFlags{
 			Command: k.KubectlCommand,
+			Native:  k.KubectlNative,
 		},
 		Docker: docker.DockerFlags{
 			Command: k.DockerCommand,
+			Native:  k.DockerNative,
 		},
 		Chart: helm.ChartFlags{
 			ChartName:     k.ChartName,
@@ -161,6 +168,12 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// noConfigPreRun replaces the root pre-run for commands which run within a node container,
+// where there is no cluster configuration to load
+func noConfigPreRun(cmd *cobra.Command, args []string) error {
+	return nil
+}
+
 // exitCode is the exit code that main() will exit with.
 // This is a global variable so that os.Exit does not need to be called until main would otherwise exit
 // If an error is returned by any command, this value is ignored and instead klog.Fatal is used instead.
@@ -198,29 +211,53 @@ func (d devNullT) Write(b []byte) (int, error) {
 }
 
 func loadConfig(args *kinkArgsT) (*resolvedConfigT, error) {
-	var cfg resolvedConfigT
-	var err error
+	cfg, err := loadBaseConfig(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if args.ReleaseConfigMount != "" {
+		err = cfg.ReleaseConfig.LoadFromMount(args.ReleaseConfigMount)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err = resolveReleaseConfig(context.TODO(), cfg, args.DoRepoUpdate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	klog.V(1).Infof("%#v", cfg.ReleaseConfig)
 
+	return cfg, nil
+}
+
+// loadBaseConfig loads the configuration file and flags, and connects to the host cluster, but does not determine
+// the release configuration
+func loadBaseConfig(args *kinkArgsT) (*resolvedConfigT, error) {
+	var kinkConfig cfg.Config
 	if args.ConfigPath != "" {
 		var rawConfig config.RawConfig
-		err = rawConfig.LoadFromFile(args.ConfigPath)
+		err := rawConfig.LoadFromFile(args.ConfigPath)
 		if err != nil {
 			return nil, fmt.Errorf("Configuration file %s is invalid or missing: %s", args.ConfigPath, err)
 		}
-		cfg.KinkConfig = rawConfig.Format()
+		kinkConfig = rawConfig.Format()
 	}
+	return resolveBaseConfig(kinkConfig, args)
+}
+
+// resolveBaseConfig applies the flags to a configuration, and connects to the host cluster
+func resolveBaseConfig(kinkConfig cfg.Config, args *kinkArgsT) (*resolvedConfigT, error) {
+	cfg := resolvedConfigT{KinkConfig: kinkConfig}
+	var err error
 
 	overrides := args.ConfigOverrides()
 	klog.V(1).Infof("%#v", &overrides)
 	cfg.KinkConfig.Override(&overrides)
 	klog.V(1).Infof("%#v", cfg.KinkConfig)
 
-	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-		&clientcmd.ClientConfigLoadingRules{
-			ExplicitPath: args.Kubeconfig,
-		},
-		&cfg.KinkConfig.Kubernetes.ConfigOverrides,
-	)
+	clientConfig := cfg.KinkConfig.Kubernetes.ClientConfig()
 	cfg.Kubeconfig, err = clientConfig.ClientConfig()
 	if err != nil {
 		return nil, err
@@ -231,94 +268,76 @@ func loadConfig(args *kinkArgsT) (*resolvedConfigT, error) {
 		return nil, err
 	}
 
-	if args.ReleaseConfigMount != "" {
-		err = cfg.ReleaseConfig.LoadFromMount(args.ReleaseConfigMount)
+	return &cfg, nil
+}
+
+// resolveReleaseConfig renders the chart for a cluster in order to determine the names, labels, and other settings of
+// its resources
+func resolveReleaseConfig(ctx context.Context, cfg *resolvedConfigT, doRepoUpdate bool) error {
+	var err error
+	doc := corev1.ConfigMap{}
+	if !cfg.KinkConfig.Chart.IsLocalChart() && !cfg.KinkConfig.Chart.IsOCIChart() && !cfg.KinkConfig.Helm.Native {
+		klog.Info("Ensuring helm repo exists...")
+		err = withStreams(helmRepoAdd(ctx, cfg), gosh.ForwardOutErr).Run()
 		if err != nil {
-			return nil, err
+			return err
 		}
-	} else {
-		doc := corev1.ConfigMap{}
-		if !cfg.KinkConfig.Chart.IsLocalChart() && !cfg.KinkConfig.Chart.IsOCIChart() {
-			klog.Info("Ensuring helm repo exists...")
-			repoAdd := helm.RepoAdd(&cfg.KinkConfig.Helm, &cfg.KinkConfig.Chart)
-			err = gosh.
-				Command(repoAdd...).
-				WithStreams(gosh.ForwardOutErr).
-				Run()
+		if doRepoUpdate {
+			klog.Info("Updating chart repo...")
+			err = withStreams(helmRepoUpdate(ctx, cfg), gosh.ForwardOutErr).Run()
 			if err != nil {
-				return nil, err
+				return err
 			}
-			if args.DoRepoUpdate {
-				repoUpdate := helm.RepoUpdate(&cfg.KinkConfig.Helm, cfg.KinkConfig.Chart.RepoName())
-				klog.Info("Updating chart repo...")
-				err = gosh.
-					Command(repoUpdate...).
-					WithContext(context.TODO()).
-					WithStreams(gosh.ForwardOutErr).
-					Run()
+		} else {
+			klog.Info("Chart repo update skipped by flag")
+		}
+	}
+	loadedConfig := false
+	err = withStreams(
+		helmTemplateCluster(ctx, cfg),
+		gosh.ForwardErr,
+		gosh.FuncOut(func(r io.Reader) error {
+			decoder := yaml.NewYAMLOrJSONDecoder(r, 1024)
+			for {
+				doc = corev1.ConfigMap{}
+				err := decoder.Decode(&doc)
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				if err != nil {
+					// TODO: find a way to distinguish I/O errors and syntax errors from "not a configmap" errors
+					klog.Warning(err)
+					continue
+				}
+				klog.V(1).Infof("%s/%s/%s/%s", doc.APIVersion, doc.Kind, doc.Namespace, doc.Name)
+				if doc.APIVersion != "v1" || doc.Kind != "ConfigMap" {
+					continue
+				}
+				if doc.Namespace != cfg.ReleaseNamespace && doc.Namespace != "" {
+					klog.Warning("Found a configmap other than the one we're looking for")
+					continue
+				}
+				ok, err := cfg.ReleaseConfig.LoadFromConfigMap(&doc)
 				if err != nil {
-					return nil, err
+					// If we don't flush its stdout, the helm template process never exits on windows
+					io.Copy(devNull, r)
+					return err
 				}
-			} else {
-				klog.Info("Chart repo update skipped by flag")
+				if ok {
+					// See above
+					io.Copy(devNull, r)
+					loadedConfig = true
+					return nil
+				}
+				klog.Warning("Found a configmap other than the one we're looking for")
 			}
-		}
-		loadedConfig := false
-		err = gosh.
-			Command(helm.TemplateCluster(
-				&cfg.KinkConfig.Helm,
-				&cfg.KinkConfig.Chart,
-				&cfg.KinkConfig.Release,
-				&cfg.KinkConfig.Kubernetes,
-			)...).
-			WithStreams(
-				gosh.ForwardErr,
-				gosh.FuncOut(func(r io.Reader) error {
-					decoder := yaml.NewYAMLOrJSONDecoder(r, 1024)
-					for {
-						doc = corev1.ConfigMap{}
-						err := decoder.Decode(&doc)
-						if errors.Is(err, io.EOF) {
-							return nil
-						}
-						if err != nil {
-							// TODO: find a way to distinguish I/O errors and syntax errors from "not a configmap" errors
-							klog.Warning(err)
-							continue
-						}
-						klog.V(1).Infof("%s/%s/%s/%s", doc.APIVersion, doc.Kind, doc.Namespace, doc.Name)
-						if doc.APIVersion != "v1" || doc.Kind != "ConfigMap" {
-							continue
-						}
-						if doc.Namespace != cfg.ReleaseNamespace && doc.Namespace != "" {
-							klog.Warning("Found a configmap other than the one we're looking for")
-							continue
-						}
-						ok, err := cfg.ReleaseConfig.LoadFromConfigMap(&doc)
-						if err != nil {
-							// If we don't flush its stdout, the helm template process never exits on windows
-							io.Copy(devNull, r)
-							return err
-						}
-						if ok {
-							// See above
-							io.Copy(devNull, r)
-							loadedConfig = true
-							return nil
-						}
-						klog.Warning("Found a configmap other than the one we're looking for")
-					}
-				}),
-			).
-			Run()
-		if err != nil {
-			return nil, err
-		}
-		if !loadedConfig {
-			return nil, fmt.Errorf("Did not find the expected cluster configmap in the helm template output. This could be a bug or your release values are invalid")
-		}
+		}),
+	).Run()
+	if err != nil {
+		return err
 	}
-	klog.V(1).Infof("%#v", cfg.ReleaseConfig)
-
-	return &cfg, nil
+	if !loadedConfig {
+		return fmt.Errorf("Did not find the expected cluster configmap in the helm template output. This could be a bug or your release values are invalid")
+	}
+	return nil
 }
@@ -0,0 +1,18 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// scaleCmd represents the scale command
+var scaleCmd = &cobra.Command{
+	Use:   "scale",
+	Short: "Scales one of [workers]",
+}
+
+func init() {
+	rootCmd.AddCommand(scaleCmd)
+}
@@ -0,0 +1,157 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/meln5674/gosh"
+	"github.com/meln5674/rflag"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// scaleWorkersCmd represents the scale workers command
+var scaleWorkersCmd = &cobra.Command{
+	Use:   "workers REPLICAS",
+	Short: "Change the number of worker nodes of a cluster",
+	Long: `Changes the number of worker pods of a cluster.
+
+When scaling down, the nodes of the highest ordinal worker pods are cordoned and drained first, so their pods are
+rescheduled onto the remaining nodes, instead of being stuck on nodes which are NotReady until the guest cluster gives
+up on them. Once the worker pods have been deleted, their Node objects are deleted from the guest cluster as well.
+If a node fails to drain, the workers are not scaled down, and the nodes drained so far are left cordoned. Run this
+again to continue, or use 'kubectl uncordon' to keep them.
+
+The persistent volume claims of the removed workers are kept, as they are for any statefulset, and are reused if the
+workers are scaled back up.
+
+This only changes the worker statefulset, not the release, so worker.replicaCount must also be changed in the values
+of the cluster, otherwise the next 'kink create cluster' or 'kink upgrade cluster' scales the workers back.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		replicas, err := strconv.Atoi(args[0])
+		if err != nil || replicas < 0 {
+			return fmt.Errorf("REPLICAS must be a non-negative integer, got %s", args[0])
+		}
+		return scaleWorkers(context.Background(), &scaleWorkersArgs, &resolvedConfig, int32(replicas))
+	},
+}
+
+type scaleWorkersArgsT struct {
+	ExecArgs     execArgsT     `rflag:""`
+	DrainTimeout time.Duration `rflag:"usage=How long to wait for each worker node to drain before aborting. Set to zero to wait forever"`
+	PodTimeout   time.Duration `rflag:"usage=How long to wait for the removed worker pods to be deleted,, or the added worker pods to be ready"`
+}
+
+func (scaleWorkersArgsT) Defaults() scaleWorkersArgsT {
+	return scaleWorkersArgsT{
+		ExecArgs:     execArgsT{}.Defaults(),
+		DrainTimeout: 5 * time.Minute,
+		PodTimeout:   5 * time.Minute,
+	}
+}
+
+var scaleWorkersArgs = scaleWorkersArgsT{}.Defaults()
+
+func init() {
+	scaleCmd.AddCommand(scaleWorkersCmd)
+	rflag.MustRegister(rflag.ForPFlag(scaleWorkersCmd.Flags()), "", &scaleWorkersArgs)
+}
+
+func scaleWorkers(ctx context.Context, args *scaleWorkersArgsT, cfg *resolvedConfigT, replicas int32) error {
+	host, err := hostClient(cfg)
+	if err != nil {
+		return err
+	}
+	name := cfg.ReleaseConfig.WorkerFullname
+	sts, err := host.AppsV1().StatefulSets(cfg.ReleaseNamespace).Get(ctx, name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return fmt.Errorf("Cluster %s does not exist, use 'kink create cluster' to create it", cfg.KinkConfig.Release.ClusterName)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Failed to get worker statefulset")
+	}
+	current := statefulSetReplicas(sts)
+
+	if replicas == current {
+		klog.Infof("Cluster already has %d workers", replicas)
+		return nil
+	}
+
+	guest, stop, err := guestClient(ctx, &args.ExecArgs, cfg)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	removed := make([]string, 0)
+	for ordinal := current - 1; ordinal >= replicas; ordinal-- {
+		podName := fmt.Sprintf("%s-%d", name, ordinal)
+		err = drainGuestNode(ctx, guest, podName, args.DrainTimeout)
+		if err != nil {
+			return fmt.Errorf("Aborted, the workers have not been scaled down, and the nodes drained so far have been left cordoned. Once the problem is fixed, run this again to continue: %w", err)
+		}
+		removed = append(removed, podName)
+	}
+
+	klog.Infof("Scaling workers from %d to %d", current, replicas)
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": replicas,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = host.AppsV1().StatefulSets(cfg.ReleaseNamespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return errors.Wrap(err, "Failed to scale worker statefulset")
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, args.PodTimeout)
+	defer cancel()
+	if len(removed) == 0 {
+		err = withStreams(kubectlStatefulSetRolloutStatus(waitCtx, cfg, name), gosh.ForwardOutErr).Run()
+		if waitCtx.Err() != nil {
+			return fmt.Errorf("Worker pods were not ready within %s: %w", args.PodTimeout, waitCtx.Err())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, podName := range removed {
+		err = wait.PollUntilContextCancel(waitCtx, upgradePollInterval, true, func(ctx context.Context) (bool, error) {
+			_, err := host.CoreV1().Pods(cfg.ReleaseNamespace).Get(ctx, podName, metav1.GetOptions{})
+			if kerrors.IsNotFound(err) {
+				return true, nil
+			}
+			if err != nil {
+				klog.Warningf("Failed to get pod %s, retrying: %v", podName, err)
+			}
+			return false, nil
+		})
+		if err != nil {
+			return fmt.Errorf("Pod %s was not deleted within %s: %w", podName, args.PodTimeout, err)
+		}
+		klog.Infof("Deleting node %s", podName)
+		err = guest.CoreV1().Nodes().Delete(ctx, podName, metav1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return errors.Wrapf(err, "Failed to delete node %s", podName)
+		}
+	}
+
+	klog.Warningf("Scaled workers to %d. Set worker.replicaCount=%d in the values of the cluster, otherwise the next upgrade will scale them back to the previous number", replicas, replicas)
+	return nil
+}
@@ -0,0 +1,416 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/meln5674/gosh"
+	"github.com/meln5674/rflag"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/meln5674/kink/pkg/helm"
+)
+
+const (
+	snapshotMetadataFile = "metadata.json"
+	snapshotEtcdFile     = "etcd.db"
+	snapshotSharedFile   = "shared.tar"
+
+	// snapshotPVCMountPath is where the snapshot PVC is mounted in the helper pod
+	snapshotPVCMountPath    = "/snapshots"
+	snapshotPodPollInterval = 2 * time.Second
+
+	k3sDataDir  = "/var/lib/rancher/k3s"
+	rke2DataDir = "/var/lib/rancher/rke2"
+	// etcdRestoreFile is where the controlplane expects a snapshot to be uploaded to, relative to the data dir.
+	// This must match the chart.
+	etcdRestoreFile = "server/db/kink-restore.db"
+	// etcdConfigFile is where k3s and rke2 write the etcd configuration, relative to the data dir
+	etcdConfigFile = "server/db/etcd/config"
+	etcdEndpoint   = "https://127.0.0.1:2379"
+)
+
+// snapshotCmd represents the snapshot command
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Save and restore the state of clusters",
+	Long: `A snapshot consists of a snapshot of the etcd datastore of a cluster, and, if shared persistence is enabled,
+a tar archive of the shared persistence volume. Restoring a snapshot creates a new cluster with the same state,
+which is much faster than re-creating the same resources from scratch.
+
+Snapshots are stored in a local directory (--dir) or in a PVC in the host cluster (--pvc). Etcd snapshots can only be
+taken of clusters which use etcd, i.e. those with more than one controlplane replica, or which use rke2.`,
+}
+
+type snapshotArgsT struct {
+	Dir      string `rflag:"usage=Local directory to store snapshots in. Exactly one of --dir or --pvc is required"`
+	PVC      string `rflag:"name=pvc,usage=PVC in the host cluster to store snapshots in. Exactly one of --dir or --pvc is required"`
+	PVCImage string `rflag:"name=pvc-image,usage=Image to use for the pod that accesses --pvc. Must contain sh,, cat,, ls,, mkdir,, mv,, and rm"`
+}
+
+func (snapshotArgsT) Defaults() snapshotArgsT {
+	return snapshotArgsT{
+		PVCImage: "docker.io/library/busybox:1.36",
+	}
+}
+
+var snapshotArgs = snapshotArgsT{}.Defaults()
+
+func init() {
+	rootCmd.AddCommand(snapshotCmd)
+	rflag.MustRegister(rflag.ForPFlag(snapshotCmd.PersistentFlags()), "", &snapshotArgs)
+}
+
+// SnapshotMetadata describes the cluster a snapshot was taken from
+type SnapshotMetadata struct {
+	// Name is the name of the snapshot
+	Name string `json:"name"`
+	// Cluster is the name of the cluster the snapshot was taken from
+	Cluster string `json:"cluster"`
+	// Namespace is the namespace the cluster was deployed to
+	Namespace string `json:"namespace"`
+	// Created is when the snapshot was taken
+	Created metav1.Time `json:"created"`
+	// Distribution is the kubernetes distribution of the cluster, either k3s or rke2
+	Distribution string `json:"distribution"`
+	// ChartVersion is the version of the chart the cluster was deployed with
+	ChartVersion string `json:"chartVersion"`
+	// ControlplaneReplicas is the number of controlplane replicas when the snapshot was taken
+	ControlplaneReplicas int32 `json:"controlplaneReplicas"`
+	// SharedPersistence is true if the snapshot includes the contents of the shared persistence volume
+	SharedPersistence bool `json:"sharedPersistence"`
+	// SharedPersistenceMounts are the directories which were included from the shared persistence volume
+	SharedPersistenceMounts []string `json:"sharedPersistenceMounts,omitempty"`
+}
+
+// snapshotStore is where snapshots are kept. Each snapshot is a set of named files.
+type snapshotStore interface {
+	// Writer returns a writer for a file in a snapshot, creating the snapshot if it does not exist.
+	// The file is not guaranteed to be complete until the writer is closed.
+	Writer(ctx context.Context, snapshot, file string) (io.WriteCloser, error)
+	// Reader returns a reader for a file in a snapshot
+	Reader(ctx context.Context, snapshot, file string) (io.ReadCloser, error)
+	// Remove deletes a file from a snapshot, if it exists
+	Remove(ctx context.Context, snapshot, file string) error
+	// List returns the names of all snapshots
+	List(ctx context.Context) ([]string, error)
+	// Close releases any resources used to access the store
+	Close(ctx context.Context) error
+}
+
+func openSnapshotStore(ctx context.Context, args *snapshotArgsT, cfg *resolvedConfigT) (snapshotStore, error) {
+	if (args.Dir == "") == (args.PVC == "") {
+		return nil, errors.New("Exactly one of --dir or --pvc is required")
+	}
+	if args.Dir != "" {
+		return &localSnapshotStore{dir: args.Dir}, nil
+	}
+	return newPVCSnapshotStore(ctx, args.PVC, args.PVCImage, cfg)
+}
+
+// validateSnapshotName ensures a snapshot name can be safely used as a directory name
+func validateSnapshotName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("Invalid snapshot name %q", name)
+	}
+	return nil
+}
+
+func saveSnapshotMetadata(ctx context.Context, store snapshotStore, metadata *SnapshotMetadata) error {
+	w, err := store.Writer(ctx, metadata.Name, snapshotMetadataFile)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(w).Encode(metadata)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func loadSnapshotMetadata(ctx context.Context, store snapshotStore, name string) (*SnapshotMetadata, error) {
+	r, err := store.Reader(ctx, name, snapshotMetadataFile)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var metadata SnapshotMetadata
+	err = json.NewDecoder(r).Decode(&metadata)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Snapshot %s has invalid or missing metadata", name)
+	}
+	return &metadata, nil
+}
+
+// localSnapshotStore keeps each snapshot as a subdirectory of a local directory
+type localSnapshotStore struct {
+	dir string
+}
+
+var _ = snapshotStore(&localSnapshotStore{})
+
+func (l *localSnapshotStore) Writer(ctx context.Context, snapshot, file string) (io.WriteCloser, error) {
+	dir := filepath.Join(l.dir, snapshot)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(filepath.Join(dir, file))
+}
+
+func (l *localSnapshotStore) Reader(ctx context.Context, snapshot, file string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(l.dir, snapshot, file))
+}
+
+func (l *localSnapshotStore) Remove(ctx context.Context, snapshot, file string) error {
+	err := os.Remove(filepath.Join(l.dir, snapshot, file))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (l *localSnapshotStore) List(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(l.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		_, err := os.Stat(filepath.Join(l.dir, entry.Name(), snapshotMetadataFile))
+		if err != nil {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+func (l *localSnapshotStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// pvcSnapshotStore keeps each snapshot as a subdirectory of a PVC in the host cluster, which is accessed by
+// executing commands in a temporary pod which mounts it
+type pvcSnapshotStore struct {
+	cfg *resolvedConfigT
+	pod string
+}
+
+var _ = snapshotStore(&pvcSnapshotStore{})
+
+func newPVCSnapshotStore(ctx context.Context, pvc, image string, cfg *resolvedConfigT) (*pvcSnapshotStore, error) {
+	host, err := hostClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	_, err = host.CoreV1().PersistentVolumeClaims(cfg.ReleaseNamespace).Get(ctx, pvc, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get snapshot PVC %s", pvc)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: fmt.Sprintf("kink-snapshots-%s-", pvc),
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{
+				{
+					Name:  "snapshots",
+					Image: image,
+					// The pod is deleted once the command is done, this only limits how long it lingers if that fails
+					Command: []string{"sleep", "3600"},
+					VolumeMounts: []corev1.VolumeMount{
+						{Name: "snapshots", MountPath: snapshotPVCMountPath},
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{
+					Name: "snapshots",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvc},
+					},
+				},
+			},
+		},
+	}
+	pod, err = host.CoreV1().Pods(cfg.ReleaseNamespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create snapshot pod")
+	}
+	store := &pvcSnapshotStore{cfg: cfg, pod: pod.Name}
+	klog.Infof("Waiting for snapshot pod %s to start...", pod.Name)
+	err = wait.PollUntilContextCancel(ctx, snapshotPodPollInterval, true, func(ctx context.Context) (bool, error) {
+		pod, err := host.CoreV1().Pods(cfg.ReleaseNamespace).Get(ctx, store.pod, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+			return false, fmt.Errorf("Snapshot pod %s exited unexpectedly", store.pod)
+		}
+		return pod.Status.Phase == corev1.PodRunning, nil
+	})
+	if err != nil {
+		store.Close(ctx)
+		return nil, err
+	}
+	return store, nil
+}
+
+func (p *pvcSnapshotStore) path(snapshot, file string) string {
+	return path.Join(snapshotPVCMountPath, snapshot, file)
+}
+
+func (p *pvcSnapshotStore) Writer(ctx context.Context, snapshot, file string) (io.WriteCloser, error) {
+	// Writing to a temporary file first ensures an interrupted upload doesn't leave a truncated file behind
+	script := `mkdir -p "$(dirname "$0")" && cat > "$0.tmp" && mv "$0.tmp" "$0"`
+	r, w := io.Pipe()
+	cmd := withStreams(
+		kubectlExec(ctx, p.cfg, p.pod, true, false, "sh", "-c", script, p.path(snapshot, file)),
+		gosh.ReaderIn(r),
+		gosh.ForwardOutErr,
+	)
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		// If the command exits early, this unblocks any pending writes
+		r.CloseWithError(errors.New("snapshot pod command exited"))
+		done <- err
+	}()
+	return &cmdWriter{PipeWriter: w, done: done}, nil
+}
+
+func (p *pvcSnapshotStore) Reader(ctx context.Context, snapshot, file string) (io.ReadCloser, error) {
+	r, w := io.Pipe()
+	cmd := withStreams(
+		kubectlExec(ctx, p.cfg, p.pod, false, false, "cat", p.path(snapshot, file)),
+		gosh.WriterOut(w),
+		gosh.ForwardErr,
+	)
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		w.CloseWithError(cmd.Wait())
+	}()
+	return r, nil
+}
+
+func (p *pvcSnapshotStore) Remove(ctx context.Context, snapshot, file string) error {
+	return withStreams(kubectlExec(ctx, p.cfg, p.pod, false, false, "rm", "-f", p.path(snapshot, file)), gosh.ForwardOutErr).Run()
+}
+
+func (p *pvcSnapshotStore) List(ctx context.Context) ([]string, error) {
+	var out string
+	script := fmt.Sprintf(`cd '%s' && for f in */%s; do [ -f "${f}" ] && dirname "${f}"; done; true`, snapshotPVCMountPath, snapshotMetadataFile)
+	err := withStreams(
+		kubectlExec(ctx, p.cfg, p.pod, false, false, "sh", "-c", script),
+		gosh.FuncOut(gosh.SaveString(&out)),
+		gosh.ForwardErr,
+	).Run()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, name := range strings.Split(out, "\n") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (p *pvcSnapshotStore) Close(ctx context.Context) error {
+	host, err := hostClient(p.cfg)
+	if err != nil {
+		return err
+	}
+	// The pod may be closed after the command's context is cancelled, so a fresh one is used to ensure it is deleted
+	return host.CoreV1().Pods(p.cfg.ReleaseNamespace).Delete(context.Background(), p.pod, metav1.DeleteOptions{})
+}
+
+// cmdWriter is a pipe to the stdin of a running command, closing it waits for the command to exit
+type cmdWriter struct {
+	*io.PipeWriter
+	done <-chan error
+}
+
+func (c *cmdWriter) Close() error {
+	err := c.PipeWriter.Close()
+	if err != nil {
+		return err
+	}
+	return <-c.done
+}
+
+// clusterDataDir is the directory k3s or rke2 stores its state in on controlplane nodes
+func clusterDataDir(cfg *resolvedConfigT) string {
+	if cfg.ReleaseConfig.RKE2Enabled {
+		return rke2DataDir
+	}
+	return k3sDataDir
+}
+
+func clusterDistribution(cfg *resolvedConfigT) string {
+	if cfg.ReleaseConfig.RKE2Enabled {
+		return "rke2"
+	}
+	return "k3s"
+}
+
+// clusterChartVersion finds the version of the chart the cluster is currently deployed with, or the empty string
+// if it is not deployed
+func clusterChartVersion(ctx context.Context, cfg *resolvedConfigT) (string, error) {
+	releases, err := helmListReleases(ctx, cfg, false)
+	if err != nil {
+		return "", err
+	}
+	releaseName := cfg.KinkConfig.Release.Raw().Name
+	for _, release := range releases {
+		if release.Name == releaseName {
+			_, version := helm.SplitChart(release.Chart)
+			return version, nil
+		}
+	}
+	return "", nil
+}
+
+// sharedPersistenceTarPaths are the shared persistence directories, relative to the root directory, as they are
+// archived and extracted by tar
+func sharedPersistenceTarPaths(mounts []string) []string {
+	paths := make([]string, 0, len(mounts))
+	for _, mount := range mounts {
+		paths = append(paths, strings.TrimPrefix(mount, "/"))
+	}
+	return paths
+}
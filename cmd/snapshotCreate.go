@@ -0,0 +1,220 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/meln5674/gosh"
+	"github.com/meln5674/rflag"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// snapshotCreateCmd represents the snapshot create command
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "create NAME",
+	Short: "Take a snapshot of a cluster",
+	Long: `Takes a snapshot of the etcd datastore of the first controlplane node, and, if shared persistence is enabled,
+the contents of the shared persistence volume.
+
+Pods in the cluster continue to run while the snapshot is taken. To get a consistent snapshot of the shared persistence
+volume, ensure nothing is writing to it.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return createSnapshot(context.Background(), args[0], &snapshotCreateArgs, &snapshotArgs, &resolvedConfig)
+	},
+}
+
+type snapshotCreateArgsT struct {
+	Overwrite             bool `rflag:"usage=Replace the snapshot if it already exists"`
+	SkipSharedPersistence bool `rflag:"usage=Do not include the contents of the shared persistence volume,, even if it is enabled"`
+}
+
+func (snapshotCreateArgsT) Defaults() snapshotCreateArgsT {
+	return snapshotCreateArgsT{}
+}
+
+var snapshotCreateArgs = snapshotCreateArgsT{}.Defaults()
+
+// snapshotEtcdSaveCmd represents the snapshot etcd-save command
+var snapshotEtcdSaveCmd = &cobra.Command{
+	Use:               "etcd-save",
+	Short:             "Write a snapshot of etcd to stdout",
+	Long:              `This command is used by 'kink snapshot create' within a KinK controlplane container, you should not use it outside of one`,
+	Hidden:            true,
+	PersistentPreRunE: noConfigPreRun,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return saveEtcdSnapshot(context.Background(), os.Stdout, &snapshotEtcdSaveArgs)
+	},
+}
+
+type snapshotEtcdSaveArgsT struct {
+	ConfigPath string `rflag:"name=etcd-config-path,usage=Path to etcd config file"`
+	Endpoint   string `rflag:"name=etcd-endpoint,usage=Endpoint to connect to etcd cluster"`
+}
+
+var snapshotEtcdSaveArgs snapshotEtcdSaveArgsT
+
+func init() {
+	snapshotCmd.AddCommand(snapshotCreateCmd)
+	rflag.MustRegister(rflag.ForPFlag(snapshotCreateCmd.Flags()), "", &snapshotCreateArgs)
+
+	snapshotCmd.AddCommand(snapshotEtcdSaveCmd)
+	rflag.MustRegister(rflag.ForPFlag(snapshotEtcdSaveCmd.Flags()), "", &snapshotEtcdSaveArgs)
+}
+
+func createSnapshot(ctx context.Context, name string, args *snapshotCreateArgsT, storeArgs *snapshotArgsT, cfg *resolvedConfigT) error {
+	err := validateSnapshotName(name)
+	if err != nil {
+		return err
+	}
+
+	host, err := hostClient(cfg)
+	if err != nil {
+		return err
+	}
+	controlplane, err := host.AppsV1().StatefulSets(cfg.ReleaseNamespace).Get(ctx, cfg.ReleaseConfig.ControlplaneFullname, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "Failed to get controlplane statefulset, does the cluster exist?")
+	}
+	podName := fmt.Sprintf("%s-0", cfg.ReleaseConfig.ControlplaneFullname)
+	pod, err := host.CoreV1().Pods(cfg.ReleaseNamespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get controlplane pod %s", podName)
+	}
+	if pod.Status.Phase != corev1.PodRunning {
+		return fmt.Errorf("Controlplane pod %s is %s, not Running", podName, pod.Status.Phase)
+	}
+	// The node container is always first, the file gateway may be present as a second container
+	container := pod.Spec.Containers[0].Name
+
+	chartVersion, err := clusterChartVersion(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	store, err := openSnapshotStore(ctx, storeArgs, cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close(ctx)
+
+	existing, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, existingName := range existing {
+		if existingName != name {
+			continue
+		}
+		if !args.Overwrite {
+			return fmt.Errorf("Snapshot %s already exists, use --overwrite to replace it", name)
+		}
+		err = removeSnapshotFiles(ctx, store, name)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to remove existing snapshot %s", name)
+		}
+	}
+
+	metadata := SnapshotMetadata{
+		Name:                 name,
+		Cluster:              cfg.KinkConfig.Release.ClusterName,
+		Namespace:            cfg.ReleaseNamespace,
+		Created:              metav1.NewTime(time.Now()),
+		Distribution:         clusterDistribution(cfg),
+		ChartVersion:         chartVersion,
+		ControlplaneReplicas: statefulSetReplicas(controlplane),
+		SharedPersistence:    bool(cfg.ReleaseConfig.SharedPersistenceEnabled) && !args.SkipSharedPersistence,
+	}
+	if metadata.SharedPersistence {
+		metadata.SharedPersistenceMounts = []string(cfg.ReleaseConfig.SharedPersistenceMounts)
+	}
+
+	klog.Infof("Saving etcd snapshot from %s...", podName)
+	dataDir := clusterDataDir(cfg)
+	err = saveSnapshotFile(ctx, store, name, snapshotEtcdFile, kubectlExecInContainer(
+		ctx, cfg, podName, container, false, false,
+		"kink", "snapshot", "etcd-save",
+		"--etcd-config-path", path.Join(dataDir, etcdConfigFile),
+		"--etcd-endpoint", etcdEndpoint,
+	))
+	if err != nil {
+		return errors.Wrap(err, "Failed to save etcd snapshot")
+	}
+
+	if metadata.SharedPersistence {
+		klog.Infof("Saving shared persistence from %s...", podName)
+		tar := append([]string{"tar", "cf", "-", "-C", "/"}, sharedPersistenceTarPaths(metadata.SharedPersistenceMounts)...)
+		err = saveSnapshotFile(ctx, store, name, snapshotSharedFile, kubectlExecInContainer(ctx, cfg, podName, container, false, false, tar...))
+		if err != nil {
+			return errors.Wrap(err, "Failed to save shared persistence")
+		}
+	}
+
+	// The metadata is written last so that incomplete snapshots are not listed
+	err = saveSnapshotMetadata(ctx, store, &metadata)
+	if err != nil {
+		return err
+	}
+	klog.Infof("Snapshot %s created", name)
+	return nil
+}
+
+// removeSnapshotFiles deletes the files of an existing snapshot. The metadata is removed first, so that if this, or
+// writing the replacement, fails, the snapshot is no longer listed, instead of pairing the old metadata with new data.
+func removeSnapshotFiles(ctx context.Context, store snapshotStore, name string) error {
+	for _, file := range []string{snapshotMetadataFile, snapshotEtcdFile, snapshotSharedFile} {
+		err := store.Remove(ctx, name, file)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveSnapshotFile writes the output of a command to a file in a snapshot
+func saveSnapshotFile(ctx context.Context, store snapshotStore, snapshot, file string, cmd gosh.Pipelineable) error {
+	w, err := store.Writer(ctx, snapshot, file)
+	if err != nil {
+		return err
+	}
+	err = withStreams(cmd, gosh.WriterOut(w), gosh.ForwardErr).Run()
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func saveEtcdSnapshot(ctx context.Context, out io.Writer, args *snapshotEtcdSaveArgsT) error {
+	config, err := loadEtcdConfig(args.ConfigPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Etcd config %s does not exist. Only clusters with more than one controlplane replica, or which use rke2, use etcd", args.ConfigPath)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open etcd config at path %s", args.ConfigPath)
+	}
+	etcdClient, err := newEtcdClient(config, args.Endpoint)
+	if err != nil {
+		return err
+	}
+	defer etcdClient.Close()
+	snapshot, err := etcdClient.Snapshot(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Failed to start etcd snapshot")
+	}
+	defer snapshot.Close()
+	_, err = io.Copy(out, snapshot)
+	return err
+}
@@ -0,0 +1,105 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/meln5674/rflag"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/klog/v2"
+)
+
+// snapshotListCmd represents the snapshot list command
+var snapshotListCmd = &cobra.Command{
+	Use:          "list",
+	Short:        "List snapshots",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listSnapshots(context.Background(), os.Stdout, &snapshotListArgs, &snapshotArgs, &resolvedConfig)
+	},
+}
+
+type snapshotListArgsT struct {
+	Output string `rflag:"shorthand=o,usage=Output format. One of name|table|json|yaml"`
+}
+
+func (snapshotListArgsT) Defaults() snapshotListArgsT {
+	return snapshotListArgsT{
+		Output: getOutputTable,
+	}
+}
+
+var snapshotListArgs = snapshotListArgsT{}.Defaults()
+
+func init() {
+	snapshotCmd.AddCommand(snapshotListCmd)
+	rflag.MustRegister(rflag.ForPFlag(snapshotListCmd.Flags()), "", &snapshotListArgs)
+}
+
+func listSnapshots(ctx context.Context, out io.Writer, args *snapshotListArgsT, storeArgs *snapshotArgsT, cfg *resolvedConfigT) error {
+	switch args.Output {
+	case getOutputName, getOutputTable, getOutputJSON, getOutputYAML:
+	default:
+		return fmt.Errorf("Unknown output format %s, must be one of name, table, json, yaml", args.Output)
+	}
+
+	store, err := openSnapshotStore(ctx, storeArgs, cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close(ctx)
+
+	names, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	if args.Output == getOutputName {
+		for _, name := range names {
+			fmt.Fprintln(out, name)
+		}
+		return nil
+	}
+
+	snapshots := make([]SnapshotMetadata, 0, len(names))
+	for _, name := range names {
+		metadata, err := loadSnapshotMetadata(ctx, store, name)
+		if err != nil {
+			klog.Warning(err)
+			continue
+		}
+		snapshots = append(snapshots, *metadata)
+	}
+
+	if ok, err := writeStructuredOutput(out, args.Output, snapshots); ok {
+		return err
+	}
+	return printSnapshotTable(out, snapshots)
+}
+
+func printSnapshotTable(out io.Writer, snapshots []SnapshotMetadata) error {
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCLUSTER\tNAMESPACE\tAGE\tDISTRIBUTION\tVERSION\tCONTROLPLANE\tSHARED PERSISTENCE")
+	for _, snapshot := range snapshots {
+		fmt.Fprintf(
+			w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			snapshot.Name,
+			snapshot.Cluster,
+			snapshot.Namespace,
+			duration.HumanDuration(time.Since(snapshot.Created.Time)),
+			snapshot.Distribution,
+			snapshot.ChartVersion,
+			snapshot.ControlplaneReplicas,
+			strconv.FormatBool(snapshot.SharedPersistence),
+		)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,266 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/meln5674/gosh"
+	"github.com/meln5674/rflag"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// controlplaneInitContainerName is the name of the init container of the controlplane pods in the chart
+	controlplaneInitContainerName = "init"
+	// restoreFromSnapshotValue is the chart value which causes the first controlplane pod to wait for a snapshot
+	restoreFromSnapshotValue = "controlplane.restoreFromSnapshot"
+)
+
+// snapshotRestoreCmd represents the snapshot restore command
+var snapshotRestoreCmd = &cobra.Command{
+	Use:   "restore NAME",
+	Short: "Create a cluster from a snapshot",
+	Long: `Creates a cluster, and, before it starts, replaces its etcd datastore with the one from the snapshot, and then
+restores the contents of the shared persistence volume, if it was included.
+
+The cluster is created with the same flags and configuration as 'kink create cluster', which should match those used
+to create the original cluster, in particular, the number of controlplane replicas, the distribution, and the token.
+The etcd cluster is reset to a single member before the remaining controlplane nodes join, so the restored cluster does
+not depend on the pod IPs of the original.
+
+Once the snapshot has been restored, the chart is deployed again without the value that enables restoring, which rolls
+the controlplane pods once, so that later 'kink create cluster' or 'kink upgrade cluster' calls do not.
+
+If the cluster already exists, --force must be provided, in which case it is deleted along with its PVCs first.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return restoreSnapshot(context.Background(), args[0], &snapshotRestoreArgs, &snapshotArgs, &resolvedConfig)
+	},
+}
+
+type snapshotRestoreArgsT struct {
+	Create createClusterArgsT `rflag:""`
+	Force  bool               `rflag:"usage=If the cluster already exists,, delete it and its PVCs before restoring"`
+}
+
+func (snapshotRestoreArgsT) Defaults() snapshotRestoreArgsT {
+	return snapshotRestoreArgsT{
+		Create: createClusterArgsT{}.Defaults(),
+	}
+}
+
+var snapshotRestoreArgs = snapshotRestoreArgsT{}.Defaults()
+
+func init() {
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
+	rflag.MustRegister(rflag.ForPFlag(snapshotRestoreCmd.Flags()), "", &snapshotRestoreArgs)
+}
+
+func restoreSnapshot(ctx context.Context, name string, args *snapshotRestoreArgsT, storeArgs *snapshotArgsT, cfg *resolvedConfigT) error {
+	err := validateSnapshotName(name)
+	if err != nil {
+		return err
+	}
+
+	store, err := openSnapshotStore(ctx, storeArgs, cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close(ctx)
+
+	metadata, err := loadSnapshotMetadata(ctx, store, name)
+	if err != nil {
+		return err
+	}
+	if distribution := clusterDistribution(cfg); metadata.Distribution != distribution {
+		return fmt.Errorf("Snapshot %s was taken from a %s cluster, but the cluster would be created with %s", name, metadata.Distribution, distribution)
+	}
+	if metadata.SharedPersistence && !bool(cfg.ReleaseConfig.SharedPersistenceEnabled) {
+		klog.Warningf("Snapshot %s includes shared persistence, but it is not enabled, it will not be restored", name)
+	}
+
+	chartVersion, err := clusterChartVersion(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if chartVersion != "" {
+		if !args.Force {
+			return fmt.Errorf("Cluster %s already exists, use --force to delete and replace it", cfg.KinkConfig.Release.ClusterName)
+		}
+		klog.Infof("Deleting existing cluster %s...", cfg.KinkConfig.Release.ClusterName)
+		err = deleteCluster(ctx, &deleteClusterArgsT{DeletePVCs: true}, cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	restoreCfg := *cfg
+	restoreCfg.KinkConfig.Release.Set = make(map[string]string, len(cfg.KinkConfig.Release.Set)+1)
+	for k, v := range cfg.KinkConfig.Release.Set {
+		restoreCfg.KinkConfig.Release.Set[k] = v
+	}
+	restoreCfg.KinkConfig.Release.Set[restoreFromSnapshotValue] = "true"
+
+	// Helm waits for the controlplane to become ready, which it will not until the snapshot is uploaded,
+	// so the release is deployed while waiting to upload it
+	deployCtx, cancelDeploy := context.WithCancel(ctx)
+	defer cancelDeploy()
+	deployed := make(chan error, 1)
+	go func() {
+		deployed <- deployCluster(deployCtx, &restoreCfg)
+	}()
+	err = uploadEtcdSnapshot(ctx, store, name, &restoreCfg, deployed)
+	if err != nil {
+		cancelDeploy()
+		return err
+	}
+	err = <-deployed
+	if err != nil {
+		return err
+	}
+	klog.Info("Deployed chart, waiting for controlplane to be healthy")
+
+	err = withStreams(kubectlStatefulSetRolloutStatus(ctx, cfg, cfg.ReleaseConfig.ControlplaneFullname), gosh.ForwardOutErr).Run()
+	if err != nil {
+		return err
+	}
+
+	// The restore value changes the controlplane pod template, so if it were left in the release, the next create or
+	// upgrade would roll every controlplane pod. Instead, the controlplane is rolled once now, while nothing else is
+	// happening. etcd has already been reset, so the first pod starts normally from the restored datastore.
+	klog.Info("Snapshot restored, redeploying chart without restore value")
+	err = deployCluster(ctx, cfg)
+	if err != nil {
+		return errors.Wrap(err, "Snapshot was restored, but the chart could not be redeployed without the restore value")
+	}
+	err = withStreams(kubectlStatefulSetRolloutStatus(ctx, cfg, cfg.ReleaseConfig.ControlplaneFullname), gosh.ForwardOutErr).Run()
+	if err != nil {
+		return err
+	}
+
+	if metadata.SharedPersistence && bool(cfg.ReleaseConfig.SharedPersistenceEnabled) {
+		err = restoreSharedPersistence(ctx, store, name, cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.Create.WaitTimeout != 0 {
+		klog.Info("Controlplane is healthy, waiting for nodes and core addons to be ready")
+		err = waitForClusterReady(ctx, args.Create.WaitTimeout, &args.Create.ExportKubeconfigArgs.Common, cfg)
+		if err != nil {
+			return err
+		}
+	}
+	klog.Infof("Cluster restored from snapshot %s", name)
+
+	if args.Create.ExportKubeconfigArgs.KubeconfigToExportPath == "" {
+		return nil
+	}
+	err = exportKubeconfigToPath(ctx, &args.Create.ExportKubeconfigArgs, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to export kubeconfig: %w", err)
+	}
+	return nil
+}
+
+// uploadEtcdSnapshot waits for the init container of the first controlplane pod to start waiting for a snapshot,
+// then uploads it. If the release finishes deploying first, the snapshot cannot be restored, and its error is returned.
+func uploadEtcdSnapshot(ctx context.Context, store snapshotStore, name string, cfg *resolvedConfigT, deployed <-chan error) error {
+	host, err := hostClient(cfg)
+	if err != nil {
+		return err
+	}
+	podName := fmt.Sprintf("%s-0", cfg.ReleaseConfig.ControlplaneFullname)
+	klog.Infof("Waiting for %s to be ready to restore snapshot...", podName)
+	err = wait.PollUntilContextCancel(ctx, snapshotPodPollInterval, true, func(ctx context.Context) (bool, error) {
+		select {
+		case err := <-deployed:
+			if err == nil {
+				err = errors.New("Release was deployed without waiting for a snapshot, this is likely a bug")
+			}
+			return false, err
+		default:
+		}
+		return initContainerRunning(ctx, host, cfg.ReleaseNamespace, podName)
+	})
+	if err != nil {
+		return err
+	}
+
+	klog.Infof("Uploading etcd snapshot to %s...", podName)
+	snapshot, err := store.Reader(ctx, name, snapshotEtcdFile)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+	// The init container stops waiting as soon as the file exists, so it must be complete when it appears
+	script := `mkdir -p "$(dirname "$0")" && cat > "$0.tmp" && mv "$0.tmp" "$0"`
+	dest := path.Join(clusterDataDir(cfg), etcdRestoreFile)
+	return withStreams(
+		kubectlExecInContainer(ctx, cfg, podName, controlplaneInitContainerName, true, false, "sh", "-c", script, dest),
+		gosh.ReaderIn(snapshot),
+		gosh.ForwardOutErr,
+	).Run()
+}
+
+func initContainerRunning(ctx context.Context, host *kubernetes.Clientset, namespace, podName string) (bool, error) {
+	pod, err := host.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.Name != controlplaneInitContainerName {
+			continue
+		}
+		if status.State.Terminated != nil {
+			return false, fmt.Errorf("Init container of %s exited before the snapshot was uploaded", podName)
+		}
+		return status.State.Running != nil, nil
+	}
+	return false, nil
+}
+
+// restoreSharedPersistence extracts the shared persistence archive from a snapshot. Because the volume is shared,
+// only the first controlplane pod is used.
+func restoreSharedPersistence(ctx context.Context, store snapshotStore, name string, cfg *resolvedConfigT) error {
+	podName := fmt.Sprintf("%s-0", cfg.ReleaseConfig.ControlplaneFullname)
+	host, err := hostClient(cfg)
+	if err != nil {
+		return err
+	}
+	pod, err := host.CoreV1().Pods(cfg.ReleaseNamespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get controlplane pod %s", podName)
+	}
+	if pod.Status.Phase != corev1.PodRunning {
+		return fmt.Errorf("Controlplane pod %s is %s, not Running", podName, pod.Status.Phase)
+	}
+
+	klog.Infof("Restoring shared persistence to %s...", podName)
+	archive, err := store.Reader(ctx, name, snapshotSharedFile)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	return withStreams(
+		kubectlExecInContainer(ctx, cfg, podName, pod.Spec.Containers[0].Name, true, false, "tar", "xf", "-", "-C", "/"),
+		gosh.ReaderIn(archive),
+		gosh.ForwardOutErr,
+	).Run()
+}
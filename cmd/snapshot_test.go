@@ -0,0 +1,135 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValidateSnapshotName(t *testing.T) {
+	cases := map[string]bool{
+		"snap":       true,
+		"snap-1.2_3": true,
+		"..snap":     true,
+		"":           false,
+		".":          false,
+		"..":         false,
+		"../snap":    false,
+		"a/b":        false,
+		`a\b`:        false,
+		"/snap":      false,
+	}
+	for name, valid := range cases {
+		err := validateSnapshotName(name)
+		if (err == nil) != valid {
+			t.Errorf("%q: expected valid=%t, got %v", name, valid, err)
+		}
+	}
+}
+
+func testWriteSnapshotFile(t *testing.T, store snapshotStore, snapshot, file, contents string) {
+	t.Helper()
+	w, err := store.Writer(context.Background(), snapshot, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.WriteString(w, contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testListSnapshots(t *testing.T, store snapshotStore) []string {
+	t.Helper()
+	names, err := store.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestLocalSnapshotStore(t *testing.T) {
+	ctx := context.Background()
+	store := &localSnapshotStore{dir: filepath.Join(t.TempDir(), "snapshots")}
+
+	if names := testListSnapshots(t, store); len(names) != 0 {
+		t.Errorf("expected no snapshots in a missing directory, got %v", names)
+	}
+
+	// Snapshots without metadata are incomplete, and are not listed
+	testWriteSnapshotFile(t, store, "incomplete", snapshotEtcdFile, "etcd")
+	testWriteSnapshotFile(t, store, "b", snapshotEtcdFile, "etcd-b")
+	testWriteSnapshotFile(t, store, "b", snapshotMetadataFile, "{}")
+	testWriteSnapshotFile(t, store, "a", snapshotMetadataFile, "{}")
+	err := os.WriteFile(filepath.Join(store.dir, "not-a-snapshot"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names, expected := testListSnapshots(t, store), []string{"a", "b"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected snapshots %v, got %v", expected, names)
+	}
+
+	r, err := store.Reader(ctx, "b", snapshotEtcdFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "etcd-b" {
+		t.Errorf("expected etcd-b, got %q", contents)
+	}
+	_, err = store.Reader(ctx, "b", snapshotSharedFile)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected a missing file to not exist, got %v", err)
+	}
+
+	err = store.Remove(ctx, "b", snapshotMetadataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Remove(ctx, "b", snapshotMetadataFile)
+	if err != nil {
+		t.Errorf("removing a missing file should succeed, got %v", err)
+	}
+	if names, expected := testListSnapshots(t, store), []string{"a"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected snapshots %v, got %v", expected, names)
+	}
+}
+
+func TestRemoveSnapshotFiles(t *testing.T) {
+	ctx := context.Background()
+	store := &localSnapshotStore{dir: t.TempDir()}
+	testWriteSnapshotFile(t, store, "snap", snapshotEtcdFile, "etcd")
+	testWriteSnapshotFile(t, store, "snap", snapshotSharedFile, "shared")
+	testWriteSnapshotFile(t, store, "snap", snapshotMetadataFile, "{}")
+
+	err := removeSnapshotFiles(ctx, store, "snap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := testListSnapshots(t, store); len(names) != 0 {
+		t.Errorf("expected the overwritten snapshot to not be listed, got %v", names)
+	}
+	checkEmptyDir(t, filepath.Join(store.dir, "snap"))
+
+	// Snapshots which were never completed can also be overwritten
+	testWriteSnapshotFile(t, store, "partial", snapshotEtcdFile, "etcd")
+	err = removeSnapshotFiles(ctx, store, "partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEmptyDir(t, filepath.Join(store.dir, "partial"))
+}
@@ -0,0 +1,216 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/meln5674/gosh"
+	"github.com/meln5674/rflag"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+
+	cfg "github.com/meln5674/kink/pkg/config"
+)
+
+// upCmd represents the up command
+var upCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Create, load, and export the kubeconfigs of all clusters in an environment file",
+	Long: `An environment file has the same fields as a configuration file, with the Kind "Environment", and an
+additional list of clusters. The shared fields are used for every cluster, and each cluster can override the release
+section, as well as list images and archives to load once it is ready, and a path to export its kubeconfig to.
+
+Clusters are created in parallel, and an error creating one cluster does not stop the others. All errors are reported
+once every cluster has finished.`,
+	SilenceUsage:      true,
+	PersistentPreRunE: loadEnvironment,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return environmentUp(context.Background(), &upArgs, &resolvedEnvironment)
+	},
+}
+
+type environmentArgsT struct {
+	EnvironmentPath  string `rflag:"name=environment,shorthand=f,usage=Path to environment file"`
+	ParallelClusters int    `rflag:"usage=How many clusters to create or delete at once. Zero or less means all at once"`
+}
+
+func (environmentArgsT) Defaults() environmentArgsT {
+	return environmentArgsT{
+		ParallelClusters: 0,
+	}
+}
+
+// environmentArgs are shared by up and down
+var environmentArgs = environmentArgsT{}.Defaults()
+
+type upArgsT struct {
+	WaitTimeout   time.Duration `rflag:"usage=How long to wait for the nodes and core addons of each cluster to become ready. Set to zero to not wait"`
+	SkipPreflight bool          `rflag:"usage=Do not run the checks of 'kink doctor' before deploying each cluster"`
+	Load          loadArgsT     `rflag:""`
+}
+
+func (upArgsT) Defaults() upArgsT {
+	return upArgsT{
+		WaitTimeout: createClusterArgsT{}.Defaults().WaitTimeout,
+		Load:        loadArgsT{}.Defaults(),
+	}
+}
+
+var upArgs = upArgsT{}.Defaults()
+
+type resolvedEnvironmentT struct {
+	Environment cfg.RawEnvironment
+	// Base is the configuration shared by all clusters
+	Base resolvedConfigT
+}
+
+var resolvedEnvironment resolvedEnvironmentT
+
+func init() {
+	rootCmd.AddCommand(upCmd)
+	rflag.MustRegister(rflag.ForPFlag(upCmd.Flags()), "", &environmentArgs)
+	rflag.MustRegister(rflag.ForPFlag(upCmd.Flags()), "", &upArgs)
+}
+
+// loadEnvironment replaces the root pre-run, as there is no single cluster to resolve the configuration of
+func loadEnvironment(*cobra.Command, []string) error {
+	gosh.GlobalLog = klog.Background()
+
+	path := environmentArgs.EnvironmentPath
+	if path == "" {
+		return errors.New("--environment is required")
+	}
+	err := resolvedEnvironment.Environment.LoadFromFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "Environment file %s is invalid or missing", path)
+	}
+	base, err := resolveBaseConfig(resolvedEnvironment.Environment.Format(), &kinkArgs)
+	if err != nil {
+		return err
+	}
+	resolvedEnvironment.Base = *base
+	return nil
+}
+
+// clusterConfig produces the configuration that would be used if the shared configuration was used with a kink command
+// to manage one of the clusters in the environment
+func (e *resolvedEnvironmentT) clusterConfig(ix int) *resolvedConfigT {
+	cluster := &e.Environment.Clusters[ix]
+	resolved := e.Base
+	resolved.KinkConfig.Release = cluster.ClusterRelease(&e.Base.KinkConfig.Release)
+	if cluster.Namespace != "" {
+		resolved.KinkConfig.Kubernetes.ConfigOverrides.Context.Namespace = cluster.Namespace
+		resolved.ReleaseNamespace = cluster.Namespace
+	}
+	resolved.ReleaseConfig = cfg.ReleaseConfig{}
+	return &resolved
+}
+
+// portForwardArgs returns the local ports for a cluster, which are offset from the defaults by its position if
+// not set, so that they do not conflict
+func (e *resolvedEnvironmentT) portForwardArgs(ix int) portForwardArgsT {
+	cluster := &e.Environment.Clusters[ix]
+	args := portForwardArgsT{}.Defaults()
+	args.ControlplanePort += ix
+	args.FileGatewayPort += ix
+	if cluster.PortForward.ControlplanePort != 0 {
+		args.ControlplanePort = cluster.PortForward.ControlplanePort
+	}
+	if cluster.PortForward.FileGatewayPort != 0 {
+		args.FileGatewayPort = cluster.PortForward.FileGatewayPort
+	}
+	return args
+}
+
+// forEachCluster calls f for every cluster in the environment, with at most parallelism calls at once,
+// and returns all errors that occurred
+func (e *resolvedEnvironmentT) forEachCluster(parallelism int, f func(ix int, cluster *cfg.EnvironmentCluster) error) error {
+	if parallelism <= 0 {
+		parallelism = len(e.Environment.Clusters)
+	}
+	sem := make(chan struct{}, parallelism)
+	errs := make([]error, len(e.Environment.Clusters))
+	var wg sync.WaitGroup
+	for ix := range e.Environment.Clusters {
+		wg.Add(1)
+		go func(ix int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			cluster := &e.Environment.Clusters[ix]
+			err := f(ix, cluster)
+			if err != nil {
+				klog.Errorf("Cluster %s failed: %s", cluster.Name, err)
+				errs[ix] = errors.Wrapf(err, "cluster %s", cluster.Name)
+			}
+		}(ix)
+	}
+	wg.Wait()
+	return utilerrors.NewAggregate(errs)
+}
+
+func environmentUp(ctx context.Context, args *upArgsT, env *resolvedEnvironmentT) error {
+	base := &env.Base
+	if !base.KinkConfig.Chart.IsLocalChart() && !base.KinkConfig.Chart.IsOCIChart() && !base.KinkConfig.Helm.Native {
+		// Updating the repo once up front avoids every cluster updating it at the same time
+		klog.Info("Ensuring helm repo exists...")
+		err := withStreams(helmRepoAdd(ctx, base), gosh.ForwardOutErr).Run()
+		if err != nil {
+			return err
+		}
+		if kinkArgs.DoRepoUpdate {
+			klog.Info("Updating chart repo...")
+			err = withStreams(helmRepoUpdate(ctx, base), gosh.ForwardOutErr).Run()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return env.forEachCluster(environmentArgs.ParallelClusters, func(ix int, cluster *cfg.EnvironmentCluster) error {
+		clusterCfg := env.clusterConfig(ix)
+		klog.Infof("Resolving configuration for cluster %s...", cluster.Name)
+		err := resolveReleaseConfig(ctx, clusterCfg, false)
+		if err != nil {
+			return err
+		}
+
+		createArgs := createClusterArgsT{}.Defaults()
+		createArgs.WaitTimeout = args.WaitTimeout
+		createArgs.SkipPreflight = args.SkipPreflight
+		createArgs.ExportKubeconfigArgs.Common.PortForward = env.portForwardArgs(ix)
+		createArgs.ExportKubeconfigArgs.KubeconfigToExportPath = cluster.Kubeconfig
+		klog.Infof("Creating cluster %s...", cluster.Name)
+		err = createCluster(ctx, &createArgs, clusterCfg)
+		if err != nil {
+			return err
+		}
+
+		if len(cluster.Load.DockerImages) != 0 {
+			klog.Infof("Loading docker images into cluster %s...", cluster.Name)
+			err = loadImages(ctx, &args.Load, clusterCfg, cluster.Load.DockerImages...)
+			if err != nil {
+				return errors.Wrap(err, "Failed to load docker images")
+			}
+		}
+		archives := make([]string, 0, len(cluster.Load.DockerArchives)+len(cluster.Load.OCIArchives))
+		archives = append(archives, cluster.Load.DockerArchives...)
+		archives = append(archives, cluster.Load.OCIArchives...)
+		if len(archives) != 0 {
+			klog.Infof("Loading archives into cluster %s...", cluster.Name)
+			err = loadArchives(ctx, &args.Load, clusterCfg, archives...)
+			if err != nil {
+				return errors.Wrap(err, "Failed to load archives")
+			}
+		}
+
+		klog.Infof("Cluster %s is up", cluster.Name)
+		return nil
+	})
+}
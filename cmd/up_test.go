@@ -0,0 +1,135 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cfg "github.com/meln5674/kink/pkg/config"
+)
+
+func testEnvironment(names ...string) *resolvedEnvironmentT {
+	env := &resolvedEnvironmentT{}
+	for _, name := range names {
+		env.Environment.Clusters = append(env.Environment.Clusters, cfg.EnvironmentCluster{Name: name})
+	}
+	return env
+}
+
+func TestForEachCluster(t *testing.T) {
+	cases := []struct {
+		name        string
+		parallelism int
+		maxRunning  int32
+	}{
+		{name: "limited", parallelism: 2, maxRunning: 2},
+		{name: "serial", parallelism: 1, maxRunning: 1},
+		{name: "unlimited", parallelism: 0, maxRunning: 5},
+	}
+	for _, c := range cases {
+		env := testEnvironment("a", "b", "c", "d", "e")
+		var running, maxRunning atomic.Int32
+		var lock sync.Mutex
+		called := make(map[string]int)
+		err := env.forEachCluster(c.parallelism, func(ix int, cluster *cfg.EnvironmentCluster) error {
+			now := running.Add(1)
+			defer running.Add(-1)
+			for {
+				max := maxRunning.Load()
+				if now <= max || maxRunning.CompareAndSwap(max, now) {
+					break
+				}
+			}
+			// Give the other calls a chance to start, so that the limit is exercised
+			time.Sleep(20 * time.Millisecond)
+			lock.Lock()
+			defer lock.Unlock()
+			called[cluster.Name] = ix
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if len(called) != 5 || called["a"] != 0 || called["e"] != 4 {
+			t.Errorf("%s: expected each cluster to be called once with its index, got %v", c.name, called)
+		}
+		if max := maxRunning.Load(); max > c.maxRunning {
+			t.Errorf("%s: expected at most %d calls at once, got %d", c.name, c.maxRunning, max)
+		}
+	}
+}
+
+func TestForEachClusterErrors(t *testing.T) {
+	env := testEnvironment("a", "b", "c")
+	var calls atomic.Int32
+	err := env.forEachCluster(1, func(ix int, cluster *cfg.EnvironmentCluster) error {
+		calls.Add(1)
+		if cluster.Name == "b" {
+			return nil
+		}
+		return errors.New("failed to deploy")
+	})
+	// A failure must not stop the remaining clusters
+	if calls.Load() != 3 {
+		t.Errorf("expected all 3 clusters to be called, got %d", calls.Load())
+	}
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, expected := range []string{"cluster a: failed to deploy", "cluster c: failed to deploy"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, got %v", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), "cluster b") {
+		t.Errorf("expected no error for cluster b, got %v", err)
+	}
+}
+
+func TestEnvironmentClusterConfig(t *testing.T) {
+	env := testEnvironment("a", "b")
+	env.Environment.Clusters[1].Namespace = "other"
+	env.Base.ReleaseNamespace = "default"
+	env.Base.KinkConfig.Release.Set = map[string]string{"shared": "true"}
+	env.Base.ReleaseConfig.ControlplaneFullname = "stale"
+
+	for ix, namespace := range []string{"default", "other"} {
+		resolved := env.clusterConfig(ix)
+		name := env.Environment.Clusters[ix].Name
+		if resolved.KinkConfig.Release.ClusterName != name || resolved.KinkConfig.Release.Set["shared"] != "true" {
+			t.Errorf("%s: expected the cluster name and shared values, got %#v", name, resolved.KinkConfig.Release)
+		}
+		if resolved.ReleaseNamespace != namespace {
+			t.Errorf("%s: expected namespace %s, got %s", name, namespace, resolved.ReleaseNamespace)
+		}
+		if resolved.ReleaseConfig.ControlplaneFullname != "" {
+			t.Errorf("%s: expected the release config to be reset", name)
+		}
+	}
+	if env.Base.ReleaseNamespace != "default" || env.Base.KinkConfig.Kubernetes.ConfigOverrides.Context.Namespace != "" {
+		t.Error("the shared configuration was modified")
+	}
+}
+
+func TestEnvironmentPortForwardArgs(t *testing.T) {
+	env := testEnvironment("a", "b", "c")
+	env.Environment.Clusters[2].PortForward.ControlplanePort = 7443
+	defaults := portForwardArgsT{}.Defaults()
+	expected := [][2]int{
+		{defaults.ControlplanePort, defaults.FileGatewayPort},
+		{defaults.ControlplanePort + 1, defaults.FileGatewayPort + 1},
+		{7443, defaults.FileGatewayPort + 2},
+	}
+	for ix := range env.Environment.Clusters {
+		args := env.portForwardArgs(ix)
+		if actual := [2]int{args.ControlplanePort, args.FileGatewayPort}; actual != expected[ix] {
+			t.Errorf("cluster %d: expected ports %v, got %v", ix, expected[ix], actual)
+		}
+	}
+}
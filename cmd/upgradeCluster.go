@@ -0,0 +1,465 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/meln5674/gosh"
+	"github.com/meln5674/rflag"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"github.com/meln5674/kink/pkg/kubectl"
+)
+
+const (
+	upgradePausedAnnotation = "kink.meln5674.github.com/upgrade-paused"
+	upgradePollInterval     = 5 * time.Second
+)
+
+var (
+	errUpgradePaused = errors.New("Upgrade paused")
+)
+
+// upgradeClusterCmd represents the upgrade cluster command
+var upgradeClusterCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "Upgrade a cluster one node at a time",
+	Long: `Upgrade the release of a cluster, the same as 'kink create cluster' does, but replace its node pods one at a
+time, checking that the cluster is healthy after each one, instead of letting the host cluster replace them on its own.
+
+Before the release is upgraded, the rolling update partition of each statefulset is set so that the upgrade itself
+does not replace any pods. Then, the controlplane pods are replaced one at a time, from the highest ordinal to the
+lowest. After each one, this waits until every node is ready, and, if the cluster uses etcd, until every etcd member
+is healthy and there is a voting member for each controlplane pod, so that etcd never loses quorum. Then, the workers
+are replaced the same way, except that each worker node is cordoned and drained first, and uncordoned once it is
+healthy again.
+
+If the cluster does not become healthy within --pod-timeout, either after replacing a pod, or before replacing the
+next one, the upgrade is aborted, and the remaining pods are left on the previous version. Once the problem is fixed,
+run this again with --resume to continue replacing the remaining pods without upgrading the release again.
+
+To pause an upgrade in progress, run this again with --pause. The upgrade stops once the pod it is replacing is
+healthy. Interrupting the upgrade also leaves the remaining pods on the previous version. Either way, run this again
+with --resume to continue.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return upgradeCluster(context.Background(), &upgradeClusterArgs, &resolvedConfig)
+	},
+}
+
+type upgradeClusterArgsT struct {
+	ExecArgs     execArgsT     `rflag:""`
+	PodTimeout   time.Duration `rflag:"usage=How long to wait for the cluster to become healthy after replacing each pod,, or before replacing the next one,, before aborting"`
+	DrainTimeout time.Duration `rflag:"usage=How long to wait for each worker node to drain before aborting. Set to zero to wait forever"`
+	Resume       bool          `rflag:"usage=Continue a paused or aborted upgrade by replacing the remaining pods,, without upgrading the release again"`
+	Pause        bool          `rflag:"usage=Pause an upgrade in progress once the pod it is replacing is healthy,, then exit"`
+	Force        bool          `rflag:"usage=Start the upgrade even if the cluster is not healthy beforehand"`
+}
+
+func (upgradeClusterArgsT) Defaults() upgradeClusterArgsT {
+	return upgradeClusterArgsT{
+		ExecArgs:     execArgsT{}.Defaults(),
+		PodTimeout:   10 * time.Minute,
+		DrainTimeout: 5 * time.Minute,
+	}
+}
+
+var upgradeClusterArgs = upgradeClusterArgsT{}.Defaults()
+
+func init() {
+	upgradeCmd.AddCommand(upgradeClusterCmd)
+	rflag.MustRegister(rflag.ForPFlag(upgradeClusterCmd.Flags()), "", &upgradeClusterArgs)
+}
+
+// clusterUpgrader replaces the node pods of a cluster one at a time
+type clusterUpgrader struct {
+	args  *upgradeClusterArgsT
+	cfg   *resolvedConfigT
+	host  kubernetes.Interface
+	guest kubernetes.Interface
+	// usesEtcd is true if the controlplane uses etcd, as opposed to k3s's sqlite datastore
+	usesEtcd bool
+}
+
+func upgradeCluster(ctx context.Context, args *upgradeClusterArgsT, cfg *resolvedConfigT) error {
+	host, err := hostClient(cfg)
+	if err != nil {
+		return err
+	}
+	if args.Pause {
+		err = setUpgradePaused(ctx, host, cfg, true)
+		if err != nil {
+			return err
+		}
+		klog.Info("The upgrade will pause once the pod it is replacing is healthy")
+		return nil
+	}
+
+	controlplane, err := host.AppsV1().StatefulSets(cfg.ReleaseNamespace).Get(ctx, cfg.ReleaseConfig.ControlplaneFullname, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return fmt.Errorf("Cluster %s does not exist, use 'kink create cluster' to create it", cfg.KinkConfig.Release.ClusterName)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Failed to get controlplane statefulset")
+	}
+
+	guest, stop, err := guestClient(ctx, &args.ExecArgs, cfg)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	u := &clusterUpgrader{
+		args:     args,
+		cfg:      cfg,
+		host:     host,
+		guest:    guest,
+		usesEtcd: bool(cfg.ReleaseConfig.RKE2Enabled) || statefulSetReplicas(controlplane) > 1,
+	}
+
+	err = setUpgradePaused(ctx, host, cfg, false)
+	if err != nil {
+		return err
+	}
+	if args.Resume {
+		klog.Info("Resuming upgrade")
+	} else {
+		healthy, progress, err := u.checkHealthy(ctx)
+		if err != nil {
+			return errors.Wrap(err, "Failed to check cluster health")
+		}
+		if !healthy && !args.Force {
+			return fmt.Errorf("Cluster is not healthy, refusing to upgrade: %s. Use --force to upgrade anyways", progress)
+		}
+		if !healthy {
+			klog.Warningf("Cluster is not healthy, upgrading anyways: %s", progress)
+		}
+
+		for _, name := range []string{cfg.ReleaseConfig.ControlplaneFullname, cfg.ReleaseConfig.WorkerFullname} {
+			err = u.holdStatefulSet(ctx, name)
+			if err != nil {
+				return err
+			}
+		}
+		err = deployCluster(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("Failed to upgrade release, no pods have been replaced: %w", err)
+		}
+		klog.Info("Upgraded release, replacing controlplane pods")
+	}
+
+	for _, step := range []struct {
+		name  string
+		drain bool
+	}{
+		{name: cfg.ReleaseConfig.ControlplaneFullname},
+		{name: cfg.ReleaseConfig.WorkerFullname, drain: true},
+	} {
+		err = u.rollStatefulSet(ctx, step.name, step.drain)
+		if errors.Is(err, errUpgradePaused) {
+			klog.Info("Upgrade paused, use --resume to continue")
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Upgrade aborted, the remaining pods have not been replaced. Once the problem is fixed, use --resume to continue: %w", err)
+		}
+	}
+	klog.Info("All pods have been replaced, the upgrade is complete")
+	return nil
+}
+
+// setUpgradePaused sets or removes the annotation on the controlplane statefulset which tells an upgrade in progress
+// to stop before replacing its next pod
+func setUpgradePaused(ctx context.Context, host kubernetes.Interface, cfg *resolvedConfigT, paused bool) error {
+	var value interface{}
+	if paused {
+		value = "true"
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{upgradePausedAnnotation: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = host.AppsV1().StatefulSets(cfg.ReleaseNamespace).Patch(ctx, cfg.ReleaseConfig.ControlplaneFullname, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return errors.Wrap(err, "Failed to annotate controlplane statefulset")
+	}
+	return nil
+}
+
+// holdStatefulSet sets the partition of a statefulset so that none of its existing pods are replaced when its
+// template changes
+func (u *clusterUpgrader) holdStatefulSet(ctx context.Context, name string) error {
+	sts, err := u.host.AppsV1().StatefulSets(u.cfg.ReleaseNamespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get statefulset %s", name)
+	}
+	return u.setPartition(ctx, name, statefulSetReplicas(sts))
+}
+
+func (u *clusterUpgrader) setPartition(ctx context.Context, name string, partition int32) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"updateStrategy": map[string]interface{}{
+				"type": appsv1.RollingUpdateStatefulSetStrategyType,
+				"rollingUpdate": map[string]interface{}{
+					"partition": partition,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = u.host.AppsV1().StatefulSets(u.cfg.ReleaseNamespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to set partition of statefulset %s to %d", name, partition)
+	}
+	return nil
+}
+
+// rollStatefulSet replaces the pods of a statefulset one at a time, from the highest ordinal to the lowest, by
+// lowering its partition, and waits for the cluster to be healthy before and after each one
+func (u *clusterUpgrader) rollStatefulSet(ctx context.Context, name string, drainNodes bool) error {
+	for {
+		sts, err := u.host.AppsV1().StatefulSets(u.cfg.ReleaseNamespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "Failed to get statefulset %s", name)
+		}
+		// This is checked on the controlplane for both statefulsets, so there is only one place to pause
+		if name != u.cfg.ReleaseConfig.ControlplaneFullname {
+			controlplane, err := u.host.AppsV1().StatefulSets(u.cfg.ReleaseNamespace).Get(ctx, u.cfg.ReleaseConfig.ControlplaneFullname, metav1.GetOptions{})
+			if err != nil {
+				return errors.Wrap(err, "Failed to get controlplane statefulset")
+			}
+			if controlplane.Annotations[upgradePausedAnnotation] == "true" {
+				return errUpgradePaused
+			}
+		} else if sts.Annotations[upgradePausedAnnotation] == "true" {
+			return errUpgradePaused
+		}
+
+		partition := int32(0)
+		if sts.Spec.UpdateStrategy.RollingUpdate != nil && sts.Spec.UpdateStrategy.RollingUpdate.Partition != nil {
+			partition = *sts.Spec.UpdateStrategy.RollingUpdate.Partition
+		}
+		if replicas := statefulSetReplicas(sts); partition > replicas {
+			partition = replicas
+		}
+		if partition == 0 {
+			break
+		}
+
+		ordinal := partition - 1
+		podName := fmt.Sprintf("%s-%d", name, ordinal)
+		pod, err := u.host.CoreV1().Pods(u.cfg.ReleaseNamespace).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return errors.Wrapf(err, "Failed to get pod %s", podName)
+		}
+		updated := err == nil && pod.Labels[appsv1.ControllerRevisionHashLabelKey] == sts.Status.UpdateRevision
+		if updated {
+			klog.Infof("Pod %s is already up to date", podName)
+			// If an upgrade was aborted after replacing this pod, its node was left cordoned
+			if drainNodes && u.args.Resume {
+				err = uncordonGuestNode(ctx, u.guest, podName)
+				if err != nil {
+					return err
+				}
+			}
+			err = u.setPartition(ctx, name, ordinal)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = u.waitHealthy(ctx, fmt.Sprintf("before replacing %s", podName))
+		if err != nil {
+			return err
+		}
+		if drainNodes {
+			err = drainGuestNode(ctx, u.guest, podName, u.args.DrainTimeout)
+			if err != nil {
+				return err
+			}
+		}
+		klog.Infof("Replacing pod %s", podName)
+		err = u.setPartition(ctx, name, ordinal)
+		if err != nil {
+			return err
+		}
+		err = u.waitPodUpdated(ctx, name, podName)
+		if err != nil {
+			return err
+		}
+		err = u.waitHealthy(ctx, fmt.Sprintf("after replacing %s", podName))
+		if err != nil {
+			return err
+		}
+		if drainNodes {
+			err = uncordonGuestNode(ctx, u.guest, podName)
+			if err != nil {
+				return err
+			}
+		}
+		klog.Infof("Replaced pod %s", podName)
+	}
+
+	// Pods at or above the partition when the release was upgraded, i.e. new replicas, are replaced by the statefulset
+	// controller without us, so the rollout as a whole still needs to finish
+	return withStreams(kubectlStatefulSetRolloutStatus(ctx, u.cfg, name), gosh.ForwardOutErr).Run()
+}
+
+// waitPodUpdated waits for the pod of a statefulset to be recreated from its latest revision and become ready
+func (u *clusterUpgrader) waitPodUpdated(ctx context.Context, stsName, podName string) error {
+	ctx, cancel := context.WithTimeout(ctx, u.args.PodTimeout)
+	defer cancel()
+	err := wait.PollUntilContextCancel(ctx, upgradePollInterval, true, func(ctx context.Context) (bool, error) {
+		sts, err := u.host.AppsV1().StatefulSets(u.cfg.ReleaseNamespace).Get(ctx, stsName, metav1.GetOptions{})
+		if err != nil {
+			klog.Warningf("Failed to get statefulset %s: %s", stsName, err)
+			return false, nil
+		}
+		pod, err := u.host.CoreV1().Pods(u.cfg.ReleaseNamespace).Get(ctx, podName, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			klog.Infof("Waiting for pod %s to be recreated", podName)
+			return false, nil
+		}
+		if err != nil {
+			klog.Warningf("Failed to get pod %s: %s", podName, err)
+			return false, nil
+		}
+		if pod.Labels[appsv1.ControllerRevisionHashLabelKey] != sts.Status.UpdateRevision {
+			klog.Infof("Waiting for pod %s to be replaced", podName)
+			return false, nil
+		}
+		if !kubectl.IsPodReady(pod) {
+			klog.Infof("Waiting for pod %s to be ready", podName)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Pod %s was not replaced and ready within %s", podName, u.args.PodTimeout)
+	}
+	return nil
+}
+
+// waitHealthy waits for the cluster to be healthy, and returns an error describing what is not if it is not within
+// the pod timeout
+func (u *clusterUpgrader) waitHealthy(ctx context.Context, when string) error {
+	ctx, cancel := context.WithTimeout(ctx, u.args.PodTimeout)
+	defer cancel()
+	lastProgress := ""
+	err := wait.PollUntilContextCancel(ctx, upgradePollInterval, true, func(ctx context.Context) (bool, error) {
+		healthy, progress, err := u.checkHealthy(ctx)
+		if err != nil {
+			// The API server may be unavailable while a controlplane pod restarts, so errors are only logged
+			klog.Warningf("Failed to check cluster health: %s", err)
+			lastProgress = err.Error()
+			return false, nil
+		}
+		lastProgress = progress
+		if !healthy {
+			klog.Infof("Waiting for cluster to be healthy %s: %s", when, progress)
+		}
+		return healthy, nil
+	})
+	if err != nil {
+		return fmt.Errorf("Cluster was not healthy %s within %s: %s", when, u.args.PodTimeout, lastProgress)
+	}
+	return nil
+}
+
+// checkHealthy checks that every node is ready, and that every etcd member is healthy, if etcd is used
+func (u *clusterUpgrader) checkHealthy(ctx context.Context) (bool, string, error) {
+	workers, err := u.host.AppsV1().StatefulSets(u.cfg.ReleaseNamespace).Get(ctx, u.cfg.ReleaseConfig.WorkerFullname, metav1.GetOptions{})
+	if err != nil {
+		return false, "", errors.Wrap(err, "Failed to get worker statefulset")
+	}
+	ready, progress, err := checkNodesReady(ctx, u.guest, statefulSetReplicas(workers))
+	if err != nil {
+		return false, "", err
+	}
+	if !u.usesEtcd {
+		return ready, progress, nil
+	}
+	etcdReady, etcdProgress, err := u.checkEtcdHealthy(ctx)
+	if err != nil {
+		return false, "", err
+	}
+	return ready && etcdReady, progress + ", " + etcdProgress, nil
+}
+
+// checkEtcdHealthy checks that every etcd member is healthy, and that there is a voting member for each controlplane
+// pod, from within a ready controlplane pod
+func (u *clusterUpgrader) checkEtcdHealthy(ctx context.Context) (bool, string, error) {
+	controlplane, err := u.host.AppsV1().StatefulSets(u.cfg.ReleaseNamespace).Get(ctx, u.cfg.ReleaseConfig.ControlplaneFullname, metav1.GetOptions{})
+	if err != nil {
+		return false, "", errors.Wrap(err, "Failed to get controlplane statefulset")
+	}
+	pods, err := kubectlGetPods(ctx, u.cfg, u.cfg.ReleaseConfig.ControlplaneSelectorLabels)
+	if err != nil {
+		return false, "", errors.Wrap(err, "Failed to list controlplane pods")
+	}
+	var pod *corev1.Pod
+	for ix := range pods.Items {
+		if kubectl.IsPodReady(&pods.Items[ix]) {
+			pod = &pods.Items[ix]
+			break
+		}
+	}
+	if pod == nil {
+		return false, "no controlplane pods are ready to check etcd from", nil
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = withStreams(kubectlEtcdStatus(ctx, u.cfg, pod), gosh.WriterOut(&stdout), gosh.WriterErr(&stderr)).Run()
+	if err != nil {
+		return false, "", fmt.Errorf("Failed to get etcd status from %s: %w: %s", pod.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	var status EtcdStatus
+	err = yaml.Unmarshal(stdout.Bytes(), &status)
+	if err != nil {
+		return false, "", errors.Wrap(err, "Invalid etcd status")
+	}
+
+	healthy, voting := 0, 0
+	unhealthy := make([]string, 0)
+	for _, member := range status.Members {
+		if !member.IsLearner {
+			voting++
+		}
+		if member.Healthy {
+			healthy++
+		} else {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s (%s)", member.Name, member.Error))
+		}
+	}
+	expected := int(statefulSetReplicas(controlplane))
+	progress := fmt.Sprintf("%d of %d etcd members are healthy, %d of %d voting members", healthy, len(status.Members), voting, expected)
+	if len(unhealthy) != 0 {
+		progress += fmt.Sprintf(", unhealthy: %s", strings.Join(unhealthy, ", "))
+	}
+	return healthy == len(status.Members) && voting == expected, progress, nil
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	cfg "github.com/meln5674/kink/pkg/config"
+)
+
+func testNode(name string, ready corev1.ConditionStatus, unschedulable bool, labels map[string]string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Spec:       corev1.NodeSpec{Unschedulable: unschedulable},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+		},
+	}
+}
+
+func TestCheckNodesReady(t *testing.T) {
+	controlplane := map[string]string{controlplaneRoleLabel: "true"}
+	cases := []struct {
+		name     string
+		nodes    []*corev1.Node
+		workers  int32
+		expected bool
+	}{
+		{
+			name: "all ready",
+			nodes: []*corev1.Node{
+				testNode("cp-0", corev1.ConditionTrue, false, controlplane),
+				testNode("w-0", corev1.ConditionTrue, false, nil),
+			},
+			workers:  1,
+			expected: true,
+		},
+		{
+			name: "cordoned worker is ready",
+			nodes: []*corev1.Node{
+				testNode("cp-0", corev1.ConditionTrue, false, controlplane),
+				testNode("w-0", corev1.ConditionTrue, true, nil),
+			},
+			workers:  1,
+			expected: true,
+		},
+		{
+			name: "cordoned NotReady worker",
+			nodes: []*corev1.Node{
+				testNode("cp-0", corev1.ConditionTrue, false, controlplane),
+				testNode("w-0", corev1.ConditionUnknown, true, nil),
+			},
+			workers:  1,
+			expected: false,
+		},
+		{
+			name: "missing worker",
+			nodes: []*corev1.Node{
+				testNode("cp-0", corev1.ConditionTrue, false, controlplane),
+			},
+			workers:  1,
+			expected: false,
+		},
+	}
+	for _, c := range cases {
+		guest := fake.NewSimpleClientset()
+		for _, node := range c.nodes {
+			_, err := guest.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		ready, progress, err := checkNodesReady(context.Background(), guest, c.workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ready != c.expected {
+			t.Errorf("%s: expected ready=%v, got %v (%s)", c.name, c.expected, ready, progress)
+		}
+	}
+}
+
+func TestUpgradeCheckHealthyCordonedWorker(t *testing.T) {
+	replicas := int32(1)
+	host := fake.NewSimpleClientset(&appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kink-test-worker", Namespace: "default"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	})
+	// This is the state of a worker after it has been drained and replaced, but before it is uncordoned
+	guest := fake.NewSimpleClientset(
+		testNode("kink-test-controlplane-0", corev1.ConditionTrue, false, map[string]string{controlplaneRoleLabel: "true"}),
+		testNode("kink-test-worker-0", corev1.ConditionTrue, true, nil),
+	)
+	u := &clusterUpgrader{
+		cfg: &resolvedConfigT{
+			ReleaseNamespace: "default",
+			ReleaseConfig:    cfg.ReleaseConfig{WorkerFullname: "kink-test-worker"},
+		},
+		host:  host,
+		guest: guest,
+	}
+	healthy, progress, err := u.checkHealthy(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !healthy {
+		t.Errorf("expected cluster with a cordoned, ready worker to be healthy, got %s", progress)
+	}
+}
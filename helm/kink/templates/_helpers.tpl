@@ -147,6 +147,11 @@ app.kubernetes.io/component: lb-manager
 {{- end }}
 {{- end -}}
 
+{{- define "kink.kubeconfig.selectorLabels" -}}
+{{ include "kink.selectorLabels" . }}
+app.kubernetes.io/component: kubeconfig
+{{- end -}}
+
 
 {{/*
 Create the name of the service account to use
@@ -302,6 +307,11 @@ https://{{ include "kink.controlplane.fullname" . }}:{{ .Values.controlplane.ser
 - --extra-manifests-path={{ $dataDir }}/server/manifests/
 - --local-path-provisioner-chart-path=/etc/kink/extra-charts/local-path-provisioner-0.0.25-dev.tgz
 - --local-path-provisioner-manifest-path={{ $dataDir }}/server/manifests/kink-local-storage.yaml
+{{- if $dot.Values.controlplane.restoreFromSnapshot }}
+- --etcd-restore-path={{ $dataDir }}/server/db/kink-restore.db
+- --etcd-restore-pod-name={{ include "kink.controlplane.fullname" $dot }}-0
+- --pod-name=$(POD_NAME)
+{{- end }}
 {{- end }}
 
 {{- define "kink.initArgsAll" -}}
@@ -80,6 +80,12 @@ func (c *Config) Override(c2 *Config) {
 }
 
 func (c *RawConfig) LoadFromFile(path string) error {
+	return loadFromFile(path, c, &c.TypeMeta, Kind)
+}
+
+// loadFromFile parses a YAML or JSON document into obj, and validates that typeMeta, which must be part of obj,
+// has a supported APIVersion and the expected Kind
+func loadFromFile(path string, obj interface{}, typeMeta *metav1.TypeMeta, kind string) error {
 	f, err := os.Open(path)
 	if err != nil {
 		return err
@@ -89,22 +95,22 @@ func (c *RawConfig) LoadFromFile(path string) error {
 	if err != nil {
 		return err
 	}
-	err = yaml.Unmarshal(bytes, c)
+	err = yaml.Unmarshal(bytes, obj)
 	if err != nil {
 		return err
 	}
 	validAPIVersion := false
 	for _, version := range APIVersions {
-		if c.APIVersion == version {
+		if typeMeta.APIVersion == version {
 			validAPIVersion = true
 			break
 		}
 	}
 	if !validAPIVersion {
-		return fmt.Errorf("Unsupported APIVersion %s, supported: %v", c.APIVersion, APIVersions)
+		return fmt.Errorf("Unsupported APIVersion %s, supported: %v", typeMeta.APIVersion, APIVersions)
 	}
-	if c.Kind != Kind {
-		return fmt.Errorf("Unsupported Kind %s, must be %s", c.Kind, Kind)
+	if typeMeta.Kind != kind {
+		return fmt.Errorf("Unsupported Kind %s, must be %s", typeMeta.Kind, kind)
 	}
 	return nil
 }
@@ -128,6 +134,25 @@ func (s *StringMap) UnmarshalJSON(bytes []byte) (err error) {
 	return nil
 }
 
+// A StringList is a list of strings, but unmarshals from JSON by parsing a string, then re-parsing that string as JSON
+type StringList []string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (s *StringList) UnmarshalJSON(bytes []byte) (err error) {
+	var sJSON string
+	err = json.Unmarshal(bytes, &sJSON)
+	if err != nil {
+		return
+	}
+	x := []string{}
+	err = json.Unmarshal([]byte(sJSON), &x)
+	if err != nil {
+		return err
+	}
+	*s = StringList(x)
+	return nil
+}
+
 type Int int
 
 // An Int is a int that unmarshals from a JSON string
@@ -251,9 +276,14 @@ type ReleaseConfig struct {
 	LoadBalancerServiceAnnotations StringMap           `json:"load-balancer.service.annotations"`
 	LoadBalancerIngress            LoadBalancerIngress `json:"load-balancer.ingress"`
 	LBManagerFullname              string              `json:"lb-manager.fullname"`
+	LBManagerEnabled               Bool                `json:"lb-manager.enabled"`
+	LBManagerSelectorLabels        StringMap           `json:"lb-manager.selectorLabels"`
+	KubeconfigSelectorLabels       StringMap           `json:"kubeconfig.selectorLabels"`
 	FileGatewayEnabled             Bool                `json:"file-gateway.enabled"`
 	FileGatewayHostname            string              `json:"file-gateway.hostname"`
 	FileGatewayContainerPort       Int                 `json:"file-gateway.containerPort"`
+	SharedPersistenceEnabled       Bool                `json:"shared-persistence.enabled"`
+	SharedPersistenceMounts        StringList          `json:"shared-persistence.mounts"`
 	RKE2Enabled                    Bool                `json:"rke2.enabled"`
 }
 
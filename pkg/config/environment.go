@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/meln5674/kink/pkg/helm"
+)
+
+const (
+	EnvironmentKind = "Environment"
+)
+
+// RawEnvironment is a set of clusters which are created and deleted together, as provided by the user.
+// The helm, kubectl, kubernetes, docker, chart, and release sections are the same as those of a RawConfig,
+// and are shared by all clusters.
+type RawEnvironment struct {
+	RawConfig `json:",inline"`
+	// Clusters are the clusters in the environment
+	Clusters []EnvironmentCluster `json:"clusters"`
+}
+
+// EnvironmentCluster is a single cluster within an environment
+type EnvironmentCluster struct {
+	// Name is the name of the cluster, as would be provided to the --name flag
+	Name string `json:"name"`
+	// Namespace is the namespace to deploy the cluster to. If not set, the namespace of the kubernetes section is used.
+	Namespace string `json:"namespace"`
+	// Release overrides the shared release section for this cluster. Fields set here take precedence, and set and
+	// setString are merged with the shared values.
+	Release helm.ClusterReleaseFlags `json:"release"`
+	// Load are the images and archives to load into the cluster once it is ready
+	Load EnvironmentLoad `json:"load"`
+	// Kubeconfig is the path to export the kubeconfig for the cluster to. If not set, it is not exported.
+	Kubeconfig string `json:"kubeconfig"`
+	// PortForward are the local ports used to access the cluster while waiting for it to be ready, and which the
+	// default context of the exported kubeconfig expects. If not set, each cluster is assigned a distinct port based
+	// on its position in the list, so that the clusters can be port-forwarded at the same time.
+	PortForward EnvironmentPortForward `json:"portForward"`
+}
+
+// EnvironmentLoad are the images and archives to load into a cluster, as with `kink load`
+type EnvironmentLoad struct {
+	DockerImages   []string `json:"dockerImages"`
+	DockerArchives []string `json:"dockerArchives"`
+	OCIArchives    []string `json:"ociArchives"`
+}
+
+// EnvironmentPortForward are the local ports to use for a cluster, as with `kink port-forward`
+type EnvironmentPortForward struct {
+	ControlplanePort int `json:"controlplanePort"`
+	FileGatewayPort  int `json:"fileGatewayPort"`
+}
+
+func (e *RawEnvironment) LoadFromFile(path string) error {
+	err := loadFromFile(path, e, &e.TypeMeta, EnvironmentKind)
+	if err != nil {
+		return err
+	}
+	return e.Validate()
+}
+
+// Validate checks that every cluster in the environment has a unique name
+func (e *RawEnvironment) Validate() error {
+	if len(e.Clusters) == 0 {
+		return fmt.Errorf("Environment must contain at least one cluster")
+	}
+	names := make(map[string]struct{}, len(e.Clusters))
+	for ix, cluster := range e.Clusters {
+		if cluster.Name == "" {
+			return fmt.Errorf("Cluster %d in environment has no name", ix)
+		}
+		key := cluster.Namespace + "/" + cluster.Name
+		if _, ok := names[key]; ok {
+			return fmt.Errorf("Cluster %s appears more than once in environment", cluster.Name)
+		}
+		names[key] = struct{}{}
+	}
+	return nil
+}
+
+// ClusterRelease returns the release configuration for a cluster, using the shared release configuration
+// for any fields the cluster does not set
+func (c *EnvironmentCluster) ClusterRelease(shared *helm.ClusterReleaseFlags) helm.ClusterReleaseFlags {
+	release := helm.ClusterReleaseFlags{
+		ClusterName:  c.Name,
+		Values:       c.Release.Values,
+		Set:          make(map[string]string, len(c.Release.Set)),
+		SetString:    make(map[string]string, len(c.Release.SetString)),
+		UpgradeFlags: c.Release.UpgradeFlags,
+	}
+	for k, v := range c.Release.Set {
+		release.Set[k] = v
+	}
+	for k, v := range c.Release.SetString {
+		release.SetString[k] = v
+	}
+	release.Override(shared)
+	return release
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/meln5674/kink/pkg/helm"
+)
+
+func TestEnvironmentValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		clusters []EnvironmentCluster
+		err      string
+	}{
+		{name: "empty", err: "at least one cluster"},
+		{name: "unnamed", clusters: []EnvironmentCluster{{Name: "a"}, {}}, err: "Cluster 1 in environment has no name"},
+		{name: "duplicate", clusters: []EnvironmentCluster{{Name: "a"}, {Name: "a"}}, err: "Cluster a appears more than once"},
+		{name: "same name in other namespaces", clusters: []EnvironmentCluster{{Name: "a"}, {Name: "a", Namespace: "other"}}},
+	}
+	for _, c := range cases {
+		env := RawEnvironment{Clusters: c.clusters}
+		err := env.Validate()
+		if c.err == "" && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: expected error containing %q, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestEnvironmentClusterRelease(t *testing.T) {
+	shared := helm.ClusterReleaseFlags{
+		ClusterName:  "shared",
+		Values:       []string{"shared.yaml"},
+		Set:          map[string]string{"a": "shared", "b": "shared"},
+		SetString:    map[string]string{"c": "shared"},
+		UpgradeFlags: []string{"--timeout=10m"},
+	}
+	cluster := EnvironmentCluster{
+		Name: "cluster",
+		Release: helm.ClusterReleaseFlags{
+			ClusterName: "ignored",
+			Values:      []string{"cluster.yaml"},
+			Set:         map[string]string{"a": "cluster"},
+		},
+	}
+	release := cluster.ClusterRelease(&shared)
+	expected := helm.ClusterReleaseFlags{
+		ClusterName:  "cluster",
+		Values:       []string{"cluster.yaml"},
+		Set:          map[string]string{"a": "cluster", "b": "shared"},
+		SetString:    map[string]string{"c": "shared"},
+		UpgradeFlags: []string{"--timeout=10m"},
+	}
+	if !reflect.DeepEqual(release, expected) {
+		t.Errorf("expected %#v, got %#v", expected, release)
+	}
+	// Merging must not modify either the shared or the cluster configuration
+	if len(shared.Set) != 2 || shared.Set["a"] != "shared" || len(cluster.Release.Set) != 1 {
+		t.Errorf("inputs were modified: %#v, %#v", shared.Set, cluster.Release.Set)
+	}
+}
@@ -0,0 +1,193 @@
+package containerd
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	// AnnotationImageName is the annotation containerd uses to name imported images
+	AnnotationImageName = "io.containerd.image.name"
+	// AnnotationRefName is the OCI annotation for the name of an image in a layout
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+
+	ociLayoutFile    = "oci-layout"
+	ociLayoutVersion = "1.0.0"
+	ociIndexFile     = "index.json"
+)
+
+// NamedImage is an image and the name it should be imported as
+type NamedImage struct {
+	Ref   name.Reference
+	Image v1.Image
+}
+
+// ImageName returns the name containerd and the kubelet expect for an image, e.g. docker.io/library/nginx:latest,
+// which differs from the go-containerregistry name for images from Docker Hub
+func ImageName(ref name.Reference) string {
+	registry := ref.Context().RegistryStr()
+	if registry == name.DefaultRegistry {
+		registry = "docker.io"
+	}
+	sep := ":"
+	if _, ok := ref.(name.Digest); ok {
+		sep = "@"
+	}
+	return fmt.Sprintf("%s/%s%s%s", registry, ref.Context().RepositoryStr(), sep, ref.Identifier())
+}
+
+// LayerDigests returns the digests of the compressed layers of a set of images, without duplicates
+func LayerDigests(images []NamedImage) ([]v1.Hash, error) {
+	seen := make(map[v1.Hash]bool)
+	digests := make([]v1.Hash, 0)
+	for _, image := range images {
+		layers, err := image.Image.Layers()
+		if err != nil {
+			return nil, err
+		}
+		for _, layer := range layers {
+			digest, err := layer.Digest()
+			if err != nil {
+				return nil, err
+			}
+			if seen[digest] {
+				continue
+			}
+			seen[digest] = true
+			digests = append(digests, digest)
+		}
+	}
+	return digests, nil
+}
+
+// WriteArchive writes a set of images as a tar archive of an OCI image layout, which can be imported by containerd.
+// Layers for which skipLayer returns true are left out, and must already be present in the content store of any
+// containerd the archive is imported into.
+func WriteArchive(w io.Writer, images []NamedImage, skipLayer func(v1.Hash) bool) error {
+	tw := tar.NewWriter(w)
+	written := make(map[v1.Hash]bool)
+	writeBlob := func(digest v1.Hash, size int64, open func() (io.ReadCloser, error)) error {
+		if written[digest] {
+			return nil
+		}
+		written[digest] = true
+		r, err := open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return writeFile(tw, path.Join("blobs", digest.Algorithm, digest.Hex), size, r)
+	}
+	writeBytes := func(digest v1.Hash, content []byte) error {
+		return writeBlob(digest, int64(len(content)), func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(content)), nil
+		})
+	}
+
+	layout, err := json.Marshal(map[string]string{"imageLayoutVersion": ociLayoutVersion})
+	if err != nil {
+		return err
+	}
+	err = writeFile(tw, ociLayoutFile, int64(len(layout)), bytes.NewReader(layout))
+	if err != nil {
+		return err
+	}
+
+	manifests := make([]v1.Descriptor, 0, len(images))
+	for _, image := range images {
+		rawManifest, err := image.Image.RawManifest()
+		if err != nil {
+			return err
+		}
+		manifest, err := image.Image.Manifest()
+		if err != nil {
+			return err
+		}
+		rawConfig, err := image.Image.RawConfigFile()
+		if err != nil {
+			return err
+		}
+		err = writeBytes(manifest.Config.Digest, rawConfig)
+		if err != nil {
+			return err
+		}
+		layers, err := image.Image.Layers()
+		if err != nil {
+			return err
+		}
+		for ix, layer := range layers {
+			desc := manifest.Layers[ix]
+			if skipLayer(desc.Digest) {
+				continue
+			}
+			err = writeBlob(desc.Digest, desc.Size, layer.Compressed)
+			if err != nil {
+				return fmt.Errorf("failed to write layer %s of %s: %w", desc.Digest, image.Ref, err)
+			}
+		}
+		digest, err := image.Image.Digest()
+		if err != nil {
+			return err
+		}
+		err = writeBytes(digest, rawManifest)
+		if err != nil {
+			return err
+		}
+		mediaType, err := image.Image.MediaType()
+		if err != nil {
+			return err
+		}
+		imageName := ImageName(image.Ref)
+		manifests = append(manifests, v1.Descriptor{
+			MediaType: mediaType,
+			Digest:    digest,
+			Size:      int64(len(rawManifest)),
+			Annotations: map[string]string{
+				AnnotationImageName: imageName,
+				AnnotationRefName:   image.Ref.Identifier(),
+			},
+		})
+	}
+
+	index, err := json.Marshal(v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+		Manifests:     manifests,
+	})
+	if err != nil {
+		return err
+	}
+	err = writeFile(tw, ociIndexFile, int64(len(index)), bytes.NewReader(index))
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func writeFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+	})
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(tw, r)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("%s was %d bytes, expected %d", name, n, size)
+	}
+	return nil
+}
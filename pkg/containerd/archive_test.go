@@ -0,0 +1,107 @@
+package containerd_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"path"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+
+	"github.com/meln5674/kink/pkg/containerd"
+)
+
+func TestImageName(t *testing.T) {
+	cases := map[string]string{
+		"nginx":                      "docker.io/library/nginx:latest",
+		"nginx:1.25":                 "docker.io/library/nginx:1.25",
+		"ghcr.io/meln5674/kink:v0.3": "ghcr.io/meln5674/kink:v0.3",
+		"localhost:5000/foo/bar@sha256:0000000000000000000000000000000000000000000000000000000000000000": "localhost:5000/foo/bar@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+	}
+	for in, expected := range cases {
+		ref, err := name.ParseReference(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := containerd.ImageName(ref); actual != expected {
+			t.Errorf("%s: expected %s, got %s", in, expected, actual)
+		}
+	}
+}
+
+func TestWriteArchiveSkipsLayers(t *testing.T) {
+	img, err := random.Image(64, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference("example.com/test:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := []containerd.NamedImage{{Ref: ref, Image: img}}
+	layers, err := containerd.LayerDigests(images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layers) != 3 {
+		t.Fatalf("expected 3 layers, got %d", len(layers))
+	}
+	skipped := layers[1]
+
+	var archive bytes.Buffer
+	err = containerd.WriteArchive(&archive, images, func(layer v1.Hash) bool { return layer == skipped })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(&archive)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = content
+	}
+
+	blobPath := func(h v1.Hash) string { return path.Join("blobs", h.Algorithm, h.Hex) }
+	for _, layer := range layers {
+		_, ok := files[blobPath(layer)]
+		if layer == skipped && ok {
+			t.Errorf("skipped layer %s was written", layer)
+		}
+		if layer != skipped && !ok {
+			t.Errorf("layer %s was not written", layer)
+		}
+	}
+
+	var index v1.IndexManifest
+	err = json.Unmarshal(files["index.json"], &index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 {
+		t.Fatalf("expected 1 manifest, got %d", len(index.Manifests))
+	}
+	if name := index.Manifests[0].Annotations[containerd.AnnotationImageName]; name != "example.com/test:latest" {
+		t.Errorf("unexpected image name %s", name)
+	}
+	if _, ok := files[blobPath(index.Manifests[0].Digest)]; !ok {
+		t.Error("manifest was not written")
+	}
+	if _, ok := files["oci-layout"]; !ok {
+		t.Error("oci-layout was not written")
+	}
+}
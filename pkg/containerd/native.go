@@ -0,0 +1,49 @@
+package containerd
+
+import (
+	"context"
+	"io"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+)
+
+// NativeClient connects to the containerd socket described by the flags, instead of executing ctr
+func (c *CtrFlags) NativeClient() (*containerd.Client, error) {
+	return containerd.New(c.Address, containerd.WithDefaultNamespace(c.Namespace))
+}
+
+// NativeHasBlobs returns which of a set of blobs, such as image layers, are present in the content store
+func NativeHasBlobs(ctx context.Context, client *containerd.Client, digests []digest.Digest) ([]digest.Digest, error) {
+	present := make([]digest.Digest, 0, len(digests))
+	for _, dgst := range digests {
+		_, err := client.ContentStore().Info(ctx, dgst)
+		if errdefs.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		present = append(present, dgst)
+	}
+	return present, nil
+}
+
+// NativeImportImage is the equivalent of ImportImage, and additionally unpacks the imported images into the given
+// snapshotter so that they are immediately usable by the kubelet. It returns the names of the imported images.
+func NativeImportImage(ctx context.Context, client *containerd.Client, snapshotter string, archive io.Reader) ([]string, error) {
+	imgs, err := client.Import(ctx, archive)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(imgs))
+	for _, img := range imgs {
+		err = containerd.NewImage(client, img).Unpack(ctx, snapshotter)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, img.Name)
+	}
+	return names, nil
+}
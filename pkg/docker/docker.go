@@ -8,11 +8,14 @@ import (
 type DockerFlags struct {
 	Command []string `json:"command"`
 	Context string   `json:"context"`
+	// Native uses the Docker Engine API instead of executing Command
+	Native bool `json:"native"`
 }
 
 func (d *DockerFlags) Override(d2 *DockerFlags) {
 	util.Override(&d.Command, &d2.Command)
 	util.Override(&d.Context, &d2.Context)
+	util.Override(&d.Native, &d2.Native)
 }
 
 func (d *DockerFlags) Flags() map[string]string {
@@ -0,0 +1,34 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/client"
+)
+
+// NativeClient creates a Docker Engine API client equivalent to the docker command these flags describe.
+// The connection is configured from the same environment variables as the docker command, e.g. DOCKER_HOST.
+func (d *DockerFlags) NativeClient() (*client.Client, error) {
+	if d.Context != "" {
+		return nil, fmt.Errorf("Docker contexts are not supported by the native docker client, set DOCKER_HOST instead")
+	}
+	return client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+}
+
+// NativeSave is the equivalent of Save, but uses the Docker Engine API directly instead of a docker process
+func NativeSave(ctx context.Context, d *DockerFlags, w io.Writer, images ...string) error {
+	client, err := d.NativeClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	archive, err := client.ImageSave(ctx, images)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	_, err = io.Copy(w, archive)
+	return err
+}
@@ -72,10 +72,13 @@ func GetReleaseClusterName(release string) (string, bool) {
 
 type HelmFlags struct {
 	Command []string `json:"command"`
+	// Native uses the Helm SDK instead of executing Command
+	Native bool `json:"native"`
 }
 
 func (h *HelmFlags) Override(h2 *HelmFlags) {
 	util.Override(&h.Command, &h2.Command)
+	util.Override(&h.Native, &h2.Native)
 }
 
 func (h *HelmFlags) Helm(k *kubectl.KubeFlags, args ...string) []string {
@@ -142,6 +145,9 @@ type ClusterReleaseFlags struct {
 	Set          map[string]string `json:"set"`
 	SetString    map[string]string `json:"setString"`
 	UpgradeFlags []string          `json:"upgradeFlags"`
+	// NoWait, if set, returns as soon as the release is upgraded, instead of waiting for its resources to be ready.
+	// It is not configurable, and is only set by callers which wait for readiness themselves.
+	NoWait bool `json:"-"`
 }
 
 func (f *ClusterReleaseFlags) Raw() ReleaseFlags {
@@ -151,6 +157,7 @@ func (f *ClusterReleaseFlags) Raw() ReleaseFlags {
 		Set:          f.Set,
 		SetString:    f.SetString,
 		UpgradeFlags: f.UpgradeFlags,
+		NoWait:       f.NoWait,
 	}
 }
 
@@ -160,6 +167,8 @@ type ReleaseFlags struct {
 	Set          map[string]string `json:"set"`
 	SetString    map[string]string `json:"setString"`
 	UpgradeFlags []string          `json:"upgradeFlags"`
+	// NoWait, if set, returns as soon as the release is upgraded, instead of waiting for its resources to be ready
+	NoWait bool `json:"-"`
 }
 
 func (r *ReleaseFlags) ValuesFlags() []string {
@@ -201,7 +210,11 @@ func RepoUpdate(h *HelmFlags, repoNames ...string) []string {
 
 func Upgrade(h *HelmFlags, c *ChartFlags, r *ReleaseFlags, k *kubectl.KubeFlags) []string {
 	args := make([]string, 0)
-	args = append(args, "upgrade", "--install", "--wait", r.Name, c.FullChartName())
+	args = append(args, "upgrade", "--install")
+	if !r.NoWait {
+		args = append(args, "--wait")
+	}
+	args = append(args, r.Name, c.FullChartName())
 	args = append(args, c.UpgradeFlags()...)
 	args = append(args, r.ValuesFlags()...)
 	args = append(args, r.UpgradeFlags...)
@@ -233,3 +246,25 @@ func Delete(h *HelmFlags, c *ChartFlags, r *ReleaseFlags, k *kubectl.KubeFlags)
 func List(h *HelmFlags, k *kubectl.KubeFlags) []string {
 	return h.Helm(k, "list", "--output", "json", "--all")
 }
+
+func ListAllNamespaces(h *HelmFlags, k *kubectl.KubeFlags) []string {
+	return h.Helm(k, "list", "--output", "json", "--all", "--all-namespaces")
+}
+
+func History(h *HelmFlags, r *ReleaseFlags, k *kubectl.KubeFlags) []string {
+	return h.Helm(k, "history", r.Name, "--output", "json")
+}
+
+func GetManifest(h *HelmFlags, r *ReleaseFlags, k *kubectl.KubeFlags) []string {
+	return h.Helm(k, "get", "manifest", r.Name)
+}
+
+// SplitChart splits the chart field of a release summary, which is of the form <name>-<version>, into its parts
+func SplitChart(chart string) (name, version string) {
+	for ix := 0; ix < len(chart)-1; ix++ {
+		if chart[ix] == '-' && chart[ix+1] >= '0' && chart[ix+1] <= '9' {
+			return chart[:ix], chart[ix+1:]
+		}
+	}
+	return chart, ""
+}
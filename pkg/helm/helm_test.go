@@ -0,0 +1,28 @@
+package helm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/meln5674/kink/pkg/kubectl"
+)
+
+func TestUpgradeCluster(t *testing.T) {
+	h := HelmFlags{Command: []string{"helm"}}
+	c := ChartFlags{ChartName: "./helm/kink"}
+	cases := []struct {
+		name     string
+		noWait   bool
+		expected []string
+	}{
+		{name: "wait", expected: []string{"helm", "upgrade", "--install", "--wait", "kink-test", "./helm/kink", "--set", "a=b"}},
+		{name: "no wait", noWait: true, expected: []string{"helm", "upgrade", "--install", "kink-test", "./helm/kink", "--set", "a=b"}},
+	}
+	for _, tc := range cases {
+		r := ClusterReleaseFlags{ClusterName: "test", Set: map[string]string{"a": "b"}, NoWait: tc.noWait}
+		actual := UpgradeCluster(&h, &c, &r, &kubectl.KubeFlags{})
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, actual)
+		}
+	}
+}
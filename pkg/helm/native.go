@@ -0,0 +1,323 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/klog/v2"
+
+	"github.com/meln5674/kink/pkg/kubectl"
+)
+
+const (
+	// DefaultNativeTimeout is the timeout used when waiting for releases with the native client, matching the
+	// default of the helm command
+	DefaultNativeTimeout = 5 * time.Minute
+)
+
+// ReleaseSummary is a single release as listed by `helm list --output json`
+type ReleaseSummary struct {
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	Revision   string `json:"revision"`
+	Updated    string `json:"updated"`
+	Status     string `json:"status"`
+	Chart      string `json:"chart"`
+	AppVersion string `json:"app_version"`
+}
+
+// HistoryEntry is a single revision of a release as listed by `helm history --output json`
+type HistoryEntry struct {
+	Revision    int    `json:"revision"`
+	Updated     string `json:"updated"`
+	Status      string `json:"status"`
+	Chart       string `json:"chart"`
+	AppVersion  string `json:"app_version"`
+	Description string `json:"description"`
+}
+
+// NativeClient performs the same operations as the helm command using the Helm SDK
+type NativeClient struct {
+	settings *cli.EnvSettings
+	config   *action.Configuration
+}
+
+// NewNativeClient creates a client for releases within a namespace of the cluster described by a set of kubernetes flags
+func NewNativeClient(c *ChartFlags, k *kubectl.KubeFlags, namespace string) (*NativeClient, error) {
+	settings := cli.New()
+	settings.SetNamespace(namespace)
+
+	registryOpts := []registry.ClientOption{
+		registry.ClientOptCredentialsFile(settings.RegistryConfig),
+		registry.ClientOptEnableCache(true),
+	}
+	if c.PlainHTTP {
+		registryOpts = append(registryOpts, registry.ClientOptPlainHTTP())
+	}
+	registryClient, err := registry.NewClient(registryOpts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create helm registry client")
+	}
+
+	config := new(action.Configuration)
+	err = config.Init(k.RESTClientGetter(), namespace, "secret", func(format string, v ...interface{}) {
+		klog.V(4).Infof(format, v...)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to initialize helm SDK")
+	}
+	config.RegistryClient = registryClient
+
+	return &NativeClient{settings: settings, config: config}, nil
+}
+
+// ChartPathOptions returns the options to locate a chart, equivalent to UpgradeFlags.
+// Because the repository URL is provided directly, no `repo add` or `repo update` is required
+func (c *ChartFlags) ChartPathOptions() action.ChartPathOptions {
+	opts := action.ChartPathOptions{
+		Version:   c.Version,
+		PlainHTTP: c.PlainHTTP,
+	}
+	if !c.IsOCIChart() && !c.IsLocalChart() {
+		opts.RepoURL = c.RepositoryURL
+	}
+	return opts
+}
+
+// ValuesOptions returns the values to merge, equivalent to ValuesFlags
+func (r *ReleaseFlags) ValuesOptions() *values.Options {
+	opts := &values.Options{
+		ValueFiles:   r.Values,
+		Values:       make([]string, 0, len(r.Set)),
+		StringValues: make([]string, 0, len(r.SetString)),
+	}
+	for k, v := range r.Set {
+		opts.Values = append(opts.Values, fmt.Sprintf("%s=%s", k, v))
+	}
+	for k, v := range r.SetString {
+		opts.StringValues = append(opts.StringValues, fmt.Sprintf("%s=%s", k, v))
+	}
+	return opts
+}
+
+func (n *NativeClient) loadChart(opts *action.ChartPathOptions, c *ChartFlags) (*chart.Chart, error) {
+	chartPath, err := opts.LocateChart(c.ChartName, n.settings)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to locate chart %s", c.ChartName)
+	}
+	return loader.Load(chartPath)
+}
+
+func (n *NativeClient) loadValues(r *ReleaseFlags) (map[string]interface{}, error) {
+	if len(r.UpgradeFlags) != 0 {
+		klog.Warningf("Ignoring extra upgrade flags, these are not supported by the native helm client: %v", r.UpgradeFlags)
+	}
+	return r.ValuesOptions().MergeValues(getter.All(n.settings))
+}
+
+// Upgrade is the equivalent of Upgrade
+func (n *NativeClient) Upgrade(ctx context.Context, c *ChartFlags, r *ReleaseFlags, out io.Writer) error {
+	vals, err := n.loadValues(r)
+	if err != nil {
+		return err
+	}
+
+	history := action.NewHistory(n.config)
+	history.Max = 1
+	_, err = history.Run(r.Name)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		install := action.NewInstall(n.config)
+		install.ChartPathOptions = c.ChartPathOptions()
+		install.SetRegistryClient(n.config.RegistryClient)
+		install.ReleaseName = r.Name
+		install.Namespace = n.settings.Namespace()
+		install.Wait = !r.NoWait
+		install.Timeout = DefaultNativeTimeout
+		chart, err := n.loadChart(&install.ChartPathOptions, c)
+		if err != nil {
+			return err
+		}
+		rel, err := install.RunWithContext(ctx, chart, vals)
+		if err != nil {
+			return err
+		}
+		printReleaseStatus(out, rel)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	upgrade := action.NewUpgrade(n.config)
+	upgrade.ChartPathOptions = c.ChartPathOptions()
+	upgrade.SetRegistryClient(n.config.RegistryClient)
+	upgrade.Namespace = n.settings.Namespace()
+	upgrade.Wait = !r.NoWait
+	upgrade.Timeout = DefaultNativeTimeout
+	chart, err := n.loadChart(&upgrade.ChartPathOptions, c)
+	if err != nil {
+		return err
+	}
+	rel, err := upgrade.RunWithContext(ctx, r.Name, chart, vals)
+	if err != nil {
+		return err
+	}
+	printReleaseStatus(out, rel)
+	return nil
+}
+
+// UpgradeCluster is the equivalent of UpgradeCluster
+func (n *NativeClient) UpgradeCluster(ctx context.Context, c *ChartFlags, r *ClusterReleaseFlags, out io.Writer) error {
+	raw := r.Raw()
+	return n.Upgrade(ctx, c, &raw, out)
+}
+
+// Template is the equivalent of Template, writing the rendered manifests to out
+func (n *NativeClient) Template(ctx context.Context, c *ChartFlags, r *ReleaseFlags, out io.Writer) error {
+	vals, err := n.loadValues(r)
+	if err != nil {
+		return err
+	}
+	install := action.NewInstall(n.config)
+	install.ChartPathOptions = c.ChartPathOptions()
+	install.SetRegistryClient(n.config.RegistryClient)
+	install.ReleaseName = r.Name
+	install.Namespace = n.settings.Namespace()
+	install.DryRun = true
+	install.ClientOnly = true
+	install.Replace = true
+	chart, err := n.loadChart(&install.ChartPathOptions, c)
+	if err != nil {
+		return err
+	}
+	rel, err := install.RunWithContext(ctx, chart, vals)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(out, rel.Manifest)
+	return err
+}
+
+// TemplateCluster is the equivalent of TemplateCluster
+func (n *NativeClient) TemplateCluster(ctx context.Context, c *ChartFlags, r *ClusterReleaseFlags, out io.Writer) error {
+	raw := r.Raw()
+	return n.Template(ctx, c, &raw, out)
+}
+
+// Delete is the equivalent of Delete
+func (n *NativeClient) Delete(ctx context.Context, r *ReleaseFlags, out io.Writer) error {
+	uninstall := action.NewUninstall(n.config)
+	resp, err := uninstall.Run(r.Name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "release \"%s\" uninstalled\n", r.Name)
+	if resp.Info != "" {
+		fmt.Fprintln(out, resp.Info)
+	}
+	return nil
+}
+
+// List is the equivalent of List
+func (n *NativeClient) List(ctx context.Context) ([]ReleaseSummary, error) {
+	return n.list(ctx, false)
+}
+
+// ListAllNamespaces is the equivalent of ListAllNamespaces.
+// The client must have been created with an empty namespace.
+func (n *NativeClient) ListAllNamespaces(ctx context.Context) ([]ReleaseSummary, error) {
+	return n.list(ctx, true)
+}
+
+func (n *NativeClient) list(ctx context.Context, allNamespaces bool) ([]ReleaseSummary, error) {
+	list := action.NewList(n.config)
+	list.All = true
+	list.AllNamespaces = allNamespaces
+	list.SetStateMask()
+	releases, err := list.Run()
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]ReleaseSummary, 0, len(releases))
+	for _, rel := range releases {
+		summaries = append(summaries, SummarizeRelease(rel))
+	}
+	return summaries, nil
+}
+
+// History is the equivalent of History
+func (n *NativeClient) History(ctx context.Context, r *ReleaseFlags) ([]HistoryEntry, error) {
+	history := action.NewHistory(n.config)
+	releases, err := history.Run(r.Name)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]HistoryEntry, 0, len(releases))
+	for _, rel := range releases {
+		summary := SummarizeRelease(rel)
+		entry := HistoryEntry{
+			Revision:   rel.Version,
+			Updated:    summary.Updated,
+			Status:     summary.Status,
+			Chart:      summary.Chart,
+			AppVersion: summary.AppVersion,
+		}
+		if rel.Info != nil {
+			entry.Description = rel.Info.Description
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// GetManifest is the equivalent of GetManifest
+func (n *NativeClient) GetManifest(ctx context.Context, r *ReleaseFlags, out io.Writer) error {
+	get := action.NewGet(n.config)
+	rel, err := get.Run(r.Name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(out, rel.Manifest)
+	return err
+}
+
+// SummarizeRelease converts a release into the form output by `helm list`
+func SummarizeRelease(rel *release.Release) ReleaseSummary {
+	summary := ReleaseSummary{
+		Name:      rel.Name,
+		Namespace: rel.Namespace,
+		Revision:  strconv.Itoa(rel.Version),
+	}
+	if rel.Info != nil {
+		summary.Status = rel.Info.Status.String()
+		if !rel.Info.LastDeployed.IsZero() {
+			summary.Updated = rel.Info.LastDeployed.String()
+		}
+	}
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		summary.Chart = fmt.Sprintf("%s-%s", rel.Chart.Metadata.Name, rel.Chart.Metadata.Version)
+		summary.AppVersion = rel.Chart.Metadata.AppVersion
+	}
+	return summary
+}
+
+func printReleaseStatus(out io.Writer, rel *release.Release) {
+	fmt.Fprintf(out, "Release \"%s\": %s\n", rel.Name, rel.Info.Description)
+	fmt.Fprintf(out, "NAME: %s\n", rel.Name)
+	fmt.Fprintf(out, "NAMESPACE: %s\n", rel.Namespace)
+	fmt.Fprintf(out, "STATUS: %s\n", rel.Info.Status.String())
+	fmt.Fprintf(out, "REVISION: %d\n", rel.Version)
+}
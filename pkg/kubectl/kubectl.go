@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
@@ -17,10 +18,13 @@ var recommendedFlags = clientcmd.RecommendedConfigOverrideFlags("")
 
 type KubectlFlags struct {
 	Command []string
+	// Native uses client-go instead of executing Command
+	Native bool `json:"native"`
 }
 
 func (k *KubectlFlags) Override(k2 *KubectlFlags) {
 	util.Override(&k.Command, &k2.Command)
+	util.Override(&k.Native, &k2.Native)
 }
 
 type KubeFlags struct {
@@ -290,19 +294,17 @@ func ConfigGetContext(k *KubectlFlags, ku *KubeFlags, context string) []string {
 	return Kubectl(k, ku, "config", "get", context, "--output", "json")
 }
 
-func PortForward(k *KubectlFlags, ku *KubeFlags, target string, mappings map[string]string) []string {
-	args := make([]string, 0, 2+len(mappings))
-	args = append(args, "port-forward", target)
-
-	for local, remote := range mappings {
-		args = append(args, fmt.Sprintf("%s:%s", local, remote))
-	}
-	return Kubectl(k, ku, args...)
+func Exec(k *KubectlFlags, ku *KubeFlags, target string, stdin, tty bool, exec ...string) []string {
+	return ExecInContainer(k, ku, target, "", stdin, tty, exec...)
 }
 
-func Exec(k *KubectlFlags, ku *KubeFlags, target string, stdin, tty bool, exec ...string) []string {
-	args := make([]string, 0, 4+len(exec))
+// ExecInContainer is Exec, but for a specific container. If container is empty, the default container is used.
+func ExecInContainer(k *KubectlFlags, ku *KubeFlags, target, container string, stdin, tty bool, exec ...string) []string {
+	args := make([]string, 0, 6+len(exec))
 	args = append(args, "exec", target)
+	if container != "" {
+		args = append(args, "--container", container)
+	}
 	if stdin {
 		args = append(args, "--stdin")
 	}
@@ -314,12 +316,41 @@ func Exec(k *KubectlFlags, ku *KubeFlags, target string, stdin, tty bool, exec .
 	return Kubectl(k, ku, args...)
 }
 
-func Cp(k *KubectlFlags, ku *KubeFlags, target, src, dest string) []string {
-	return Kubectl(k, ku, "cp", fmt.Sprintf("%s:%s", target, src), dest)
+// LogsOptions are the flags for Logs and NativeLogs
+type LogsOptions struct {
+	// Container is the name of the container to get the logs of. If empty, the default container is used
+	Container string
+	// Follow streams new logs until the container exits or the context is cancelled
+	Follow bool
+	// Previous gets the logs of the previous instance of the container, if it has restarted
+	Previous bool
+	// Tail is the number of most recent lines to get, or all lines if negative
+	Tail int
+	// Since, if non-zero, only gets logs newer than this
+	Since time.Duration
 }
 
-func Version(k *KubectlFlags, ku *KubeFlags) []string {
-	return Kubectl(k, ku, "version")
+func Logs(k *KubectlFlags, ku *KubeFlags, target string, opts *LogsOptions) []string {
+	args := make([]string, 0, 8)
+	args = append(args, "logs", target)
+	if opts.Container != "" {
+		args = append(args, "--container", opts.Container)
+	}
+	if opts.Follow {
+		args = append(args, "--follow")
+	}
+	if opts.Previous {
+		args = append(args, "--previous")
+	}
+	args = append(args, fmt.Sprintf("--tail=%d", opts.Tail))
+	if opts.Since != 0 {
+		args = append(args, fmt.Sprintf("--since=%s", opts.Since))
+	}
+	return Kubectl(k, ku, args...)
+}
+
+func Cp(k *KubectlFlags, ku *KubeFlags, target, src, dest string) []string {
+	return Kubectl(k, ku, "cp", fmt.Sprintf("%s:%s", target, src), dest)
 }
 
 func ConfigSetCluster(k *KubectlFlags, ku *KubeFlags, cluster string, data map[string]string) []string {
@@ -0,0 +1,251 @@
+package kubectl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/klog/v2"
+)
+
+// ClientConfig returns a loader for the cluster connection described by these flags,
+// using the same rules as kubectl would for the equivalent command-line flags
+func (k *KubeFlags) ClientConfig() clientcmd.ClientConfig {
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{
+			ExplicitPath: k.Kubeconfig,
+		},
+		&k.ConfigOverrides,
+	)
+}
+
+// RESTClientGetter adapts these flags for libraries, such as the Helm SDK, which expect kubectl-style flags
+func (k *KubeFlags) RESTClientGetter() genericclioptions.RESTClientGetter {
+	return &restClientGetter{loader: k.ClientConfig()}
+}
+
+type restClientGetter struct {
+	loader clientcmd.ClientConfig
+}
+
+var _ = genericclioptions.RESTClientGetter(&restClientGetter{})
+
+func (r *restClientGetter) ToRESTConfig() (*rest.Config, error) {
+	return r.loader.ClientConfig()
+}
+
+func (r *restClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	config, err := r.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return memory.NewMemCacheClient(client), nil
+}
+
+func (r *restClientGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	client, err := r.ToDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(client)
+	return restmapper.NewShortcutExpander(mapper, client, func(msg string) { klog.Warning(msg) }), nil
+}
+
+func (r *restClientGetter) ToRawKubeConfigLoader() clientcmd.ClientConfig {
+	return r.loader
+}
+
+// ExecOptions are the streams and terminal settings for NativeExec
+type ExecOptions struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	TTY    bool
+	// TerminalSizeQueue, if set, is used to propagate terminal resizes when TTY is true
+	TerminalSizeQueue remotecommand.TerminalSizeQueue
+	// Container is the name of the container to execute in. If empty, the default container is used
+	Container string
+}
+
+// NativeExec is the equivalent of Exec, but uses the SPDY client directly instead of a kubectl process
+func NativeExec(ctx context.Context, config *rest.Config, namespace, pod string, opts *ExecOptions, exec ...string) error {
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	req := client.
+		CoreV1().
+		RESTClient().
+		Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: opts.Container,
+			Command:   exec,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    opts.Stdout != nil,
+			Stderr:    opts.Stderr != nil && !opts.TTY,
+			TTY:       opts.TTY,
+		}, metav1.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(config, http.MethodPost, req.URL())
+	if err != nil {
+		return err
+	}
+	streams := remotecommand.StreamOptions{
+		Stdin:             opts.Stdin,
+		Stdout:            opts.Stdout,
+		Tty:               opts.TTY,
+		TerminalSizeQueue: opts.TerminalSizeQueue,
+	}
+	if !opts.TTY {
+		streams.Stderr = opts.Stderr
+	}
+	return executor.StreamWithContext(ctx, streams)
+}
+
+// NativeCat is the equivalent of Cp when copying a single file out of a pod, but uses the SPDY client directly instead of
+// a kubectl process. Unlike kubectl cp, this only requires the cat command to be present in the container, not tar.
+func NativeCat(ctx context.Context, config *rest.Config, namespace, pod, src string, w io.Writer) error {
+	return NativeExec(ctx, config, namespace, pod, &ExecOptions{Stdout: w}, "cat", src)
+}
+
+// NativeLogs is the equivalent of Logs, but uses the client directly instead of a kubectl process
+func NativeLogs(ctx context.Context, config *rest.Config, namespace, pod string, opts *LogsOptions, w io.Writer) error {
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	return StreamLogs(ctx, client, namespace, pod, opts, w)
+}
+
+// StreamLogs is NativeLogs, but with an existing client
+func StreamLogs(ctx context.Context, client kubernetes.Interface, namespace, pod string, opts *LogsOptions, w io.Writer) error {
+	logOpts := corev1.PodLogOptions{
+		Container: opts.Container,
+		Follow:    opts.Follow,
+		Previous:  opts.Previous,
+	}
+	if opts.Tail >= 0 {
+		tail := int64(opts.Tail)
+		logOpts.TailLines = &tail
+	}
+	if opts.Since != 0 {
+		since := int64(opts.Since.Round(time.Second).Seconds())
+		if since == 0 {
+			since = 1
+		}
+		logOpts.SinceSeconds = &since
+	}
+	logs, err := client.CoreV1().Pods(namespace).GetLogs(pod, &logOpts).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+	_, err = io.Copy(w, logs)
+	return err
+}
+
+// IsPodReady returns true if a pod is running and has its Ready condition set
+func IsPodReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// ServiceTargetPort translates a service port into the port on a particular pod behind that service, the same way
+// kubectl port-forward does for svc/ targets. If the service does not have a matching port, the port is assumed to
+// already be a container port.
+func ServiceTargetPort(svc *corev1.Service, pod *corev1.Pod, port int32) (int32, error) {
+	for _, svcPort := range svc.Spec.Ports {
+		if svcPort.Port != port {
+			continue
+		}
+		switch svcPort.TargetPort.Type {
+		case intstr.Int:
+			if svcPort.TargetPort.IntVal == 0 {
+				return port, nil
+			}
+			return svcPort.TargetPort.IntVal, nil
+		case intstr.String:
+			for _, container := range pod.Spec.Containers {
+				for _, containerPort := range container.Ports {
+					if containerPort.Name == svcPort.TargetPort.StrVal {
+						return containerPort.ContainerPort, nil
+					}
+				}
+			}
+			return 0, fmt.Errorf("Pod %s/%s has no port named %s", pod.Namespace, pod.Name, svcPort.TargetPort.StrVal)
+		}
+	}
+	return port, nil
+}
+
+// StatefulSetRolledOut returns true if a statefulset has finished rolling out, using the same rules as kubectl rollout status
+func StatefulSetRolledOut(sts *appsv1.StatefulSet) bool {
+	if sts.Spec.UpdateStrategy.Type != appsv1.RollingUpdateStatefulSetStrategyType {
+		return true
+	}
+	if sts.Status.ObservedGeneration == 0 || sts.Generation > sts.Status.ObservedGeneration {
+		return false
+	}
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	if sts.Status.ReadyReplicas < replicas {
+		return false
+	}
+	if sts.Spec.UpdateStrategy.RollingUpdate != nil && sts.Spec.UpdateStrategy.RollingUpdate.Partition != nil {
+		return sts.Status.UpdatedReplicas >= replicas-*sts.Spec.UpdateStrategy.RollingUpdate.Partition
+	}
+	return sts.Status.UpdateRevision == sts.Status.CurrentRevision
+}
+
+// NativeStatefulSetRolloutStatus is the equivalent of RolloutStatus for a statefulset, but polls using the client directly
+// instead of a kubectl process
+func NativeStatefulSetRolloutStatus(ctx context.Context, client kubernetes.Interface, namespace, name string) error {
+	return wait.PollUntilContextCancel(ctx, 2*time.Second, true, func(ctx context.Context) (bool, error) {
+		sts, err := client.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		replicas := int32(1)
+		if sts.Spec.Replicas != nil {
+			replicas = *sts.Spec.Replicas
+		}
+		done := StatefulSetRolledOut(sts)
+		if !done {
+			klog.Infof("Waiting for statefulset %s rollout to finish: %d of %d updated pods are ready...", name, sts.Status.ReadyReplicas, replicas)
+		}
+		return done, nil
+	})
+}
@@ -0,0 +1,405 @@
+package kubectl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	"k8s.io/klog/v2"
+)
+
+// ReadyPodsForServiceEndpoints returns the pods which are ready endpoints of a service, according to its EndpointSlices,
+// i.e. only the pods which the service would actually send traffic to, in order of name.
+func ReadyPodsForServiceEndpoints(ctx context.Context, client kubernetes.Interface, svc *corev1.Service) ([]corev1.Pod, error) {
+	slices, err := client.DiscoveryV1().EndpointSlices(svc.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: svc.Name}).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]struct{})
+	for _, slice := range slices.Items {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.TargetRef == nil || endpoint.TargetRef.Kind != "Pod" {
+				continue
+			}
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			names[endpoint.TargetRef.Name] = struct{}{}
+		}
+	}
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	pods := make([]corev1.Pod, 0, len(sortedNames))
+	for _, name := range sortedNames {
+		pod, err := client.CoreV1().Pods(svc.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			klog.V(4).Infof("Skipping endpoint %s/%s: %v", svc.Namespace, name, err)
+			continue
+		}
+		// Services which publish addresses which are not ready still mark their endpoints as ready
+		if IsPodReady(pod) {
+			pods = append(pods, *pod)
+		}
+	}
+	return pods, nil
+}
+
+// ServicePortForwardOptions are the settings for a ServicePortForwarder
+type ServicePortForwardOptions struct {
+	// Addresses are the local addresses to listen on. If empty, localhost is used
+	Addresses []string
+	// Ports are pairs of local:service ports. A local port of 0 picks a free port, which is then kept when reconnecting
+	Ports []string
+	// Reconnect, if true, reconnects to another pod of the service whenever the connection is lost, until the context
+	// is cancelled
+	Reconnect bool
+	// MinBackoff and MaxBackoff are the range of delays between attempts to connect, which doubles after each failure
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// PodCheckInterval is how often to check that the pod being forwarded to is still ready. The connection to a pod
+	// is not always lost when the pod stops, so this is needed to notice that it should be replaced.
+	PodCheckInterval time.Duration
+	// Out and ErrOut receive the same messages kubectl port-forward would print
+	Out    io.Writer
+	ErrOut io.Writer
+}
+
+// ServicePortForwarder is the equivalent of kubectl port-forward for a service, but uses the SPDY client directly instead of a
+// kubectl process. Like kubectl port-forward, it only forwards to a single ready pod of the service at a time, but
+// unlike it, it can move to another pod if that pod stops.
+type ServicePortForwarder struct {
+	client    kubernetes.Interface
+	config    *rest.Config
+	namespace string
+	service   string
+	opts      ServicePortForwardOptions
+
+	lock  sync.Mutex
+	ports []portforward.ForwardedPort
+	pod   string
+
+	done chan struct{}
+	err  error
+}
+
+// NewServicePortForwarder prepares to forward ports to a service. Nothing is forwarded until Start is called.
+func NewServicePortForwarder(config *rest.Config, namespace, service string, opts *ServicePortForwardOptions) (*ServicePortForwarder, error) {
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	s := &ServicePortForwarder{
+		client:    client,
+		config:    config,
+		namespace: namespace,
+		service:   service,
+		opts:      *opts,
+		ports:     make([]portforward.ForwardedPort, 0, len(opts.Ports)),
+		done:      make(chan struct{}),
+	}
+	if s.opts.MinBackoff == 0 {
+		s.opts.MinBackoff = 500 * time.Millisecond
+	}
+	if s.opts.MaxBackoff == 0 {
+		s.opts.MaxBackoff = 30 * time.Second
+	}
+	if s.opts.PodCheckInterval == 0 {
+		s.opts.PodCheckInterval = 5 * time.Second
+	}
+	for _, pair := range opts.Ports {
+		local, remote, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("Invalid port pair %s, must be local:remote", pair)
+		}
+		localPort, err := strconv.ParseUint(local, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid local port %s: %v", local, err)
+		}
+		remotePort, err := strconv.ParseUint(remote, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid remote port %s: %v", remote, err)
+		}
+		s.ports = append(s.ports, portforward.ForwardedPort{Local: uint16(localPort), Remote: uint16(remotePort)})
+	}
+	return s, nil
+}
+
+// Start connects to a ready pod of the service, and returns once the local ports are listening. Forwarding then
+// continues in the background until the context is cancelled or, if not reconnecting, the connection is lost.
+func (s *ServicePortForwarder) Start(ctx context.Context) error {
+	backoff := s.opts.MinBackoff
+	for {
+		session, err := s.connect(ctx)
+		if err == nil {
+			go s.run(ctx, session)
+			return nil
+		}
+		if !s.opts.Reconnect {
+			return err
+		}
+		klog.Warningf("Failed to port-forward to service %s/%s, retrying in %s: %v", s.namespace, s.service, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = nextBackoff(backoff, s.opts.MaxBackoff)
+	}
+}
+
+// Ports returns the local and service ports being forwarded, in the same order they were provided. Any local ports
+// which were 0 are replaced with the ports that were picked once Start returns.
+func (s *ServicePortForwarder) Ports() []portforward.ForwardedPort {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ports := make([]portforward.ForwardedPort, len(s.ports))
+	copy(ports, s.ports)
+	return ports
+}
+
+// Pod returns the name of the pod currently being forwarded to
+func (s *ServicePortForwarder) Pod() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pod
+}
+
+// Done is closed once forwarding has stopped
+func (s *ServicePortForwarder) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why forwarding stopped, once Done is closed. It is nil if the context was cancelled.
+func (s *ServicePortForwarder) Err() error {
+	<-s.done
+	return s.err
+}
+
+type portForwardSession struct {
+	pod    string
+	cancel context.CancelFunc
+	result chan error
+}
+
+// connect starts forwarding to a ready pod, preferring one other than the last pod, and waits until the local
+// ports are listening
+func (s *ServicePortForwarder) connect(ctx context.Context) (*portForwardSession, error) {
+	svc, err := s.client.CoreV1().Services(s.namespace).Get(ctx, s.service, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods, err := ReadyPodsForServiceEndpoints(ctx, s.client, svc)
+	if err != nil {
+		return nil, err
+	}
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("Service %s/%s has no ready pods", s.namespace, s.service)
+	}
+	pod := preferredPod(pods, s.Pod())
+
+	ports := s.Ports()
+	podPorts := make([]string, 0, len(ports))
+	for _, port := range ports {
+		podPort, err := ServiceTargetPort(svc, pod, int32(port.Remote))
+		if err != nil {
+			return nil, err
+		}
+		podPorts = append(podPorts, fmt.Sprintf("%d:%d", port.Local, podPort))
+	}
+
+	sessionCtx, cancel := context.WithCancel(ctx)
+	ready := make(chan struct{})
+	forwarder, err := newPortForwarder(sessionCtx, s.client, s.config, s.namespace, pod.Name, &podPortForwardOptions{
+		Addresses: s.opts.Addresses,
+		Ports:     podPorts,
+		Ready:     ready,
+		Out:       s.opts.Out,
+		ErrOut:    s.opts.ErrOut,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	session := &portForwardSession{pod: pod.Name, cancel: cancel, result: make(chan error, 1)}
+	go func() { session.result <- forwarder.ForwardPorts() }()
+	select {
+	case <-ready:
+	case err := <-session.result:
+		cancel()
+		if err == nil {
+			err = ctx.Err()
+		}
+		return nil, err
+	}
+
+	bound, err := forwarder.GetPorts()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for ix := range s.ports {
+		s.ports[ix].Local = bound[ix].Local
+	}
+	s.pod = pod.Name
+	klog.V(4).Infof("Port-forwarding to %s/%s via pod %s", s.namespace, s.service, pod.Name)
+	return session, nil
+}
+
+// preferredPod picks the first pod other than the last one forwarded to, so that reconnecting moves away from a pod
+// which may be failing, but falls back to the last pod if it is the only one
+func preferredPod(pods []corev1.Pod, lastPod string) *corev1.Pod {
+	for ix := range pods {
+		if pods[ix].Name != lastPod {
+			return &pods[ix]
+		}
+	}
+	return &pods[0]
+}
+
+// run waits for a session to end, and then reconnects, until the context is cancelled
+func (s *ServicePortForwarder) run(ctx context.Context, session *portForwardSession) {
+	defer close(s.done)
+	for {
+		err := s.wait(ctx, session)
+		if ctx.Err() != nil {
+			return
+		}
+		if !s.opts.Reconnect {
+			s.err = err
+			return
+		}
+		klog.Warningf("Lost port-forward to %s/%s via pod %s, reconnecting: %v", s.namespace, s.service, session.pod, err)
+
+		backoff := s.opts.MinBackoff
+		for {
+			session, err = s.connect(ctx)
+			if err == nil {
+				klog.Infof("Reconnected port-forward to %s/%s via pod %s", s.namespace, s.service, session.pod)
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			klog.Warningf("Failed to reconnect port-forward to %s/%s, retrying in %s: %v", s.namespace, s.service, backoff, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = nextBackoff(backoff, s.opts.MaxBackoff)
+		}
+	}
+}
+
+// wait waits for a session to end, or for its pod to no longer be ready, in which case the session is ended. The
+// local ports are closed once this returns, so they can be listened on again.
+func (s *ServicePortForwarder) wait(ctx context.Context, session *portForwardSession) error {
+	ticker := time.NewTicker(s.opts.PodCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-session.result:
+			session.cancel()
+			if err == nil {
+				err = errors.New("Port-forward stopped")
+			}
+			return err
+		case <-ticker.C:
+			pod, err := s.client.CoreV1().Pods(s.namespace).Get(ctx, session.pod, metav1.GetOptions{})
+			if ctx.Err() != nil {
+				session.cancel()
+				<-session.result
+				return ctx.Err()
+			}
+			if err != nil && !apierrors.IsNotFound(err) {
+				klog.V(4).Infof("Could not check pod %s/%s: %v", s.namespace, session.pod, err)
+				continue
+			}
+			if err != nil || !IsPodReady(pod) {
+				session.cancel()
+				<-session.result
+				return fmt.Errorf("Pod %s/%s is no longer ready", s.namespace, session.pod)
+			}
+		}
+	}
+}
+
+func nextBackoff(backoff, max time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > max {
+		return max
+	}
+	return backoff
+}
+
+// podPortForwardOptions are the settings for forwarding to a single pod
+type podPortForwardOptions struct {
+	// Addresses are the local addresses to listen on. If empty, localhost is used
+	Addresses []string
+	// Ports are pairs of local:remote ports, as in the arguments to kubectl port-forward
+	Ports []string
+	// Ready, if not nil, is closed once all ports are listening
+	Ready chan struct{}
+	// Out and ErrOut receive the same messages kubectl port-forward would print
+	Out    io.Writer
+	ErrOut io.Writer
+}
+
+func newPortForwarder(ctx context.Context, client kubernetes.Interface, config *rest.Config, namespace, pod string, opts *podPortForwardOptions) (*portforward.PortForwarder, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return nil, err
+	}
+	req := client.
+		CoreV1().
+		RESTClient().
+		Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL())
+
+	addresses := opts.Addresses
+	if len(addresses) == 0 {
+		addresses = []string{"localhost"}
+	}
+	out := opts.Out
+	if out == nil {
+		out = io.Discard
+	}
+	errOut := opts.ErrOut
+	if errOut == nil {
+		errOut = io.Discard
+	}
+	ready := opts.Ready
+	if ready == nil {
+		ready = make(chan struct{})
+	}
+
+	return portforward.NewOnAddresses(dialer, addresses, opts.Ports, ctx.Done(), ready, out, errOut)
+}
@@ -0,0 +1,172 @@
+package kubectl
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestNextBackoff(t *testing.T) {
+	cases := []struct {
+		backoff, max, expected time.Duration
+	}{
+		{time.Second, 30 * time.Second, 2 * time.Second},
+		{10 * time.Second, 30 * time.Second, 20 * time.Second},
+		{20 * time.Second, 30 * time.Second, 30 * time.Second},
+		{30 * time.Second, 30 * time.Second, 30 * time.Second},
+		{time.Second, 500 * time.Millisecond, 500 * time.Millisecond},
+	}
+	for _, c := range cases {
+		if actual := nextBackoff(c.backoff, c.max); actual != c.expected {
+			t.Errorf("nextBackoff(%s, %s): expected %s, got %s", c.backoff, c.max, c.expected, actual)
+		}
+	}
+}
+
+func TestBackoffSequence(t *testing.T) {
+	s, err := NewServicePortForwarder(&rest.Config{Host: "https://localhost:6443"}, "default", "svc", &ServicePortForwardOptions{
+		Ports: []string{"0:443"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backoff := s.opts.MinBackoff
+	sequence := []time.Duration{backoff}
+	for ix := 0; ix < 10; ix++ {
+		backoff = nextBackoff(backoff, s.opts.MaxBackoff)
+		sequence = append(sequence, backoff)
+	}
+	expected := []time.Duration{
+		500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second,
+		30 * time.Second, 30 * time.Second, 30 * time.Second, 30 * time.Second, 30 * time.Second,
+	}
+	if !reflect.DeepEqual(sequence, expected) {
+		t.Errorf("expected backoff %v, got %v", expected, sequence)
+	}
+}
+
+func TestNewServicePortForwarderPorts(t *testing.T) {
+	config := &rest.Config{Host: "https://localhost:6443"}
+	for _, ports := range [][]string{{"8080"}, {"x:80"}, {"80:x"}, {"70000:80"}} {
+		_, err := NewServicePortForwarder(config, "default", "svc", &ServicePortForwardOptions{Ports: ports})
+		if err == nil {
+			t.Errorf("%v: expected an error", ports)
+		}
+	}
+	s, err := NewServicePortForwarder(config, "default", "svc", &ServicePortForwardOptions{Ports: []string{"0:443", "8080:80"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ports := s.Ports(); len(ports) != 2 || ports[0].Local != 0 || ports[0].Remote != 443 || ports[1].Local != 8080 || ports[1].Remote != 80 {
+		t.Errorf("unexpected ports %v", ports)
+	}
+}
+
+func testPod(name string, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+func testEndpoint(kind, name string, ready *bool) discoveryv1.Endpoint {
+	endpoint := discoveryv1.Endpoint{
+		Addresses:  []string{"10.0.0.1"},
+		Conditions: discoveryv1.EndpointConditions{Ready: ready},
+	}
+	if kind != "" {
+		endpoint.TargetRef = &corev1.ObjectReference{Kind: kind, Name: name, Namespace: "default"}
+	}
+	return endpoint
+}
+
+func testEndpointSlice(name, service string, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: service},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   endpoints,
+	}
+}
+
+func TestReadyPodsForServiceEndpoints(t *testing.T) {
+	ready := true
+	notReady := false
+	objects := []runtime.Object{
+		testPod("c", true),
+		testPod("a", true),
+		testPod("b", true),
+		testPod("unready-endpoint", true),
+		testPod("unready-pod", false),
+		testPod("other", true),
+		testEndpointSlice("svc-1", "svc",
+			testEndpoint("Pod", "c", &ready),
+			testEndpoint("Pod", "unready-endpoint", &notReady),
+			testEndpoint("Pod", "unready-pod", &ready),
+			testEndpoint("Pod", "missing", &ready),
+			testEndpoint("Node", "b", &ready),
+			testEndpoint("", "", &ready),
+		),
+		// Endpoints may be split across, or duplicated between, slices, and unknown readiness is treated as ready
+		testEndpointSlice("svc-2", "svc",
+			testEndpoint("Pod", "a", nil),
+			testEndpoint("Pod", "c", &ready),
+		),
+		testEndpointSlice("other-1", "other", testEndpoint("Pod", "other", &ready)),
+	}
+	deleting := testPod("deleting", true)
+	deleting.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	deleting.Finalizers = []string{"test"}
+	objects = append(objects, deleting, testEndpointSlice("svc-3", "svc", testEndpoint("Pod", "deleting", &ready)))
+
+	client := fake.NewSimpleClientset(objects...)
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default"}}
+	pods, err := ReadyPodsForServiceEndpoints(context.Background(), client, svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	if expected := []string{"a", "c"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected pods %v, got %v", expected, names)
+	}
+}
+
+func TestPreferredPod(t *testing.T) {
+	pods := []corev1.Pod{*testPod("a", true), *testPod("b", true)}
+	cases := []struct {
+		pods     []corev1.Pod
+		lastPod  string
+		expected string
+	}{
+		{pods, "", "a"},
+		{pods, "a", "b"},
+		{pods, "b", "a"},
+		{pods[:1], "a", "a"},
+	}
+	for _, c := range cases {
+		if actual := preferredPod(c.pods, c.lastPod).Name; actual != c.expected {
+			t.Errorf("last pod %q: expected %s, got %s", c.lastPod, c.expected, actual)
+		}
+	}
+}
@@ -156,7 +156,9 @@ var _ = BeforeSuite(func() {
 				ClassMappings:          mappings,
 			},
 		},
-		LBManagerFullname: "test",
+		LBManagerFullname:    "test",
+		ControlplaneFullname: "test-controlplane",
+		WorkerFullname:       "test-worker",
 	}
 
 	serviceController := lbmanager.ServiceController{
@@ -197,6 +199,21 @@ var _ = BeforeSuite(func() {
 			Complete(&ingressController),
 	).To(Succeed())
 
+	nodeController := lbmanager.NodeController{
+		Guest:            testGuest.k8sClient,
+		Host:             testHost.k8sClient,
+		Log:              ctrl.Log.WithName("node-ctrl"),
+		RequeueDelay:     1 * time.Second,
+		ReleaseNamespace: "default",
+		ReleaseConfig:    releaseConfig,
+	}
+	Expect(
+		builder.
+			ControllerManagedBy(testGuest.mgr).
+			For(&corev1.Node{}).
+			Complete(&nodeController),
+	).To(Succeed())
+
 	mgrCtx, stopMgr := context.WithCancel(context.Background())
 	go func() {
 		GinkgoRecover()
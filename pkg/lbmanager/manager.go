@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"hash/adler32"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -651,3 +652,98 @@ func (i *IngressControllerRun) CreateOrUpdateHostIngress(guestClass string) erro
 		i.Log.Info("Host ingress still exists after deletion", "host-ingress", *i.Targets[guestClass])
 	}
 }
+
+// NodeController deletes guest nodes which are backed by a host pod which no longer exists, such as when the workers
+// are scaled down, which would otherwise be left NotReady forever.
+type NodeController struct {
+	Host  client.Client
+	Guest client.Client
+	Log   logr.Logger
+	// GracePeriod is how long a node must be NotReady before it is deleted, so that nodes are not deleted while their
+	// pods are being replaced
+	GracePeriod      time.Duration
+	RequeueDelay     time.Duration
+	ReleaseNamespace string
+	ReleaseConfig    cfg.ReleaseConfig
+}
+
+func (n *NodeController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	key := client.ObjectKey(req.NamespacedName)
+	node := &corev1.Node{}
+	err := n.Guest.Get(ctx, key, node)
+	if kerrors.IsNotFound(err) {
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		return ctrl.Result{Requeue: true, RequeueAfter: n.RequeueDelay}, err
+	}
+
+	// Nodes which don't belong to one of the statefulsets, e.g. ones joined from outside of the host cluster, are not
+	// touched
+	if !n.IsNodePodName(node.Name) {
+		return ctrl.Result{}, nil
+	}
+
+	log := n.Log.WithValues("node", key)
+
+	notReadySince, ready := NodeNotReadySince(node)
+	if ready {
+		return ctrl.Result{}, nil
+	}
+	log.Info("Received event for NotReady node")
+
+	pod := &corev1.Pod{}
+	err = n.Host.Get(ctx, client.ObjectKey{Namespace: n.ReleaseNamespace, Name: node.Name}, pod)
+	if err == nil {
+		// Nodes are not updated once they are NotReady, so the pod needs to be checked again later, in case it is
+		// deleted
+		recheck := n.GracePeriod
+		if recheck < n.RequeueDelay {
+			recheck = n.RequeueDelay
+		}
+		return ctrl.Result{RequeueAfter: recheck}, nil
+	}
+	if !kerrors.IsNotFound(err) {
+		return ctrl.Result{Requeue: true, RequeueAfter: n.RequeueDelay}, err
+	}
+
+	if remaining := n.GracePeriod - time.Since(notReadySince); remaining > 0 {
+		log.Info("Host pod does not exist, waiting for grace period before deleting node", "remaining", remaining)
+		return ctrl.Result{RequeueAfter: remaining}, nil
+	}
+
+	log.Info("Host pod no longer exists, deleting node")
+	err = n.Guest.Delete(ctx, node)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return ctrl.Result{Requeue: true, RequeueAfter: n.RequeueDelay}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// IsNodePodName returns true if a name is that of a controlplane or worker pod, which is also the name of its node
+func (n *NodeController) IsNodePodName(name string) bool {
+	for _, prefix := range []string{n.ReleaseConfig.ControlplaneFullname, n.ReleaseConfig.WorkerFullname} {
+		if prefix == "" || !strings.HasPrefix(name, prefix+"-") {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimPrefix(name, prefix+"-")); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// NodeNotReadySince returns true if a node is ready, or, if not, when it stopped being ready. Nodes which have never
+// been ready are considered not ready since they were created.
+func NodeNotReadySince(node *corev1.Node) (time.Time, bool) {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type != corev1.NodeReady {
+			continue
+		}
+		if cond.Status == corev1.ConditionTrue {
+			return time.Time{}, true
+		}
+		return cond.LastTransitionTime.Time, false
+	}
+	return node.CreationTimestamp.Time, false
+}
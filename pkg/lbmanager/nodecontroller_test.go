@@ -0,0 +1,63 @@
+package lbmanager_test
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func createNode(ctx context.Context, name string) *corev1.Node {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	Expect(testGuest.k8sClient.Create(ctx, node)).To(Succeed())
+	DeferCleanup(func() {
+		Expect(client.IgnoreNotFound(testGuest.k8sClient.Delete(context.Background(), node))).To(Succeed())
+	})
+	return node
+}
+
+func nodeExists(ctx context.Context, node *corev1.Node) func() (bool, error) {
+	return func() (bool, error) {
+		err := testGuest.k8sReader.Get(ctx, client.ObjectKeyFromObject(node), &corev1.Node{})
+		if kerrors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+}
+
+var _ = Describe("Node Controller", func() {
+	It("should delete a NotReady node whose pod does not exist", func(ctx context.Context) {
+		node := createNode(ctx, "test-worker-1")
+		Eventually(nodeExists(ctx, node), "10s").Should(BeFalse())
+	})
+
+	It("should not delete a node whose pod exists", func(ctx context.Context) {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-worker-0", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "node", Image: "test"}},
+			},
+		}
+		Expect(testHost.k8sClient.Create(ctx, pod)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(testHost.k8sClient.Delete(context.Background(), pod))).To(Succeed())
+		})
+		Eventually(func() error {
+			return testHost.k8sClient.Get(ctx, client.ObjectKeyFromObject(pod), &corev1.Pod{})
+		}, "10s").Should(Succeed())
+		node := createNode(ctx, pod.Name)
+		Consistently(nodeExists(ctx, node), 3*time.Second).Should(BeTrue())
+	})
+
+	It("should not delete nodes which do not belong to the cluster", func(ctx context.Context) {
+		node := createNode(ctx, "external-node")
+		Consistently(nodeExists(ctx, node), 3*time.Second).Should(BeTrue())
+	})
+})
@@ -0,0 +1,259 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	cfg "github.com/meln5674/kink/pkg/config"
+	"github.com/meln5674/kink/pkg/operator/v1alpha1"
+)
+
+// A Backend performs the same operations on behalf of the operator as the corresponding kink commands
+type Backend interface {
+	// Resolve determines the release configuration for a cluster, as it would be for any other command
+	Resolve(ctx context.Context, cluster *v1alpha1.KinkCluster) (*cfg.ReleaseConfig, error)
+	// Apply installs or upgrades the release for a cluster, as in `kink create cluster`
+	Apply(ctx context.Context, cluster *v1alpha1.KinkCluster) error
+	// Kubeconfig exports the kubeconfig for a cluster, as in `kink export kubeconfig`, using the in-cluster context
+	Kubeconfig(ctx context.Context, cluster *v1alpha1.KinkCluster, releaseConfig *cfg.ReleaseConfig) ([]byte, error)
+	// Delete removes the release for a cluster, as in `kink delete cluster`
+	Delete(ctx context.Context, cluster *v1alpha1.KinkCluster) error
+}
+
+// KinkClusterController deploys a cluster for each KinkCluster, and publishes its kubeconfig in a secret
+type KinkClusterController struct {
+	Client  client.Client
+	Log     logr.Logger
+	Backend Backend
+	// ReleaseNamespace is the namespace all clusters are deployed to, regardless of the namespace of the KinkCluster
+	ReleaseNamespace string
+	RequeueDelay     time.Duration
+}
+
+func (k *KinkClusterController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	key := client.ObjectKey(req.NamespacedName)
+	cluster := &v1alpha1.KinkCluster{}
+	err := k.Client.Get(ctx, key, cluster)
+	if kerrors.IsNotFound(err) {
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		return ctrl.Result{Requeue: true, RequeueAfter: k.RequeueDelay}, err
+	}
+
+	log := k.Log.WithValues("cluster", key)
+	log.Info("Received event")
+	run := KinkClusterControllerRun{
+		KinkClusterController: k,
+		Log:                   log,
+		Cluster:               cluster,
+		Ctx:                   ctx,
+	}
+	deleted, err := run.HandleFinalizer()
+	if err != nil {
+		return ctrl.Result{Requeue: true, RequeueAfter: k.RequeueDelay}, err
+	}
+	if deleted {
+		return ctrl.Result{}, nil
+	}
+
+	ready, err := run.Reconcile()
+	statusErr := run.UpdateStatus()
+	if err != nil {
+		return ctrl.Result{Requeue: true, RequeueAfter: k.RequeueDelay}, err
+	}
+	if statusErr != nil {
+		return ctrl.Result{Requeue: true, RequeueAfter: k.RequeueDelay}, statusErr
+	}
+	if !ready {
+		// The statefulsets are in another namespace and not owned by the KinkCluster, so we poll instead of watching them
+		return ctrl.Result{Requeue: true, RequeueAfter: k.RequeueDelay}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+type KinkClusterControllerRun struct {
+	*KinkClusterController
+	Log     logr.Logger
+	Cluster *v1alpha1.KinkCluster
+	Ctx     context.Context
+}
+
+func (k *KinkClusterControllerRun) HandleFinalizer() (deleted bool, err error) {
+	if k.Cluster.DeletionTimestamp != nil {
+		if !controllerutil.ContainsFinalizer(k.Cluster, v1alpha1.Finalizer) {
+			return true, nil
+		}
+		k.Log.Info("Deleting release for cluster being deleted")
+		err = k.Backend.Delete(k.Ctx, k.Cluster)
+		if err != nil {
+			return false, err
+		}
+		k.Log.Info("Removing finalizer")
+		controllerutil.RemoveFinalizer(k.Cluster, v1alpha1.Finalizer)
+		err = k.Client.Update(k.Ctx, k.Cluster)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	if controllerutil.ContainsFinalizer(k.Cluster, v1alpha1.Finalizer) {
+		return false, nil
+	}
+	k.Log.Info("Adding finalizer")
+	controllerutil.AddFinalizer(k.Cluster, v1alpha1.Finalizer)
+	err = k.Client.Update(k.Ctx, k.Cluster)
+	if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// Reconcile deploys the cluster if its spec has changed, then updates the status conditions, and publishes the
+// kubeconfig once the controlplane is ready.
+func (k *KinkClusterControllerRun) Reconcile() (ready bool, err error) {
+	if err := ValidateSpec(&k.Cluster.Spec); err != nil {
+		k.setCondition(v1alpha1.ConditionControlplaneReady, metav1.ConditionFalse, "InvalidSpec", err.Error())
+		// There's no point in retrying until the spec is changed
+		return true, nil
+	}
+
+	k.Cluster.Status.ClusterName = k.Cluster.ClusterName()
+	k.Cluster.Status.ReleaseNamespace = k.ReleaseNamespace
+
+	releaseConfig, err := k.Backend.Resolve(k.Ctx, k.Cluster)
+	if err != nil {
+		k.setCondition(v1alpha1.ConditionControlplaneReady, metav1.ConditionUnknown, "ResolveFailed", err.Error())
+		return false, err
+	}
+
+	if k.Cluster.Status.ObservedGeneration != k.Cluster.Generation {
+		k.Log.Info("Deploying cluster", "generation", k.Cluster.Generation)
+		err = k.Backend.Apply(k.Ctx, k.Cluster)
+		if err != nil {
+			k.setCondition(v1alpha1.ConditionControlplaneReady, metav1.ConditionFalse, "DeployFailed", err.Error())
+			return false, err
+		}
+		k.Cluster.Status.ObservedGeneration = k.Cluster.Generation
+	}
+
+	controlplaneReady, err := k.checkStatefulSet(v1alpha1.ConditionControlplaneReady, releaseConfig.ControlplaneFullname)
+	if err != nil {
+		return false, err
+	}
+	workersReady, err := k.checkStatefulSet(v1alpha1.ConditionWorkersReady, releaseConfig.WorkerFullname)
+	if err != nil {
+		return false, err
+	}
+	if !controlplaneReady {
+		if k.Cluster.Status.KubeconfigSecret == nil {
+			k.setCondition(v1alpha1.ConditionKubeconfigAvailable, metav1.ConditionFalse, "WaitingForControlplane", "Controlplane is not yet ready")
+		}
+		return false, nil
+	}
+
+	kubeconfigAvailable, err := k.ensureKubeconfigSecret(releaseConfig)
+	if err != nil {
+		k.setCondition(v1alpha1.ConditionKubeconfigAvailable, metav1.ConditionFalse, "ExportFailed", err.Error())
+		return false, err
+	}
+
+	return controlplaneReady && workersReady && kubeconfigAvailable, nil
+}
+
+// ValidateSpec returns an error if a spec uses fields of the config file which are not supported by the operator
+func ValidateSpec(spec *v1alpha1.KinkClusterSpec) error {
+	if len(spec.Release.Values) != 0 {
+		return fmt.Errorf("release.values is not supported by the operator, use release.set and release.setString instead")
+	}
+	if len(spec.Release.UpgradeFlags) != 0 {
+		return fmt.Errorf("release.upgradeFlags is not supported by the operator")
+	}
+	return nil
+}
+
+func (k *KinkClusterControllerRun) checkStatefulSet(conditionType, name string) (ready bool, err error) {
+	sts := &appsv1.StatefulSet{}
+	err = k.Client.Get(k.Ctx, client.ObjectKey{Namespace: k.ReleaseNamespace, Name: name}, sts)
+	if kerrors.IsNotFound(err) {
+		k.setCondition(conditionType, metav1.ConditionFalse, "NotFound", fmt.Sprintf("StatefulSet %s does not exist", name))
+		return false, nil
+	}
+	if err != nil {
+		k.setCondition(conditionType, metav1.ConditionUnknown, "GetFailed", err.Error())
+		return false, err
+	}
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	message := fmt.Sprintf("%d of %d pods are ready", sts.Status.ReadyReplicas, replicas)
+	if sts.Status.ReadyReplicas < replicas || sts.Status.ObservedGeneration < sts.Generation {
+		k.setCondition(conditionType, metav1.ConditionFalse, "PodsNotReady", message)
+		return false, nil
+	}
+	k.setCondition(conditionType, metav1.ConditionTrue, "PodsReady", message)
+	return true, nil
+}
+
+func (k *KinkClusterControllerRun) ensureKubeconfigSecret(releaseConfig *cfg.ReleaseConfig) (bool, error) {
+	secret := &corev1.Secret{}
+	secret.Namespace = k.Cluster.Namespace
+	secret.Name = k.Cluster.KubeconfigSecretName()
+	err := k.Client.Get(k.Ctx, client.ObjectKeyFromObject(secret), secret)
+	if err == nil && len(secret.Data[v1alpha1.KubeconfigSecretKey]) != 0 {
+		k.Cluster.Status.KubeconfigSecret = &corev1.LocalObjectReference{Name: secret.Name}
+		k.setCondition(v1alpha1.ConditionKubeconfigAvailable, metav1.ConditionTrue, "SecretExists", "")
+		return true, nil
+	}
+	if err != nil && !kerrors.IsNotFound(err) {
+		return false, err
+	}
+
+	k.Log.Info("Exporting kubeconfig")
+	kubeconfig, err := k.Backend.Kubeconfig(k.Ctx, k.Cluster, releaseConfig)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = controllerutil.CreateOrUpdate(k.Ctx, k.Client, secret, func() error {
+		secret.Type = corev1.SecretTypeOpaque
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte, 1)
+		}
+		secret.Data[v1alpha1.KubeconfigSecretKey] = kubeconfig
+		return controllerutil.SetControllerReference(k.Cluster, secret, k.Client.Scheme())
+	})
+	if err != nil {
+		return false, err
+	}
+	k.Cluster.Status.KubeconfigSecret = &corev1.LocalObjectReference{Name: secret.Name}
+	k.setCondition(v1alpha1.ConditionKubeconfigAvailable, metav1.ConditionTrue, "SecretCreated", "")
+	return true, nil
+}
+
+func (k *KinkClusterControllerRun) setCondition(conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&k.Cluster.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: k.Cluster.Generation,
+	})
+}
+
+func (k *KinkClusterControllerRun) UpdateStatus() error {
+	return k.Client.Status().Update(k.Ctx, k.Cluster)
+}
@@ -0,0 +1,325 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	cfg "github.com/meln5674/kink/pkg/config"
+	"github.com/meln5674/kink/pkg/operator/v1alpha1"
+)
+
+const (
+	testReleaseNamespace = "kink-system"
+	testRequeueDelay     = 10 * time.Second
+)
+
+// testBackend records the operations the controller performs instead of running helm
+type testBackend struct {
+	applied    []int64
+	deleted    int
+	exported   int
+	applyError error
+}
+
+func (t *testBackend) Resolve(ctx context.Context, cluster *v1alpha1.KinkCluster) (*cfg.ReleaseConfig, error) {
+	return &cfg.ReleaseConfig{
+		ControlplaneFullname: "kink-" + cluster.ClusterName() + "-controlplane",
+		WorkerFullname:       "kink-" + cluster.ClusterName() + "-worker",
+	}, nil
+}
+
+func (t *testBackend) Apply(ctx context.Context, cluster *v1alpha1.KinkCluster) error {
+	t.applied = append(t.applied, cluster.Generation)
+	return t.applyError
+}
+
+func (t *testBackend) Kubeconfig(ctx context.Context, cluster *v1alpha1.KinkCluster, releaseConfig *cfg.ReleaseConfig) ([]byte, error) {
+	t.exported++
+	return []byte("kubeconfig for " + releaseConfig.ControlplaneFullname), nil
+}
+
+func (t *testBackend) Delete(ctx context.Context, cluster *v1alpha1.KinkCluster) error {
+	t.deleted++
+	return nil
+}
+
+func testController(t *testing.T, objects ...client.Object) (*KinkClusterController, *testBackend) {
+	scheme := runtime.NewScheme()
+	for _, addToScheme := range []func(*runtime.Scheme) error{clientgoscheme.AddToScheme, v1alpha1.AddToScheme} {
+		err := addToScheme(scheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	backend := &testBackend{}
+	return &KinkClusterController{
+		Client: fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(objects...).
+			WithStatusSubresource(&v1alpha1.KinkCluster{}).
+			Build(),
+		Log:              logr.Discard(),
+		Backend:          backend,
+		ReleaseNamespace: testReleaseNamespace,
+		RequeueDelay:     testRequeueDelay,
+	}, backend
+}
+
+func testCluster(spec v1alpha1.KinkClusterSpec) *v1alpha1.KinkCluster {
+	return &v1alpha1.KinkCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "0123456789", Generation: 1},
+		Spec:       spec,
+	}
+}
+
+func testReadyStatefulSet(name string) *appsv1.StatefulSet {
+	replicas := int32(1)
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testReleaseNamespace},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		Status:     appsv1.StatefulSetStatus{ReadyReplicas: 1},
+	}
+}
+
+var testClusterKey = types.NamespacedName{Namespace: "default", Name: "test"}
+
+func testReconcile(t *testing.T, k *KinkClusterController) (ctrl.Result, *v1alpha1.KinkCluster, error) {
+	t.Helper()
+	result, err := k.Reconcile(context.Background(), ctrl.Request{NamespacedName: testClusterKey})
+	cluster := &v1alpha1.KinkCluster{}
+	getErr := k.Client.Get(context.Background(), testClusterKey, cluster)
+	if kerrors.IsNotFound(getErr) {
+		return result, nil, err
+	}
+	if getErr != nil {
+		t.Fatal(getErr)
+	}
+	return result, cluster, err
+}
+
+func checkCondition(t *testing.T, cluster *v1alpha1.KinkCluster, conditionType string, status metav1.ConditionStatus, reason string) {
+	t.Helper()
+	condition := meta.FindStatusCondition(cluster.Status.Conditions, conditionType)
+	if condition == nil {
+		t.Errorf("expected condition %s to be set", conditionType)
+		return
+	}
+	if condition.Status != status || condition.Reason != reason {
+		t.Errorf("expected condition %s to be %s (%s), got %s (%s): %s", conditionType, status, reason, condition.Status, condition.Reason, condition.Message)
+	}
+}
+
+func TestReconcileCreate(t *testing.T) {
+	k, backend := testController(t, testCluster(v1alpha1.KinkClusterSpec{}))
+
+	result, cluster, err := testReconcile(t, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !controllerutil.ContainsFinalizer(cluster, v1alpha1.Finalizer) {
+		t.Error("expected the finalizer to be added")
+	}
+	if len(backend.applied) != 1 || cluster.Status.ObservedGeneration != 1 {
+		t.Errorf("expected generation 1 to be deployed once, deployed %v, observed %d", backend.applied, cluster.Status.ObservedGeneration)
+	}
+	if cluster.Status.ClusterName != "test-01234567" || cluster.Status.ReleaseNamespace != testReleaseNamespace {
+		t.Errorf("unexpected status %#v", cluster.Status)
+	}
+	// The statefulsets are polled, as they are not owned by the KinkCluster
+	if result.RequeueAfter != testRequeueDelay {
+		t.Errorf("expected to requeue after %s until ready, got %#v", testRequeueDelay, result)
+	}
+	checkCondition(t, cluster, v1alpha1.ConditionControlplaneReady, metav1.ConditionFalse, "NotFound")
+	checkCondition(t, cluster, v1alpha1.ConditionWorkersReady, metav1.ConditionFalse, "NotFound")
+	checkCondition(t, cluster, v1alpha1.ConditionKubeconfigAvailable, metav1.ConditionFalse, "WaitingForControlplane")
+	if backend.exported != 0 {
+		t.Error("expected the kubeconfig to not be exported before the controlplane is ready")
+	}
+
+	for _, sts := range []*appsv1.StatefulSet{testReadyStatefulSet("kink-test-01234567-controlplane"), testReadyStatefulSet("kink-test-01234567-worker")} {
+		err = k.Client.Create(context.Background(), sts)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, cluster, err = testReconcile(t, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Requeue || result.RequeueAfter != 0 {
+		t.Errorf("expected no requeue once ready, got %#v", result)
+	}
+	if len(backend.applied) != 1 {
+		t.Errorf("expected an unchanged spec to not be deployed again, deployed %v", backend.applied)
+	}
+	checkCondition(t, cluster, v1alpha1.ConditionControlplaneReady, metav1.ConditionTrue, "PodsReady")
+	checkCondition(t, cluster, v1alpha1.ConditionWorkersReady, metav1.ConditionTrue, "PodsReady")
+	checkCondition(t, cluster, v1alpha1.ConditionKubeconfigAvailable, metav1.ConditionTrue, "SecretCreated")
+
+	secret := &corev1.Secret{}
+	err = k.Client.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "test-kubeconfig"}, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data[v1alpha1.KubeconfigSecretKey]) != "kubeconfig for kink-test-01234567-controlplane" {
+		t.Errorf("unexpected kubeconfig %q", secret.Data[v1alpha1.KubeconfigSecretKey])
+	}
+	if owner := metav1.GetControllerOf(secret); owner == nil || owner.UID != cluster.UID {
+		t.Errorf("expected the secret to be owned by the cluster, got %v", owner)
+	}
+	if cluster.Status.KubeconfigSecret == nil || cluster.Status.KubeconfigSecret.Name != secret.Name {
+		t.Errorf("expected the status to reference the secret, got %v", cluster.Status.KubeconfigSecret)
+	}
+
+	// The existing secret is reused
+	_, cluster, err = testReconcile(t, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend.exported != 1 {
+		t.Errorf("expected the kubeconfig to be exported once, exported %d times", backend.exported)
+	}
+	checkCondition(t, cluster, v1alpha1.ConditionKubeconfigAvailable, metav1.ConditionTrue, "SecretExists")
+}
+
+func TestReconcileSpecChange(t *testing.T) {
+	k, backend := testController(t,
+		testCluster(v1alpha1.KinkClusterSpec{}),
+		testReadyStatefulSet("kink-test-01234567-controlplane"),
+		testReadyStatefulSet("kink-test-01234567-worker"),
+	)
+	_, cluster, err := testReconcile(t, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake client does not track generations, so the change is simulated
+	cluster.Spec.Release.Set = map[string]string{"worker.replicaCount": "2"}
+	cluster.Generation = 2
+	err = k.Client.Update(context.Background(), cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cluster, err = testReconcile(t, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.applied) != 2 || backend.applied[1] != 2 {
+		t.Errorf("expected generation 2 to be deployed, deployed %v", backend.applied)
+	}
+	if cluster.Status.ObservedGeneration != 2 {
+		t.Errorf("expected observed generation 2, got %d", cluster.Status.ObservedGeneration)
+	}
+
+	// A failed deploy is retried, and the generation is not marked as observed
+	backend.applyError = errors.New("helm failed")
+	cluster.Generation = 3
+	err = k.Client.Update(context.Background(), cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, cluster, err := testReconcile(t, k)
+	if err == nil || result.RequeueAfter != testRequeueDelay {
+		t.Errorf("expected the failed deploy to be retried, got %#v, %v", result, err)
+	}
+	if cluster.Status.ObservedGeneration != 2 {
+		t.Errorf("expected observed generation to remain 2, got %d", cluster.Status.ObservedGeneration)
+	}
+	checkCondition(t, cluster, v1alpha1.ConditionControlplaneReady, metav1.ConditionFalse, "DeployFailed")
+}
+
+func TestReconcileDelete(t *testing.T) {
+	k, backend := testController(t, testCluster(v1alpha1.KinkClusterSpec{}))
+	_, cluster, err := testReconcile(t, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The finalizer keeps the cluster until its release is deleted
+	err = k.Client.Delete(context.Background(), cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, cluster, err := testReconcile(t, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend.deleted != 1 {
+		t.Errorf("expected the release to be deleted once, deleted %d times", backend.deleted)
+	}
+	if cluster != nil {
+		t.Errorf("expected the cluster to be removed once its finalizer was removed, got %#v", cluster)
+	}
+	if result.Requeue || result.RequeueAfter != 0 {
+		t.Errorf("expected no requeue after deletion, got %#v", result)
+	}
+
+	// Events for clusters which no longer exist are ignored
+	_, _, err = testReconcile(t, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend.deleted != 1 || len(backend.applied) != 1 {
+		t.Errorf("expected no further operations, deleted %d, deployed %v", backend.deleted, backend.applied)
+	}
+}
+
+func TestReconcileInvalidSpec(t *testing.T) {
+	values := v1alpha1.KinkClusterSpec{}
+	values.Release.Values = []string{"values.yaml"}
+	upgradeFlags := v1alpha1.KinkClusterSpec{}
+	upgradeFlags.Release.UpgradeFlags = []string{"--force"}
+	cases := map[string]v1alpha1.KinkClusterSpec{"values": values, "upgradeFlags": upgradeFlags}
+	for name, spec := range cases {
+		k, backend := testController(t, testCluster(spec))
+		result, cluster, err := testReconcile(t, k)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(backend.applied) != 0 {
+			t.Errorf("%s: expected an invalid spec to not be deployed", name)
+		}
+		// There is no point retrying until the spec changes
+		if result.Requeue || result.RequeueAfter != 0 {
+			t.Errorf("%s: expected no requeue, got %#v", name, result)
+		}
+		checkCondition(t, cluster, v1alpha1.ConditionControlplaneReady, metav1.ConditionFalse, "InvalidSpec")
+	}
+}
+
+func TestClusterName(t *testing.T) {
+	cases := []struct {
+		namespace, name, clusterName, uid string
+		expected                          string
+	}{
+		{"a", "test", "", "0123456789", "test-01234567"},
+		{"a", "test", "shared", "0123456789", "shared-01234567"},
+		// Another namespace requesting the same cluster name gets a different cluster
+		{"b", "test", "shared", "abcdefabcdef", "shared-abcdefab"},
+	}
+	for _, c := range cases {
+		cluster := &v1alpha1.KinkCluster{ObjectMeta: metav1.ObjectMeta{Namespace: c.namespace, Name: c.name, UID: types.UID(c.uid)}}
+		cluster.Spec.Release.ClusterName = c.clusterName
+		if actual := cluster.ClusterName(); actual != c.expected {
+			t.Errorf("%s/%s (%q): expected %s, got %s", c.namespace, c.name, c.clusterName, c.expected, actual)
+		}
+	}
+}
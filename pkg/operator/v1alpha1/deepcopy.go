@@ -0,0 +1,118 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/meln5674/kink/pkg/helm"
+)
+
+// These are written by hand, as the spec types are shared with the config file, and do not have generated deep copies.
+
+func copyStrings(in []string) []string {
+	if in == nil {
+		return nil
+	}
+	out := make([]string, len(in))
+	copy(out, in)
+	return out
+}
+
+func copyStringMap(in map[string]string) map[string]string {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+// DeepCopyInto copies the receiver into out
+func (in *KinkClusterSpec) DeepCopyInto(out *KinkClusterSpec) {
+	out.Chart = in.Chart
+	out.Release = helm.ClusterReleaseFlags{
+		ClusterName:  in.Release.ClusterName,
+		Values:       copyStrings(in.Release.Values),
+		Set:          copyStringMap(in.Release.Set),
+		SetString:    copyStringMap(in.Release.SetString),
+		UpgradeFlags: copyStrings(in.Release.UpgradeFlags),
+		NoWait:       in.Release.NoWait,
+	}
+}
+
+// DeepCopy returns a copy of the receiver
+func (in *KinkClusterSpec) DeepCopy() *KinkClusterSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KinkClusterSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto copies the receiver into out
+func (in *KinkClusterStatus) DeepCopyInto(out *KinkClusterStatus) {
+	*out = *in
+	if in.KubeconfigSecret != nil {
+		out.KubeconfigSecret = &corev1.LocalObjectReference{Name: in.KubeconfigSecret.Name}
+	}
+	if in.Conditions != nil {
+		out.Conditions = make([]metav1.Condition, len(in.Conditions))
+		for ix := range in.Conditions {
+			in.Conditions[ix].DeepCopyInto(&out.Conditions[ix])
+		}
+	}
+}
+
+// DeepCopyInto copies the receiver into out
+func (in *KinkCluster) DeepCopyInto(out *KinkCluster) {
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy returns a copy of the receiver
+func (in *KinkCluster) DeepCopy() *KinkCluster {
+	if in == nil {
+		return nil
+	}
+	out := new(KinkCluster)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject implements runtime.Object
+func (in *KinkCluster) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopyInto copies the receiver into out
+func (in *KinkClusterList) DeepCopyInto(out *KinkClusterList) {
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]KinkCluster, len(in.Items))
+		for ix := range in.Items {
+			in.Items[ix].DeepCopyInto(&out.Items[ix])
+		}
+	}
+}
+
+// DeepCopy returns a copy of the receiver
+func (in *KinkClusterList) DeepCopy() *KinkClusterList {
+	if in == nil {
+		return nil
+	}
+	out := new(KinkClusterList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject implements runtime.Object
+func (in *KinkClusterList) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
@@ -0,0 +1,106 @@
+package v1alpha1
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/scheme"
+
+	"github.com/meln5674/kink/pkg/helm"
+)
+
+const (
+	// ConditionControlplaneReady is true when all controlplane pods are ready
+	ConditionControlplaneReady = "ControlplaneReady"
+	// ConditionWorkersReady is true when all worker pods are ready
+	ConditionWorkersReady = "WorkersReady"
+	// ConditionKubeconfigAvailable is true when the kubeconfig secret referenced by the status exists
+	ConditionKubeconfigAvailable = "KubeconfigAvailable"
+
+	// Finalizer is added to each KinkCluster so that its release is removed when it is deleted
+	Finalizer = "kink.meln5674.github.com/cluster"
+
+	// KubeconfigSecretKey is the key within the kubeconfig secret which contains the kubeconfig
+	KubeconfigSecretKey = "config"
+)
+
+var (
+	// GroupVersion is group version used to register these objects
+	GroupVersion = schema.GroupVersion{Group: "kink.meln5674.github.com", Version: "v1alpha1"}
+
+	// SchemeBuilder is used to add go types to the GroupVersionKind scheme
+	SchemeBuilder = &scheme.Builder{GroupVersion: GroupVersion}
+
+	// AddToScheme adds the types in this group-version to the given scheme.
+	AddToScheme = SchemeBuilder.AddToScheme
+)
+
+func init() {
+	SchemeBuilder.Register(&KinkCluster{}, &KinkClusterList{})
+}
+
+// KinkClusterSpec is the requested state of a cluster.
+// This has the same shape as the chart and release sections of a config file (see config.RawConfig).
+// The helm, kubectl, docker, and kubernetes sections are deliberately not included, as these are controlled by the operator.
+type KinkClusterSpec struct {
+	// Chart configures the Helm Chart used to deploy the cluster. This is ignored unless the operator allows custom charts.
+	Chart helm.ChartFlags `json:"chart,omitempty"`
+	// Release configures the Helm Release of the Chart that is used to deploy the cluster.
+	// Values files and extra upgrade flags are not supported, use set and setString instead.
+	// The cluster name is always suffixed with a prefix of the UID of the KinkCluster, and defaults to its name.
+	Release helm.ClusterReleaseFlags `json:"release,omitempty"`
+}
+
+// KinkClusterStatus is the observed state of a cluster
+type KinkClusterStatus struct {
+	// ObservedGeneration is the generation of the spec that was most recently deployed
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// ClusterName is the name of the cluster as it would be provided to the --name flag
+	ClusterName string `json:"clusterName,omitempty"`
+	// ReleaseNamespace is the namespace the cluster is deployed to
+	ReleaseNamespace string `json:"releaseNamespace,omitempty"`
+	// KubeconfigSecret is the secret, in the same namespace as this KinkCluster, which contains the kubeconfig for the cluster
+	KubeconfigSecret *corev1.LocalObjectReference `json:"kubeconfigSecret,omitempty"`
+	// Conditions are the current state of the cluster
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// KinkCluster is a request for a cluster managed by `kink operator`
+type KinkCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KinkClusterSpec   `json:"spec,omitempty"`
+	Status KinkClusterStatus `json:"status,omitempty"`
+}
+
+// KinkClusterList is a list of KinkClusters
+type KinkClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KinkCluster `json:"items"`
+}
+
+// ClusterName returns the name of the cluster to deploy for this KinkCluster. Every cluster is deployed to the same
+// namespace, so the name always ends with a prefix of the UID, otherwise, a KinkCluster in one namespace could name,
+// and then upgrade or delete, the cluster of another. The requested cluster name, if any, is only used in place of
+// the name of the KinkCluster. The full UID is too long to be used in the names of the statefulsets, so only a prefix
+// is used.
+func (k *KinkCluster) ClusterName() string {
+	name := k.Name
+	if k.Spec.Release.ClusterName != "" {
+		name = k.Spec.Release.ClusterName
+	}
+	uid := string(k.UID)
+	if len(uid) > 8 {
+		uid = uid[:8]
+	}
+	return fmt.Sprintf("%s-%s", name, uid)
+}
+
+// KubeconfigSecretName returns the name of the secret the kubeconfig is stored in
+func (k *KinkCluster) KubeconfigSecretName() string {
+	return fmt.Sprintf("%s-kubeconfig", k.Name)
+}